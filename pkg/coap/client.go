@@ -0,0 +1,499 @@
+package coap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// 默认传输参数，与 RFC 7252 4.8 节一致
+const (
+	DefaultAckTimeout      = 2 * time.Second
+	DefaultMaxRetransmit   = 4
+	DefaultResponseTimeout = 30 * time.Second
+)
+
+// exchangeLifetime 收到的 CON 消息按消息 ID 去重的时间
+const exchangeLifetime = 247 * time.Second
+
+// observeFreshness 超过该时间的通知不再比较序号，直接视为新通知（RFC 7641 3.4 节）
+const observeFreshness = 128 * time.Second
+
+var (
+	// ErrClosed 客户端已关闭
+	ErrClosed = errors.New("coap: client closed")
+	// ErrTimeout 重传次数用完仍未收到确认，或确认后未在 ResponseTimeout 内收到响应
+	ErrTimeout = errors.New("coap: request timeout")
+	// ErrReset 服务端以 RST 拒绝请求
+	ErrReset = errors.New("coap: request reset by server")
+	// ErrNotObservable 服务端的响应没有 Observe 选项，资源不支持观察
+	ErrNotObservable = errors.New("coap: resource is not observable")
+)
+
+// ClientOptions 客户端配置
+type ClientOptions struct {
+	// Server 服务端地址，host:port
+	Server string
+	// AckTimeout 首次等待 ACK 的时间，之后每次重传翻倍，默认 DefaultAckTimeout
+	AckTimeout time.Duration
+	// MaxRetransmit 最大重传次数，默认 DefaultMaxRetransmit，小于 0 时不重传
+	MaxRetransmit int
+	// ResponseTimeout 收到空 ACK 后等待分离响应的时间，默认 DefaultResponseTimeout
+	ResponseTimeout time.Duration
+	// Queries 附加到每个请求的 URIQuery，如认证参数 "username=1"
+	Queries []string
+	// Dial 创建 UDP 连接，默认 net.Dial
+	Dial func(network, address string) (net.Conn, error)
+}
+
+// exchange 等待响应的 CON 请求
+type exchange struct {
+	messageID uint16
+	acked     chan struct{}
+	resp      chan *Message
+	err       chan error
+	ackOnce   sync.Once
+}
+
+// Observation 观察关系，通过 Cancel 取消
+type Observation struct {
+	client  *Client
+	token   string
+	path    string
+	handler func(*Message)
+
+	mu       sync.Mutex
+	seq      uint32
+	received time.Time
+}
+
+// Client CoAP 客户端，一个客户端对应一个服务端地址，可以并发使用
+type Client struct {
+	opts ClientOptions
+	conn net.Conn
+	done chan struct{}
+
+	mu        sync.Mutex
+	closed    bool
+	nextID    uint16
+	pending   map[string]*exchange
+	pendingID map[uint16]*exchange
+	observers map[string]*Observation
+	seen      map[uint16]time.Time
+	queries   []string
+}
+
+// Dial 创建客户端并启动接收协程
+func Dial(opts ClientOptions) (*Client, error) {
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = DefaultAckTimeout
+	}
+	if opts.MaxRetransmit == 0 {
+		opts.MaxRetransmit = DefaultMaxRetransmit
+	}
+	if opts.MaxRetransmit < 0 {
+		opts.MaxRetransmit = 0
+	}
+	if opts.ResponseTimeout <= 0 {
+		opts.ResponseTimeout = DefaultResponseTimeout
+	}
+	dial := opts.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	conn, err := dial("udp", opts.Server)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	c := &Client{
+		opts:      opts,
+		conn:      conn,
+		done:      make(chan struct{}),
+		nextID:    binary.BigEndian.Uint16(id[:]),
+		pending:   make(map[string]*exchange),
+		pendingID: make(map[uint16]*exchange),
+		observers: make(map[string]*Observation),
+		seen:      make(map[uint16]time.Time),
+		queries:   opts.Queries,
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close 关闭客户端，等待中的请求返回 ErrClosed，重复调用不做任何处理
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.observers = make(map[string]*Observation)
+	c.mu.Unlock()
+	close(c.done)
+	return c.conn.Close()
+}
+
+// IsClosed 客户端是否已关闭
+func (c *Client) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// newToken 随机生成 4 字节 Token
+func newToken() []byte {
+	token := make([]byte, 4)
+	rand.Read(token)
+	return token
+}
+
+// messageID 分配消息 ID
+func (c *Client) messageID() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return c.nextID
+}
+
+// SetQueries 替换附加到每个请求的 URIQuery，如重新登录后更新认证参数
+func (c *Client) SetQueries(queries []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries = queries
+}
+
+// NewRequest 创建请求，附加 ClientOptions.Queries 或 SetQueries 设置的查询参数
+func (c *Client) NewRequest(t Type, code Code, path string) *Message {
+	m := &Message{Type: t, Code: code, Token: newToken()}
+	m.SetPath(path)
+	c.mu.Lock()
+	for _, q := range c.queries {
+		m.AddOption(URIQuery, []byte(q))
+	}
+	c.mu.Unlock()
+	return m
+}
+
+// Post 发送 POST 请求，confirmable 为 false 时以 NON 发送，不等待响应，返回的响应为 nil
+func (c *Client) Post(path string, contentFormat uint32, payload []byte, confirmable bool) (*Message, error) {
+	t := NonConfirmable
+	if confirmable {
+		t = Confirmable
+	}
+	m := c.NewRequest(t, POST, path)
+	m.SetOption(ContentFormat, EncodeUint(contentFormat))
+	m.Payload = payload
+	return c.Do(m)
+}
+
+// Do 发送请求，未设置消息 ID 时自动分配。CON 请求在收到 ACK 前按指数退避重传，
+// 返回携带在 ACK 中或单独发送的响应；NON 请求只发送一次，返回的响应为 nil
+func (c *Client) Do(m *Message) (*Message, error) {
+	if m.MessageID == 0 {
+		m.MessageID = c.messageID()
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != Confirmable {
+		return nil, c.send(data)
+	}
+	ex, err := c.register(m)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unregister(m, ex)
+
+	timeout := c.opts.AckTimeout
+	for attempt := 0; ; attempt++ {
+		if err := c.send(data); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case resp := <-ex.resp:
+			timer.Stop()
+			return resp, nil
+		case err := <-ex.err:
+			timer.Stop()
+			return nil, err
+		case <-ex.acked:
+			timer.Stop()
+			return c.waitResponse(ex)
+		case <-c.done:
+			timer.Stop()
+			return nil, ErrClosed
+		case <-timer.C:
+		}
+		if attempt >= c.opts.MaxRetransmit {
+			return nil, ErrTimeout
+		}
+		timeout *= 2
+	}
+}
+
+// waitResponse 收到空 ACK 后等待分离响应
+func (c *Client) waitResponse(ex *exchange) (*Message, error) {
+	timer := time.NewTimer(c.opts.ResponseTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ex.resp:
+		return resp, nil
+	case err := <-ex.err:
+		return nil, err
+	case <-c.done:
+		return nil, ErrClosed
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
+}
+
+// register 记录等待响应的请求
+func (c *Client) register(m *Message) (*exchange, error) {
+	ex := &exchange{
+		messageID: m.MessageID,
+		acked:     make(chan struct{}),
+		resp:      make(chan *Message, 1),
+		err:       make(chan error, 1),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	c.pending[string(m.Token)] = ex
+	c.pendingID[m.MessageID] = ex
+	return ex, nil
+}
+
+// unregister 删除等待响应的请求
+func (c *Client) unregister(m *Message, ex *exchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[string(m.Token)] == ex {
+		delete(c.pending, string(m.Token))
+	}
+	if c.pendingID[m.MessageID] == ex {
+		delete(c.pendingID, m.MessageID)
+	}
+}
+
+// send 发送编码后的消息
+func (c *Client) send(data []byte) error {
+	if c.IsClosed() {
+		return ErrClosed
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// reply 回复空的 ACK 或 RST
+func (c *Client) reply(t Type, messageID uint16) {
+	data, _ := (&Message{Type: t, MessageID: messageID}).Marshal()
+	c.send(data)
+}
+
+// Observe 观察资源，服务端确认后返回，之后的每个通知在新的协程中调用 handler。
+// 注册成功的响应只作为确认，不调用 handler
+func (c *Client) Observe(path string, handler func(*Message)) (*Observation, error) {
+	m := c.NewRequest(Confirmable, GET, path)
+	m.SetObserve(0)
+	o := &Observation{client: c, token: string(m.Token), path: path, handler: handler}
+	// 先登记观察关系，避免服务端在响应之前发送的通知被拒绝
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.observers[o.token] = o
+	c.mu.Unlock()
+
+	resp, err := c.Do(m)
+	if err == nil && !resp.Code.IsSuccess() {
+		err = &ResponseError{Code: resp.Code, Payload: resp.Payload}
+	}
+	if err == nil {
+		if seq, ok := resp.ObserveSeq(); ok {
+			o.fresh(seq)
+		} else {
+			err = ErrNotObservable
+		}
+	}
+	if err != nil {
+		c.mu.Lock()
+		delete(c.observers, o.token)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return o, nil
+}
+
+// Path 观察的资源路径
+func (o *Observation) Path() string {
+	return o.path
+}
+
+// Cancel 取消观察，向服务端发送 Observe 为 1 的 GET 请求注销，注销失败时本地仍不再处理通知
+func (o *Observation) Cancel() error {
+	c := o.client
+	c.mu.Lock()
+	if c.observers[o.token] != o {
+		c.mu.Unlock()
+		return nil
+	}
+	delete(c.observers, o.token)
+	c.mu.Unlock()
+
+	m := c.NewRequest(Confirmable, GET, o.path)
+	m.Token = []byte(o.token)
+	m.SetObserve(1)
+	_, err := c.Do(m)
+	return err
+}
+
+// fresh 通知是否比上一个通知新，按 RFC 7641 3.4 节比较 24 位序号
+func (o *Observation) fresh(seq uint32) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	v1, v2 := o.seq, seq
+	isNew := o.received.IsZero() ||
+		(v1 < v2 && v2-v1 < 1<<23) ||
+		(v1 > v2 && v1-v2 > 1<<23) ||
+		now.Sub(o.received) > observeFreshness
+	if isNew {
+		o.seq = seq
+		o.received = now
+	}
+	return isNew
+}
+
+// ResponseError 服务端返回的错误响应
+type ResponseError struct {
+	Code    Code
+	Payload []byte
+}
+
+// Error 错误信息
+func (e *ResponseError) Error() string {
+	if len(e.Payload) == 0 {
+		return "coap: response " + e.Code.String()
+	}
+	return "coap: response " + e.Code.String() + " " + string(e.Payload)
+}
+
+// readLoop 接收并分发消息，直到客户端关闭
+func (c *Client) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			// UDP 读失败（如 ICMP 端口不可达）不影响后续的收发
+			continue
+		}
+		m, err := Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		c.handle(m)
+	}
+}
+
+// handle 处理收到的消息
+func (c *Client) handle(m *Message) {
+	switch m.Type {
+	case Acknowledgement, Reset:
+		c.mu.Lock()
+		ex := c.pendingID[m.MessageID]
+		c.mu.Unlock()
+		if ex == nil {
+			return
+		}
+		switch {
+		case m.Type == Reset:
+			ex.deliverErr(ErrReset)
+		case m.Code == Empty:
+			ex.ackOnce.Do(func() { close(ex.acked) })
+		default:
+			ex.deliver(m)
+		}
+		return
+	}
+	// CON、NON：响应或观察通知，服务端发起的请求不支持
+	if m.Code.Class() < 2 {
+		if m.Type == Confirmable {
+			c.reply(Reset, m.MessageID)
+		}
+		return
+	}
+	if m.Type == Confirmable && c.duplicate(m.MessageID) {
+		c.reply(Acknowledgement, m.MessageID)
+		return
+	}
+	c.mu.Lock()
+	ex := c.pending[string(m.Token)]
+	o := c.observers[string(m.Token)]
+	c.mu.Unlock()
+	switch {
+	case ex != nil:
+		ex.deliver(m)
+	case o != nil:
+		if seq, ok := m.ObserveSeq(); !ok || o.fresh(seq) {
+			go o.handler(m)
+		}
+	default:
+		if m.Type == Confirmable {
+			// 未知的 Token，拒绝后服务端会删除对应的观察关系
+			c.reply(Reset, m.MessageID)
+		}
+		return
+	}
+	if m.Type == Confirmable {
+		c.reply(Acknowledgement, m.MessageID)
+	}
+}
+
+// duplicate 是否为已处理过的 CON 消息，同时清理过期的记录
+func (c *Client) duplicate(messageID uint16) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if t, ok := c.seen[messageID]; ok && now.Sub(t) < exchangeLifetime {
+		return true
+	}
+	for id, t := range c.seen {
+		if now.Sub(t) >= exchangeLifetime {
+			delete(c.seen, id)
+		}
+	}
+	c.seen[messageID] = now
+	return false
+}
+
+// deliver 投递响应，重复的响应忽略
+func (ex *exchange) deliver(m *Message) {
+	select {
+	case ex.resp <- m:
+	default:
+	}
+}
+
+// deliverErr 投递错误，重复的错误忽略
+func (ex *exchange) deliverErr(err error) {
+	select {
+	case ex.err <- err:
+	default:
+	}
+}
@@ -0,0 +1,189 @@
+// Package coaptest 用于测试的本地 CoAP 服务端
+package coaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"iot-sdk-go/pkg/coap"
+)
+
+// Handler 处理请求，返回响应码与负载
+type Handler func(m *coap.Message) (coap.Code, []byte)
+
+// observer 观察资源的客户端
+type observer struct {
+	addr  net.Addr
+	token []byte
+}
+
+// Server 监听 127.0.0.1 随机端口的 CoAP 服务端。收到的请求写入 Requests，
+// 客户端发送的 ACK、RST 写入 Replies，通道满时丢弃。
+// Observe 为 0 的 GET 请求登记观察关系，为 1 时注销，之后可以通过 Notify 向观察者发送通知，
+// 客户端以 RST 拒绝通知时删除对应的观察关系
+type Server struct {
+	// Addr 监听地址，host:port
+	Addr     string
+	Requests chan *coap.Message
+	Replies  chan *coap.Message
+
+	conn    net.PacketConn
+	handler Handler
+
+	mu        sync.Mutex
+	drop      int
+	seq       uint32
+	messageID uint16
+	observers map[string]map[string]*observer
+	// notified 通知的消息 ID 对应的观察关系，收到 RST 时删除
+	notified map[uint16][2]string
+}
+
+// NewServer 创建并启动服务端，handler 为 nil 时 POST 返回 2.04，其他请求返回 2.05
+func NewServer(handler Handler) (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:      conn.LocalAddr().String(),
+		Requests:  make(chan *coap.Message, 100),
+		Replies:   make(chan *coap.Message, 100),
+		conn:      conn,
+		handler:   handler,
+		seq:       1,
+		observers: make(map[string]map[string]*observer),
+		notified:  make(map[uint16][2]string),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Close 停止服务端
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Drop 丢弃接下来的 n 个请求，用于测试重传
+func (s *Server) Drop(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop = n
+}
+
+// Observers 观察 path 的客户端数量，忽略 path 首尾的 /
+func (s *Server) Observers(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.observers[strings.Trim(path, "/")])
+}
+
+// Notify 向观察 path 的客户端发送 CON 通知，返回发送的数量，忽略 path 首尾的 /
+func (s *Server) Notify(path string, payload []byte) (int, error) {
+	path = strings.Trim(path, "/")
+	s.mu.Lock()
+	s.seq++
+	var msgs []*coap.Message
+	var addrs []net.Addr
+	for key, o := range s.observers[path] {
+		s.messageID++
+		s.notified[s.messageID] = [2]string{path, key}
+		m := &coap.Message{Type: coap.Confirmable, Code: coap.Content, MessageID: s.messageID, Token: o.token, Payload: payload}
+		m.SetObserve(s.seq)
+		msgs = append(msgs, m)
+		addrs = append(addrs, o.addr)
+	}
+	s.mu.Unlock()
+	for i, m := range msgs {
+		if err := s.send(m, addrs[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// send 发送消息
+func (s *Server) send(m *coap.Message, addr net.Addr) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteTo(data, addr)
+	return err
+}
+
+// offer 写入通道，通道满时丢弃
+func offer(ch chan *coap.Message, m *coap.Message) {
+	select {
+	case ch <- m:
+	default:
+	}
+}
+
+// serve 接收并处理消息，直到服务端关闭
+func (s *Server) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m, err := coap.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		if m.Type == coap.Acknowledgement || m.Type == coap.Reset {
+			s.mu.Lock()
+			if n, ok := s.notified[m.MessageID]; ok && m.Type == coap.Reset {
+				delete(s.observers[n[0]], n[1])
+			}
+			delete(s.notified, m.MessageID)
+			s.mu.Unlock()
+			offer(s.Replies, m)
+			continue
+		}
+		s.mu.Lock()
+		if s.drop > 0 {
+			s.drop--
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Unlock()
+		offer(s.Requests, m)
+		s.respond(m, addr)
+	}
+}
+
+// respond 处理请求并回复，CON 请求的响应携带在 ACK 中
+func (s *Server) respond(m *coap.Message, addr net.Addr) {
+	code, payload := coap.Content, []byte(nil)
+	if m.Code == coap.POST {
+		code = coap.Changed
+	}
+	if s.handler != nil {
+		code, payload = s.handler(m)
+	}
+	resp := &coap.Message{Type: coap.Acknowledgement, Code: code, MessageID: m.MessageID, Token: m.Token, Payload: payload}
+	s.mu.Lock()
+	if m.Type != coap.Confirmable {
+		resp.Type = coap.NonConfirmable
+		s.messageID++
+		resp.MessageID = s.messageID
+	}
+	if seq, ok := m.ObserveSeq(); ok && m.Code == coap.GET && code.IsSuccess() {
+		path := m.Path()
+		switch seq {
+		case 0:
+			if s.observers[path] == nil {
+				s.observers[path] = make(map[string]*observer)
+			}
+			s.observers[path][addr.String()+"/"+string(m.Token)] = &observer{addr: addr, token: m.Token}
+			resp.SetObserve(s.seq)
+		case 1:
+			delete(s.observers[path], addr.String()+"/"+string(m.Token))
+		}
+	}
+	s.mu.Unlock()
+	s.send(resp, addr)
+}
@@ -0,0 +1,364 @@
+// Package coap 精简的 CoAP（RFC 7252）客户端，支持 CON/NON 请求、确认与重传、分离响应以及 Observe（RFC 7641）
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type 消息类型
+type Type uint8
+
+const (
+	// Confirmable 需要确认的消息，未收到 ACK 时重传
+	Confirmable Type = 0
+	// NonConfirmable 不需要确认的消息
+	NonConfirmable Type = 1
+	// Acknowledgement 确认消息，可以携带响应（piggybacked response）
+	Acknowledgement Type = 2
+	// Reset 拒绝消息，收到无法处理的消息时发送
+	Reset Type = 3
+)
+
+// String 消息类型名称
+func (t Type) String() string {
+	switch t {
+	case Confirmable:
+		return "CON"
+	case NonConfirmable:
+		return "NON"
+	case Acknowledgement:
+		return "ACK"
+	case Reset:
+		return "RST"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// Code 请求方法或响应码，高 3 位为类别，低 5 位为详情，如 2.05 为 Code(2<<5|5)
+type Code uint8
+
+const (
+	// Empty 空消息，用于单独的 ACK、RST
+	Empty Code = 0
+	// GET 请求方法
+	GET Code = 1
+	// POST 请求方法
+	POST Code = 2
+	// PUT 请求方法
+	PUT Code = 3
+	// DELETE 请求方法
+	DELETE Code = 4
+
+	// Created 2.01
+	Created Code = 65
+	// Deleted 2.02
+	Deleted Code = 66
+	// Valid 2.03
+	Valid Code = 67
+	// Changed 2.04
+	Changed Code = 68
+	// Content 2.05
+	Content Code = 69
+	// BadRequest 4.00
+	BadRequest Code = 128
+	// Unauthorized 4.01
+	Unauthorized Code = 129
+	// Forbidden 4.03
+	Forbidden Code = 131
+	// NotFound 4.04
+	NotFound Code = 132
+	// MethodNotAllowed 4.05
+	MethodNotAllowed Code = 133
+	// InternalServerError 5.00
+	InternalServerError Code = 160
+	// ServiceUnavailable 5.03
+	ServiceUnavailable Code = 163
+)
+
+// Class 响应码类别，2 为成功，4 为客户端错误，5 为服务端错误
+func (c Code) Class() uint8 {
+	return uint8(c) >> 5
+}
+
+// IsSuccess 是否为成功的响应（2.xx）
+func (c Code) IsSuccess() bool {
+	return c.Class() == 2
+}
+
+// String 响应码的点分形式，如 2.05
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c.Class(), uint8(c)&0x1f)
+}
+
+// OptionID 选项编号
+type OptionID uint16
+
+const (
+	// Observe 观察，注册时为 0，取消时为 1，通知中为序号
+	Observe OptionID = 6
+	// URIPath 路径中的一段，每段一个选项
+	URIPath OptionID = 11
+	// ContentFormat 内容格式
+	ContentFormat OptionID = 12
+	// MaxAge 最大缓存时间，单位秒
+	MaxAge OptionID = 14
+	// URIQuery 查询参数中的一项，每项一个选项
+	URIQuery OptionID = 15
+)
+
+// 常用的内容格式
+const (
+	// TextPlain text/plain;charset=utf-8
+	TextPlain uint32 = 0
+	// OctetStream application/octet-stream
+	OctetStream uint32 = 42
+	// AppJSON application/json
+	AppJSON uint32 = 50
+)
+
+// payloadMarker 选项与负载之间的分隔符
+const payloadMarker = 0xff
+
+// MaxTokenLength Token 最大长度
+const MaxTokenLength = 8
+
+// ErrInvalidMessage 消息格式错误
+var ErrInvalidMessage = errors.New("coap: invalid message")
+
+// Option 选项
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+// Message CoAP 消息
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+// AddOption 添加选项，可重复的选项（如 URIPath）按添加顺序编码
+func (m *Message) AddOption(id OptionID, value []byte) {
+	m.Options = append(m.Options, Option{ID: id, Value: value})
+}
+
+// SetOption 设置选项，删除已有的同名选项
+func (m *Message) SetOption(id OptionID, value []byte) {
+	m.RemoveOption(id)
+	m.AddOption(id, value)
+}
+
+// RemoveOption 删除选项
+func (m *Message) RemoveOption(id OptionID) {
+	options := m.Options[:0]
+	for _, o := range m.Options {
+		if o.ID != id {
+			options = append(options, o)
+		}
+	}
+	m.Options = options
+}
+
+// Option 第一个指定编号的选项
+func (m *Message) Option(id OptionID) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.ID == id {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// OptionValues 所有指定编号的选项
+func (m *Message) OptionValues(id OptionID) [][]byte {
+	var ret [][]byte
+	for _, o := range m.Options {
+		if o.ID == id {
+			ret = append(ret, o.Value)
+		}
+	}
+	return ret
+}
+
+// SetPath 设置路径，按 / 拆分为多个 URIPath 选项，忽略首尾的 /
+func (m *Message) SetPath(path string) {
+	m.RemoveOption(URIPath)
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment != "" {
+			m.AddOption(URIPath, []byte(segment))
+		}
+	}
+}
+
+// Path 由 URIPath 选项组成的路径，不带首尾的 /
+func (m *Message) Path() string {
+	segments := m.OptionValues(URIPath)
+	parts := make([]string, len(segments))
+	for i, segment := range segments {
+		parts[i] = string(segment)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Queries URIQuery 选项
+func (m *Message) Queries() []string {
+	values := m.OptionValues(URIQuery)
+	ret := make([]string, len(values))
+	for i, v := range values {
+		ret[i] = string(v)
+	}
+	return ret
+}
+
+// SetObserve 设置 Observe 选项
+func (m *Message) SetObserve(v uint32) {
+	m.SetOption(Observe, EncodeUint(v))
+}
+
+// ObserveSeq Observe 选项的值，没有该选项时返回 false
+func (m *Message) ObserveSeq() (uint32, bool) {
+	v, ok := m.Option(Observe)
+	if !ok {
+		return 0, false
+	}
+	return DecodeUint(v), true
+}
+
+// EncodeUint 将整数编码为最短的大端字节序，0 编码为空
+func EncodeUint(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// DecodeUint 解码 EncodeUint 编码的整数
+func DecodeUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// Marshal 编码消息，选项按编号排序，编号相同的选项保持添加顺序
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > MaxTokenLength {
+		return nil, fmt.Errorf("coap: token length %d exceeds %d", len(m.Token), MaxTokenLength)
+	}
+	buf := make([]byte, 4, 4+len(m.Token)+len(m.Payload)+16)
+	buf[0] = 1<<6 | byte(m.Type)<<4 | byte(len(m.Token))
+	buf[1] = byte(m.Code)
+	binary.BigEndian.PutUint16(buf[2:], m.MessageID)
+	buf = append(buf, m.Token...)
+
+	options := make([]Option, len(m.Options))
+	copy(options, m.Options)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].ID < options[j].ID })
+	var prev OptionID
+	for _, o := range options {
+		if len(o.Value) > 65535+269 {
+			return nil, fmt.Errorf("coap: option %d too long", o.ID)
+		}
+		delta, deltaExt := extend(int(o.ID - prev))
+		length, lengthExt := extend(len(o.Value))
+		buf = append(buf, byte(delta<<4|length))
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, o.Value...)
+		prev = o.ID
+	}
+	if len(m.Payload) > 0 {
+		buf = append(buf, payloadMarker)
+		buf = append(buf, m.Payload...)
+	}
+	return buf, nil
+}
+
+// extend 选项差值、长度的 4 位表示与扩展字节
+func extend(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
+
+// readExtended 读取扩展的选项差值或长度
+func readExtended(v int, data []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(data) < 1 {
+			return 0, nil, ErrInvalidMessage
+		}
+		return int(data[0]) + 13, data[1:], nil
+	case 14:
+		if len(data) < 2 {
+			return 0, nil, ErrInvalidMessage
+		}
+		return int(binary.BigEndian.Uint16(data)) + 269, data[2:], nil
+	case 15:
+		return 0, nil, ErrInvalidMessage
+	}
+	return v, data, nil
+}
+
+// Unmarshal 解码消息
+func Unmarshal(data []byte) (*Message, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, ErrInvalidMessage
+	}
+	tokenLen := int(data[0] & 0x0f)
+	if tokenLen > MaxTokenLength || len(data) < 4+tokenLen {
+		return nil, ErrInvalidMessage
+	}
+	m := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:]),
+	}
+	if tokenLen > 0 {
+		m.Token = append([]byte(nil), data[4:4+tokenLen]...)
+	}
+	data = data[4+tokenLen:]
+	var id int
+	for len(data) > 0 {
+		if data[0] == payloadMarker {
+			if len(data) == 1 {
+				return nil, ErrInvalidMessage
+			}
+			m.Payload = append([]byte(nil), data[1:]...)
+			break
+		}
+		delta, length := int(data[0]>>4), int(data[0]&0x0f)
+		var err error
+		if delta, data, err = readExtended(delta, data[1:]); err != nil {
+			return nil, err
+		}
+		if length, data, err = readExtended(length, data); err != nil {
+			return nil, err
+		}
+		if len(data) < length {
+			return nil, ErrInvalidMessage
+		}
+		id += delta
+		m.Options = append(m.Options, Option{ID: OptionID(id), Value: append([]byte(nil), data[:length]...)})
+		data = data[length:]
+	}
+	return m, nil
+}
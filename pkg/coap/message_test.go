@@ -0,0 +1,88 @@
+package coap
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessageMarshal(t *testing.T) {
+	m := &Message{Type: Confirmable, Code: POST, MessageID: 0x1234, Token: []byte{1, 2, 3, 4}}
+	m.SetPath("/devices/1/property/")
+	m.AddOption(URIQuery, []byte("username=1"))
+	m.SetOption(ContentFormat, EncodeUint(OctetStream))
+	m.AddOption(ContentFormat+1000, []byte(strings.Repeat("x", 300)))
+	m.Payload = []byte{0xff, 0x00}
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:4], []byte{0x44, 0x02, 0x12, 0x34}) {
+		t.Errorf("header = % x", data[:4])
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != Confirmable || got.Code != POST || got.MessageID != 0x1234 || !bytes.Equal(got.Token, m.Token) {
+		t.Errorf("unmarshal header = %+v", got)
+	}
+	if got.Path() != "devices/1/property" {
+		t.Errorf("path = %q", got.Path())
+	}
+	if q := got.Queries(); !reflect.DeepEqual(q, []string{"username=1"}) {
+		t.Errorf("queries = %v", q)
+	}
+	if v, ok := got.Option(ContentFormat); !ok || DecodeUint(v) != OctetStream {
+		t.Errorf("content format = %v %v", v, ok)
+	}
+	if v, _ := got.Option(ContentFormat + 1000); len(v) != 300 {
+		t.Errorf("long option length = %d", len(v))
+	}
+	if !bytes.Equal(got.Payload, m.Payload) {
+		t.Errorf("payload = % x", got.Payload)
+	}
+}
+
+func TestObserveOption(t *testing.T) {
+	m := &Message{}
+	m.SetObserve(0)
+	if v, _ := m.Option(Observe); len(v) != 0 {
+		t.Errorf("observe 0 should be encoded as empty, got % x", v)
+	}
+	m.SetObserve(0x010203)
+	if seq, ok := m.ObserveSeq(); !ok || seq != 0x010203 {
+		t.Errorf("observe = %d %v", seq, ok)
+	}
+	if len(m.OptionValues(Observe)) != 1 {
+		t.Error("SetObserve should replace the previous value")
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{0x80, 0x01, 0, 0},       // 版本错误
+		{0x49, 0x01, 0, 0},       // Token 长度超过 8
+		{0x40, 0x01, 0, 0, 0xff}, // 负载标记后没有负载
+		{0x40, 0x01, 0, 0, 0xf0}, // 保留的选项差值
+		{0x40, 0x01, 0, 0, 0xb3, 'a'},
+	} {
+		if _, err := Unmarshal(data); err != ErrInvalidMessage {
+			t.Errorf("Unmarshal(% x) err = %v", data, err)
+		}
+	}
+}
+
+func TestObservationFresh(t *testing.T) {
+	o := &Observation{}
+	if !o.fresh(5) || o.fresh(4) || o.fresh(5) || !o.fresh(6) {
+		t.Error("notifications should be ordered by sequence")
+	}
+	o = &Observation{}
+	o.fresh(1<<24 - 1)
+	if !o.fresh(1) {
+		t.Error("sequence wrap around should be fresh")
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -66,11 +68,15 @@ type Client struct {
 	errors          chan error
 	stop            chan struct{}
 	persist         Store
+	persistent      bool
 	options         ClientOptions
 	lastContact     lastcontact
+	pingInterval    pinginterval
 	pingOutstanding bool
 	connected       bool
-	workers         sync.WaitGroup
+	// closed Disconnect 后为 true，断线重连的循环随之退出
+	closed  bool
+	workers sync.WaitGroup
 }
 
 func (c *Client) RefreshPassword(password string) {
@@ -85,8 +91,11 @@ func NewClient(o *ClientOptions) *Client {
 	c := &Client{}
 	c.options = *o
 
+	// inflight messages are only persisted when a Store is provided
 	if c.options.Store == nil {
 		c.options.Store = NewMemoryStore()
+	} else {
+		c.persistent = true
 	}
 	switch c.options.ProtocolVersion {
 	case 3, 4:
@@ -117,6 +126,13 @@ func (c *Client) setConnected(status bool) {
 	c.connected = status
 }
 
+// isClosed 是否已调用 Disconnect
+func (c *Client) isClosed() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.closed
+}
+
 //ErrNotConnected is the error returned from function calls that are
 //made when the client is not connected to a broker
 var ErrNotConnected = errors.New("Not Connected")
@@ -139,7 +155,7 @@ func (c *Client) Connect() Token {
 		for _, broker := range c.options.Servers {
 		CONN:
 			DEBUG.Println(CLI, "about to write new connect msg")
-			c.conn, err = openConnection(broker, &c.options.TLSConfig, c.options.ConnectTimeout)
+			c.conn, err = c.dial(broker)
 			if err == nil {
 				DEBUG.Println(CLI, "socket connected to broker")
 				switch c.options.ProtocolVersion {
@@ -218,9 +234,8 @@ func (c *Client) Connect() Token {
 		}
 
 		// Take care of any messages in the store
-		//var leftovers []Receipt
 		if c.options.CleanSession == false {
-			//leftovers = c.resume()
+			c.resume()
 		} else {
 			c.persist.Reset()
 		}
@@ -235,6 +250,14 @@ func (c *Client) Connect() Token {
 	return t
 }
 
+// dial opens the network connection to the broker with the configured dialer
+func (c *Client) dial(broker *url.URL) (net.Conn, error) {
+	if c.options.Dialer != nil {
+		return c.options.Dialer(broker, &c.options.TLSConfig, c.options.ConnectTimeout)
+	}
+	return openConnection(broker, &c.options.TLSConfig, c.options.ConnectTimeout)
+}
+
 // internal function used to reconnect the client when it loses its connection
 func (c *Client) reconnect() {
 	DEBUG.Println(CLI, "enter reconnect")
@@ -243,12 +266,26 @@ func (c *Client) reconnect() {
 	var err error
 
 	for rc != 0 {
+		if c.isClosed() {
+			DEBUG.Println(CLI, "client disconnected, stop reconnecting")
+			return
+		}
 		cm := newConnectMsgFromOptions(&c.options)
 
-		for _, broker := range c.options.Servers {
+		servers := c.options.Servers
+		skipped := false
+		if c.options.Reconnecting != nil {
+			if err := c.options.Reconnecting(c); err != nil {
+				WARN.Println(CLI, "reconnect attempt skipped:", err.Error())
+				servers = nil
+				skipped = true
+				rc = packets.ErrNetworkError
+			}
+		}
+		for _, broker := range servers {
 		CONN:
 			DEBUG.Println(CLI, "about to write new connect msg")
-			c.conn, err = openConnection(broker, &c.options.TLSConfig, c.options.ConnectTimeout)
+			c.conn, err = c.dial(broker)
 			if err == nil {
 				DEBUG.Println(CLI, "socket connected to broker")
 				switch c.options.ProtocolVersion {
@@ -286,6 +323,9 @@ func (c *Client) reconnect() {
 				rc = packets.ErrNetworkError
 			}
 		}
+		if rc != 0 && !skipped && c.options.ReconnectFailed != nil {
+			c.options.ReconnectFailed(c)
+		}
 		if rc != 0 {
 			DEBUG.Println(CLI, "Reconnect failed, sleeping for", sleep, "seconds")
 			time.Sleep(time.Duration(sleep) * time.Second)
@@ -295,6 +335,11 @@ func (c *Client) reconnect() {
 		}
 	}
 
+	if c.isClosed() {
+		DEBUG.Println(CLI, "client disconnected while reconnecting")
+		c.conn.Close()
+		return
+	}
 	c.lastContact.update()
 	c.stop = make(chan struct{})
 
@@ -312,10 +357,55 @@ func (c *Client) reconnect() {
 		c.workers.Add(1)
 		go keepalive(c)
 	}
+	if c.options.CleanSession == false {
+		c.resume()
+	}
 	c.workers.Add(1)
 	go incoming(c)
 }
 
+// resume resends the outbound messages left in a persistent store that
+// were not acknowledged by the broker before the last disconnect
+func (c *Client) resume() {
+	if !c.persistent {
+		return
+	}
+	DEBUG.Println(STR, "enter resume")
+	for _, key := range c.persist.All() {
+		if !strings.HasPrefix(key, outboundPrefix) {
+			continue
+		}
+		packet := c.persist.Get(key)
+		if packet == nil {
+			continue
+		}
+		id := mIDFromKey(key)
+		switch p := packet.(type) {
+		case *packets.PublishPacket:
+			p.Dup = true
+			// reuse the token of a message still inflight since the last reconnect
+			token := c.getToken(id)
+			if token == nil {
+				pt := newToken(packets.Publish).(*PublishToken)
+				pt.messageID = id
+				token = pt
+				c.claimID(token, id)
+			}
+			DEBUG.Println(STR, "resending publish, id:", id)
+			c.obound <- &PacketAndToken{p: p, t: token}
+		case *packets.PubrelPacket:
+			if c.getToken(id) == nil {
+				c.claimID(newToken(packets.Publish), id)
+			}
+			DEBUG.Println(STR, "resending pubrel, id:", id)
+			c.oboundP <- &PacketAndToken{p: p, t: nil}
+		default:
+			c.persist.Del(key)
+		}
+	}
+	DEBUG.Println(STR, "exit resume")
+}
+
 // This function is only used for receiving a connack
 // when the connection is first started.
 // This prevents receiving incoming data while resume
@@ -347,6 +437,9 @@ func (c *Client) connect() byte {
 // the specified number of milliseconds to wait for existing work to be
 // completed.
 func (c *Client) Disconnect(quiesce uint) {
+	c.Lock()
+	c.closed = true
+	c.Unlock()
 	if !c.IsConnected() {
 		WARN.Println(CLI, "already disconnected")
 		return
@@ -380,10 +473,14 @@ func (c *Client) internalConnLost(err error) {
 	c.conn.Close()
 	c.workers.Wait()
 	if c.IsConnected() {
+		reconnect := c.options.AutoReconnect
+		if c.options.ShouldReconnect != nil && !c.options.ShouldReconnect(c, err) {
+			reconnect = false
+		}
 		if c.options.OnConnectionLost != nil {
 			go c.options.OnConnectionLost(c, err)
 		}
-		if c.options.AutoReconnect {
+		if reconnect {
 			go c.reconnect()
 		} else {
 			c.setConnected(false)
@@ -450,6 +547,7 @@ func (c *Client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
 	if err := validateTopicAndQos(topic, qos); err != nil {
 		token.err = err
+		token.flowComplete()
 		return token
 	}
 	sub.Topics = append(sub.Topics, topic)
@@ -480,6 +578,7 @@ func (c *Client) SubscribeMultiple(filters map[string]byte, callback MessageHand
 	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
 	if sub.Topics, sub.Qoss, err = validateSubscribeMap(filters); err != nil {
 		token.err = err
+		token.flowComplete()
 		return token
 	}
 
@@ -37,6 +37,7 @@ type message struct {
 	topic     string
 	messageID uint16
 	payload   []byte
+	discarded int
 }
 
 func (m *message) Duplicate() bool {
@@ -63,6 +64,12 @@ func (m *message) Payload() []byte {
 	return m.payload
 }
 
+// PayloadDiscarded returns the size of the payload dropped because it exceeded
+// ClientOptions.MaxIncomingPayload, 0 if the payload was delivered
+func (m *message) PayloadDiscarded() int {
+	return m.discarded
+}
+
 func messageFromPublish(p *packets.PublishPacket) Message {
 	return &message{
 		duplicate: p.Dup,
@@ -71,6 +78,7 @@ func messageFromPublish(p *packets.PublishPacket) Message {
 		topic:     p.TopicName,
 		messageID: p.MessageID,
 		payload:   p.Payload,
+		discarded: p.Discarded,
 	}
 }
 
@@ -51,6 +51,14 @@ func (mids *messageIds) getID(t Token) uint16 {
 	return 0
 }
 
+// claimID registers the token for a known message id, such as the id of
+// a message resumed from a persistent store
+func (mids *messageIds) claimID(t Token, id uint16) {
+	mids.Lock()
+	defer mids.Unlock()
+	mids.index[id] = t
+}
+
 func (mids *messageIds) getToken(id uint16) Token {
 	mids.RLock()
 	defer mids.RUnlock()
@@ -75,7 +75,7 @@ func incoming(c *Client) {
 	DEBUG.Println(NET, "incoming started")
 
 	for {
-		if cp, err = packets.ReadPacket(c.conn); err != nil {
+		if cp, err = packets.ReadPacketLimit(c.conn, c.options.MaxIncomingPayload); err != nil {
 			break
 		}
 		DEBUG.Println(NET, "Received Message")
@@ -113,7 +113,9 @@ func outgoing(c *Client) {
 				msg.MessageID = c.getID(pub.t)
 				pub.t.(*PublishToken).messageID = msg.MessageID
 			}
-			//persist_obound(c.persist, msg)
+			if c.persistent {
+				persistOutbound(c.persist, msg)
+			}
 
 			if c.options.WriteTimeout > 0 {
 				c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
@@ -144,6 +146,9 @@ func outgoing(c *Client) {
 			case *packets.UnsubscribePacket:
 				msg.p.(*packets.UnsubscribePacket).MessageID = c.getID(msg.t)
 			}
+			if c.persistent {
+				persistOutbound(c.persist, msg.p)
+			}
 			DEBUG.Println(NET, "obound priority msg to write, type", reflect.TypeOf(msg.p))
 			if err := msg.p.Write(c.conn); err != nil {
 				ERROR.Println(NET, "outgoing stopped with error")
@@ -175,7 +180,9 @@ func alllogic(c *Client) {
 		select {
 		case msg := <-c.ibound:
 			DEBUG.Println(NET, "logic got msg on ibound")
-			//persist_ibound(c.persist, msg)
+			if c.persistent {
+				persistInbound(c.persist, msg)
+			}
 			switch msg.(type) {
 			case *packets.PingrespPacket:
 				DEBUG.Println(NET, "received pingresp")
@@ -187,6 +194,10 @@ func alllogic(c *Client) {
 				DEBUG.Println(NET, "granted qoss", sa.GrantedQoss)
 				for i, qos := range sa.GrantedQoss {
 					token.subResult[token.subs[i]] = qos
+					// the broker rejected this topic, stop routing messages for it
+					if qos == packets.ErrSubscribeFailure {
+						c.msgRouter.deleteRoute(token.subs[i])
+					}
 				}
 				token.flowComplete()
 				go c.freeID(sa.MessageID)
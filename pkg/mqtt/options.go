@@ -16,6 +16,7 @@ package mqtt
 
 import (
 	"crypto/tls"
+	"net"
 	"net/url"
 	"time"
 )
@@ -31,6 +32,24 @@ type MessageHandler func(*Client, Message)
 // not cause an OnConnectionLost callback to execute.
 type ConnectionLostHandler func(*Client, error)
 
+// ShouldReconnectHandler is a callback that is called synchronously after an
+// unintended disconnection, before the ConnectionLostHandler is executed.
+// Returning false stops the client from reconnecting for this disconnection.
+type ShouldReconnectHandler func(*Client, error) bool
+
+// ReconnectingHandler is a callback that is called synchronously before each
+// automatic reconnect attempt. Returning an error skips the attempt, the client
+// sleeps and tries again later.
+type ReconnectingHandler func(*Client) error
+
+// ReconnectFailedHandler is a callback that is called synchronously after an
+// automatic reconnect attempt fails to connect to any of the brokers.
+type ReconnectFailedHandler func(*Client)
+
+// DialFunc is a function used to open the network connection to a broker
+// instead of the built in tcp, tls and websocket dialers.
+type DialFunc func(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.Conn, error)
+
 // OnConnectHandler is a callback that is called when the client
 // state changes from unconnected/disconnected to connected. Both
 // at initial connection and on reconnection
@@ -60,7 +79,12 @@ type ClientOptions struct {
 	DefaultPublishHander    MessageHandler
 	OnConnect               OnConnectHandler
 	OnConnectionLost        ConnectionLostHandler
+	ShouldReconnect         ShouldReconnectHandler
+	Reconnecting            ReconnectingHandler
+	ReconnectFailed         ReconnectFailedHandler
 	WriteTimeout            time.Duration
+	MaxIncomingPayload      int
+	Dialer                  DialFunc
 }
 
 // NewClientOptions will create a new ClientClientOptions type with some
@@ -95,7 +119,11 @@ func NewClientOptions() *ClientOptions {
 		Store:                   nil,
 		OnConnect:               nil,
 		OnConnectionLost:        DefaultConnectionLostHandler,
+		ShouldReconnect:         nil,
+		Reconnecting:            nil,
+		ReconnectFailed:         nil,
 		WriteTimeout:            0, // 0 represents timeout disabled
+		Dialer:                  nil,
 	}
 	return o
 }
@@ -171,6 +199,15 @@ func (o *ClientOptions) SetStore(s Store) *ClientOptions {
 	return o
 }
 
+// SetMaxIncomingPayload sets the largest payload in bytes of a received
+// message. Larger payloads are read off the wire and dropped without being
+// buffered, the message is still delivered and acknowledged with an empty
+// payload. 0 means no limit.
+func (o *ClientOptions) SetMaxIncomingPayload(n int) *ClientOptions {
+	o.MaxIncomingPayload = n
+	return o
+}
+
 // SetKeepAlive will set the amount of time (in seconds) that the client
 // should wait before sending a PING request to the broker. This will
 // allow the client to know that a connection has not been lost with the
@@ -239,6 +276,29 @@ func (o *ClientOptions) SetConnectionLostHandler(onLost ConnectionLostHandler) *
 	return o
 }
 
+// SetShouldReconnectHandler sets the function used to decide whether the client
+// should automatically reconnect after the connection is lost. It is called
+// with the error that caused the disconnection and has no effect when
+// AutoReconnect is disabled.
+func (o *ClientOptions) SetShouldReconnectHandler(should ShouldReconnectHandler) *ClientOptions {
+	o.ShouldReconnect = should
+	return o
+}
+
+// SetReconnectingHandler sets the function called before each automatic
+// reconnect attempt, an error returned from it skips the attempt.
+func (o *ClientOptions) SetReconnectingHandler(reconnecting ReconnectingHandler) *ClientOptions {
+	o.Reconnecting = reconnecting
+	return o
+}
+
+// SetReconnectFailedHandler sets the function called after each failed
+// automatic reconnect attempt.
+func (o *ClientOptions) SetReconnectFailedHandler(failed ReconnectFailedHandler) *ClientOptions {
+	o.ReconnectFailed = failed
+	return o
+}
+
 // SetWriteTimeout puts a limit on how long a mqtt publish should block until it unblocks with a
 // timeout error. A duration of 0 never times out. Default 30 seconds
 func (o *ClientOptions) SetWriteTimeout(t time.Duration) *ClientOptions {
@@ -268,3 +328,11 @@ func (o *ClientOptions) SetAutoReconnect(a bool) *ClientOptions {
 	o.AutoReconnect = a
 	return o
 }
+
+// SetDialer sets the function used to open the network connection to the
+// broker, such as a dialer from a TLS library supporting pre-shared keys.
+// When set it is used for every broker regardless of the URI scheme.
+func (o *ClientOptions) SetDialer(dialer DialFunc) *ClientOptions {
+	o.Dialer = dialer
+	return o
+}
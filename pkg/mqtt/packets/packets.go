@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/pborman/uuid"
 	"io"
+	"io/ioutil"
 )
 
 //ControlPacket defines the interface for structs intended to hold
@@ -122,6 +123,64 @@ func ReadPacket(r io.Reader) (cp ControlPacket, err error) {
 	return cp, nil
 }
 
+//ReadPacketLimit reads a packet like ReadPacket, but the payload of a PUBLISH
+//packet larger than maxPayload bytes is read off the wire and dropped without
+//being buffered. The returned PublishPacket has an empty Payload and Discarded
+//set to the size of the dropped payload. maxPayload <= 0 means no limit.
+func ReadPacketLimit(r io.Reader, maxPayload int) (cp ControlPacket, err error) {
+	if maxPayload <= 0 {
+		return ReadPacket(r)
+	}
+	var fh FixedHeader
+	b := make([]byte, 1)
+
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	fh.unpack(b[0], r)
+	cp = NewControlPacketWithHeader(fh)
+	if cp == nil {
+		return nil, errors.New("Bad data from client")
+	}
+	if fh.MessageType != Publish || fh.RemainingLength <= maxPayload {
+		packetBytes := make([]byte, fh.RemainingLength)
+		_, err = io.ReadFull(r, packetBytes)
+		if err != nil {
+			return nil, err
+		}
+		cp.Unpack(bytes.NewBuffer(packetBytes))
+		return cp, nil
+	}
+	p := cp.(*PublishPacket)
+	lr := io.LimitReader(r, int64(fh.RemainingLength))
+	num := make([]byte, 2)
+	if _, err = io.ReadFull(lr, num); err != nil {
+		return nil, err
+	}
+	topic := make([]byte, binary.BigEndian.Uint16(num))
+	if _, err = io.ReadFull(lr, topic); err != nil {
+		return nil, err
+	}
+	p.TopicName = string(topic)
+	payloadLength := fh.RemainingLength - len(topic) - 2
+	if p.Qos > 0 {
+		if _, err = io.ReadFull(lr, num); err != nil {
+			return nil, err
+		}
+		p.MessageID = binary.BigEndian.Uint16(num)
+		payloadLength -= 2
+	}
+	if payloadLength <= maxPayload {
+		p.Payload = make([]byte, payloadLength)
+		_, err = io.ReadFull(lr, p.Payload)
+		return cp, err
+	}
+	p.Discarded = payloadLength
+	_, err = io.Copy(ioutil.Discard, lr)
+	return cp, err
+}
+
 //NewControlPacket is used to create a new ControlPacket of the type specified
 //by packetType, this is usually done by reference to the packet type constants
 //defined in packets.go. The newly created ControlPacket is empty and a pointer
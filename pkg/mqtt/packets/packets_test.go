@@ -0,0 +1,46 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func makePublish(qos byte, payload []byte) *bytes.Buffer {
+	p := NewControlPacket(Publish).(*PublishPacket)
+	p.Qos = qos
+	p.TopicName = "c"
+	p.MessageID = 7
+	p.Payload = payload
+	buf := new(bytes.Buffer)
+	p.Write(buf)
+	// 追加一个 PINGREQ，确认丢弃的内容没有多读或少读
+	NewControlPacket(Pingreq).Write(buf)
+	return buf
+}
+
+func TestReadPacketLimit(t *testing.T) {
+	for _, qos := range []byte{0, 1} {
+		r := makePublish(qos, make([]byte, 1024))
+		cp, err := ReadPacketLimit(r, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := cp.(*PublishPacket)
+		if p.Discarded != 1024 || len(p.Payload) != 0 || p.TopicName != "c" {
+			t.Fatalf("qos %d: unexpected packet: %+v", qos, p)
+		}
+		if qos > 0 && p.MessageID != 7 {
+			t.Fatalf("message id %d, want 7", p.MessageID)
+		}
+		if cp, err := ReadPacket(r); err != nil || cp.(*PingreqPacket) == nil {
+			t.Fatalf("next packet should be pingreq: %v", err)
+		}
+	}
+	cp, err := ReadPacketLimit(makePublish(1, []byte("on")), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := cp.(*PublishPacket); p.Discarded != 0 || string(p.Payload) != "on" {
+		t.Fatalf("small payload should be read: %+v", p)
+	}
+}
@@ -14,6 +14,9 @@ type PublishPacket struct {
 	TopicName string
 	MessageID uint16
 	Payload   []byte
+	//Discarded is the size of the payload dropped by ReadPacketLimit, 0 if
+	//the payload was read
+	Discarded int
 	uuid      uuid.UUID
 }
 
@@ -7,6 +7,10 @@ import (
 	"io"
 )
 
+//ErrSubscribeFailure is the return code in a suback packet for a topic
+//the server refused to subscribe
+const ErrSubscribeFailure = 0x80
+
 //SubackPacket is an internal representation of the fields of the
 //Suback MQTT packet
 type SubackPacket struct {
@@ -21,6 +21,10 @@ import (
 	"time"
 )
 
+// ErrPingTimeout is the error passed to the connection lost handler when
+// the broker did not answer a keepalive ping in time
+var ErrPingTimeout = errors.New("pingresp not received, disconnecting")
+
 type lastcontact struct {
 	sync.Mutex
 	lasttime time.Time
@@ -39,6 +43,30 @@ func (l *lastcontact) get() time.Time {
 	return l.lasttime
 }
 
+type pinginterval struct {
+	sync.Mutex
+	interval time.Duration
+}
+
+// SetPingInterval changes how long the connection may stay idle before a
+// ping is sent, without reconnecting. The broker enforces the KeepAlive sent
+// in CONNECT, so the interval is capped at KeepAlive; zero restores KeepAlive.
+func (c *Client) SetPingInterval(d time.Duration) {
+	c.pingInterval.Lock()
+	defer c.pingInterval.Unlock()
+	c.pingInterval.interval = d
+}
+
+// PingInterval returns the idle time after which a ping is sent.
+func (c *Client) PingInterval() time.Duration {
+	c.pingInterval.Lock()
+	defer c.pingInterval.Unlock()
+	if c.pingInterval.interval <= 0 || c.pingInterval.interval > c.options.KeepAlive {
+		return c.options.KeepAlive
+	}
+	return c.pingInterval.interval
+}
+
 func keepalive(c *Client) {
 	DEBUG.Println(PNG, "keepalive starting")
 	c.pingOutstanding = false
@@ -52,7 +80,7 @@ func keepalive(c *Client) {
 		default:
 			last := uint(time.Since(c.lastContact.get()).Seconds())
 			//DEBUG.Printf("%s last contact: %d (timeout: %d)", PNG, last, uint(c.options.KeepAlive.Seconds()))
-			if last > uint(c.options.KeepAlive.Seconds()) {
+			if last > uint(c.PingInterval().Seconds()) {
 				if !c.pingOutstanding {
 					DEBUG.Println(PNG, "keepalive sending ping")
 					ping := packets.NewControlPacket(packets.Pingreq).(*packets.PingreqPacket)
@@ -63,7 +91,7 @@ func keepalive(c *Client) {
 				} else {
 					CRITICAL.Println(PNG, "pingresp not received, disconnecting")
 					c.workers.Done()
-					c.internalConnLost(errors.New("pingresp not received, disconnecting"))
+					c.internalConnLost(ErrPingTimeout)
 					return
 				}
 			}
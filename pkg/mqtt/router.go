@@ -64,6 +64,12 @@ func routeIncludesTopic(route, topic string) bool {
 	return match(strings.Split(route, "/"), strings.Split(topic, "/"))
 }
 
+// TopicMatches reports whether the topic matches the subscription filter,
+// which may contain the + and # wildcards
+func TopicMatches(filter, topic string) bool {
+	return filter == topic || routeIncludesTopic(filter, topic)
+}
+
 // match takes the topic string of the published message and does a basic compare to the
 // string of the current Route, if they match it returns true
 func (r *route) match(topic string) bool {
@@ -48,19 +48,24 @@ func (c *Command) UnMarshal(buf []byte) error {
 
 func (e *Event) Marshal() ([]byte, error) {
 	buffer := new(bytes.Buffer)
+	if err := e.MarshalTo(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// MarshalTo 序列化并写入 buffer，便于调用方复用缓冲区
+func (e *Event) MarshalTo(buffer *bytes.Buffer) error {
 	err := binary.Write(buffer, binary.BigEndian, e.Head)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for _, param := range e.Params {
-		err = binary.Write(buffer, binary.BigEndian, param.ToBinary())
-		if err != nil {
-			return nil, err
-		}
+	for i := range e.Params {
+		e.Params[i].WriteBinary(buffer)
 	}
 
-	return buffer.Bytes(), nil
+	return nil
 }
 
 func (e *Event) UnMarshal(buf []byte) error {
@@ -83,25 +88,30 @@ func (e *Event) UnMarshal(buf []byte) error {
 
 func (d *Data) Marshal() ([]byte, error) {
 	buffer := new(bytes.Buffer)
+	if err := d.MarshalTo(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// MarshalTo 序列化并写入 buffer，便于调用方复用缓冲区
+func (d *Data) MarshalTo(buffer *bytes.Buffer) error {
 	err := binary.Write(buffer, binary.BigEndian, d.Head)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	for _, sub := range d.SubData {
 		err = binary.Write(buffer, binary.BigEndian, sub.Head)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for _, param := range sub.Params {
-			err = binary.Write(buffer, binary.BigEndian, param.ToBinary())
-			if err != nil {
-				return nil, err
-			}
+		for i := range sub.Params {
+			sub.Params[i].WriteBinary(buffer)
 		}
 	}
 
-	return buffer.Bytes(), nil
+	return nil
 }
 
 func (d *Data) UnMarshal(buf []byte) error {
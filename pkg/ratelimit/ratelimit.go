@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter 限流器
+type Limiter interface {
+	// Wait 阻塞直到允许执行下一次请求
+	Wait()
+}
+
+// IntervalLimiter 固定间隔限流器，多个协程共享时相邻两次请求至少间隔 Interval
+type IntervalLimiter struct {
+	Interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// NewIntervalLimiter 创建固定间隔限流器
+func NewIntervalLimiter(interval time.Duration) *IntervalLimiter {
+	return &IntervalLimiter{Interval: interval}
+}
+
+// NewRateLimiter 创建每秒最多 n 次请求的限流器
+func NewRateLimiter(n int) *IntervalLimiter {
+	if n <= 0 {
+		return NewIntervalLimiter(0)
+	}
+	return NewIntervalLimiter(time.Second / time.Duration(n))
+}
+
+// Wait 阻塞直到允许执行下一次请求
+func (l *IntervalLimiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	l.next = now.Add(wait + l.Interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
@@ -0,0 +1,221 @@
+// Package redis 精简的 Redis 客户端，使用 RESP 协议，只支持单连接的请求-响应命令
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout 默认的连接、读写超时时间
+const DefaultTimeout = 5 * time.Second
+
+// Error 服务端返回的错误
+type Error string
+
+// Error 错误信息
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrClosed 客户端已关闭
+var ErrClosed = errors.New("redis: client closed")
+
+// Options 连接配置
+type Options struct {
+	// Addr 服务端地址，host:port
+	Addr string
+	// Username Redis 6 ACL 用户名，为空时只使用 Password 认证
+	Username string
+	// Password 密码，为空时不认证
+	Password string
+	// DB 数据库编号
+	DB int
+	// Timeout 连接、每个命令的读写超时时间，为 0 时使用 DefaultTimeout
+	Timeout time.Duration
+	// Dial 建立连接，为 nil 时使用 net.Dialer
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+// Client Redis 客户端，并发调用时命令依次执行。连接断开后下一个命令自动重连
+type Client struct {
+	opts Options
+
+	mu     sync.Mutex
+	conn   net.Conn
+	rd     *bufio.Reader
+	closed bool
+}
+
+// Dial 创建客户端，建立连接并完成认证、选择数据库
+func Dial(opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	c := &Client{opts: opts}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect 建立连接，调用方持有 mu
+func (c *Client) connect() error {
+	dial := c.opts.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: c.opts.Timeout}).Dial
+	}
+	conn, err := dial("tcp", c.opts.Addr)
+	if err != nil {
+		return err
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.opts.Password != "" {
+		if c.opts.Username != "" {
+			setup = append(setup, []string{"AUTH", c.opts.Username, c.opts.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.opts.Password})
+		}
+	}
+	if c.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+// drop 关闭当前连接，下一个命令重新连接
+func (c *Client) drop() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.rd = nil, nil
+	}
+}
+
+// Close 关闭客户端
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.drop()
+	return nil
+}
+
+// Do 执行命令，返回值为 string（状态回复）、int64、[]byte（批量回复，不存在时为 nil）或 []interface{}，
+// 服务端返回错误时为 Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args)
+	if _, ok := err.(Error); err != nil && !ok {
+		// 网络错误后连接状态未知，丢弃连接
+		c.drop()
+	}
+	return reply, err
+}
+
+// roundTrip 发送命令并读取回复，调用方持有 mu
+func (c *Client) roundTrip(args []string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if _, err := c.conn.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return ReadReply(c.rd)
+}
+
+// encodeCommand 编码为 RESP 数组
+func encodeCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readLine 读取一行，不包含 \r\n
+func readLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// ReadReply 读取一个 RESP 回复，服务端的错误回复返回 Error
+func ReadReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := readLine(rd)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			// 数组中的错误作为元素返回，不中断读取
+			v, err := ReadReply(rd)
+			if rerr, ok := err.(Error); ok {
+				v, err = rerr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("+OK\r\n:42\r\n$3\r\na\r\n\r\n$-1\r\n*2\r\n$1\r\nx\r\n-ERR bad\r\n-NOAUTH\r\n"))
+	for _, want := range []interface{}{
+		"OK",
+		int64(42),
+		[]byte("a\r\n"),
+		nil,
+		[]interface{}{[]byte("x"), Error("ERR bad")},
+	} {
+		got, err := ReadReply(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	}
+	if _, err := ReadReply(rd); err != Error("NOAUTH") {
+		t.Fatalf("expect NOAUTH error, got %v", err)
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	got := string(encodeCommand([]string{"SET", "k", "a\r\nb"}))
+	if want := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,261 @@
+// Package redistest 用于测试的内存 Redis 服务端，支持 PING、AUTH、SELECT、GET、SET（EX、PX）、DEL、EXISTS、PTTL。
+//
+// 与 pkg/coap/coaptest 相同，测试服务端随客户端放在仓库内，而不是使用 miniredis：
+// SDK 的协议实现（pkg/mqtt、pkg/coap、pkg/redis）都不依赖第三方库，miniredis 会为测试引入 gopher-lua 等依赖，
+// 且 storage.Redis 只用到上面几个命令
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-sdk-go/pkg/redis"
+)
+
+// entry 保存的值
+type entry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// Server 监听 127.0.0.1 随机端口的内存 Redis 服务端
+type Server struct {
+	// Addr 监听地址，host:port
+	Addr string
+
+	// password 不为空时连接需要先 AUTH，只在创建时设置
+	password string
+	ln       net.Listener
+
+	mu       sync.Mutex
+	dbs      map[int]map[string]*entry
+	commands int
+	conns    map[net.Conn]bool
+}
+
+// Option 服务端配置函数
+type Option func(*Server)
+
+// WithPassword 设置密码，连接需要先 AUTH
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.password = password
+	}
+}
+
+// NewServer 创建并启动服务端
+func NewServer(opts ...Option) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: ln.Addr().String(), ln: ln, dbs: make(map[int]map[string]*entry), conns: make(map[net.Conn]bool)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Close 停止服务端，关闭已建立的连接
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.CloseConns()
+	return err
+}
+
+// CloseConns 关闭已建立的连接，服务端继续接受新连接，用于测试重连
+func (s *Server) CloseConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// Commands 收到的命令数
+func (s *Server) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// Get 直接读取 db 中的值，不存在或已过期时返回 false
+func (s *Server) Get(db int, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(db, key)
+	if e == nil {
+		return "", false
+	}
+	return string(e.value), true
+}
+
+// TTL db 中 key 的剩余有效期，没有设置有效期时返回 0
+func (s *Server) TTL(db int, key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(db, key)
+	if e == nil || e.expireAt.IsZero() {
+		return 0
+	}
+	return time.Until(e.expireAt)
+}
+
+// FastForward 所有 key 的有效期减少 d，用于测试过期
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, db := range s.dbs {
+		for _, e := range db {
+			if !e.expireAt.IsZero() {
+				e.expireAt = e.expireAt.Add(-d)
+			}
+		}
+	}
+}
+
+// lookup 查找未过期的值，调用方持有 mu
+func (s *Server) lookup(db int, key string) *entry {
+	e := s.dbs[db][key]
+	if e == nil {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		delete(s.dbs[db], key)
+		return nil
+	}
+	return e
+}
+
+// serve 接受连接
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle 处理一个连接上的命令
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	rd := bufio.NewReader(conn)
+	db, authed := 0, s.password == ""
+	for {
+		req, err := redis.ReadReply(rd)
+		if err != nil {
+			return
+		}
+		items, ok := req.([]interface{})
+		if !ok || len(items) == 0 {
+			conn.Write([]byte("-ERR protocol error\r\n"))
+			return
+		}
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[len(args)-1] != s.password || s.password == "" {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			} else {
+				authed = true
+				reply = "+OK\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT" && len(args) == 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 || n > 15 {
+				reply = "-ERR DB index is out of range\r\n"
+			} else {
+				db = n
+				reply = "+OK\r\n"
+			}
+		default:
+			reply = s.exec(db, cmd, args[1:])
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// exec 执行数据命令，返回编码后的回复
+func (s *Server) exec(db int, cmd string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+	if s.dbs[db] == nil {
+		s.dbs[db] = make(map[string]*entry)
+	}
+	switch {
+	case cmd == "PING":
+		return "+PONG\r\n"
+	case cmd == "GET" && len(args) == 1:
+		e := s.lookup(db, args[0])
+		if e == nil {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(e.value), e.value)
+	case cmd == "SET" && (len(args) == 2 || len(args) == 4):
+		e := &entry{value: []byte(args[1])}
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || n <= 0 {
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+			switch strings.ToUpper(args[2]) {
+			case "EX":
+				e.expireAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				e.expireAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			default:
+				return "-ERR syntax error\r\n"
+			}
+		}
+		s.dbs[db][args[0]] = e
+		return "+OK\r\n"
+	case (cmd == "DEL" || cmd == "EXISTS") && len(args) > 0:
+		n := 0
+		for _, key := range args {
+			if s.lookup(db, key) != nil {
+				n++
+				if cmd == "DEL" {
+					delete(s.dbs[db], key)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case cmd == "PTTL" && len(args) == 1:
+		e := s.lookup(db, args[0])
+		switch {
+		case e == nil:
+			return ":-2\r\n"
+		case e.expireAt.IsZero():
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(e.expireAt)/time.Millisecond)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd)
+}
@@ -0,0 +1,49 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+)
+
+// errPanicked fn panic 时等待中的调用得到的错误
+var errPanicked = errors.New("singleflight: call panicked")
+
+// call 正在执行或已完成的一次调用
+type call struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+// Group 合并相同 key 的并发调用，同一时刻只会执行一次
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do 执行 fn，并发调用相同 key 时共享同一次执行及其结果
+func (g *Group) Do(key string, fn func() error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// fn panic 时也要唤醒等待者并删除记录，否则之后相同 key 的调用会永远阻塞
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.err = errPanicked
+	c.err = fn()
+	return c.err
+}
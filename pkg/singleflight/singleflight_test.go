@@ -0,0 +1,38 @@
+package singleflight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoPanic(t *testing.T) {
+	g := &Group{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		defer func() { recover() }()
+		g.Do("k", func() error {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() { done <- g.Do("k", func() error { return nil }) }()
+	// 等待第二次调用加入后再 panic
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	select {
+	case err := <-done:
+		if err != errPanicked {
+			t.Fatalf("got %v, want errPanicked", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after panic")
+	}
+	// 记录已删除，之后的调用正常执行
+	if err := g.Do("k", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
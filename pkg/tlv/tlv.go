@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iot-sdk-go/pkg/typeconv"
 )
 
 // 定义数据类型
@@ -23,6 +24,14 @@ const (
 	TLVBYTES   = 11
 	TLVSTRING  = 12
 	TLVBOOL    = 13
+	// TLVQUALITY 数据质量标记，值为 1 字节质量码
+	TLVQUALITY = 14
+	// TLVVERSION 属性版本标记，值为 8 字节大端序版本号
+	TLVVERSION = 15
+	// TLVTIMESTAMP 属性采集时间标记，值为 8 字节大端序毫秒时间戳
+	TLVTIMESTAMP = 16
+	// TLVINCREMENT 增量标记，值为 8 字节大端序增量序号，带该标记的属性值为增量
+	TLVINCREMENT = 17
 )
 
 // TLV type length value
@@ -55,6 +64,13 @@ func (tlv *TLV) ToBinary() []byte {
 	return buf.Bytes()
 }
 
+// WriteBinary write tlv to buffer, same as ToBinary without intermediate allocation
+func (tlv *TLV) WriteBinary(buf *bytes.Buffer) {
+	buf.WriteByte(byte(tlv.Tag >> 8))
+	buf.WriteByte(byte(tlv.Tag))
+	buf.Write(tlv.Value)
+}
+
 // Length get tlv length
 func (tlv *TLV) Length() int {
 	length := int(0)
@@ -79,6 +95,10 @@ func (tlv *TLV) Length() int {
 		length = 1
 	case TLVUINT8:
 		length = 1
+	case TLVQUALITY:
+		length = 1
+	case TLVVERSION, TLVTIMESTAMP, TLVINCREMENT:
+		length = 8
 	case TLVBYTES:
 		length = int(byteToUint16(tlv.Value[0:2]))
 		length += 2
@@ -139,6 +159,14 @@ func (tlv *TLV) FromBinary(r io.Reader) error {
 		length = 1
 		tlv.Value = make([]byte, length)
 		binary.Read(r, binary.BigEndian, &tlv.Value)
+	case TLVQUALITY:
+		length = 1
+		tlv.Value = make([]byte, length)
+		binary.Read(r, binary.BigEndian, &tlv.Value)
+	case TLVVERSION, TLVTIMESTAMP, TLVINCREMENT:
+		length = 8
+		tlv.Value = make([]byte, length)
+		binary.Read(r, binary.BigEndian, &tlv.Value)
 	case TLVBYTES:
 		binary.Read(r, binary.BigEndian, &length)
 		tlv.Value = make([]byte, length+2)
@@ -323,33 +351,45 @@ func ReadTLVs(tlvs []TLV) ([]interface{}, error) {
 	return values, nil
 }
 
-// CastTLV cast tlv
+// CastTLV cast tlv, value is a number (or numeric string) for numeric types and a string for bytes and string.
+// Returns nil when value cannot be converted to valueType
 func CastTLV(value interface{}, valueType int32) interface{} {
+	switch valueType {
+	case TLVBYTES, TLVSTRING:
+		str, err := typeconv.InterfaceToString(value)
+		if err != nil {
+			return nil
+		}
+		if valueType == TLVBYTES {
+			return []byte(str)
+		}
+		return str
+	}
+	f, err := typeconv.InterfaceToFloat64(value)
+	if err != nil {
+		return nil
+	}
 	switch valueType {
 	case TLVFLOAT64:
-		return float64(value.(float64))
+		return f
 	case TLVFLOAT32:
-		return float32(value.(float64))
+		return float32(f)
 	case TLVINT8:
-		return int8(value.(float64))
+		return int8(f)
 	case TLVINT16:
-		return int16(value.(float64))
+		return int16(f)
 	case TLVINT32:
-		return int32(value.(float64))
+		return int32(f)
 	case TLVINT64:
-		return int64(value.(float64))
+		return int64(f)
 	case TLVUINT8:
-		return uint8(value.(float64))
+		return uint8(f)
 	case TLVUINT16:
-		return uint16(value.(float64))
+		return uint16(f)
 	case TLVUINT32:
-		return uint32(value.(float64))
+		return uint32(f)
 	case TLVUINT64:
-		return uint64(value.(float64))
-	case TLVBYTES:
-		return []byte(value.(string))
-	case TLVSTRING:
-		return value.(string)
+		return uint64(f)
 	default:
 		return nil
 	}
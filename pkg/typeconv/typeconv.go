@@ -2,7 +2,10 @@ package typeconv
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"reflect"
+	"strconv"
 	"time"
 )
 
@@ -42,13 +45,110 @@ func InterfaceToInt(v interface{}) (int, error) {
 	return 0, errors.New("interface to int failed")
 }
 
-// InterfaceToBool 接口转Bool
+// InterfaceToInt64 接口转Int64，支持各种整数类型、没有小数部分的浮点数与十进制字符串，与平台的 int 位数无关。
+// 超出 int64 范围或有小数部分时返回错误
+func InterfaceToInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		if uint64(n) <= math.MaxInt64 {
+			return int64(n), nil
+		}
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+	case float32:
+		return floatToInt64(float64(n))
+	case float64:
+		return floatToInt64(n)
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("interface to int64 failed: %v", err)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("interface to int64 failed: unsupported type %T", v)
+}
+
+// floatToInt64 没有小数部分且在 int64 范围内的浮点数转Int64
+func floatToInt64(f float64) (int64, error) {
+	// -2^63 可以精确表示，2^63 超出 int64 范围
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("interface to int64 failed: %v is not an int64", f)
+	}
+	return int64(f), nil
+}
+
+// InterfaceToFloat64 接口转Float64，支持各种整数、浮点数类型与数字字符串
+func InterfaceToFloat64(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int8:
+		return float64(n), nil
+	case int16:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint8:
+		return float64(n), nil
+	case uint16:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("interface to float64 failed: %v", err)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("interface to float64 failed: unsupported type %T", v)
+}
+
+// InterfaceToBool 接口转Bool，支持 bool、strconv.ParseBool 可解析的字符串与值为 0、1 的整数
 func InterfaceToBool(v interface{}) (bool, error) {
-	switch v.(type) {
+	switch b := v.(type) {
 	case bool:
-		return v.(bool), nil
+		return b, nil
+	case string:
+		ret, err := strconv.ParseBool(b)
+		if err != nil {
+			return false, fmt.Errorf("interface to bool failed: %v", err)
+		}
+		return ret, nil
+	}
+	if i, err := InterfaceToInt64(v); err == nil && (i == 0 || i == 1) {
+		return i == 1, nil
 	}
-	return false, errors.New("interface to bool failed")
+	return false, fmt.Errorf("interface to bool failed: %v (%T)", v, v)
 }
 
 // InterfaceToMap 接口转Map
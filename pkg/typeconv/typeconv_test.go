@@ -0,0 +1,97 @@
+package typeconv
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterfaceToInt64(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		want   int64
+		failed bool
+	}{
+		{int(-1), -1, false},
+		{int8(-8), -8, false},
+		{int16(16), 16, false},
+		{int32(math.MaxInt32), math.MaxInt32, false},
+		{int64(math.MaxInt64), math.MaxInt64, false},
+		{uint(7), 7, false},
+		{uint8(8), 8, false},
+		{uint16(16), 16, false},
+		{uint32(math.MaxUint32), math.MaxUint32, false},
+		{uint64(math.MaxInt64), math.MaxInt64, false},
+		{uint64(math.MaxUint64), 0, true},
+		{float64(3), 3, false},
+		{float32(-2), -2, false},
+		{float64(1.5), 0, true},
+		{math.Inf(1), 0, true},
+		{"4294967296", 4294967296, false},
+		{"1.5", 0, true},
+		{true, 0, true},
+		{nil, 0, true},
+	}
+	for _, c := range cases {
+		got, err := InterfaceToInt64(c.v)
+		if (err != nil) != c.failed || got != c.want {
+			t.Errorf("InterfaceToInt64(%#v) = %d, %v, want %d, failed %v", c.v, got, err, c.want, c.failed)
+		}
+	}
+}
+
+func TestInterfaceToFloat64(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		want   float64
+		failed bool
+	}{
+		{float64(1.5), 1.5, false},
+		{float32(0.5), 0.5, false},
+		{int(-1), -1, false},
+		{int8(8), 8, false},
+		{int16(16), 16, false},
+		{int32(32), 32, false},
+		{int64(64), 64, false},
+		{uint(1), 1, false},
+		{uint8(8), 8, false},
+		{uint16(16), 16, false},
+		{uint32(32), 32, false},
+		{uint64(64), 64, false},
+		{"-2.25", -2.25, false},
+		{"1e3", 1000, false},
+		{"abc", 0, true},
+		{[]byte("1"), 0, true},
+		{nil, 0, true},
+	}
+	for _, c := range cases {
+		got, err := InterfaceToFloat64(c.v)
+		if (err != nil) != c.failed || got != c.want {
+			t.Errorf("InterfaceToFloat64(%#v) = %v, %v, want %v, failed %v", c.v, got, err, c.want, c.failed)
+		}
+	}
+}
+
+func TestInterfaceToBool(t *testing.T) {
+	cases := []struct {
+		v      interface{}
+		want   bool
+		failed bool
+	}{
+		{true, true, false},
+		{false, false, false},
+		{"true", true, false},
+		{"0", false, false},
+		{"yes", false, true},
+		{int(1), true, false},
+		{uint8(0), false, false},
+		{float64(1), true, false},
+		{int(2), false, true},
+		{nil, false, true},
+	}
+	for _, c := range cases {
+		got, err := InterfaceToBool(c.v)
+		if (err != nil) != c.failed || got != c.want {
+			t.Errorf("InterfaceToBool(%#v) = %v, %v, want %v, failed %v", c.v, got, err, c.want, c.failed)
+		}
+	}
+}
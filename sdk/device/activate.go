@@ -0,0 +1,65 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WithActivation 设置 AutoLogin 是否在注册后、登录前调用 Activate 激活设备，
+// 用于要求设备激活后才能登录的平台
+func WithActivation(enabled bool) Option {
+	return func(d *Device) {
+		d.Activation = enabled
+	}
+}
+
+// Activate 使用注册得到的设备 ID 与密钥请求 Topics.Activate 激活设备。
+// 设备已经激活时平台返回 409，视为激活成功，重复调用不会返回错误
+func (d *Device) Activate(ctx context.Context) error {
+	return d.flightDo("Activate", func() error {
+		return d.traced("activate", func() error {
+			return d.activate(ctx)
+		})
+	})
+}
+
+func (d *Device) activate(ctx context.Context) error {
+	c := d.Credentials()
+	if c.ID == 0 || c.Secret == "" {
+		return errors.New("device activate failed, field ID and Secret cannot be empty, register first")
+	}
+	args, err := json.Marshal(ActivateArgs{ID: c.ID, Secret: c.Secret})
+	if err != nil {
+		return errors.Wrap(err, "device activate failed, activate arguments convert to json failed")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Topics.Activate, strings.NewReader(string(args)))
+	if err != nil {
+		return errors.Wrap(err, "device activate failed, create request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	jsonresp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "device activate failed, request activate rest api failed")
+	}
+	defer jsonresp.Body.Close()
+	if jsonresp.StatusCode == http.StatusConflict {
+		return nil
+	}
+	body, err := ioutil.ReadAll(jsonresp.Body)
+	if err != nil {
+		return errors.Wrap(err, "device activate failed, read response failed")
+	}
+	response := ActivateResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return errors.Wrap(err, "device activate failed, activate rest api response convert to json failed")
+	}
+	if err := HTTPIsOK(response); err != nil {
+		return errors.Wrap(err, "device activate failed, activate rest api state not is ok")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/trace"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AlarmSeverity 告警级别
+type AlarmSeverity uint8
+
+const (
+	// AlarmWarning 警告
+	AlarmWarning AlarmSeverity = 1
+	// AlarmMinor 次要告警
+	AlarmMinor AlarmSeverity = 2
+	// AlarmMajor 主要告警
+	AlarmMajor AlarmSeverity = 3
+	// AlarmCritical 严重告警
+	AlarmCritical AlarmSeverity = 4
+)
+
+// AlarmState 告警状态
+type AlarmState uint8
+
+const (
+	// AlarmCleared 已清除
+	AlarmCleared AlarmState = 0
+	// AlarmRaised 已产生，等待确认或清除
+	AlarmRaised AlarmState = 1
+	// AlarmAcknowledged 已确认，仍未清除
+	AlarmAcknowledged AlarmState = 2
+)
+
+// ErrAlarmNotActive 告警未产生或已清除
+var ErrAlarmNotActive = errors.New("alarm not active")
+
+// Alarm 告警
+type Alarm struct {
+	ID          uint16
+	SubDeviceID uint16
+	Severity    AlarmSeverity
+	// State 告警状态，由 RaiseAlarm、AcknowledgeAlarm、ClearAlarm 设置
+	State AlarmState
+	// Params 告警附带的参数，追加在状态与级别之后
+	Params []interface{}
+}
+
+// alarmKey 告警的唯一标识，不同子设备的告警 ID 可以相同
+type alarmKey struct {
+	SubDeviceID uint16
+	ID          uint16
+}
+
+// alarms 未清除的告警，为 nil 时（未通过 New 创建设备）不跟踪
+type alarms struct {
+	mu     sync.Mutex
+	active map[alarmKey]Alarm
+	// pending 正在上报产生的告警，避免并发重复上报
+	pending map[alarmKey]struct{}
+}
+
+// newAlarms 创建 alarms 对象
+func newAlarms() *alarms {
+	return &alarms{
+		active:  make(map[alarmKey]Alarm),
+		pending: make(map[alarmKey]struct{}),
+	}
+}
+
+// RaiseAlarm 上报告警产生，发布到 Topics.Alarm。告警按 SubDeviceID 与 ID 区分，未清除时重复调用不会再次上报，
+// 上报失败时告警不记为产生，可以重试
+func (d *Device) RaiseAlarm(a Alarm) error {
+	if d.alarms == nil {
+		return d.publishAlarm(a, AlarmRaised)
+	}
+	key := alarmKey{SubDeviceID: a.SubDeviceID, ID: a.ID}
+	d.alarms.mu.Lock()
+	_, active := d.alarms.active[key]
+	_, pending := d.alarms.pending[key]
+	if active || pending {
+		d.alarms.mu.Unlock()
+		return nil
+	}
+	d.alarms.pending[key] = struct{}{}
+	d.alarms.mu.Unlock()
+
+	err := d.publishAlarm(a, AlarmRaised)
+	d.alarms.mu.Lock()
+	defer d.alarms.mu.Unlock()
+	delete(d.alarms.pending, key)
+	if err != nil {
+		return err
+	}
+	a.State = AlarmRaised
+	d.alarms.active[key] = a
+	return nil
+}
+
+// AcknowledgeAlarm 上报设备自身（SubDeviceID 为 0）的告警已确认，告警未产生时返回 ErrAlarmNotActive，已确认时不再上报
+func (d *Device) AcknowledgeAlarm(id uint16) error {
+	return d.AcknowledgeSubDeviceAlarm(0, id)
+}
+
+// ClearAlarm 上报设备自身（SubDeviceID 为 0）的告警清除，告警未产生或已清除时返回 ErrAlarmNotActive
+func (d *Device) ClearAlarm(id uint16) error {
+	return d.ClearSubDeviceAlarm(0, id)
+}
+
+// AcknowledgeSubDeviceAlarm 上报子设备的告警已确认，同 AcknowledgeAlarm
+func (d *Device) AcknowledgeSubDeviceAlarm(subDeviceID, id uint16) error {
+	return d.transitAlarm(alarmKey{SubDeviceID: subDeviceID, ID: id}, AlarmAcknowledged)
+}
+
+// ClearSubDeviceAlarm 上报子设备的告警清除，同 ClearAlarm
+func (d *Device) ClearSubDeviceAlarm(subDeviceID, id uint16) error {
+	return d.transitAlarm(alarmKey{SubDeviceID: subDeviceID, ID: id}, AlarmCleared)
+}
+
+// transitAlarm 将未清除的告警切换到 state 并上报，上报失败时保持原状态
+func (d *Device) transitAlarm(key alarmKey, state AlarmState) error {
+	if d.alarms == nil {
+		return errors.Wrapf(ErrAlarmNotActive, "alarm %d of sub device %d", key.ID, key.SubDeviceID)
+	}
+	d.alarms.mu.Lock()
+	a, ok := d.alarms.active[key]
+	if !ok {
+		d.alarms.mu.Unlock()
+		return errors.Wrapf(ErrAlarmNotActive, "alarm %d of sub device %d", key.ID, key.SubDeviceID)
+	}
+	if a.State == state {
+		d.alarms.mu.Unlock()
+		return nil
+	}
+	// 先更新状态，并发的切换看到的是新状态，上报失败时恢复
+	if state == AlarmCleared {
+		delete(d.alarms.active, key)
+	} else {
+		next := a
+		next.State = state
+		d.alarms.active[key] = next
+	}
+	d.alarms.mu.Unlock()
+
+	if err := d.publishAlarm(a, state); err != nil {
+		d.alarms.mu.Lock()
+		d.alarms.active[key] = a
+		d.alarms.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ActiveAlarms 未清除的告警，按 SubDeviceID、ID 升序排列
+func (d *Device) ActiveAlarms() []Alarm {
+	if d.alarms == nil {
+		return nil
+	}
+	d.alarms.mu.Lock()
+	defer d.alarms.mu.Unlock()
+	ret := make([]Alarm, 0, len(d.alarms.active))
+	for _, a := range d.alarms.active {
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SubDeviceID != ret[j].SubDeviceID {
+			return ret[i].SubDeviceID < ret[j].SubDeviceID
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// publishAlarm 以高优先级发布告警状态，告警作为事件序列化，事件编号为告警 ID，参数依次为状态、级别和 Params
+func (d *Device) publishAlarm(a Alarm, state AlarmState) error {
+	value := append([]interface{}{uint8(state), uint8(a.Severity)}, a.Params...)
+	alarm := Property{
+		SubDeviceID: a.SubDeviceID,
+		PropertyID:  a.ID,
+		Value:       value,
+	}
+	data, err := d.serializerFor(d.Topics.Alarm).MakeEventData(alarm.toSerializerProperty())
+	if err == nil {
+		data, err = d.encodePayload(data)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "publish alarm %d failed", a.ID)
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.Alarm
+	r.Qos = 1
+	r.Payload = data
+	if err := d.publishWithPriority(PriorityHigh, protocol.OptionsFormatter(*r), trace.Int(trace.AttrPropertyID, int(a.ID))); err != nil {
+		return errors.Wrapf(err, "publish alarm %d failed", a.ID)
+	}
+	return nil
+}
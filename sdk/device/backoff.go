@@ -0,0 +1,50 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sleep 重试前等待，测试时替换
+var sleep = time.Sleep
+
+// BackoffJitter 重试间隔的随机抖动比例，实际间隔在基础间隔的 [1-BackoffJitter, 1+BackoffJitter] 倍之间，
+// 避免大量设备同时断线后按相同的节奏重试
+var BackoffJitter = 0.2
+
+// retryInterval 第 attempt 次重试（从 0 开始）的间隔：interval*BackoffFactor^attempt 加上随机抖动，
+// 不超过 MaxInterval。BackoffFactor 小于 1 时不增长
+func (o InitOptions) retryInterval(interval time.Duration, attempt int) time.Duration {
+	factor := o.BackoffFactor
+	if factor < 1 {
+		factor = 1
+	}
+	d := float64(interval) * math.Pow(factor, float64(attempt))
+	d *= 1 - BackoffJitter + 2*BackoffJitter*rand.Float64()
+	if o.MaxInterval > 0 && d > float64(o.MaxInterval) {
+		return o.MaxInterval
+	}
+	if d > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+// retry err 为第一次调用 fn 的结果，失败时按退避间隔等待后重试，
+// 达到 MaxAttempts（包括第一次调用）时返回最后一次的错误
+func (d *Device) retry(o InitOptions, interval time.Duration, op string, err error, fn func() error) error {
+	for attempt := 1; err != nil; attempt++ {
+		if o.MaxAttempts > 0 && attempt >= o.MaxAttempts {
+			return errors.Wrapf(err, "%s failed after %d attempts", op, attempt)
+		}
+		wait := o.retryInterval(interval, attempt-1)
+		d.logf(log.LevelWarn, "%s failed, retrying in %v: %v", op, wait, err)
+		sleep(wait)
+		err = fn()
+	}
+	return nil
+}
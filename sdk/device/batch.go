@@ -0,0 +1,97 @@
+package device
+
+import (
+	"iot-sdk-go/pkg/ratelimit"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// registerBatchOptions 批量注册配置
+type registerBatchOptions struct {
+	limiter ratelimit.Limiter
+	retry   InitOptions
+}
+
+// RegisterBatchOption 批量注册配置项
+type RegisterBatchOption func(*registerBatchOptions)
+
+// RegisterBatchLimiter 设置批量注册共享的限流器，每次注册（包括重试）前等待限流器允许，默认不限流
+func RegisterBatchLimiter(limiter ratelimit.Limiter) RegisterBatchOption {
+	return func(o *registerBatchOptions) {
+		o.limiter = limiter
+	}
+}
+
+// RegisterBatchRetry 设置注册失败时的重试，opts.AutoReregister 为 true 时按 ReregisterInterval 与
+// BackoffFactor、MaxInterval、MaxAttempts 退避重试，默认不重试
+func RegisterBatchRetry(opts InitOptions) RegisterBatchOption {
+	return func(o *registerBatchOptions) {
+		o.retry = opts
+	}
+}
+
+// RegisterBatch 批量注册设备，最多 concurrency 个设备同时注册，返回以设备名称为 key 的注册结果
+func RegisterBatch(devices []*Device, concurrency int, opts ...RegisterBatchOption) (map[string]error, error) {
+	o := registerBatchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if concurrency <= 0 {
+		return nil, errors.New("register batch failed, concurrency must be greater than 0")
+	}
+	results := make(map[string]error, len(devices))
+	for _, d := range devices {
+		if _, ok := results[d.Name]; ok {
+			return nil, errors.Errorf("register batch failed, duplicate device name %s", d.Name)
+		}
+		results[d.Name] = nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *Device)
+	for i := 0; i < concurrency && i < len(devices); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				err := o.register(d)
+				mu.Lock()
+				results[d.Name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, d := range devices {
+		queue <- d
+	}
+	close(queue)
+	wg.Wait()
+
+	failed := 0
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, errors.Errorf("register batch failed, %d of %d devices register failed", failed, len(devices))
+	}
+	return results, nil
+}
+
+// register 等待限流器后注册设备，开启重试时失败后退避重试
+func (o registerBatchOptions) register(d *Device) error {
+	register := func() error {
+		if o.limiter != nil {
+			o.limiter.Wait()
+		}
+		return d.Register()
+	}
+	err := register()
+	if err == nil || !o.retry.AutoReregister {
+		return err
+	}
+	return d.retry(o.retry, o.retry.ReregisterInterval, "register", err, register)
+}
@@ -0,0 +1,61 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/trace"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlockingPublishPollInterval 阻塞发布时检查连接是否恢复的间隔
+var BlockingPublishPollInterval = 100 * time.Millisecond
+
+// ErrPublishWaitTimeout 阻塞发布等待超过 BlockingPublish 仍未发送成功
+var ErrPublishWaitTimeout = errors.New("publish wait timeout")
+
+// WithBlockingPublish 开启阻塞发布，PostProperty、PostEvent 在未连接、连接断开导致发送失败时
+// 阻塞等待连接恢复后发送，等待发送限流、发布队列同样计入等待时间，最多等待 maxWait，超时返回 ErrPublishWaitTimeout。
+// maxWait 为 0 时不阻塞，无法发送时立即返回错误
+func WithBlockingPublish(maxWait time.Duration) Option {
+	return func(d *Device) {
+		d.BlockingPublish = maxWait
+	}
+}
+
+// PostPropertyContext 上报属性，开启阻塞发布时 ctx 结束后不再等待，返回 ctx 的错误
+func (d *Device) PostPropertyContext(ctx context.Context, property Property, opts ...PublishOption) error {
+	return d.postPropertyContext(ctx, property, PriorityNormal, opts...)
+}
+
+// publishBlocking 发布消息，开启阻塞发布时等待连接恢复，否则与 publishWithPriority 相同
+func (d *Device) publishBlocking(ctx context.Context, p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	if d.queueWhenOffline(p, opts, attrs...) {
+		return nil
+	}
+	if d.BlockingPublish <= 0 {
+		return d.publishWithPriorityContext(ctx, p, opts, attrs...)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, d.BlockingPublish)
+	defer cancel()
+	var err error
+	for {
+		if d.IsConnected() {
+			// 连接正常时的发送失败（如序列化、服务端拒绝）不重试
+			if err = d.publishContext(waitCtx, p, opts, attrs...); err == nil || (waitCtx.Err() == nil && d.IsConnected()) {
+				return err
+			}
+		}
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return errors.Wrap(ctx.Err(), "publish canceled")
+			}
+			if err == nil {
+				err = errors.New("not connected")
+			}
+			return errors.Wrapf(ErrPublishWaitTimeout, "publish failed after waiting %s, last error: %v", d.BlockingPublish, err)
+		case <-time.After(BlockingPublishPollInterval):
+		}
+	}
+}
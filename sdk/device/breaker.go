@@ -0,0 +1,113 @@
+package device
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	// CircuitClosed 关闭，正常连接
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 打开，冷却期内跳过连接
+	CircuitOpen
+	// CircuitHalfOpen 半开，冷却期结束后允许一次试探连接
+	CircuitHalfOpen
+)
+
+// String 熔断器状态名称
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// ErrCircuitOpen 熔断器打开，跳过连接
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker 连接熔断器，连续失败 FailThreshold 次后打开，冷却 Cooldown 后半开试探
+type CircuitBreaker struct {
+	FailThreshold int
+	Cooldown      time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(failThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		FailThreshold: failThreshold,
+		Cooldown:      cooldown,
+	}
+}
+
+// WithCircuitBreaker 为连接设置熔断器
+func WithCircuitBreaker(failThreshold int, cooldown time.Duration) Option {
+	return func(d *Device) {
+		d.Breaker = NewCircuitBreaker(failThreshold, cooldown)
+	}
+}
+
+// Allow 是否允许连接，不允许时返回 ErrCircuitOpen
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return nil
+	case CircuitHalfOpen:
+		// 半开状态同一时刻只允许一次试探
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Success 记录连接成功，关闭熔断器
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = CircuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure 记录连接失败，连续失败达到阈值或试探失败时打开熔断器
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == CircuitHalfOpen || b.failures >= b.FailThreshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State 熔断器当前状态
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.Cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
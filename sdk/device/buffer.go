@@ -0,0 +1,101 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/request"
+	"sync/atomic"
+)
+
+// ReceiveBuffer 订阅消息缓冲配置，每个订阅使用独立的缓冲区
+type ReceiveBuffer struct {
+	// dropped 放在首位，保证 32 位平台上原子操作的对齐
+	dropped uint64
+	Size    int
+}
+
+// WithReceiveBuffer 为每个订阅设置大小为 n 的接收缓冲区，消息按顺序投递给回调，
+// 回调处理较慢时不阻塞 MQTT 接收，缓冲区满时丢弃最早的消息
+func WithReceiveBuffer(n int) Option {
+	return func(d *Device) {
+		d.ReceiveBuffer = &ReceiveBuffer{Size: n}
+	}
+}
+
+// Dropped 缓冲区满时丢弃的消息数
+func (b *ReceiveBuffer) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// wrap 包装回调，消息先进入缓冲区，再由单独的协程按顺序投递。协程在收到消息时启动，
+// 缓冲区为空或设备关闭时退出，取消订阅、订阅失败的回调不会留下常驻协程
+func (b *ReceiveBuffer) wrap(callback func(request.Response), g *goroutines) func(request.Response) {
+	if b.Size <= 0 || callback == nil {
+		return callback
+	}
+	queue := make(chan request.Response, b.Size)
+	// running 为 1 时投递协程正在运行，保证同一时刻只有一个协程投递，消息按顺序处理
+	var running int32
+	drain := func() {
+		closing := g.closing()
+		for {
+			select {
+			case resp := <-queue:
+				callback(resp)
+				continue
+			case <-closing:
+				atomic.StoreInt32(&running, 0)
+				return
+			default:
+			}
+			atomic.StoreInt32(&running, 0)
+			// 退出前又有消息进入缓冲区且没有其他协程接手时继续投递
+			if len(queue) == 0 || !atomic.CompareAndSwapInt32(&running, 0, 1) {
+				return
+			}
+		}
+	}
+	return func(resp request.Response) {
+		for {
+			select {
+			case queue <- resp:
+				if atomic.CompareAndSwapInt32(&running, 0, 1) {
+					g.spawn(drain)
+				}
+				return
+			default:
+			}
+			// 缓冲区已满，丢弃最早的消息
+			select {
+			case <-queue:
+				atomic.AddUint64(&b.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+// bufferCallback 设置了接收缓冲区时包装回调，并统计、录制收到的消息，创建处理消息的 Span
+func (d *Device) bufferCallback(callback func(request.Response)) func(request.Response) {
+	callback = d.traceCallback(callback)
+	if d.ReceiveBuffer != nil {
+		callback = d.ReceiveBuffer.wrap(callback, d.goroutines)
+	}
+	if callback == nil {
+		return nil
+	}
+	return func(resp request.Response) {
+		d.stats.recordReceive()
+		if err := d.recorder.record(resp); err != nil {
+			d.logf(log.LevelWarn, "%v", err)
+		}
+		callback(resp)
+	}
+}
+
+// DroppedMessages 接收缓冲区满时丢弃的消息数
+func (d *Device) DroppedMessages() uint64 {
+	if d.ReceiveBuffer == nil {
+		return 0
+	}
+	return d.ReceiveBuffer.Dropped()
+}
@@ -0,0 +1,182 @@
+package device
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// PayloadCodec 消息内容变换，发布时在序列化之后调用 Encode，接收时在反序列化之前调用 Decode
+type PayloadCodec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// ErrPayloadMAC 消息签名校验失败
+var ErrPayloadMAC = errors.New("payload mac mismatch")
+
+// WithPayloadCodecs 设置消息内容变换，发布时按顺序 Encode，接收时按相反顺序 Decode。
+// 作用于属性、事件、子设备状态、告警、命令回复的发布以及命令的接收
+func WithPayloadCodecs(codecs ...PayloadCodec) Option {
+	return func(d *Device) {
+		d.PayloadCodecs = codecs
+	}
+}
+
+// encodePayload 按顺序调用 PayloadCodecs 的 Encode
+func (d *Device) encodePayload(data []byte) ([]byte, error) {
+	for _, c := range d.PayloadCodecs {
+		var err error
+		if data, err = c.Encode(data); err != nil {
+			return nil, errors.Wrap(err, "encode payload failed")
+		}
+	}
+	return data, nil
+}
+
+// decodePayload 按相反顺序调用 PayloadCodecs 的 Decode
+func (d *Device) decodePayload(data []byte) ([]byte, error) {
+	for i := len(d.PayloadCodecs) - 1; i >= 0; i-- {
+		var err error
+		if data, err = d.PayloadCodecs[i].Decode(data); err != nil {
+			return nil, errors.Wrap(err, "decode payload failed")
+		}
+	}
+	return data, nil
+}
+
+// gzipCodec gzip 压缩，limit 为解压后的最大长度，为 0 时不限制
+type gzipCodec struct {
+	limit int
+}
+
+// GzipCodec gzip 压缩，与 WithCompression 不同，不经过平台协商，双方需事先约定。
+// 解压后超过 DefaultMaxReceiveSize 时返回 ErrPayloadTooLarge
+func GzipCodec() PayloadCodec {
+	return gzipCodec{limit: DefaultMaxReceiveSize}
+}
+
+// NewGzipCodec gzip 压缩，limit 为解压后的最大长度，超过时返回 ErrPayloadTooLarge，为 0 时不限制
+func NewGzipCodec(limit int) PayloadCodec {
+	return gzipCodec{limit: limit}
+}
+
+// Encode 压缩
+func (gzipCodec) Encode(data []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Wrap(err, "gzip compress failed")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "gzip compress failed")
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解压，最多读取 limit+1 字节，避免压缩炸弹耗尽内存
+func (c gzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip decompress failed")
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if c.limit > 0 {
+		src = io.LimitReader(r, int64(c.limit)+1)
+	}
+	ret, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip decompress failed")
+	}
+	if c.limit > 0 && len(ret) > c.limit {
+		return nil, errors.Wrapf(ErrPayloadTooLarge, "gzip decompressed size exceeds %d", c.limit)
+	}
+	return ret, nil
+}
+
+// aesGCMCodec AES-GCM 加密
+type aesGCMCodec struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMCodec AES-GCM 加密，key 长度为 16、24、32 字节，分别对应 AES-128、AES-192、AES-256。
+// 密文格式为随机 nonce 加上带认证标签的密文
+func NewAESGCMCodec(key []byte) (PayloadCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new aes cipher failed")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "new gcm failed")
+	}
+	return &aesGCMCodec{aead: aead}, nil
+}
+
+// Encode 加密
+func (c *aesGCMCodec) Encode(data []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce failed")
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decode 解密，密文被篡改或密钥不一致时返回错误
+func (c *aesGCMCodec) Decode(data []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(data) < n+c.aead.Overhead() {
+		return nil, errors.Errorf("aes-gcm payload too short: %d bytes", len(data))
+	}
+	ret, err := c.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes-gcm decrypt failed")
+	}
+	return ret, nil
+}
+
+// hmacCodec HMAC-SHA256 签名
+type hmacCodec struct {
+	key []byte
+}
+
+// NewHMACCodec HMAC-SHA256 签名，Encode 在消息末尾追加 32 字节签名，Decode 校验并去掉签名，
+// 校验失败时返回 ErrPayloadMAC
+func NewHMACCodec(key []byte) PayloadCodec {
+	return &hmacCodec{key: append([]byte{}, key...)}
+}
+
+// sum 计算签名
+func (c *hmacCodec) sum(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Encode 追加签名
+func (c *hmacCodec) Encode(data []byte) ([]byte, error) {
+	ret := make([]byte, 0, len(data)+sha256.Size)
+	ret = append(ret, data...)
+	return append(ret, c.sum(data)...), nil
+}
+
+// Decode 校验并去掉签名
+func (c *hmacCodec) Decode(data []byte) ([]byte, error) {
+	if len(data) < sha256.Size {
+		return nil, ErrPayloadMAC
+	}
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(sum, c.sum(body)) {
+		return nil, ErrPayloadMAC
+	}
+	return body, nil
+}
@@ -0,0 +1,153 @@
+package device
+
+import (
+	"encoding/hex"
+	"iot-sdk-go/sdk/storage"
+	"sync"
+)
+
+// DefaultCommandLogSize 默认保留的已处理命令条数
+const DefaultCommandLogSize = 100
+
+// CommandLog 持久化的已处理命令日志，用于重复投递的命令去重
+type CommandLog struct {
+	Key  string
+	Size int
+	mu   sync.Mutex
+	// running 已预留、正在处理的命令标识，同时到达的重复投递只执行一次
+	running map[string]bool
+}
+
+// WithPersistentCommandDedup 开启命令去重，已成功处理的命令以命令的唯一标识（serializer.Command.Token，
+// 不携带时为 MQTT 5 Correlation Data）记录在 Storage 的 storageKey 下，没有唯一标识的命令不去重
+func WithPersistentCommandDedup(storageKey string) Option {
+	return func(d *Device) {
+		d.CommandLog = &CommandLog{
+			Key:  storageKey,
+			Size: DefaultCommandLogSize,
+		}
+	}
+}
+
+// commandLogID 命令的唯一标识，优先使用序列化格式携带的 Token，其次为 MQTT 5 Correlation Data，都没有时为空
+func commandLogID(ctx CommandContext) string {
+	if ctx.Token != "" {
+		return ctx.Token
+	}
+	return hex.EncodeToString(ctx.CorrelationData)
+}
+
+// load 读取已处理的命令标识，按处理顺序排列
+func (l *CommandLog) load(s storage.Storage) ([]string, error) {
+	v, err := s.Get(l.Key)
+	if err != nil {
+		return nil, err
+	}
+	switch ids := v.(type) {
+	case []string:
+		return ids, nil
+	case []interface{}:
+		ret := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if str, ok := id.(string); ok {
+				ret = append(ret, str)
+			}
+		}
+		return ret, nil
+	}
+	return []string{}, nil
+}
+
+// Processed 命令是否已处理
+func (l *CommandLog) Processed(s storage.Storage, id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids, err := l.load(s)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range ids {
+		if v == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// reserve 预留命令标识，命令已处理或正在处理时返回 false
+func (l *CommandLog) reserve(s storage.Storage, id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running[id] {
+		return false, nil
+	}
+	ids, err := l.load(s)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range ids {
+		if v == id {
+			return false, nil
+		}
+	}
+	if l.running == nil {
+		l.running = make(map[string]bool)
+	}
+	l.running[id] = true
+	return true, nil
+}
+
+// release 释放预留的命令标识，processed 为 true 时先记录为已处理，为 false 时重复投递的命令会再次执行
+func (l *CommandLog) release(s storage.Storage, id string, processed bool) error {
+	var err error
+	if processed {
+		err = l.Record(s, id)
+	}
+	l.mu.Lock()
+	delete(l.running, id)
+	l.mu.Unlock()
+	return err
+}
+
+// Record 记录已处理的命令，超出 Size 时淘汰最早的记录
+func (l *CommandLog) Record(s storage.Storage, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids, err := l.load(s)
+	if err != nil {
+		return err
+	}
+	ids = append(ids, id)
+	if l.Size > 0 && len(ids) > l.Size {
+		ids = ids[len(ids)-l.Size:]
+	}
+	return s.Set(l.Key, ids)
+}
+
+// evict 按处理顺序淘汰最早的记录，每次淘汰四分之一（至少一条），直到 over 返回 false 或记录为空，返回淘汰的条数
+func (l *CommandLog) evict(s storage.Storage, over func() bool) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids, err := l.load(s)
+	if err != nil {
+		return 0, err
+	}
+	evicted := 0
+	for len(ids) > 0 && over() {
+		n := len(ids) / 4
+		if n == 0 {
+			n = 1
+		}
+		ids = ids[n:]
+		evicted += n
+		if len(ids) == 0 {
+			err = s.Del(l.Key)
+		} else {
+			err = s.Set(l.Key, ids)
+		}
+		if err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
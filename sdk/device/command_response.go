@@ -0,0 +1,123 @@
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/serializer"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CommandResponse 命令的类型化回复，与设备的其他回复使用相同的格式序列化，见 marshalReply
+type CommandResponse struct {
+	// ID 命令 ID
+	ID uint16
+	// SubDeviceID 子设备 ID，回复网关自身的命令时为 0
+	SubDeviceID uint16
+	// Code 状态码，如 serializer.ReplyCodeOK
+	Code int
+	// Data 回复参数，key 为参数序号，从 0 开始连续编号
+	Data map[int]interface{}
+}
+
+// ReplyFunc 命令处理函数中回复命令的函数，命令 ID、子设备 ID 与回复主题由收到的命令确定
+type ReplyFunc func(code int, data map[int]interface{}) error
+
+// ReplyCommand 发送命令回复到 Topics.CommandResponse，用于在命令回调之外（如异步任务完成后）回复命令。
+// 回复为控制消息，暂停期间照常发送，发送失败时返回错误，不等待重连后重发
+func (d *Device) ReplyCommand(resp CommandResponse) error {
+	payload, err := d.marshalCommandResponse(resp)
+	if err != nil {
+		return err
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.CommandResponse
+	r.Qos = 1
+	r.Payload = payload
+	return errors.Wrapf(d.publishControl(PriorityNormal, protocol.OptionsFormatter(*r)),
+		"reply command %d failed", resp.ID)
+}
+
+// marshalCommandResponse 以 marshalReply 的格式序列化命令回复，并按 PayloadCodecs 编码
+func (d *Device) marshalCommandResponse(resp CommandResponse) ([]byte, error) {
+	reply := &serializer.Reply{
+		CommandID:   resp.ID,
+		SubDeviceID: resp.SubDeviceID,
+		Code:        resp.Code,
+	}
+	// 避免空的 map 序列化为 {}
+	if len(resp.Data) > 0 {
+		reply.Data = resp.Data
+	}
+	data, err := d.marshalReply(reply)
+	if err == nil {
+		data, err = d.encodePayload(data)
+	}
+	return data, errors.Wrapf(err, "marshal command %d response failed", resp.ID)
+}
+
+// marshalReply 序列化回复。同一设备的所有回复（命令回复、诊断回复、重启确认）使用同一种格式，
+// 平台无需区分：Topics.CommandResponse 对应的序列化器实现了 serializer.CommandResponseSerializer 时（如 TLV、JSON）
+// 以与命令相同的格式序列化，否则使用 ReplySerializer 的信封
+func (d *Device) marshalReply(reply *serializer.Reply) ([]byte, error) {
+	s := d.serializerFor(d.Topics.CommandResponse).(safeSerializer)
+	if !s.supportsCommandResponse() {
+		return d.ReplySerializer.MarshalReply(reply)
+	}
+	params, err := replyParams(reply)
+	if err != nil {
+		return nil, err
+	}
+	return s.MakeCommandResponseData(&serializer.CommandResponse{
+		ID:          reply.CommandID,
+		SubDeviceID: reply.SubDeviceID,
+		Code:        reply.Code,
+		Data:        params,
+	})
+}
+
+// replyParams 将信封格式的回复内容转换为命令回复参数：Data 为 map[int]interface{} 时原样使用，
+// 为其他值时序列化为 JSON 字符串作为参数 0；Data 为空时 Message 作为参数 0
+func replyParams(reply *serializer.Reply) (map[int]interface{}, error) {
+	switch data := reply.Data.(type) {
+	case nil:
+		if reply.Message == "" {
+			return nil, nil
+		}
+		return map[int]interface{}{0: reply.Message}, nil
+	case map[int]interface{}:
+		return data, nil
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "reply data convert to json failed")
+		}
+		return map[int]interface{}{0: string(b)}, nil
+	}
+}
+
+// replyFunc 创建命令 ctx 的 ReplyFunc，回复发送到命令的回复主题，发送失败且未超过有效期时等待重连后重发
+func (d *Device) replyFunc(ctx CommandContext, id string, receivedAt time.Time) ReplyFunc {
+	return func(code int, data map[int]interface{}) error {
+		payload, err := d.marshalCommandResponse(CommandResponse{
+			ID:          ctx.ID,
+			SubDeviceID: ctx.SubDeviceID,
+			Code:        code,
+			Data:        data,
+		})
+		if err != nil {
+			return err
+		}
+		r := &request.Request{}
+		r.Topic = d.Topics.CommandResponse
+		if ctx.ResponseTopic != "" {
+			r.Topic = ctx.ResponseTopic
+		}
+		r.Qos = 1
+		r.Payload = payload
+		r.CorrelationData = ctx.CorrelationData
+		return errors.Wrapf(d.sendReplyTo(id, receivedAt, r), "reply command %d failed", ctx.ID)
+	}
+}
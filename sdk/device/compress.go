@@ -0,0 +1,47 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"sync/atomic"
+)
+
+// CompressionGzip gzip 压缩，目前唯一支持的压缩算法
+const CompressionGzip = "gzip"
+
+// WithCompression 请求压缩上报的属性、事件数据。登录时向平台声明支持 gzip，
+// 平台在登录返回中确认支持后才启用，否则不压缩
+func WithCompression() Option {
+	return func(d *Device) {
+		d.Compression = true
+	}
+}
+
+// negotiateCompression 根据最近一次登录的结果决定当前连接是否压缩，每次登录后调用
+func (d *Device) negotiateCompression() {
+	mu := d.authLock()
+	mu.RLock()
+	platform := d.platformCompression
+	mu.RUnlock()
+	enabled := d.Compression && platform == CompressionGzip
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&d.compressionEnabled, v)
+	if d.Compression {
+		d.logf(log.LevelDebug, "compression negotiated: %v, platform advertised: %v", enabled, platform)
+	}
+}
+
+// CompressionEnabled 当前连接是否压缩上报数据
+func (d *Device) CompressionEnabled() bool {
+	return atomic.LoadInt32(&d.compressionEnabled) == 1
+}
+
+// compress 协商启用压缩时使用 gzip 压缩数据，否则原样返回
+func (d *Device) compress(data []byte) ([]byte, error) {
+	if !d.CompressionEnabled() {
+		return data, nil
+	}
+	return gzipCodec{}.Encode(data)
+}
@@ -0,0 +1,152 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/serializer"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCommandQueueSize 设置最大并发命令数时默认的排队长度
+const DefaultCommandQueueSize = 16
+
+// ErrCommandBusy 同时执行的命令数与排队的命令数都已达到上限，命令未执行
+var ErrCommandBusy = errors.New("too many concurrent commands")
+
+// WithMaxConcurrentCommands 设置同时执行的命令处理函数数量上限，命令在单独的协程中执行，
+// 超过上限的命令排队等待，排队已满时拒绝并回复 ReplyCodeBusy。n 不大于 0 时在接收协程中依次执行
+func WithMaxConcurrentCommands(n int) Option {
+	return func(d *Device) {
+		d.MaxConcurrentCommands = n
+	}
+}
+
+// WithCommandQueueSize 设置达到最大并发命令数后排队等待的命令数，为 0 时不排队，直接拒绝
+func WithCommandQueueSize(size int) Option {
+	return func(d *Device) {
+		d.CommandQueueSize = size
+	}
+}
+
+// commandSlots 命令并发控制，running 为正在执行的命令数，waiting 为排队中的命令数
+type commandSlots struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running int
+	waiting int
+}
+
+// newCommandSlots 创建 commandSlots 对象
+func newCommandSlots() *commandSlots {
+	s := &commandSlots{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// reserve 占用执行或排队的位置，queued 表示占用的是排队位置，都已满时 ok 为 false。
+// 有命令排队时新命令也排队，避免抢在排队的命令之前执行
+func (s *commandSlots) reserve(limit, queue int) (ok, queued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running < limit && s.waiting == 0 {
+		s.running++
+		return true, false
+	}
+	if s.waiting < queue {
+		s.waiting++
+		return true, true
+	}
+	return false, false
+}
+
+// acquire 等待执行的位置，reserve 时已占用执行位置的直接返回
+func (s *commandSlots) acquire(limit int, queued bool) {
+	if !queued {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.running >= limit {
+		s.cond.Wait()
+	}
+	s.waiting--
+	s.running++
+}
+
+// take 不经过排队直接等待执行位置，用于按子设备顺序执行的命令
+func (s *commandSlots) take(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.running >= limit {
+		s.cond.Wait()
+	}
+	s.running++
+}
+
+// release 释放执行位置，唤醒排队的命令
+func (s *commandSlots) release() {
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+// dispatchCommand 执行命令。开启 OrderedCommands 时按子设备排队执行；
+// 未设置 MaxConcurrentCommands 时在当前协程执行；否则在单独的协程中执行，
+// 达到上限时排队，排队已满时拒绝，以 ErrCommandBusy 调用命令错误回调，带回复的命令回复 ReplyCodeBusy。
+// 返回命令是否已执行或排队，拒绝时返回 false
+func (d *Device) dispatchCommand(cmd Command, ctx CommandContext, id string, run func()) bool {
+	if d.OrderedCommands && d.ordered != nil {
+		return d.dispatchOrdered(cmd, ctx, id, run)
+	}
+	limit := d.MaxConcurrentCommands
+	if limit <= 0 || d.commands == nil {
+		run()
+		return true
+	}
+	ok, queued := d.commands.reserve(limit, d.CommandQueueSize)
+	if !ok {
+		d.rejectBusy(cmd, ctx, id)
+		return false
+	}
+	d.goroutines.spawn(func() {
+		d.commands.acquire(limit, queued)
+		defer d.commands.release()
+		run()
+	})
+	return true
+}
+
+// rejectBusy 以 ErrCommandBusy 拒绝命令，带回复的命令回复 ReplyCodeBusy
+func (d *Device) rejectBusy(cmd Command, ctx CommandContext, id string) {
+	d.rejectCommand(cmd, ctx, id, errors.Wrapf(ErrCommandBusy, "command %d rejected", ctx.ID), serializer.ReplyCodeBusy)
+}
+
+// rejectCommand 拒绝命令，以 err 调用命令错误回调，带回复的命令回复 code
+func (d *Device) rejectCommand(cmd Command, ctx CommandContext, id string, err error, code int) {
+	d.commandError(ctx.Topic, err)
+	if cmd.ContextHandler != nil || cmd.Handler != nil {
+		d.replyCommand(ctx, id, time.Now(), &serializer.Reply{
+			CommandID:   ctx.ID,
+			SubDeviceID: ctx.SubDeviceID,
+			Code:        code,
+			Message:     errors.Cause(err).Error(),
+		})
+	} else if cmd.ReplyCallback != nil {
+		if err := d.replyFunc(ctx, id, time.Now())(code, nil); err != nil {
+			d.logf(log.LevelWarn, "%v", err)
+		}
+	}
+}
+
+// RunningCommands 正在执行与排队中的命令数
+func (d *Device) RunningCommands() (running, waiting int) {
+	if d.commands == nil {
+		return 0, 0
+	}
+	d.commands.mu.Lock()
+	defer d.commands.mu.Unlock()
+	return d.commands.running, d.commands.waiting
+}
@@ -0,0 +1,22 @@
+package device
+
+import "iot-sdk-go/sdk/request"
+
+// replyRequest 生成对 resp 的回复：请求携带 MQTT 5 Response Topic 时回复到该主题，否则回复到 topic；
+// 请求携带 Correlation Data 时原样带回。MQTT 3.1.1 下两者都没有，回复到固定主题并由 payload 中的 request_id 关联
+func replyRequest(resp request.Response, topic string, payload []byte) *request.Request {
+	r := &request.Request{}
+	r.Topic = topic
+	if t := request.ResponseTopic(resp); t != "" {
+		r.Topic = t
+	}
+	r.Qos = 1
+	r.Payload = payload
+	r.CorrelationData = request.CorrelationData(resp)
+	return r
+}
+
+// correlated 请求是否携带 MQTT 5 Correlation Data，携带时回复中不再嵌入 request_id
+func correlated(resp request.Response) bool {
+	return len(request.CorrelationData(resp)) > 0
+}
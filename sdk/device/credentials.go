@@ -0,0 +1,51 @@
+package device
+
+import "sync"
+
+// Credentials 设备的认证信息，由注册、登录、密钥轮换更新
+type Credentials struct {
+	ID     int64
+	Secret string
+	Token  []byte
+	Access string
+}
+
+// defaultAuthMu 未通过 New 创建的设备共用的锁
+var defaultAuthMu sync.RWMutex
+
+// authLock 保护 ID、Secret、Token、Access 与 Token 有效期的锁。
+// 以指针保存，复制 Device（如调用 AuthArgsFromDevice）时不复制锁
+func (d *Device) authLock() *sync.RWMutex {
+	if d.authMu == nil {
+		return &defaultAuthMu
+	}
+	return d.authMu
+}
+
+// Credentials 读取认证信息。连接断开后的重新登录在协议客户端的协程中更新 Token、Access，
+// 设备运行时应通过该方法读取，而不是直接读取字段
+func (d *Device) Credentials() Credentials {
+	mu := d.authLock()
+	mu.RLock()
+	defer mu.RUnlock()
+	c := Credentials{ID: d.ID, Secret: d.Secret, Access: d.Access}
+	if d.Token != nil {
+		c.Token = append([]byte{}, d.Token...)
+	}
+	return c
+}
+
+// SetCredentials 设置认证信息，设备运行时应通过该方法修改，而不是直接修改字段
+func (d *Device) SetCredentials(c Credentials) {
+	d.lockAuth(func() {
+		d.ID, d.Secret, d.Token, d.Access = c.ID, c.Secret, c.Token, c.Access
+	})
+}
+
+// lockAuth 持有 authLock 的写锁执行 fn
+func (d *Device) lockAuth(fn func()) {
+	mu := d.authLock()
+	mu.Lock()
+	defer mu.Unlock()
+	fn()
+}
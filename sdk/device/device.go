@@ -1,23 +1,31 @@
 package device
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/ratelimit"
+	"iot-sdk-go/pkg/singleflight"
 	"iot-sdk-go/pkg/typeconv"
 	"iot-sdk-go/sdk/httpclient"
+	"iot-sdk-go/sdk/log"
 	"iot-sdk-go/sdk/protocol"
 	"iot-sdk-go/sdk/request"
 	"iot-sdk-go/sdk/serializer"
 	"iot-sdk-go/sdk/storage"
 	"iot-sdk-go/sdk/topics"
+	"iot-sdk-go/sdk/trace"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
 )
 
@@ -32,9 +40,145 @@ type Device struct {
 	Access     string
 	Protocol   protocol.Protocol
 	Serializer serializer.Serializer
-	Topics     topics.Topics
-	Storage    storage.Storage
-	HTTPClient http.Client
+	// ReplySerializer 命令回复序列化器，默认使用 JSON 信封，Serializer 支持序列化命令回复时不使用
+	ReplySerializer serializer.ReplySerializer
+	Topics          topics.Topics
+	Storage         storage.Storage
+	HTTPClient      http.Client
+	Will            *protocol.Will
+	CommandLog      *CommandLog
+	// MessageStore 未确认的 QoS 1/2 消息存储，为 nil 时使用内存存储
+	MessageStore mqtt.Store
+	// Units 属性单位，key 为属性 ID
+	Units map[uint16]string
+	// PropertyNamespaces 子设备的属性命名空间，key 为命名空间名称，子设备通过 BindSubDevice 绑定
+	PropertyNamespaces map[string]PropertyNamespace
+	// CommandParams 命令参数名称，key 为命令 ID，第 i 个名称对应参数 i
+	CommandParams map[uint16][]string
+	// Breaker 连接熔断器，为 nil 时不熔断
+	Breaker *CircuitBreaker
+	// ClientIDFunc 生成 MQTT ClientID，为 nil 时使用设备 ID
+	ClientIDFunc func(d *Device) string
+	// ReceiveBuffer 订阅消息接收缓冲区，为 nil 时直接在接收协程中调用回调
+	ReceiveBuffer *ReceiveBuffer
+	// PSK TLS-PSK 预共享密钥，为 nil 时不使用 TLS-PSK
+	PSK *protocol.PSK
+	// Dialer 自定义建立网络连接，为 nil 时使用默认的 tcp 连接
+	Dialer protocol.Dialer
+	// TLSConfig 基于证书的 TLS 配置，为 nil 且没有设置证书文件时只在接入地址要求 TLS 时报错
+	TLSConfig *tls.Config
+	// CACertFile PEM 格式的 CA 证书文件，用于校验服务端证书
+	CACertFile string
+	// ClientCertFile、ClientKeyFile PEM 格式的客户端证书与私钥文件，用于双向认证
+	ClientCertFile string
+	ClientKeyFile  string
+	// PayloadCodecs 消息内容变换，发布时按顺序 Encode，接收时按相反顺序 Decode
+	PayloadCodecs []PayloadCodec
+	// WillDelayInterval MQTT 5 遗嘱延迟，为 0 时断开后立即发布遗嘱
+	WillDelayInterval time.Duration
+	// LoginOnReconnect 断线重连前是否重新登录，默认每次重连都登录
+	LoginOnReconnect ReconnectLogin
+	// MaxConcurrentCommands 同时执行的命令数上限，为 0 时在接收协程中依次执行
+	MaxConcurrentCommands int
+	// CommandQueueSize 达到 MaxConcurrentCommands 后排队等待的命令数，开启 OrderedCommands 时为每个子设备排队的命令数
+	CommandQueueSize int
+	// ResubscribeBatch 重连后每批重新订阅的主题数，为 0 时一次订阅全部主题
+	ResubscribeBatch int
+	// ResubscribeDelay 重连后重新订阅的批次间隔
+	ResubscribeDelay time.Duration
+	// PersistFailure 注册、登录成功后保存设备信息失败时的处理方式，默认记录 warning 日志
+	PersistFailure PersistFailure
+	// ReportDeviceInfo 每次连接成功后上报设备信息
+	ReportDeviceInfo bool
+	// DeviceInfoFields 上报设备信息时附加的字段
+	DeviceInfoFields func() map[string]interface{}
+	// TelemetryBatcher 属性批量上报配置，为 nil 时 PostProperty 立即发送
+	TelemetryBatcher *BatcherConfig
+	// AutoDetectSerializer 接收时按消息开头识别序列化格式，使用 FormatSerializers 中对应的序列化器解析
+	AutoDetectSerializer bool
+	// FormatSerializers 自动识别格式时各格式使用的序列化器
+	FormatSerializers map[serializer.Format]serializer.Serializer
+	// OrderedCommands 同一子设备的命令按到达顺序依次执行，不同子设备的命令并发执行
+	OrderedCommands bool
+	// BlockingPublish 阻塞发布的最长等待时间，为 0 时无法发送立即返回错误
+	BlockingPublish time.Duration
+	// StorageQuota Storage 占用空间上限，单位字节，为 0 时不限制
+	StorageQuota int64
+	// Activation 为 true 时 AutoLogin 在注册后、登录前激活设备
+	Activation bool
+	// KeepaliveMin、KeepaliveMax 自适应心跳间隔的上下限，KeepaliveMax 为 0 时使用固定的 DefaultKeepAlive
+	KeepaliveMin time.Duration
+	KeepaliveMax time.Duration
+	// PropertySnapshot 返回设备当前所有属性，用于响应平台的"上报全部属性"命令
+	PropertySnapshot func() []Property
+	// LogDedupWindow 日志去重窗口，为 0 时不去重
+	LogDedupWindow time.Duration
+	// Logger 日志输出，为 nil 时输出到 pkg/mqtt 的日志
+	Logger log.Logger
+
+	// SerializerRouter 按主题选择序列化器，返回 nil 时使用 Serializer
+	SerializerRouter func(topic string) serializer.Serializer
+	// DecodeFallback 命令解析失败时依次尝试的序列化器
+	DecodeFallback []serializer.Serializer
+	// Tracer 链路追踪器，为 nil 时不追踪
+	Tracer trace.Tracer
+	// CommandRouter 命令路由，默认按命令 ID 分发
+	CommandRouter CommandRouter
+	// MaxReceiveSize 接收消息的最大长度，单位字节，为 0 时不限制
+	MaxReceiveSize int
+	// ReplyTTL 命令回复有效期，连接断开期间发送失败的回复在有效期内重连后重发
+	ReplyTTL time.Duration
+	// OfflineQueueSize 离线队列长度，为 0 时不缓存
+	OfflineQueueSize int
+	// OfflineMaxAge 离线消息的最长保存时间，为 0 时不限制
+	OfflineMaxAge time.Duration
+	// OfflineDropPolicy 离线队列满时的丢弃策略
+	OfflineDropPolicy DropPolicy
+	// QueueWhenOffline 未连接时 PostProperty、PostEvent 是否放入离线队列
+	QueueWhenOffline bool
+	// Compression 请求压缩上报数据，平台确认支持后才启用
+	Compression bool
+	// IgnoreRetainedCommands 为 true 时跳过保留的命令消息，默认执行
+	IgnoreRetainedCommands bool
+	// PublishLimiter 发布限流器，为 nil 时不限流
+	PublishLimiter ratelimit.Limiter
+	// PauseMode 暂停期间发布的消息的处理方式，默认 PauseQueue
+	PauseMode PauseMode
+	// ReportMaintenance 暂停、恢复时上报设备信息，设备信息中带 maintenance 字段
+	ReportMaintenance bool
+
+	// paused 为 1 时设备已暂停
+	paused         int32
+	onDisconnect   func(reason protocol.DisconnectReason, err error)
+	onCommandError func(topic string, err error)
+	stats          *stats
+	subDevices     *subDevices
+	bindings       *namespaceBindings
+	recorder       *recorder
+	handlers       *handlers
+	inflight       *inflight
+	queue          *publishQueue
+	schedules      *schedules
+	alarms         *alarms
+	responses      *responses
+	commands       *commandSlots
+	ordered        *orderedCommands
+	offline        *offlineQueue
+	telemetry      *telemetry
+	increments     *increments
+	keepalive      *adaptiveKeepalive
+	logs           *logDedup
+	goroutines     *goroutines
+	// platformCompression 最近一次登录时平台确认的压缩算法，由 authLock 保护
+	platformCompression string
+	// compressionEnabled 当前连接是否压缩，1 为压缩
+	compressionEnabled int32
+	// tokenExpiresAt 最近一次登录得到的 Token 的过期时间，平台未返回有效期时为零值
+	tokenExpiresAt time.Time
+	// authMu 见 authLock
+	authMu *sync.RWMutex
+	// flight 合并本设备并发的注册、登录等请求，见 flightDo
+	flight *singleflight.Group
 }
 
 // Option 配置函数
@@ -42,15 +186,43 @@ type Option func(*Device)
 
 // New 创建设备
 func New(ProductKey, Name, Version string, opts ...func(*Device)) *Device {
+	g := newGoroutines()
 	device := &Device{
-		ProductKey: ProductKey,
-		Name:       Name,
-		Version:    Version,
-		Protocol:   protocol.NewMQTT(),
-		Serializer: serializer.NewTLV(),
-		Topics:     topics.DefaultTopics,
-		Storage:    &storage.LocalStorage{},
-		HTTPClient: httpclient.DefaultClient,
+		ProductKey:       ProductKey,
+		Name:             Name,
+		Version:          Version,
+		Protocol:         protocol.NewMQTT(),
+		Serializer:       serializer.NewTLV(),
+		ReplySerializer:  serializer.NewJSONReply(),
+		Topics:           topics.DefaultTopics,
+		Storage:          &storage.LocalStorage{},
+		HTTPClient:       httpclient.DefaultClient,
+		CommandRouter:    NewMapRouter(),
+		MaxReceiveSize:   DefaultMaxReceiveSize,
+		ReplyTTL:         DefaultReplyTTL,
+		OfflineQueueSize: DefaultOfflineQueueSize,
+		CommandQueueSize: DefaultCommandQueueSize,
+		stats:            &stats{},
+		subDevices:       newSubDevices(),
+		bindings:         newNamespaceBindings(),
+		recorder:         &recorder{},
+		handlers:         newHandlers(),
+		inflight:         newInflight(),
+		queue:            &publishQueue{goroutines: g},
+		schedules:        newSchedules(),
+		alarms:           newAlarms(),
+		responses:        &responses{},
+		commands:         newCommandSlots(),
+		ordered:          newOrderedCommands(),
+		offline:          &offlineQueue{},
+		telemetry:        &telemetry{},
+		increments:       newIncrements(),
+		keepalive:        &adaptiveKeepalive{},
+		authMu:           &sync.RWMutex{},
+		flight:           &singleflight.Group{},
+		logs:             newLogDedup(),
+		LogDedupWindow:   DefaultLogDedupWindow,
+		goroutines:       g,
 	}
 	for _, opt := range opts {
 		opt(device)
@@ -72,6 +244,118 @@ func Serializer(serializer serializer.Serializer) Option {
 	}
 }
 
+// WithSerializerRouter 设置按主题选择序列化器的函数，发布与接收时均会调用，返回 nil 时使用 Serializer，
+// 适用于网关桥接使用不同编码的子设备
+func WithSerializerRouter(router func(topic string) serializer.Serializer) Option {
+	return func(d *Device) {
+		d.SerializerRouter = router
+	}
+}
+
+// WithSerializerMigration 设置序列化格式迁移，按 toFraction 的比例使用新格式 to 上报，其余使用旧格式 from，
+// 接收时两种格式均可解析，详见 serializer.Migration
+func WithSerializerMigration(from, to serializer.Serializer, toFraction float64) Option {
+	return func(d *Device) {
+		d.Serializer = serializer.NewMigration(from, to, toFraction)
+	}
+}
+
+// WithDecodeFallback 设置命令解析的备选序列化器，主题对应的序列化器解析失败时按顺序尝试，
+// 全部失败才视为解析失败。用于平台与设备序列化格式不一致的迁移期间
+func WithDecodeFallback(serializers ...serializer.Serializer) Option {
+	return func(d *Device) {
+		d.DecodeFallback = serializers
+	}
+}
+
+// WithAutoDetectSerializer 接收命令、属性时按消息开头识别序列化格式（见 serializer.Detect），
+// 使用 FormatSerializers 中对应的序列化器解析，无法识别或未设置对应序列化器时使用主题对应的序列化器。
+// 默认只设置了 TLV，其他格式通过 WithFormatSerializer 设置
+func WithAutoDetectSerializer(enabled bool) Option {
+	return func(d *Device) {
+		d.AutoDetectSerializer = enabled
+		if d.FormatSerializers == nil {
+			d.FormatSerializers = map[serializer.Format]serializer.Serializer{}
+		}
+		if _, ok := d.FormatSerializers[serializer.FormatTLV]; !ok {
+			d.FormatSerializers[serializer.FormatTLV] = serializer.NewTLV()
+		}
+	}
+}
+
+// WithFormatSerializer 设置自动识别格式时 format 使用的序列化器
+func WithFormatSerializer(format serializer.Format, s serializer.Serializer) Option {
+	return func(d *Device) {
+		if d.FormatSerializers == nil {
+			d.FormatSerializers = map[serializer.Format]serializer.Serializer{}
+		}
+		d.FormatSerializers[format] = s
+	}
+}
+
+// decoderFor 获取解析接收消息的序列化器，开启自动识别时按消息格式选择，否则为主题对应的序列化器
+func (d *Device) decoderFor(topic string, payload []byte) serializer.Serializer {
+	if d.AutoDetectSerializer {
+		if format, err := serializer.Detect(payload); err == nil {
+			if s := d.FormatSerializers[format]; s != nil {
+				return safeSerializer{s}
+			}
+		}
+	}
+	return d.serializerFor(topic)
+}
+
+// unmarshalCommand 使用 decoderFor 选择的序列化器解析命令，失败时依次尝试 DecodeFallback，返回第一个序列化器的错误
+func (d *Device) unmarshalCommand(topic string, payload []byte) (*serializer.Command, error) {
+	cmd, err := d.decoderFor(topic, payload).UnmarshalCommand(payload)
+	if err == nil {
+		return cmd, nil
+	}
+	for i, s := range d.DecodeFallback {
+		if s == nil {
+			continue
+		}
+		if fallback, fallbackErr := (safeSerializer{s}).UnmarshalCommand(payload); fallbackErr == nil {
+			d.logf(log.LevelDebug, "command decoded by fallback serializer %d %T, topic: %s", i, s, topic)
+			return fallback, nil
+		}
+	}
+	return nil, err
+}
+
+// unmarshalProperties 使用 decoderFor 选择的序列化器解析消息中的所有属性，序列化器不支持批量解析时按单个属性解析
+func (d *Device) unmarshalProperties(topic string, payload []byte) ([]*serializer.Property, error) {
+	s := d.decoderFor(topic, payload)
+	if b, ok := s.(serializer.BatchDeserializer); ok {
+		properties, err := b.UnmarshalBatchProperty(payload)
+		if err != errBatchUnsupported {
+			return properties, err
+		}
+	}
+	property, err := s.UnmarshalProperty(payload)
+	if err != nil {
+		return nil, err
+	}
+	return []*serializer.Property{property}, nil
+}
+
+// serializerFor 获取主题对应的序列化器，序列化器的 panic 转换为 ErrSerializerPanic 返回
+func (d *Device) serializerFor(topic string) serializer.Serializer {
+	if d.SerializerRouter != nil {
+		if s := d.SerializerRouter(topic); s != nil {
+			return safeSerializer{s}
+		}
+	}
+	return safeSerializer{d.Serializer}
+}
+
+// ReplySerializer 设置命令回复序列化器，只在 Serializer 未实现 serializer.CommandResponseSerializer 时使用
+func ReplySerializer(replySerializer serializer.ReplySerializer) Option {
+	return func(d *Device) {
+		d.ReplySerializer = replySerializer
+	}
+}
+
 // Topics 设置主题列表
 func Topics(topics topics.Topics) Option {
 	return func(d *Device) {
@@ -93,6 +377,105 @@ func HTTPClient(HTTPClient http.Client) Option {
 	}
 }
 
+// WithLastWill 设置遗嘱消息
+func WithLastWill(topic string, payload []byte, qos byte, retained bool) Option {
+	return WithLastWillFunc(topic, func() []byte { return payload }, qos, retained)
+}
+
+// WithLastWillFunc 设置遗嘱消息，payload 在创建协议配置项时生成，可携带连接时的设备状态
+func WithLastWillFunc(topic string, payload func() []byte, qos byte, retained bool) Option {
+	return func(d *Device) {
+		d.Will = &protocol.Will{
+			Topic:    topic,
+			Qos:      qos,
+			Retained: retained,
+			Payload:  payload,
+		}
+	}
+}
+
+// WithWillDelayInterval 设置 MQTT 5 遗嘱延迟，连接断开后延迟 delay 再发布遗嘱，期间重连成功则不发布，
+// 避免短暂断线时平台上设备状态反复变化。内置的 MQTT 3.1.1 客户端忽略该设置
+func WithWillDelayInterval(delay time.Duration) Option {
+	return func(d *Device) {
+		d.WillDelayInterval = delay
+	}
+}
+
+// will 创建协议配置项使用的遗嘱消息，合并 WillDelayInterval
+func (d *Device) will() *protocol.Will {
+	if d.Will == nil || d.WillDelayInterval <= 0 {
+		return d.Will
+	}
+	will := *d.Will
+	will.DelayInterval = d.WillDelayInterval
+	return &will
+}
+
+// WithResubscribeBatch 设置重连后重新订阅的节奏，每批订阅 size 个主题，批次之间间隔 delay，
+// 避免订阅大量子设备主题的网关每次重连都一次性发送全部订阅
+func WithResubscribeBatch(size int, delay time.Duration) Option {
+	return func(d *Device) {
+		d.ResubscribeBatch = size
+		d.ResubscribeDelay = delay
+	}
+}
+
+// WithMessageStore 设置 mqtt 消息存储，用于在崩溃后重发未确认的 QoS 1/2 消息，
+// 可使用 protocol.NewStorageStore 与设备凭证共用同一个 Storage
+func WithMessageStore(store mqtt.Store) Option {
+	return func(d *Device) {
+		d.MessageStore = store
+	}
+}
+
+// WithPropertyUnit 注册属性单位，上报该属性时由支持单位的序列化器写入消息
+func WithPropertyUnit(propertyID uint16, unit string) Option {
+	return func(d *Device) {
+		if d.Units == nil {
+			d.Units = make(map[uint16]string)
+		}
+		d.Units[propertyID] = unit
+	}
+}
+
+// WithCommandParams 注册命令参数名称，names 的第 i 个名称对应参数 i，
+// 处理命令时可以通过 CommandContext.ParamByName 按名称读取参数
+func WithCommandParams(commandID uint16, names ...string) Option {
+	return func(d *Device) {
+		if d.CommandParams == nil {
+			d.CommandParams = make(map[uint16][]string)
+		}
+		d.CommandParams[commandID] = names
+	}
+}
+
+// WithClientIDFunc 设置 MQTT ClientID 生成函数，每次创建协议客户端时调用，
+// 如生成 productKey.deviceName|securemode=2| 形式的 ClientID
+func WithClientIDFunc(fn func(d *Device) string) Option {
+	return func(d *Device) {
+		d.ClientIDFunc = fn
+	}
+}
+
+// WithTLSPSK 使用 TLS-PSK 连接，需先设置 protocol.PSKDialer
+func WithTLSPSK(identity string, key []byte) Option {
+	return func(d *Device) {
+		d.PSK = &protocol.PSK{
+			Identity: identity,
+			Key:      key,
+		}
+	}
+}
+
+// WithDialer 使用 dial 建立协议的网络连接，如通过 SOCKS5 代理连接、绑定源地址或网卡、设置 TCP 选项。
+// 只支持基于连接的协议，不能与 WithTLSPSK 同时使用
+func WithDialer(dial func(network, addr string) (net.Conn, error)) Option {
+	return func(d *Device) {
+		d.Dialer = dial
+	}
+}
+
 // GetDeviceInfo 获取设备信息
 func (d *Device) GetDeviceInfo() (*Device, error) {
 	ProductKeyInter, err := d.Storage.Get(d.Name + ".ProductKey")
@@ -123,8 +506,8 @@ func (d *Device) GetDeviceInfo() (*Device, error) {
 	if err != nil {
 		return nil, err
 	}
-	IDInt, _ := typeconv.InterfaceToInt(IDInter)
-	ID := int64(IDInt)
+	// 32 位平台上 int 只有 32 位，按 int64 读取，避免截断较大的设备 ID
+	ID, _ := typeconv.InterfaceToInt64(IDInter)
 
 	AccessInter, err := d.Storage.Get(d.Name + ".Access")
 	if err != nil {
@@ -149,59 +532,74 @@ func (d *Device) GetDeviceInfo() (*Device, error) {
 	}, nil
 }
 
-// LoadDeviceInfo 合并设备信息
-func (d *Device) LoadDeviceInfo() error {
-	tmp, err := d.GetDeviceInfo()
-	if err != nil {
-		return err
-	}
-	return mergo.Merge(d, tmp, mergo.WithOverride)
+// SetDeviceInfo 设置设备信息，只写入非零值的字段，零值字段保留 Storage 中原有的值
+func (d *Device) SetDeviceInfo() error {
+	return d.setDeviceInfo(false)
 }
 
-// SetDeviceInfo 设置设备信息
-func (d *Device) SetDeviceInfo() error {
-	storage := d.Storage
-	if d.ProductKey != "" {
-		if err := storage.Set(d.Name+".ProductKey", d.ProductKey); err != nil {
-			return err
-		}
-	}
-	if d.Name != "" {
-		if err := storage.Set(d.Name+".Name", d.Name); err != nil {
-			return err
-		}
-	}
-	if d.Secret != "" {
-		if err := storage.Set(d.Name+".Secret", d.Secret); err != nil {
-			return err
-		}
-	}
-	if d.Version != "" {
-		if err := storage.Set(d.Name+".Version", d.Version); err != nil {
-			return err
-		}
-	}
-	if d.ID != 0 {
-		if err := storage.Set(d.Name+".ID", d.ID); err != nil {
-			return err
-		}
+// SetDeviceInfoForce 设置设备信息，零值字段从 Storage 中删除，使 Storage 与当前设备信息完全一致，
+// 用于清除 Secret、Token 等已保存的值
+func (d *Device) SetDeviceInfoForce() error {
+	return d.setDeviceInfo(true)
+}
+
+// setDeviceInfo 写入设备信息，force 为 true 时删除零值字段
+func (d *Device) setDeviceInfo(force bool) error {
+	c := d.Credentials()
+	fields := []struct {
+		key   string
+		value interface{}
+		zero  bool
+	}{
+		{"ProductKey", d.ProductKey, d.ProductKey == ""},
+		{"Name", d.Name, d.Name == ""},
+		{"Secret", c.Secret, c.Secret == ""},
+		{"Version", d.Version, d.Version == ""},
+		{"ID", c.ID, c.ID == 0},
+		{"Token", c.Token, c.Token == nil},
+		{"Access", c.Access, c.Access == ""},
 	}
-	if d.Token != nil {
-		if err := storage.Set(d.Name+".Token", d.Token); err != nil {
-			return err
+	storage := d.Storage
+	for _, f := range fields {
+		key := d.Name + "." + f.key
+		if !f.zero {
+			if err := storage.Set(key, f.value); err != nil {
+				return err
+			}
+			continue
 		}
-	}
-	if d.Access != "" {
-		if err := storage.Set(d.Name+".Access", d.Access); err != nil {
-			return err
+		if force {
+			if err := storage.Del(key); err != nil {
+				return err
+			}
 		}
 	}
+	d.enforceStorageQuota()
 	return nil
 }
 
-// Register 注册
+// defaultFlight 未通过 New 创建的设备共用的 singleflight.Group
+var defaultFlight singleflight.Group
+
+// flightDo 合并同一设备并发的 op 调用，如注册、登录。key 带上 ProductKey，
+// 共用 defaultFlight 的设备不会因为不同产品下 Name 相同而共享调用结果
+func (d *Device) flightDo(op string, fn func() error) error {
+	g := d.flight
+	if g == nil {
+		g = &defaultFlight
+	}
+	return g.Do(d.ProductKey+"/"+d.Name+"."+op, fn)
+}
+
+// Register 注册，同一设备的并发调用共享同一次注册请求及结果
 func (d *Device) Register() error {
-	args, err := RegisterArgsFromDevice(*d)
+	return d.flightDo("Register", func() error {
+		return d.traced("register", d.register)
+	})
+}
+
+func (d *Device) register() error {
+	args, err := newRegisterArgs(d.ProductKey, d.Name, d.Version)
 	if err != nil {
 		return errors.Wrap(err, "device register failed, from device create register arguments failed")
 	}
@@ -213,8 +611,12 @@ func (d *Device) Register() error {
 	if err != nil {
 		return errors.Wrap(err, "device register failed, register response is error")
 	}
+	defer jsonresp.Body.Close()
+	body, err := readResponse(jsonresp)
+	if err != nil {
+		return errors.Wrap(err, "device register failed, read response failed")
+	}
 	response := RegisterResponse{}
-	body, _ := ioutil.ReadAll(jsonresp.Body)
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		return errors.Wrap(err, "device register failed, register rest api response convert to json failed")
@@ -222,15 +624,73 @@ func (d *Device) Register() error {
 	if err := HTTPIsOK(response); err != nil {
 		return errors.Wrap(err, "device register failed, register rest api state not is ok")
 	}
-	d.ID = response.Data.ID
-	d.Secret = response.Data.Secret
-	d.SetDeviceInfo()
-	return nil
+	d.lockAuth(func() {
+		d.ID = response.Data.ID
+		d.Secret = response.Data.Secret
+	})
+	response.Raw = body
+	response.Data.Extra = extraFields(body, response.Data)
+	d.responses.setRegister(&response)
+	return d.persistDeviceInfo("register")
 }
 
-// Login 登陆
+// IsRegistered 查询设备是否已在平台注册，设备不存在时返回 false, nil，
+// 请求失败或平台返回错误时返回 error
+func (d *Device) IsRegistered(ctx context.Context) (bool, error) {
+	if d.ProductKey == "" || d.Name == "" {
+		return false, errors.New("query device status failed, field ProductKey and Name cannot be empty")
+	}
+	query := url.Values{}
+	query.Set("product_key", d.ProductKey)
+	query.Set("device_code", d.Name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.Topics.DeviceStatus+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, errors.Wrap(err, "query device status failed, create request failed")
+	}
+	jsonresp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "query device status failed, request status rest api failed")
+	}
+	defer jsonresp.Body.Close()
+	if jsonresp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	response := StatusResponse{}
+	body, err := ioutil.ReadAll(jsonresp.Body)
+	if err != nil {
+		return false, errors.Wrap(err, "query device status failed, read response failed")
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false, errors.Wrap(err, "query device status failed, status rest api response convert to json failed")
+	}
+	if err := HTTPIsOK(response); err != nil {
+		return false, errors.Wrap(err, "query device status failed, status rest api state not is ok")
+	}
+	return response.Data.Registered, nil
+}
+
+// ErrLoginRejected 平台拒绝登录，返回的状态码不为 0，如密钥错误
+var ErrLoginRejected = errors.New("login rejected")
+
+// Login 登陆，同一设备的并发调用共享同一次登录请求及结果
 func (d *Device) Login() error {
-	args, err := AuthArgsFromDevice(*d)
+	return d.flightDo("Login", func() error {
+		return d.traced("login", d.login)
+	})
+}
+
+func (d *Device) login() error {
+	return d.loginWith("")
+}
+
+// loginWith 登录，secret 不为空时使用 secret 代替当前密钥登录，成功后与 Token 一起替换内存中的密钥，
+// 用于密钥轮换时确认新密钥
+func (d *Device) loginWith(secret string) error {
+	c := d.Credentials()
+	if secret != "" {
+		c.Secret = secret
+	}
+	args, err := newAuthArgs(c, d.Protocol.GetName(), d.Compression)
 	if err != nil {
 		return errors.Wrap(err, "device login failed, from device create auth arguments failed")
 	}
@@ -242,117 +702,323 @@ func (d *Device) Login() error {
 	if err != nil {
 		return errors.Wrap(err, "device login failed, request login rest api failed")
 	}
+	defer jsonresp.Body.Close()
+	body, err := readResponse(jsonresp)
+	if err != nil {
+		return errors.Wrap(err, "device login failed, read response failed")
+	}
 	response := AuthResponse{}
-	body, _ := ioutil.ReadAll(jsonresp.Body)
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		return errors.Wrap(err, "device login failed, login rest api response convert to json failed")
 	}
 	if err := HTTPIsOK(response); err != nil {
-		return errors.Wrap(err, "device login failed, login rest api state not is ok")
+		return errors.Wrapf(ErrLoginRejected, "device login failed, login rest api state not is ok: %v", err)
 	}
 	hexToken, err := hex.DecodeString(response.Data.AccessToken)
 	if err != nil {
 		return errors.Wrap(err, "device login failed, access convert to byte failed")
 	}
-	d.Token = hexToken
-	d.Access = response.Data.AccessAddr
-	d.SetDeviceInfo()
-	return nil
+	d.lockAuth(func() {
+		if secret != "" {
+			d.Secret = secret
+		}
+		d.Token = hexToken
+		d.Access = response.Data.AccessAddr
+		d.tokenExpiresAt = time.Time{}
+		if response.Data.ExpiresIn > 0 {
+			d.tokenExpiresAt = time.Now().Add(time.Duration(response.Data.ExpiresIn) * time.Second)
+		}
+		d.platformCompression = response.Data.Compression
+	})
+	response.Raw = body
+	response.Data.Extra = extraFields(body, response.Data)
+	d.responses.setLogin(&response)
+	return d.persistDeviceInfo("login")
 }
 
-// AutoLogin 自动登录
+// AutoLogin 自动登录，并发调用时只会注册一次
 func (d *Device) AutoLogin() error {
-	if d.Token == nil || d.Access == "" {
+	return d.flightDo("AutoLogin", d.autoLogin)
+}
+
+func (d *Device) autoLogin() error {
+	if c := d.Credentials(); c.Token == nil || c.Access == "" {
 		if err := d.Register(); err != nil {
 			return err
 		}
+		if d.Activation {
+			if err := d.Activate(context.Background()); err != nil {
+				return err
+			}
+		}
 	}
 	return d.Login()
 }
 
-// InitProtocolClient 初始化协议客户端
+// CircuitState 连接熔断器状态，未设置熔断器时始终为 CircuitClosed
+func (d *Device) CircuitState() CircuitState {
+	if d.Breaker == nil {
+		return CircuitClosed
+	}
+	return d.Breaker.State()
+}
+
+// InitProtocolClient 初始化协议客户端，熔断器打开时返回 ErrCircuitOpen，
+// 设置了 Dialer 而协议不是基于连接的时返回 protocol.ErrDialerUnsupported
 func (d *Device) InitProtocolClient(opts ...interface{}) error {
+	if d.Dialer != nil {
+		if c, ok := d.Protocol.(protocol.ConnectionOriented); !ok || !c.SupportsDialer() {
+			return errors.Wrapf(protocol.ErrDialerUnsupported, "init protocol client failed, protocol %s", d.Protocol.GetName())
+		}
+	}
+	if d.Breaker == nil {
+		return d.initProtocolClient(opts...)
+	}
+	if err := d.Breaker.Allow(); err != nil {
+		return err
+	}
+	if err := d.initProtocolClient(opts...); err != nil {
+		d.Breaker.Failure()
+		return err
+	}
+	d.Breaker.Success()
+	return nil
+}
+
+func (d *Device) initProtocolClient(opts ...interface{}) error {
 	if len(opts) > 0 {
 		// 用户传入配置，使用配置创建客户端
 		return d.Protocol.NewClient(opts[0])
 	}
-	// 默认创建 MQTT 配置
-	return d.initMQTTClient()
+	// 使用设备信息创建通用配置，由协议的 MakeOpts 转换为各自的配置
+	return d.initDefaultClient()
 }
 
-func (d *Device) initMQTTClient() error {
-	IDStr := strconv.Itoa(int(d.ID))
-	TokenStr := hex.EncodeToString(d.Token) // 817aecf06c023365
-	mqttOpts := map[string]interface{}{
-		"Broker":    d.Access,
-		"ClientID":  IDStr,
-		"Username":  IDStr,
-		"Password":  TokenStr,
-		"KeepAlive": 30 * time.Second,
+// clientID 生成 MQTT ClientID
+func (d *Device) clientID() string {
+	if d.ClientIDFunc != nil {
+		return d.ClientIDFunc(d)
+	}
+	return strconv.FormatInt(d.Credentials().ID, 10)
+}
+
+// initDefaultClient 按设备信息创建客户端，Broker 为登录返回的接入地址，
+// 协议只使用自己支持的参数，如 CoAP 忽略 KeepAlive、Will、Store 等 MQTT 专用参数
+func (d *Device) initDefaultClient() error {
+	tlsConfig, err := d.tlsConfig()
+	if err != nil {
+		return errors.Wrapf(err, "init %s client failed", d.Protocol.GetName())
+	}
+	c := d.Credentials()
+	IDStr := strconv.FormatInt(c.ID, 10)
+	TokenStr := hex.EncodeToString(c.Token) // 817aecf06c023365
+	params := map[string]interface{}{
+		"Broker":         c.Access,
+		"ClientID":       d.clientID(),
+		"Username":       IDStr,
+		"Password":       TokenStr,
+		"KeepAlive":      d.keepAlive(),
+		"Will":           d.will(),
+		"Store":          d.MessageStore,
+		"PSK":            d.PSK,
+		"Dialer":         d.Dialer,
+		"MaxReceiveSize": d.MaxReceiveSize,
+		// 重连后分批重新订阅，避免订阅很多的网关一次性压垮服务端
+		"ResubscribeBatch": d.ResubscribeBatch,
+		"ResubscribeDelay": d.ResubscribeDelay,
+		// 自动重连同样经过熔断器，熔断器打开时跳过本次重连
+		"OnReconnecting": func() error {
+			if d.Breaker == nil {
+				return nil
+			}
+			return d.Breaker.Allow()
+		},
+		"OnReconnectFailed": func() {
+			if d.Breaker != nil {
+				d.Breaker.Failure()
+			}
+		},
+		// 连接建立后重发断开期间未发送成功的命令回复与离线消息，上报设备信息
+		"OnConnect": func() {
+			if d.Breaker != nil {
+				d.Breaker.Success()
+			}
+			d.goroutines.spawn(d.flushReplies)
+			d.goroutines.spawn(d.flushOffline)
+			d.goroutines.spawn(d.reportDeviceInfo)
+		},
+		"OnDisconnect": func(reason protocol.DisconnectReason, err error) {
+			d.stats.recordDisconnect(reason, err)
+			if d.onDisconnect != nil {
+				d.onDisconnect(reason, err)
+			}
+		},
 		// 断开后，执行 login，刷新 token，重连
-		"OnConnectionLost": func() map[string]interface{} {
-			fmt.Println("connection lost")
-			d.Login()
+		"OnConnectionLost": func(reason protocol.DisconnectReason) map[string]interface{} {
+			d.logf(log.LevelWarn, "connection lost, reason: %v", reason)
+			if !d.shouldLoginOnReconnect(reason) {
+				return nil
+			}
+			if err := d.Login(); err != nil {
+				d.logf(log.LevelWarn, "reconnect login failed: %v", err)
+			}
+			d.negotiateCompression()
 			return map[string]interface{}{
-				"Password": d.Token,
+				"Password": d.Credentials().Token,
 			}
 		},
 	}
-	newOpts, err := d.Protocol.MakeOpts(mqttOpts)
+	if tlsConfig != nil {
+		params["TLSConfig"] = tlsConfig
+	}
+	newOpts, err := d.Protocol.MakeOpts(params)
 	if err != nil {
-		return errors.Wrap(err, "init mqtt client failed")
+		return errors.Wrapf(err, "init %s client failed", d.Protocol.GetName())
 	}
 	return d.Protocol.NewClient(newOpts)
 }
 
-// Publish 发布
-func (d *Device) Publish(request request.Request) error {
-	params := protocol.OptionsFormatter(request)
-	return d.Protocol.Publish(params)
+// Publish 发布，opts 覆盖 request 中的 QoS、Retained
+func (d *Device) Publish(request request.Request, opts ...PublishOption) error {
+	params := protocol.OptionsFormatter(*applyPublishOptions(&request, opts))
+	return d.publish(params)
 }
 
 // Subscribe 订阅
 func (d *Device) Subscribe(request request.Request) error {
+	request.Callback = d.bufferCallback(request.Callback)
 	opts := protocol.OptionsFormatter(request)
-	return d.Protocol.Subscribe(opts)
+	if err := d.Protocol.Subscribe(opts); err != nil {
+		return err
+	}
+	d.handlers.set(request.Topic, request.Callback)
+	return nil
+}
+
+// SubscribeMultiple 同时订阅多个主题，filters 的 value 为 QoS，返回每个主题的订阅结果。
+// 协议未实现 protocol.MultiSubscriber 时逐个订阅
+func (d *Device) SubscribeMultiple(filters map[string]byte, callback func(request.Response)) (map[string]protocol.SubscribeResult, error) {
+	callback = d.bufferCallback(callback)
+	results, err := protocol.SubscribeMultiple(d.Protocol, map[string]interface{}{
+		"Topics":   filters,
+		"Callback": callback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for topic, result := range results {
+		if result.Err == nil {
+			d.handlers.set(topic, callback)
+		}
+	}
+	return results, nil
 }
 
 // Unsubscribe 取消订阅
 func (d *Device) Unsubscribe(topics []string) error {
+	d.handlers.del(topics...)
 	return d.Protocol.Unsubscribe(map[string]interface{}{"topics": topics})
 }
 
+// MQTTClient 获取底层的 MQTT 客户端，协议不是 MQTT 或尚未初始化客户端时返回 false。
+// 这是为高级用法保留的出口，SDK 不保证底层客户端的接口在版本间保持兼容，
+// 直接操作客户端（如断开连接、修改订阅）可能导致 SDK 的状态不一致
+func (d *Device) MQTTClient() (*mqtt.Client, bool) {
+	m, ok := d.Protocol.(*protocol.MQTT)
+	if !ok || m.Client == nil {
+		return nil, false
+	}
+	return m.Client, true
+}
+
 // toSerializerProperty device.Property 转换到 serializer.Property
 func (p *Property) toSerializerProperty() *serializer.Property {
 	sp := &serializer.Property{}
 	sp.PropertyID = p.PropertyID
 	sp.SubDeviceID = p.SubDeviceID
 	sp.Value = p.Value
+	sp.Quality = p.Quality
+	sp.Unit = p.Unit
+	sp.Version = p.Version
+	sp.Timestamp = p.Timestamp
+	sp.Increment = p.Increment
+	sp.Sequence = p.Sequence
 	return sp
 }
 
-// PostProperty 上报属性
-func (d *Device) PostProperty(property Property) error {
-	data, err := d.Serializer.MakePropertyData(property.toSerializerProperty())
+// withUnit 未指定单位时使用注册的属性单位，子设备绑定了命名空间时优先使用命名空间中的单位
+func (d *Device) withUnit(property Property) Property {
+	if property.Unit != "" {
+		return property
+	}
+	if namespace, ok := d.namespaceOf(property.SubDeviceID); ok {
+		if unit, ok := namespace.Units[property.PropertyID]; ok {
+			property.Unit = unit
+			return property
+		}
+	}
+	property.Unit = d.Units[property.PropertyID]
+	return property
+}
+
+// PostProperty 上报属性，默认 QoS 为 1、不保留，可以通过 opts 修改
+func (d *Device) PostProperty(property Property, opts ...PublishOption) error {
+	return d.PostPropertyWithPriority(property, PriorityNormal, opts...)
+}
+
+// PostPropertyWithTimestamp 上报 ts 时采集的属性，用于补发缓存的采样、读取传感器日志等历史数据，
+// 平台以 ts 而不是接收时间作为采集时间。ts 为零值时与 PostProperty 相同
+func (d *Device) PostPropertyWithTimestamp(property Property, ts time.Time, opts ...PublishOption) error {
+	if !ts.IsZero() {
+		property.Timestamp = ts
+	}
+	return d.PostProperty(property, opts...)
+}
+
+// PostPropertyWithPriority 按优先级上报属性，发布排队时优先发送高优先级的消息
+func (d *Device) PostPropertyWithPriority(property Property, p Priority, opts ...PublishOption) error {
+	return d.postPropertyContext(context.Background(), property, p, opts...)
+}
+
+// postPropertyContext 按优先级上报属性，开启阻塞发布时 ctx 结束后不再等待。
+// 开启批量上报时缓冲的属性随批量消息发送，忽略 opts
+func (d *Device) postPropertyContext(ctx context.Context, property Property, p Priority, opts ...PublishOption) error {
+	property = d.withUnit(property)
+	// 开启批量上报时高优先级的属性仍然立即发送
+	if d.TelemetryBatcher != nil && d.telemetry != nil && p != PriorityHigh {
+		return d.bufferTelemetry(property)
+	}
+	request, err := d.makePropertyRequest(property, opts...)
 	if err != nil {
 		return err
 	}
-	request := protocol.OptionsFormatter(*makePostPropertyRequest(d, data))
+	return d.publishBlocking(ctx, p, request, trace.Int(trace.AttrPropertyID, int(property.PropertyID)))
+}
+
+// makePropertyRequest 序列化属性并创建发布参数
+func (d *Device) makePropertyRequest(property Property, opts ...PublishOption) (map[string]interface{}, error) {
+	data, err := d.serializerFor(d.Topics.PostProperty).MakePropertyData(property.toSerializerProperty())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if data, err = d.compress(data); err != nil {
+		return nil, err
 	}
-	return d.Protocol.Publish(request)
+	if data, err = d.encodePayload(data); err != nil {
+		return nil, err
+	}
+	return protocol.OptionsFormatter(*makePostPropertyRequest(d, data, opts...)), nil
 }
 
 // makePostPropertyRequest 创建上报属性请求
-func makePostPropertyRequest(d *Device, payload []byte) *request.Request {
+func makePostPropertyRequest(d *Device, payload []byte, opts ...PublishOption) *request.Request {
 	request := &request.Request{}
 	request.Topic = d.Topics.PostProperty
 	request.Qos = 1
 	request.Retained = false
 	request.Payload = payload
-	return request
+	return applyPublishOptions(request, opts)
 }
 
 // InitOptions 初始化配置项
@@ -363,6 +1029,12 @@ type InitOptions struct {
 	ReregisterInterval           time.Duration
 	ReloginInterval              time.Duration
 	ReInitProtocolClientInterval time.Duration
+	// BackoffFactor 每次重试后间隔乘以该系数，小于 1 时间隔不增长
+	BackoffFactor float64
+	// MaxInterval 重试间隔上限，为 0 时不限制
+	MaxInterval time.Duration
+	// MaxAttempts 最多尝试的次数（包括第一次），达到后返回最后一次的错误，为 0 时不限制
+	MaxAttempts int
 }
 
 var defaultInitOptions = InitOptions{
@@ -372,6 +1044,8 @@ var defaultInitOptions = InitOptions{
 	ReregisterInterval:           5 * time.Second,
 	ReloginInterval:              5 * time.Second,
 	ReInitProtocolClientInterval: 5 * time.Second,
+	BackoffFactor:                2,
+	MaxInterval:                  5 * time.Minute,
 }
 
 func getFinallyInitOpts(opts ...InitOptions) InitOptions {
@@ -382,31 +1056,26 @@ func getFinallyInitOpts(opts ...InitOptions) InitOptions {
 	return finallyOpts
 }
 
-// AutoInit 自动初始化
+// AutoInit 自动初始化，开启 AutoRelogin、AutoReInitProtocolClient 时失败后按指数退避重试
 func (d *Device) AutoInit(opts ...InitOptions) error {
 	finallyOpts := getFinallyInitOpts(opts...)
 	if typeconv.IsNil(d.Protocol.GetInstance()) {
 		if err := d.AutoLogin(); err != nil {
-			if finallyOpts.AutoRelogin {
-				for {
-					time.Sleep(finallyOpts.ReregisterInterval)
-					if err := d.AutoLogin(); err == nil {
-						break
-					}
-				}
-			} else {
+			if !finallyOpts.AutoRelogin {
+				return err
+			}
+			if err := d.retry(finallyOpts, finallyOpts.ReloginInterval, "auto login", err, d.AutoLogin); err != nil {
 				return err
 			}
 		}
+		// 根据登录结果协商是否压缩上报数据
+		d.negotiateCompression()
 		if err := d.InitProtocolClient(); err != nil {
-			if finallyOpts.AutoReInitProtocolClient {
-				for {
-					time.Sleep(finallyOpts.ReInitProtocolClientInterval)
-					if err := d.InitProtocolClient(); err == nil {
-						break
-					}
-				}
-			} else {
+			if !finallyOpts.AutoReInitProtocolClient {
+				return err
+			}
+			retryInit := func() error { return d.InitProtocolClient() }
+			if err := d.retry(finallyOpts, finallyOpts.ReInitProtocolClientInterval, "init protocol client", err, retryInit); err != nil {
 				return err
 			}
 		}
@@ -425,57 +1094,268 @@ func (d *Device) AutoPostProperty(property Property, opts ...InitOptions) error
 	return d.PostProperty(property)
 }
 
-// OnProperty 设置属性
-func (d *Device) OnProperty(callback func(property interface{})) {
+// OnProperty 订阅 Topics.OnProperty，平台下发（设置、同步）属性时解码并调用 callback，
+// 一条消息包含多个属性时按顺序逐个回调。解码失败时记录日志并调用 OnCommandError 设置的回调
+func (d *Device) OnProperty(callback func(property Property)) error {
+	if d.Topics.OnProperty == "" {
+		return errors.New("on property failed, topic OnProperty is empty")
+	}
+	callbackFn := func(resp request.Response) {
+		if err := d.checkPayloadSize(resp); err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		p, err := d.decodePayload(resp.Payload())
+		if err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		properties, err := d.unmarshalProperties(resp.Topic(), p)
+		if err != nil {
+			err = errors.Wrap(err, "unmarshal property failed")
+			d.logf(log.LevelError, "%v", err)
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		for _, property := range properties {
+			if d.Paused() {
+				d.commandError(resp.Topic(), errors.Wrapf(ErrPaused, "property %d ignored", property.PropertyID))
+				continue
+			}
+			if err := d.checkNamespace(Property(*property)); err != nil {
+				d.commandError(resp.Topic(), err)
+				continue
+			}
+			callback(Property(*property))
+		}
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.OnProperty
+	r.Qos = 1
+	r.Callback = d.bufferCallback(callbackFn)
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
+
+// OnDisconnect 设置连接断开回调，需在 InitProtocolClient 之前调用。
+// 断开原因为 protocol.DisconnectIdentityConflict 时不会自动重连
+func (d *Device) OnDisconnect(callback func(reason protocol.DisconnectReason, err error)) {
+	d.onDisconnect = callback
+}
 
+// PostEvent 发送事件，默认 QoS 为 1、不保留，可以通过 opts 修改
+func (d *Device) PostEvent(identifier string, property Property, opts ...PublishOption) error {
+	return d.PostEventWithPriority(identifier, property, PriorityNormal, opts...)
 }
 
-// PostEvent 发送事件
-func (d *Device) PostEvent(identifier string, property Property) error {
-	data, err := d.Serializer.MakeEventData(property.toSerializerProperty())
+// PostEventWithPriority 按优先级上报事件，告警等事件可以使用 PriorityHigh 优先发送
+func (d *Device) PostEventWithPriority(identifier string, property Property, p Priority, opts ...PublishOption) error {
+	// 可能放入离线队列时记录发生时间，重连后发送的事件保留原来的时间
+	if d.QueueWhenOffline {
+		property = stamped(property)
+	}
+	data, err := d.serializerFor(d.Topics.PostEvent).MakeEventData(property.toSerializerProperty())
 	if err != nil {
 		return err
 	}
-	request := protocol.OptionsFormatter(*makePostEventRequest(d, data))
-	return d.Protocol.Publish(request)
+	if data, err = d.compress(data); err != nil {
+		return err
+	}
+	if data, err = d.encodePayload(data); err != nil {
+		return err
+	}
+	request := protocol.OptionsFormatter(*makePostEventRequest(d, data, opts...))
+	return d.publishBlocking(context.Background(), p, request, trace.Int(trace.AttrPropertyID, int(property.PropertyID)))
 }
 
 // makePostEventRequest 创建上报事件请求
-func makePostEventRequest(d *Device, payload []byte) *request.Request {
+func makePostEventRequest(d *Device, payload []byte, opts ...PublishOption) *request.Request {
 	request := &request.Request{}
 	request.Topic = d.Topics.PostEvent
 	request.Qos = 1
 	request.Retained = false
 	request.Payload = payload
-	return request
+	return applyPublishOptions(request, opts)
 }
 
 // Command 命令
 type Command struct {
 	ID       uint16
 	Callback func(map[int]interface{})
+	// Handler 带回复的命令处理函数，设置后忽略 Callback，处理完成后将返回值作为回复发送到
+	// Topics.CommandResponse，返回错误时回复 ReplyCodeError
+	Handler func(map[int]interface{}) (interface{}, error)
+	// ContextHandler 与 Handler 相同，参数为完整的 CommandContext，可以按名称读取参数，设置后忽略 Handler
+	ContextHandler func(ctx CommandContext) (interface{}, error)
+	// ReplyCallback 通过 reply 自行回复的命令处理函数，回复以 CommandResponse 的格式发送，
+	// 可以在处理过程中先回复再继续处理，不回复时不发送。设置了 Handler 或 ContextHandler 时忽略
+	ReplyCallback func(params map[int]interface{}, reply ReplyFunc)
 }
 
-// OnCommand 响应命令
+// OnCommand 响应命令，命令注册到 CommandRouter，收到命令后由 CommandRouter 分发
 func (d *Device) OnCommand(cmds ...Command) error {
-	callbacks := make(map[uint16]func(map[int]interface{}))
-	for _, cmd := range cmds {
-		callbacks[cmd.ID] = cmd.Callback
+	if d.CommandRouter == nil {
+		d.CommandRouter = NewMapRouter()
+	}
+	router := d.CommandRouter
+	// 内置命令先注册，应用注册相同 ID 的命令时覆盖内置命令
+	if _, ok := router.Route(CommandContext{ID: PropertySnapshotCommandID}); !ok {
+		router.Add(d.snapshotCommand())
 	}
+	router.Add(cmds...)
 	callbackFn := func(resp request.Response) {
-		p := resp.Payload()
-		cmdPayload, err := d.Serializer.UnmarshalCommand(p)
+		// 超长的消息不解析
+		if err := d.checkPayloadSize(resp); err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		// 保留消息在每次连接时都会收到，视为过期命令
+		if d.IgnoreRetainedCommands && resp.Retained() {
+			d.commandError(resp.Topic(), ErrRetainedCommand)
+			return
+		}
+		p, err := d.decodePayload(resp.Payload())
 		if err != nil {
-			// TODO log
+			d.commandError(resp.Topic(), err)
 			return
 		}
-		params := cmdPayload.Params
-		params[-1] = cmdPayload.SubDeviceID
-		if callback, ok := callbacks[cmdPayload.ID]; ok {
-			callback(params)
+		cmdPayload, err := d.unmarshalCommand(resp.Topic(), p)
+		if err != nil {
+			err = errors.Wrap(err, "unmarshal command failed")
+			d.logf(log.LevelError, "%s: %v", resp.Topic(), err)
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		// 不带参数的命令（如上报全部属性）解析后可能没有参数表
+		if cmdPayload.Params == nil {
+			cmdPayload.Params = map[int]interface{}{}
+		}
+		cmdPayload.Params[-1] = cmdPayload.SubDeviceID
+		ctx := CommandContext{
+			Topic:       resp.Topic(),
+			ID:          cmdPayload.ID,
+			SubDeviceID: cmdPayload.SubDeviceID,
+			Params:      cmdPayload.Params,
+			ParamNames:  d.CommandParams[cmdPayload.ID],
+			Payload:     p,
+
+			ResponseTopic:   request.ResponseTopic(resp),
+			CorrelationData: request.CorrelationData(resp),
+			Token:           cmdPayload.Token,
+		}
+		cmd, ok := router.Route(ctx)
+		if !ok {
+			return
+		}
+		id := d.inflight.deliveryID()
+		if d.Paused() {
+			d.rejectPaused(cmd, ctx, id)
+			return
+		}
+		// 重复投递的命令已处理或正在处理则跳过，执行成功后才记录，失败的命令重新投递时再次执行
+		logID := ""
+		if d.CommandLog != nil {
+			logID = commandLogID(ctx)
 		}
+		if logID != "" {
+			reserved, err := d.CommandLog.reserve(d.Storage, logID)
+			if err != nil {
+				// 读取去重记录失败时照常执行，不记录
+				d.logf(log.LevelWarn, "load command log failed: %v", err)
+				logID = ""
+			} else if !reserved {
+				return
+			}
+		}
+		accepted := d.dispatchCommand(cmd, ctx, id, func() {
+			err := d.runCommand(cmd, ctx, id)
+			if logID == "" {
+				return
+			}
+			if err := d.CommandLog.release(d.Storage, logID, err == nil); err != nil {
+				d.logf(log.LevelWarn, "record command %s failed: %v", logID, err)
+				return
+			}
+			if err == nil {
+				d.enforceStorageQuota()
+			}
+		})
+		if !accepted && logID != "" {
+			d.CommandLog.release(d.Storage, logID, false)
+		}
+	}
+	r := makeOnCommandRequest(d, d.bufferCallback(callbackFn))
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
+
+// runCommand 执行命令，设置了 ContextHandler 或 Handler 时发送回复，设置了 ReplyCallback 时由其自行回复，
+// id 为本次投递的序号，用于关联处理中的命令与回复。返回 ContextHandler、Handler 的错误
+func (d *Device) runCommand(cmd Command, ctx CommandContext, id string) error {
+	span := d.startSpan("command", trace.Int(trace.AttrCommandID, int(ctx.ID)))
+	defer span.End()
+	if cmd.ContextHandler == nil && cmd.Handler == nil && cmd.ReplyCallback == nil {
+		cmd.Callback(ctx.Params)
+		return nil
+	}
+	receivedAt := d.inflight.begin(id)
+	defer d.inflight.end(id)
+	if cmd.ContextHandler == nil && cmd.Handler == nil {
+		cmd.ReplyCallback(ctx.Params, d.replyFunc(ctx, id, receivedAt))
+		return nil
+	}
+	var data interface{}
+	var err error
+	if cmd.ContextHandler != nil {
+		data, err = cmd.ContextHandler(ctx)
+	} else {
+		data, err = cmd.Handler(ctx.Params)
+	}
+	reply := &serializer.Reply{
+		CommandID:   ctx.ID,
+		SubDeviceID: ctx.SubDeviceID,
+		Code:        serializer.ReplyCodeOK,
+		Data:        data,
+	}
+	if err != nil {
+		reply.Code = serializer.ReplyCodeError
+		if e, ok := errors.Cause(err).(*ReplyError); ok {
+			reply.Code = e.Code
+		}
+		reply.Message = err.Error()
+		reply.Data = nil
+	}
+	d.replyCommand(ctx, id, receivedAt, reply)
+	return err
+}
+
+// replyCommand 以 marshalReply 的格式序列化并发送命令回复
+func (d *Device) replyCommand(ctx CommandContext, id string, receivedAt time.Time, reply *serializer.Reply) {
+	replyData, err := d.marshalReply(reply)
+	if err == nil {
+		replyData, err = d.encodePayload(replyData)
+	}
+	if err != nil {
+		d.logf(log.LevelError, "marshal command %d reply failed: %v", ctx.ID, err)
+		return
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.CommandResponse
+	if ctx.ResponseTopic != "" {
+		r.Topic = ctx.ResponseTopic
+	}
+	r.Qos = 1
+	r.Payload = replyData
+	r.CorrelationData = ctx.CorrelationData
+	if err := d.sendReplyTo(id, receivedAt, r); err != nil {
+		d.logf(log.LevelWarn, "reply command %d failed: %v", ctx.ID, err)
 	}
-	return d.Protocol.Subscribe(protocol.OptionsFormatter(*makeOnCommandRequest(d, callbackFn)))
 }
 
 func makeOnCommandRequest(d *Device, callbackFn func(resp request.Response)) *request.Request {
@@ -1,12 +1,48 @@
 package device
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"iot-sdk-go/pkg/coap"
+	"iot-sdk-go/pkg/coap/coaptest"
+	"iot-sdk-go/pkg/mqtt"
+	pkgprotocol "iot-sdk-go/pkg/protocol"
+	"iot-sdk-go/pkg/redis/redistest"
+	sdklog "iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
 	request "iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/serializer"
+	"iot-sdk-go/sdk/storage"
 	"iot-sdk-go/sdk/topics"
+	"iot-sdk-go/sdk/trace"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -242,3 +278,3910 @@ func TestSubscribe(t *testing.T) {
 		t.Fatal("unsubscribe error:", err)
 	}
 }
+
+// memStorage 测试用内存存储
+type memStorage struct {
+	sync.Mutex
+	m       map[string]interface{}
+	flushed int
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{m: map[string]interface{}{}}
+}
+
+func (s *memStorage) Get(key string) (interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.m[key], nil
+}
+
+func (s *memStorage) Set(key string, value interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	s.m[key] = value
+	return nil
+}
+
+func (s *memStorage) Del(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func (s *memStorage) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+	s.flushed++
+	return nil
+}
+
+// newTestServer 模拟注册、登录接口，registered 记录注册次数
+func newTestServer(registered *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(registered, 1)
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(w, `{"code":0,"data":{"device_id":%d,"device_secret":"secret%d"}}`, n, n)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883","expires_in":3600,"region":"cn-east"}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+// failingStorage 写入总是失败的存储
+type failingStorage struct {
+	*memStorage
+}
+
+func (failingStorage) Set(key string, value interface{}) error {
+	return errors.New("disk full")
+}
+
+func TestPersistFailure(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	cases := []struct {
+		policy PersistFailure
+		failed bool
+	}{
+		{PersistWarn, false},
+		{PersistFail, true},
+		{PersistIgnore, false},
+	}
+	for _, c := range cases {
+		d := New(ProductKey, "persist", Version, Storage(failingStorage{newMemStorage()}), WithPersistFailure(c.policy),
+			Topics(topics.Topics{
+				Register: srv.URL + "/register",
+				Login:    srv.URL + "/login",
+			}))
+		for _, op := range []func() error{d.Register, d.Login} {
+			if err := op(); (err != nil) != c.failed {
+				t.Fatalf("policy %d: unexpected error %v", c.policy, err)
+			}
+		}
+		// 保存失败时凭证仍保留在内存中
+		if d.ID == 0 || d.Token == nil {
+			t.Fatalf("policy %d: credentials not kept in memory", c.policy)
+		}
+	}
+}
+
+func TestLoadDeviceInfo(t *testing.T) {
+	store := newMemStorage()
+	old := New(ProductKey, DeviceName, "1.0.0", Storage(store))
+	old.ID, old.Secret, old.Token, old.Access = 7, "secret", []byte("token"), "access"
+	if err := old.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	// 固件升级后代码中的版本以代码为准，凭证从 Storage 加载
+	d := New(ProductKey, DeviceName, "2.0.0", Storage(store))
+	if err := d.LoadDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != "2.0.0" || d.ID != 7 || d.Secret != "secret" || string(d.Token) != "token" || d.Access != "access" {
+		t.Fatalf("unexpected device after load: %+v", d)
+	}
+	// 通过 WithPrecedence 修改字段的优先级
+	d = New(ProductKey, DeviceName, "2.0.0", Storage(store))
+	d.Secret = "new"
+	if err := d.LoadDeviceInfo(WithPrecedence(PreferCode, FieldSecret), WithPrecedence(PreferStorage, FieldVersion)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Secret != "new" || d.Version != "1.0.0" {
+		t.Fatalf("precedence not applied: secret %s, version %s", d.Secret, d.Version)
+	}
+}
+
+func TestSetDeviceInfoForce(t *testing.T) {
+	store := newMemStorage()
+	d := New(ProductKey, DeviceName, Version, Storage(store))
+	d.ID, d.Secret, d.Token, d.Access = 7, "secret", []byte("token"), "access"
+	if err := d.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	d.Secret, d.Token = "", nil
+	// 零值字段保留原有的值
+	if err := d.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := d.GetDeviceInfo(); info.Secret != "secret" || string(info.Token) != "token" {
+		t.Fatalf("got secret %q, token %q, want old values kept", info.Secret, info.Token)
+	}
+	if err := d.SetDeviceInfoForce(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := d.GetDeviceInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Secret != "" || info.Token != nil || info.ID != 7 || info.Access != "access" {
+		t.Fatalf("unexpected device info after force: %+v", info)
+	}
+}
+
+func TestDeviceInfoLargeID(t *testing.T) {
+	store := newMemStorage()
+	d := New(ProductKey, DeviceName, Version, Storage(store))
+	d.ID = math.MaxInt32 + 10
+	if err := d.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	// 存储可能以不同的整数类型返回，如 32 位平台上 YAML 解析得到 int64
+	for _, stored := range []interface{}{d.ID, uint64(d.ID)} {
+		store.Set(DeviceName+".ID", stored)
+		info, err := d.GetDeviceInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ID != math.MaxInt32+10 {
+			t.Fatalf("stored %T, got ID %d, want %d", stored, info.ID, int64(math.MaxInt32+10))
+		}
+	}
+}
+
+func TestClientIDLargeID(t *testing.T) {
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	d.ID = math.MaxUint32 + 10
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	// 32 位平台上 int 转换会截断，ClientID 与 Username 需要完整的设备 ID
+	params := p.opts.(map[string]interface{})
+	if params["ClientID"] != "4294967305" || params["Username"] != "4294967305" {
+		t.Fatalf("got ClientID %v, Username %v", params["ClientID"], params["Username"])
+	}
+}
+
+func TestRedisDeviceInfo(t *testing.T) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	store, err := storage.NewRedis(storage.RedisOptions{Addr: server.Addr, KeyPrefix: "iot:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d := New(ProductKey, DeviceName, Version, Storage(store))
+	d.ID = math.MaxInt32 + 10
+	d.Secret = "secret"
+	d.Token = []byte{0, 1, 0xff}
+	d.Access = "tcp://127.0.0.1:1883"
+	if err := d.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := d.GetDeviceInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != d.ID || info.Secret != d.Secret || !bytes.Equal(info.Token, d.Token) || info.Access != d.Access || info.Version != Version {
+		t.Fatalf("unexpected device info: %+v", info)
+	}
+	if _, ok := server.Get(0, "iot:"+DeviceName+".Token"); !ok {
+		t.Fatal("token not stored with key prefix")
+	}
+}
+
+func TestConcurrentLoginPublish(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d := New(ProductKey, "race", Version, Protocol(p), Storage(newMemStorage()), Topics(topics.Topics{
+		Register:     srv.URL + "/register",
+		Login:        srv.URL + "/login",
+		PostProperty: "property",
+	}))
+	if err := d.AutoLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	lost := p.opts.(map[string]interface{})["OnConnectionLost"].(func(protocol.DisconnectReason) map[string]interface{})
+
+	// 断线重连的重新登录与发布、读取认证信息同时进行，go test -race 不应报告数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			if params := lost(protocol.DisconnectNetwork); params == nil {
+				t.Error("reconnect login should return new password")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := d.Login(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if c := d.Credentials(); c.ID == 0 || c.Access == "" {
+				t.Errorf("unexpected credentials %+v", c)
+			}
+		}()
+	}
+	wg.Wait()
+	if c := d.Credentials(); c.Access != "127.0.0.1:1883" || hex.EncodeToString(c.Token) != "817aecf06c023365" {
+		t.Fatalf("unexpected credentials %+v", c)
+	}
+}
+
+func TestConcurrentLoginPause(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d := New(ProductKey, "pause-race", Version, Protocol(p), Storage(newMemStorage()), WithCompression(), Topics(topics.Topics{
+		Register: srv.URL + "/register",
+		Login:    srv.URL + "/login",
+	}))
+	if err := d.AutoLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	lost := p.opts.(map[string]interface{})["OnConnectionLost"].(func(protocol.DisconnectReason) map[string]interface{})
+
+	// 登录、重连时的压缩协商与 Pause、Resume 同时进行，go test -race 不应报告数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := d.Login(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			lost(protocol.DisconnectNetwork)
+		}()
+		go func() {
+			defer wg.Done()
+			d.Pause()
+			d.Resume()
+		}()
+	}
+	wg.Wait()
+	if d.Paused() {
+		t.Fatal("device should be resumed")
+	}
+}
+
+func TestConcurrentAutoLogin(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	store := newMemStorage()
+	d := New(ProductKey, "concurrent", Version, Storage(store), Topics(topics.Topics{
+		Register: srv.URL + "/register",
+		Login:    srv.URL + "/login",
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.AutoLogin(); err != nil {
+				t.Error("auto login error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if registered != 1 {
+		t.Fatalf("registered %d times, want 1", registered)
+	}
+	if id, _ := store.Get("concurrent.ID"); id != int64(1) {
+		t.Fatalf("stored ID is %v, want 1", id)
+	}
+	if secret, _ := store.Get("concurrent.Secret"); secret != "secret1" {
+		t.Fatalf("stored Secret is %v, want secret1", secret)
+	}
+}
+
+func TestConcurrentAutoLoginSameName(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		var args RegisterArgs
+		json.NewDecoder(r.Body).Decode(&args)
+		time.Sleep(10 * time.Millisecond)
+		id := 1
+		if args.ProductKey == "product2" {
+			id = 2
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"device_id":%d,"device_secret":"secret%d"}}`, id, id)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		var args AuthArgs
+		json.NewDecoder(r.Body).Decode(&args)
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(w, `{"code":0,"data":{"access_token":"0%d","access_addr":"127.0.0.1:188%d"}}`, args.ID, args.ID)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	tp := topics.Topics{Register: srv.URL + "/register", Login: srv.URL + "/login"}
+
+	// 不同产品下名称相同的两个设备并发登录，各自得到自己的凭证
+	devices := []*Device{
+		New("product1", "relay", Version, Storage(newMemStorage()), Topics(tp)),
+		New("product2", "relay", Version, Storage(newMemStorage()), Topics(tp)),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, d := range devices {
+			wg.Add(1)
+			go func(d *Device) {
+				defer wg.Done()
+				if err := d.AutoLogin(); err != nil {
+					t.Error("auto login error:", err)
+				}
+			}(d)
+		}
+	}
+	wg.Wait()
+	for i, d := range devices {
+		id := int64(i + 1)
+		c := d.Credentials()
+		if c.ID != id || c.Secret != fmt.Sprintf("secret%d", id) || c.Access != fmt.Sprintf("127.0.0.1:188%d", id) {
+			t.Fatalf("%s: unexpected credentials %+v", d.ProductKey, c)
+		}
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	store := newMemStorage()
+	l := &CommandLog{Key: "commands", Size: 2}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := l.Record(store, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if processed, _ := l.Processed(store, "a"); processed {
+		t.Fatal("a should be evicted")
+	}
+	for _, id := range []string{"b", "c"} {
+		if processed, _ := l.Processed(store, id); !processed {
+			t.Fatalf("%s should be processed", id)
+		}
+	}
+}
+
+func TestPersistentCommandDedup(t *testing.T) {
+	p := newFakeProtocol()
+	store := newMemStorage()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(store), WithPersistentCommandDedup("commands"),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "token", "0"})), WithMaxConcurrentCommands(2))
+	var runs int32
+	var fail int32 = 1
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	err := d.OnCommand(Command{ID: 1, Handler: func(params map[int]interface{}) (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		started <- struct{}{}
+		<-release
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errors.New("relay stuck")
+		}
+		return nil, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait := func(want int32) {
+		for i := 0; i < 1000 && (d.InflightCommands() != 0 || atomic.LoadInt32(&runs) != want); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&runs); n != want || d.InflightCommands() != 0 {
+			t.Fatalf("runs %d, want %d, inflight %d", n, want, d.InflightCommands())
+		}
+	}
+	// 同时到达的重复投递只执行一次
+	p.deliver(d.Topics.OnCommand, []byte("1,0,uuid-1,on"))
+	<-started
+	p.deliver(d.Topics.OnCommand, []byte("1,0,uuid-1,on"))
+	close(release)
+	wait(1)
+	// 执行失败不记录，重新投递时再次执行
+	p.deliver(d.Topics.OnCommand, []byte("1,0,uuid-1,on"))
+	<-started
+	wait(2)
+	atomic.StoreInt32(&fail, 0)
+	p.deliver(d.Topics.OnCommand, []byte("1,0,uuid-1,on"))
+	<-started
+	wait(3)
+	// 执行成功后重复投递跳过
+	p.deliver(d.Topics.OnCommand, []byte("1,0,uuid-1,on"))
+	wait(3)
+	// 内容相同但标识不同、不带标识的命令都执行
+	for _, payload := range []string{"1,0,uuid-2,on", "1,0,,on", "1,0,,on"} {
+		p.deliver(d.Topics.OnCommand, []byte(payload))
+		<-started
+	}
+	wait(6)
+	if processed, _ := d.CommandLog.Processed(store, "uuid-1"); !processed {
+		t.Fatal("uuid-1 should be recorded")
+	}
+}
+
+// sizedStorage 以 key 与值的文本长度之和作为占用空间的存储
+type sizedStorage struct {
+	*memStorage
+}
+
+func (s sizedStorage) Size() (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var size int64
+	for k, v := range s.m {
+		size += int64(len(k) + len(fmt.Sprint(v)))
+	}
+	return size, nil
+}
+
+func TestStorageQuota(t *testing.T) {
+	store := sizedStorage{newMemStorage()}
+	d := New(ProductKey, DeviceName, Version, Storage(store), WithPersistentCommandDedup("commands"))
+	d.Secret = "secret"
+	if err := d.SetDeviceInfo(); err != nil {
+		t.Fatal(err)
+	}
+	infoSize, _ := d.StorageSize()
+	for i := 0; i < 20; i++ {
+		if err := d.CommandLog.Record(store, fmt.Sprintf("%040d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 超过配额时淘汰最早的命令去重记录
+	d.StorageQuota = infoSize + 200
+	d.enforceStorageQuota()
+	if size, _ := d.StorageSize(); size > d.StorageQuota {
+		t.Fatalf("size %d exceeds quota %d", size, d.StorageQuota)
+	}
+	if processed, _ := d.CommandLog.Processed(store, fmt.Sprintf("%040d", 0)); processed {
+		t.Fatal("oldest command log entry should be evicted")
+	}
+	if processed, _ := d.CommandLog.Processed(store, fmt.Sprintf("%040d", 19)); !processed {
+		t.Fatal("newest command log entry should be kept")
+	}
+	// 设备凭证不淘汰
+	d.StorageQuota = 1
+	d.enforceStorageQuota()
+	if info, _ := d.GetDeviceInfo(); info.Secret != "secret" {
+		t.Fatal("device info should never be evicted")
+	}
+	if v, _ := store.Get("commands"); v != nil {
+		t.Fatalf("command log should be emptied, got %v", v)
+	}
+	// 未实现 storage.Sizer 时不限制
+	if _, err := New(ProductKey, DeviceName, Version, Storage(newMemStorage())).StorageSize(); err != storage.ErrSizeUnsupported {
+		t.Fatalf("got %v, want ErrSizeUnsupported", err)
+	}
+}
+
+func TestRegisterBatch(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	tps := topics.Topics{
+		Register: srv.URL + "/register",
+		Login:    srv.URL + "/login",
+	}
+	devices := []*Device{}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("batch%d", i)
+		devices = append(devices, New(ProductKey, name, Version, Storage(newMemStorage()), Topics(tps)))
+	}
+	devices = append(devices, New(ProductKey, "invalid", "", Storage(newMemStorage()), Topics(tps)))
+	results, err := RegisterBatch(devices, 3)
+	if err == nil {
+		t.Fatal("register batch should fail for invalid device")
+	}
+	if registered != 10 {
+		t.Fatalf("registered %d devices, want 10", registered)
+	}
+	for _, d := range devices[:10] {
+		if results[d.Name] != nil || d.ID == 0 {
+			t.Fatalf("device %s register failed: %v", d.Name, results[d.Name])
+		}
+	}
+	if results["invalid"] == nil {
+		t.Fatal("invalid device should fail")
+	}
+}
+
+// countLimiter 记录 Wait 次数，不限流
+type countLimiter struct {
+	n int32
+}
+
+func (l *countLimiter) Wait() {
+	atomic.AddInt32(&l.n, 1)
+}
+
+func TestRegisterBatchRetry(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	// 前 2 次注册失败
+	var attempts int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"device_id":%d,"device_secret":"secret"}}`, n)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	devices := []*Device{}
+	for i := 0; i < 2; i++ {
+		devices = append(devices, New(ProductKey, fmt.Sprintf("retry%d", i), Version, Storage(newMemStorage()),
+			Topics(topics.Topics{Register: srv.URL + "/register"})))
+	}
+	limiter := &countLimiter{}
+	results, err := RegisterBatch(devices, 1, RegisterBatchLimiter(limiter), RegisterBatchRetry(InitOptions{
+		AutoReregister:     true,
+		ReregisterInterval: 100 * time.Millisecond,
+		BackoffFactor:      2,
+		MaxAttempts:        3,
+	}))
+	if err != nil {
+		t.Fatal(err, results)
+	}
+	if attempts != 4 || limiter.n != 4 || len(slept) != 2 {
+		t.Fatalf("attempts %d, limiter waits %d, slept %v", attempts, limiter.n, slept)
+	}
+
+	// 未开启重试时失败立即返回
+	atomic.StoreInt32(&attempts, 0)
+	slept = nil
+	devices[0].ID = 0
+	results, err = RegisterBatch(devices[:1], 1)
+	if err == nil || results[devices[0].Name] == nil || attempts != 1 || len(slept) != 0 {
+		t.Fatalf("attempts %d, slept %v, err %v", attempts, slept, err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 50*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatal(err)
+		}
+		b.Failure()
+	}
+	if b.State() != CircuitOpen || b.Allow() != ErrCircuitOpen {
+		t.Fatalf("breaker should be open, state: %v", b.State())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != CircuitHalfOpen {
+		t.Fatalf("breaker should be half-open, state: %v", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Allow() != ErrCircuitOpen {
+		t.Fatal("half-open breaker should allow only one probe")
+	}
+	b.Failure()
+	if b.State() != CircuitOpen {
+		t.Fatalf("failed probe should reopen breaker, state: %v", b.State())
+	}
+	time.Sleep(60 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if b.State() != CircuitClosed {
+		t.Fatalf("breaker should be closed, state: %v", b.State())
+	}
+}
+
+func TestCircuitBreakerReconnect(t *testing.T) {
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		WithCircuitBreaker(2, time.Hour))
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	params := p.opts.(map[string]interface{})
+	reconnecting := params["OnReconnecting"].(func() error)
+	failed := params["OnReconnectFailed"].(func())
+	for i := 0; i < 2; i++ {
+		if err := reconnecting(); err != nil {
+			t.Fatal(err)
+		}
+		failed()
+	}
+	// 自动重连连续失败后熔断器打开，之后的重连尝试被跳过
+	if d.CircuitState() != CircuitOpen || reconnecting() != ErrCircuitOpen {
+		t.Fatalf("breaker should be open, state: %v", d.CircuitState())
+	}
+	params["OnConnect"].(func())()
+	if d.CircuitState() != CircuitClosed {
+		t.Fatalf("breaker should be closed after reconnect, state: %v", d.CircuitState())
+	}
+}
+
+// testResponse 测试用消息
+type testResponse struct {
+	payload []byte
+}
+
+func (r testResponse) Duplicate() bool   { return false }
+func (r testResponse) Qos() byte         { return 1 }
+func (r testResponse) Retained() bool    { return false }
+func (r testResponse) Topic() string     { return "test" }
+func (r testResponse) MessageID() uint16 { return 0 }
+func (r testResponse) Payload() []byte   { return r.payload }
+
+func TestReceiveBuffer(t *testing.T) {
+	b := &ReceiveBuffer{Size: 2}
+	block := make(chan struct{})
+	received := make(chan byte, 10)
+	cb := b.wrap(func(resp request.Response) {
+		<-block
+		received <- resp.Payload()[0]
+	}, nil)
+	// 第一条消息被回调取出阻塞，其余消息进入缓冲区
+	cb(testResponse{[]byte{0}})
+	time.Sleep(10 * time.Millisecond)
+	for i := byte(1); i <= 4; i++ {
+		cb(testResponse{[]byte{i}})
+	}
+	close(block)
+	want := []byte{0, 3, 4}
+	for _, w := range want {
+		if got := <-received; got != w {
+			t.Fatalf("received %d, want %d", got, w)
+		}
+	}
+	if b.Dropped() != 2 {
+		t.Fatalf("dropped %d messages, want 2", b.Dropped())
+	}
+}
+
+func TestSubscribeMultipleFallback(t *testing.T) {
+	// fakeProtocol 没有实现 protocol.MultiSubscriber，逐个订阅
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	received := []string{}
+	results, err := d.SubscribeMultiple(map[string]byte{"a": 1, "b": 0}, func(resp request.Response) {
+		received = append(received, string(resp.Payload()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results["a"].Qos != 1 || results["a"].Err != nil || results["b"].Qos != 0 || results["b"].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	p.deliver("a", []byte("1"))
+	p.deliver("b", []byte("2"))
+	if strings.Join(received, ",") != "1,2" {
+		t.Fatalf("received %v", received)
+	}
+}
+
+func TestReceiveBufferUnsubscribe(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithReceiveBuffer(4))
+	defer d.Close()
+	received := make(chan struct{}, 1)
+	before := runtime.NumGoroutine()
+	// 反复订阅、收到消息、取消订阅，缓冲区的投递协程不应累积
+	for i := 0; i < 100; i++ {
+		topic := fmt.Sprintf("test/%d", i)
+		if err := d.Subscribe(request.Request{Topic: topic, Callback: func(request.Response) { received <- struct{}{} }}); err != nil {
+			t.Fatal(err)
+		}
+		p.deliver(topic, []byte("1"))
+		<-received
+		if err := d.Unsubscribe([]string{topic}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for d.GoroutineCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := d.GoroutineCount(); n != 0 {
+		t.Fatalf("%d receive buffer goroutines still running", n)
+	}
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Fatalf("goroutines grew from %d to %d", before, after)
+	}
+}
+
+func TestIsRegistered(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("device_code") {
+		case "registered":
+			fmt.Fprint(w, `{"code":0,"data":{"registered":true,"device_id":1}}`)
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, `{"code":500,"message":"internal error"}`)
+		}
+	}))
+	defer srv.Close()
+	tps := topics.Topics{DeviceStatus: srv.URL}
+	cases := []struct {
+		name       string
+		registered bool
+		failed     bool
+	}{
+		{"registered", true, false},
+		{"missing", false, false},
+		{"broken", false, true},
+	}
+	for _, c := range cases {
+		d := New(ProductKey, c.name, Version, Storage(newMemStorage()), Topics(tps))
+		registered, err := d.IsRegistered(context.Background())
+		if registered != c.registered || (err != nil) != c.failed {
+			t.Fatalf("%s: registered %v, err %v", c.name, registered, err)
+		}
+	}
+}
+
+// fakeProtocol 测试用协议，记录订阅回调与发布的消息
+type fakeProtocol struct {
+	mu        sync.Mutex
+	callbacks map[string]func(request.Response)
+	published []map[string]interface{}
+	// offline 为 true 时发布失败，模拟连接断开
+	offline bool
+}
+
+func newFakeProtocol() *fakeProtocol {
+	return &fakeProtocol{callbacks: map[string]func(request.Response){}}
+}
+
+func (p *fakeProtocol) Publish(opts map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.offline {
+		return errors.New("not connected")
+	}
+	p.published = append(p.published, opts)
+	return nil
+}
+
+// IsConnected 未模拟断开时视为已连接
+func (p *fakeProtocol) IsConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.offline
+}
+
+func (p *fakeProtocol) Subscribe(opts map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callbacks[opts["Topic"].(string)] = opts["Callback"].(func(request.Response))
+	return nil
+}
+
+func (p *fakeProtocol) Unsubscribe(opts map[string]interface{}) error             { return nil }
+func (p *fakeProtocol) MakeOpts(opts map[string]interface{}) (interface{}, error) { return opts, nil }
+func (p *fakeProtocol) NewClient(opts interface{}) error                          { return nil }
+func (p *fakeProtocol) GetName() string                                           { return "fake" }
+func (p *fakeProtocol) GetInstance() interface{}                                  { return p }
+
+// deliver 向订阅了 topic 的回调投递消息
+func (p *fakeProtocol) deliver(topic string, payload []byte) {
+	p.mu.Lock()
+	cb := p.callbacks[topic]
+	p.mu.Unlock()
+	cb(topicResponse{testResponse{payload}, topic})
+}
+
+// topicResponse 指定主题的测试消息
+type topicResponse struct {
+	testResponse
+	topic string
+}
+
+func (r topicResponse) Topic() string { return r.topic }
+
+func TestOnDiagnosticRequest(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	err := d.OnDiagnosticRequest(func() map[string]interface{} {
+		return map[string]interface{}{"battery": 80, "goroutines": -1}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.DiagnosticRequest, []byte(`{"request_id":"r1"}`))
+	if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.DiagnosticReply {
+		t.Fatalf("unexpected published messages: %+v", p.published)
+	}
+	reply := tlvReply(t, p.published[0]["Payload"].([]byte))
+	data, _ := reply.Data.(map[string]interface{})
+	if reply.Code != serializer.ReplyCodeOK || data["request_id"] != "r1" || data["battery"] != float64(80) || data["goroutines"] != float64(-1) {
+		t.Fatalf("unexpected diagnostics: %+v", reply)
+	}
+	if data["messages_received"] != float64(1) {
+		t.Fatalf("messages_received is %v, want 1", data["messages_received"])
+	}
+	if stats := d.Stats(); stats.MessagesSent != 1 || stats.MessagesReceived != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+// tlvReply 解析默认 TLV 格式的回复，参数 0 为 JSON 字符串时解析为 Data，否则为 Message
+func tlvReply(t *testing.T, payload []byte) serializer.Reply {
+	t.Helper()
+	resp, err := serializer.NewTLV().UnmarshalCommandResponse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := serializer.Reply{CommandID: resp.ID, SubDeviceID: resp.SubDeviceID, Code: resp.Code}
+	if s, ok := resp.Data[0].(string); ok {
+		if err := json.Unmarshal([]byte(s), &reply.Data); err != nil {
+			reply.Message = s
+		}
+	}
+	return reply
+}
+
+func (p *fakeProtocol) setOffline(offline bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offline = offline
+}
+
+func TestInflightCommandReply(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	err := d.OnCommand(Command{ID: 1, Handler: func(params map[int]interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return params[0], nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.deliver(d.Topics.OnCommand, []byte("1,2,on"))
+		close(done)
+	}()
+	<-started
+	if d.InflightCommands() != 1 {
+		t.Fatalf("inflight commands %d, want 1", d.InflightCommands())
+	}
+	// 命令处理期间连接断开，回复等待重连
+	p.setOffline(true)
+	close(release)
+	<-done
+	if d.InflightCommands() != 0 || d.PendingReplies() != 1 {
+		t.Fatalf("inflight %d, pending %d", d.InflightCommands(), d.PendingReplies())
+	}
+	p.setOffline(false)
+	d.flushReplies()
+	if d.PendingReplies() != 0 || len(p.published) != 1 {
+		t.Fatalf("pending %d, published %d", d.PendingReplies(), len(p.published))
+	}
+	reply := serializer.Reply{}
+	if err := json.Unmarshal(p.published[0]["Payload"].([]byte), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if p.published[0]["Topic"] != d.Topics.CommandResponse || reply.CommandID != 1 || reply.SubDeviceID != 2 || reply.Data != "on" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+}
+
+func TestInflightIdenticalCommands(t *testing.T) {
+	p := &unackedProtocol{newFakeProtocol()}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	err := d.OnCommand(Command{ID: 1, Handler: func(params map[int]interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return params[0], nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.deliver(d.Topics.OnCommand, []byte("1,2,toggle"))
+		}()
+	}
+	<-started
+	<-started
+	// 内容相同的两条命令分别跟踪
+	if d.InflightCommands() != 2 {
+		t.Fatalf("inflight commands %d, want 2", d.InflightCommands())
+	}
+	close(release)
+	wg.Wait()
+	// 未收到确认的回复等待重连后重发，两条回复互不覆盖
+	if d.InflightCommands() != 0 || d.PendingReplies() != 2 {
+		t.Fatalf("inflight %d, pending %d", d.InflightCommands(), d.PendingReplies())
+	}
+}
+
+func TestInflightReplyTTL(t *testing.T) {
+	p := newFakeProtocol()
+	p.setOffline(true)
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithReplyTTL(time.Millisecond))
+	d.sendReply("1", time.Now(), []byte("reply"))
+	if d.PendingReplies() != 1 {
+		t.Fatalf("pending %d, want 1", d.PendingReplies())
+	}
+	time.Sleep(5 * time.Millisecond)
+	p.setOffline(false)
+	d.flushReplies()
+	if d.PendingReplies() != 0 || len(p.published) != 0 {
+		t.Fatalf("expired reply should be dropped, pending %d, published %d", d.PendingReplies(), len(p.published))
+	}
+}
+
+func TestSerializerRouter(t *testing.T) {
+	p := newFakeProtocol()
+	csv := serializer.NewCSV([]string{"id", "sub_device_id", "0"})
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		WithSerializerRouter(func(topic string) serializer.Serializer {
+			if topic == "csv/command" || topic == "csv/property" {
+				return csv
+			}
+			return nil
+		}))
+	received := make(chan interface{}, 2)
+	if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+		received <- params[0]
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// 命令主题为 csv/command 时使用 CSV 解析
+	p.deliver(d.Topics.OnCommand, []byte("1,2,on"))
+	p.callbacks["csv/command"] = p.callbacks[d.Topics.OnCommand]
+	p.deliver("csv/command", []byte("1,2,on"))
+	if len(received) != 1 || <-received != "on" {
+		t.Fatal("command on csv/command should be decoded by csv serializer")
+	}
+	// 未匹配的主题使用默认的 TLV 序列化器
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint16(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	d.Topics.PostProperty = "csv/property"
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{"on"}}); err != nil {
+		t.Fatal(err)
+	}
+	if string(p.published[0]["Payload"].([]byte)) == "1,0,1\n" || string(p.published[1]["Payload"].([]byte)) != "1,0,on\n" {
+		t.Fatalf("unexpected payloads: %q, %q", p.published[0]["Payload"], p.published[1]["Payload"])
+	}
+}
+
+func TestDecodeFallback(t *testing.T) {
+	p := newFakeProtocol()
+	csv := serializer.NewCSV([]string{"id", "sub_device_id", "0"})
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithDecodeFallback(csv))
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	received := make(chan interface{}, 2)
+	if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+		received <- params[0]
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// TLV 解析失败后使用 CSV 解析
+	p.deliver(d.Topics.OnCommand, []byte("1,2,on"))
+	if len(received) != 1 || <-received != "on" || len(errs) != 0 {
+		t.Fatalf("command should be decoded by fallback serializer, errors: %v", errs)
+	}
+	// 全部失败时才调用错误回调
+	p.deliver(d.Topics.OnCommand, []byte("x"))
+	if len(received) != 0 || len(errs) != 1 {
+		t.Fatalf("got %d commands, %d errors, want 0, 1", len(received), len(errs))
+	}
+}
+
+func TestSubscribeFirst(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	go func() {
+		for !d.handlers.has("shadow/get/accepted") {
+			time.Sleep(time.Millisecond)
+		}
+		p.deliver("shadow/get/accepted", []byte("state"))
+	}()
+	resp, err := d.SubscribeFirst(context.Background(), "shadow/get/accepted", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload()) != "state" || d.handlers.has("shadow/get/accepted") {
+		t.Fatalf("got %q, subscription should be removed", resp.Payload())
+	}
+	// 超时后取消订阅
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.SubscribeFirst(ctx, "config", 1); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+	if d.handlers.has("config") {
+		t.Fatal("subscription should be removed after timeout")
+	}
+	// 不覆盖已有订阅
+	if err := d.Subscribe(request.Request{Topic: "config", Callback: func(request.Response) {}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SubscribeFirst(ctx, "config", 1); errors.Cause(err) != ErrAlreadySubscribed {
+		t.Fatalf("got %v, want ErrAlreadySubscribed", err)
+	}
+}
+
+// jsonCommandSerializer 将 {"id":1,"params":{"0":"on"}} 解析为命令的序列化器
+type jsonCommandSerializer struct {
+	serializer.Serializer
+}
+
+func (jsonCommandSerializer) UnmarshalCommand(data []byte) (*serializer.Command, error) {
+	cmd := struct {
+		ID     uint16              `json:"id"`
+		Params map[int]interface{} `json:"params"`
+	}{}
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, err
+	}
+	return &serializer.Command{ID: cmd.ID, Params: cmd.Params}, nil
+}
+
+func TestPropertySnapshot(t *testing.T) {
+	p := newFakeProtocol()
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv),
+		WithAutoDetectSerializer(true), WithFormatSerializer(serializer.FormatJSON, jsonCommandSerializer{tlv}))
+	if err := d.OnCommand(); err != nil {
+		t.Fatal(err)
+	}
+	lastReply := func() serializer.Reply {
+		last := p.published[len(p.published)-1]
+		if last["Topic"] != d.Topics.CommandResponse {
+			t.Fatalf("last message published to %v, want command response", last["Topic"])
+		}
+		return tlvReply(t, last["Payload"].([]byte))
+	}
+	command := []byte(fmt.Sprintf(`{"id":%d}`, PropertySnapshotCommandID))
+	// 未注册属性快照
+	p.deliver(d.Topics.OnCommand, command)
+	if reply := lastReply(); reply.Code != serializer.ReplyCodeNotImplemented {
+		t.Fatalf("got reply %+v, want not implemented", reply)
+	}
+	measured := time.Now().Truncate(time.Millisecond)
+	d.PropertySnapshot = func() []Property {
+		return []Property{
+			{PropertyID: 1, Value: []interface{}{uint8(1)}, Timestamp: measured},
+			{PropertyID: 2, Value: []interface{}{uint8(2)}, Timestamp: measured},
+		}
+	}
+	want, err := tlv.MakeBatchPropertyData([]*serializer.Property{
+		{PropertyID: 1, Value: []interface{}{uint8(1)}, Timestamp: measured},
+		{PropertyID: 2, Value: []interface{}{uint8(2)}, Timestamp: measured},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.published = nil
+	p.deliver(d.Topics.OnCommand, command)
+	if len(p.published) != 2 || p.published[0]["Topic"] != d.Topics.PostProperty {
+		t.Fatalf("published %v, want one batch and a reply", p.published)
+	}
+	if got := p.published[0]["Payload"].([]byte); !bytes.Equal(got, want) {
+		t.Fatalf("got batch %x, want %x", got, want)
+	}
+	if reply := lastReply(); reply.Code != serializer.ReplyCodeOK || reply.Data.(map[string]interface{})["count"] != float64(2) {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+}
+
+func TestPostProperties(t *testing.T) {
+	p := newFakeProtocol()
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv))
+	if err := d.PostProperties(nil); err != nil || len(p.published) != 0 {
+		t.Fatalf("empty batch should be a no-op, err %v, published %d", err, len(p.published))
+	}
+	properties := []Property{
+		{SubDeviceID: 1, PropertyID: 1, Value: []interface{}{int32(10)}},
+		{SubDeviceID: 2, PropertyID: 1, Value: []interface{}{int32(20)}},
+		{SubDeviceID: 1, PropertyID: 2, Value: []interface{}{int32(11)}},
+	}
+	if err := d.PostProperties(properties); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.PostProperty {
+		t.Fatalf("published %d messages, want one batch", len(p.published))
+	}
+	got, err := tlv.UnmarshalBatchProperty(p.published[0]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(properties) {
+		t.Fatalf("got %d properties, want %d", len(got), len(properties))
+	}
+	for i, property := range got {
+		want := properties[i]
+		if property.SubDeviceID != want.SubDeviceID || property.PropertyID != want.PropertyID || property.Value[0] != want.Value[0] {
+			t.Fatalf("property %d = %+v, want %+v", i, property, want)
+		}
+	}
+
+	// 序列化器不支持批量时逐条上报
+	p.published = nil
+	d.Serializer = jsonCommandSerializer{tlv}
+	if err := d.PostProperties(properties); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != len(properties) {
+		t.Fatalf("published %d messages, want %d", len(p.published), len(properties))
+	}
+}
+
+func TestAutoDetectSerializer(t *testing.T) {
+	p := newFakeProtocol()
+	csv := serializer.NewCSV([]string{"id", "sub_device_id", "0"})
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(csv),
+		WithAutoDetectSerializer(true), WithFormatSerializer(serializer.FormatJSON, jsonCommandSerializer{csv}))
+	if _, ok := d.FormatSerializers[serializer.FormatTLV].(*serializer.TLV); !ok {
+		t.Fatal("tlv serializer should be set by default")
+	}
+	received := make(chan interface{}, 2)
+	if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+		received <- params[0]
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte(` {"id":1,"params":{"0":"json"}}`))
+	// 无法识别的格式使用主题对应的序列化器
+	p.deliver(d.Topics.OnCommand, []byte("1,2,csv"))
+	if len(received) != 2 || <-received != "json" || <-received != "csv" {
+		t.Fatalf("got %d commands, want json and csv", len(received))
+	}
+}
+
+// panicSerializer 序列化属性、事件与解析命令时 panic 的序列化器
+type panicSerializer struct {
+	serializer.Serializer
+}
+
+func (panicSerializer) MakePropertyData(*serializer.Property) ([]byte, error) { panic("bad property") }
+func (panicSerializer) MakeEventData(*serializer.Property) ([]byte, error)    { panic("bad event") }
+func (panicSerializer) UnmarshalCommand([]byte) (*serializer.Command, error)  { panic("bad command") }
+
+func TestSerializerPanic(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(panicSerializer{serializer.NewTLV()}))
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{1}}); errors.Cause(err) != ErrSerializerPanic {
+		t.Fatalf("got %v, want ErrSerializerPanic", err)
+	}
+	if err := d.PostEvent("alarm", Property{PropertyID: 1, Value: []interface{}{1}}); errors.Cause(err) != ErrSerializerPanic {
+		t.Fatalf("got %v, want ErrSerializerPanic", err)
+	}
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	if err := d.OnCommand(Command{ID: 1, Callback: func(map[int]interface{}) {}}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte{1})
+	if len(errs) != 1 || errors.Cause(errs[0]) != ErrSerializerPanic {
+		t.Fatalf("got errors %v, want ErrSerializerPanic", errs)
+	}
+	if len(p.published) != 0 {
+		t.Fatalf("published %d messages, want 0", len(p.published))
+	}
+}
+
+func TestReportDeviceInfo(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, "1.2.3", Protocol(p), Storage(newMemStorage()),
+		WithDeviceInfoFields(func() map[string]interface{} {
+			return map[string]interface{}{"board": "rev-b", "os": "rtos"}
+		}))
+	// 未开启时不上报
+	d.reportDeviceInfo()
+	if len(p.published) != 0 {
+		t.Fatalf("published %d messages, want 0", len(p.published))
+	}
+	WithReportDeviceInfo(true)(d)
+	d.reportDeviceInfo()
+	if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.DeviceInfo {
+		t.Fatalf("published %v, want device info", p.published)
+	}
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(p.published[0]["Payload"].([]byte), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["version"] != "1.2.3" || info["sdk_version"] != SDKVersion || info["go_version"] != runtime.Version() ||
+		info["arch"] != runtime.GOARCH || info["board"] != "rev-b" || info["os"] != "rtos" {
+		t.Fatalf("unexpected device info: %v", info)
+	}
+}
+
+func TestVersionedProperty(t *testing.T) {
+	p := newFakeProtocol()
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv))
+	if err := d.OnSetProperty(func(Property) {}); err == nil {
+		t.Fatal("on set property should fail without SetProperty topic")
+	}
+	d.Topics.SetProperty = "sp"
+	// 应用平台设置的值后以平台的版本上报
+	if err := d.OnSetProperty(func(property Property) {
+		if err := d.PostPropertyVersioned(property, property.Version); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := tlv.MakePropertyData(&serializer.Property{PropertyID: 1, Value: []interface{}{uint8(3)}, Version: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver("sp", data)
+	if len(p.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(p.published))
+	}
+	posted, err := tlv.UnmarshalProperty(p.published[0]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted.Version != 5 || posted.PropertyID != 1 {
+		t.Fatalf("unexpected posted property: %+v", posted)
+	}
+
+	var conflicts []PropertyConflict
+	if err := d.OnPropertyConflict(func(c PropertyConflict) {
+		conflicts = append(conflicts, c)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.PropertyReply, []byte(`{"code":200,"property_id":1,"version":6}`))
+	p.deliver(d.Topics.PropertyReply, []byte(`{"code":409,"property_id":1,"expected_version":5,"version":7}`))
+	if len(conflicts) != 1 || conflicts[0].PropertyID != 1 || conflicts[0].ExpectedVersion != 5 || conflicts[0].CurrentVersion != 7 {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+}
+
+func TestOnReboot(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	rebooted := false
+	if err := d.OnReboot(func() error {
+		// 确认在重启之前发出
+		if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.RebootReply {
+			t.Errorf("published %v before reboot, want reboot ack", p.published)
+		}
+		rebooted = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.Reboot, []byte(`{"request_id":"r1"}`))
+	if !rebooted {
+		t.Fatal("reboot handler not called")
+	}
+	if p.published[0]["WaitTimeout"] != RebootAckTimeout {
+		t.Fatalf("wait timeout %v, want %v", p.published[0]["WaitTimeout"], RebootAckTimeout)
+	}
+	ack := tlvReply(t, p.published[0]["Payload"].([]byte))
+	if ack.Code != serializer.ReplyCodeOK || ack.Data.(map[string]interface{})["request_id"] != "r1" {
+		t.Fatalf("unexpected reboot ack: %+v", ack)
+	}
+	// 确认发送失败时仍然重启
+	rebooted = false
+	p.setOffline(true)
+	if err := d.OnReboot(func() error { rebooted = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.Reboot, []byte("reboot"))
+	if !rebooted {
+		t.Fatal("reboot handler not called after ack failed")
+	}
+}
+
+func TestDialerUnsupported(t *testing.T) {
+	d := New(ProductKey, DeviceName, Version, Protocol(newFakeProtocol()), Storage(newMemStorage()),
+		WithDialer(func(network, addr string) (net.Conn, error) {
+			return nil, errors.New("dial failed")
+		}))
+	if err := d.InitProtocolClient(); errors.Cause(err) != protocol.ErrDialerUnsupported {
+		t.Fatalf("expect ErrDialerUnsupported, got %v", err)
+	}
+}
+
+func TestTelemetryBatcher(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "0"})), WithOfflineQueue(0),
+		WithTelemetryBatcher(BatcherConfig{MaxBatch: 3, Compress: true}))
+	for i := 1; i <= 2; i++ {
+		if err := d.PostProperty(Property{PropertyID: uint16(i), Value: []interface{}{i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.published) != 0 || d.TelemetryBuffered() != 2 {
+		t.Fatalf("published %d, buffered %d, want 0, 2", len(p.published), d.TelemetryBuffered())
+	}
+	// 高优先级的属性立即发送
+	if err := d.PostPropertyWithPriority(Property{PropertyID: 9, Value: []interface{}{9}}, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	// 缓冲满时作为一条压缩的消息发送
+	if err := d.PostProperty(Property{PropertyID: 3, Value: []interface{}{3}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 2 || d.TelemetryBuffered() != 0 {
+		t.Fatalf("published %d, buffered %d, want 2, 0", len(p.published), d.TelemetryBuffered())
+	}
+	r, err := gzip.NewReader(bytes.NewReader(p.published[1]["Payload"].([]byte)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, _ := ioutil.ReadAll(r)
+	if string(batch) != "1,1\n2,2\n3,3\n" {
+		t.Fatalf("unexpected batch: %q", batch)
+	}
+	// 发送失败且没有离线队列时丢弃
+	p.setOffline(true)
+	d.PostProperty(Property{PropertyID: 4, Value: []interface{}{4}})
+	if err := d.FlushTelemetry(); err == nil || d.TelemetryDropped() != 1 {
+		t.Fatalf("flush should fail and drop 1 property, err %v, dropped %d", err, d.TelemetryDropped())
+	}
+	// 恢复连接后手动发送
+	p.setOffline(false)
+	d.PostProperty(Property{PropertyID: 5, Value: []interface{}{5}})
+	if err := d.FlushTelemetry(); err != nil || len(p.published) != 3 {
+		t.Fatalf("flush failed: %v, published %d", err, len(p.published))
+	}
+}
+
+func TestAlarmLifecycle(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	// 上报失败时告警不记为产生
+	p.setOffline(true)
+	if err := d.RaiseAlarm(Alarm{ID: 1, Severity: AlarmMajor}); err == nil {
+		t.Fatal("raise alarm should fail while offline")
+	}
+	if len(d.ActiveAlarms()) != 0 {
+		t.Fatal("failed alarm should not be active")
+	}
+	p.setOffline(false)
+	// 重复产生只上报一次
+	for i := 0; i < 2; i++ {
+		if err := d.RaiseAlarm(Alarm{ID: 1, Severity: AlarmMajor}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.Alarm {
+		t.Fatalf("published %d alarms, want 1", len(p.published))
+	}
+	if active := d.ActiveAlarms(); len(active) != 1 || active[0].State != AlarmRaised {
+		t.Fatalf("unexpected active alarms: %+v", active)
+	}
+	if err := d.AcknowledgeAlarm(1); err != nil {
+		t.Fatal(err)
+	}
+	if active := d.ActiveAlarms(); len(active) != 1 || active[0].State != AlarmAcknowledged {
+		t.Fatalf("unexpected active alarms: %+v", active)
+	}
+	if err := d.ClearAlarm(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 3 || len(d.ActiveAlarms()) != 0 {
+		t.Fatalf("published %d, active %d, want 3, 0", len(p.published), len(d.ActiveAlarms()))
+	}
+	// 清除未产生的告警返回错误
+	if err := d.ClearAlarm(1); errors.Cause(err) != ErrAlarmNotActive {
+		t.Fatalf("got %v, want ErrAlarmNotActive", err)
+	}
+}
+
+func TestSubDeviceAlarms(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	// 不同子设备的同一告警 ID 分别跟踪
+	for _, sub := range []uint16{2, 1, 0} {
+		if err := d.RaiseAlarm(Alarm{ID: 1, SubDeviceID: sub, Severity: AlarmMajor}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	active := d.ActiveAlarms()
+	if len(p.published) != 3 || len(active) != 3 || active[0].SubDeviceID != 0 || active[2].SubDeviceID != 2 {
+		t.Fatalf("published %d, active %+v", len(p.published), active)
+	}
+	if err := d.AcknowledgeSubDeviceAlarm(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClearSubDeviceAlarm(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ClearSubDeviceAlarm(3, 1); errors.Cause(err) != ErrAlarmNotActive {
+		t.Fatalf("got %v, want ErrAlarmNotActive", err)
+	}
+	active = d.ActiveAlarms()
+	if len(active) != 2 || active[0].State != AlarmRaised || active[1].SubDeviceID != 1 || active[1].State != AlarmAcknowledged {
+		t.Fatalf("unexpected active alarms: %+v", active)
+	}
+	// ClearAlarm 只清除设备自身的告警
+	if err := d.ClearAlarm(1); err != nil {
+		t.Fatal(err)
+	}
+	if active := d.ActiveAlarms(); len(active) != 1 || active[0].SubDeviceID != 1 {
+		t.Fatalf("unexpected active alarms: %+v", active)
+	}
+}
+
+func TestPayloadCodecs(t *testing.T) {
+	aesCodec, err := NewAESGCMCodec(bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := serializer.NewCSV([]string{"id", "sub_device_id", "0"})
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(csv),
+		WithPayloadCodecs(GzipCodec(), aesCodec, NewHMACCodec([]byte("key"))))
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{"on"}}); err != nil {
+		t.Fatal(err)
+	}
+	payload := p.published[0]["Payload"].([]byte)
+	decoded, err := d.decodePayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "1,0,on\n" {
+		t.Fatalf("decoded payload is %q", decoded)
+	}
+	// 篡改后签名校验失败
+	payload[0] ^= 0xff
+	if _, err := d.decodePayload(payload); errors.Cause(err) != ErrPayloadMAC {
+		t.Fatalf("got %v, want ErrPayloadMAC", err)
+	}
+	// 接收的命令按相反顺序解码
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	received := make(chan interface{}, 1)
+	if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+		received <- params[0]
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := d.encodePayload([]byte("1,2,off"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, cmd)
+	p.deliver(d.Topics.OnCommand, []byte("1,2,off"))
+	if len(received) != 1 || <-received != "off" || len(errs) != 1 {
+		t.Fatalf("got %d commands, %d errors, want 1, 1", len(received), len(errs))
+	}
+}
+
+func TestGzipCodecLimit(t *testing.T) {
+	bomb, err := GzipCodec().Encode(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GzipCodec().Decode(bomb); errors.Cause(err) != ErrPayloadTooLarge {
+		t.Fatalf("got %v, want ErrPayloadTooLarge", err)
+	}
+	data, err := NewGzipCodec(1 << 20).Decode(bomb)
+	if err != nil || len(data) != 1<<20 {
+		t.Fatalf("got %d bytes, %v", len(data), err)
+	}
+	if data, err := NewGzipCodec(0).Decode(bomb); err != nil || len(data) != 1<<20 {
+		t.Fatalf("got %d bytes, %v", len(data), err)
+	}
+}
+
+// loopbackProtocol 发布的消息投递给订阅了相同主题的回调
+type loopbackProtocol struct {
+	*fakeProtocol
+}
+
+func (p loopbackProtocol) Publish(opts map[string]interface{}) error {
+	if err := p.fakeProtocol.Publish(opts); err != nil {
+		return err
+	}
+	topic := opts["Topic"].(string)
+	p.mu.Lock()
+	_, ok := p.callbacks[topic]
+	p.mu.Unlock()
+	if ok {
+		go p.deliver(topic, opts["Payload"].([]byte))
+	}
+	return nil
+}
+
+func TestSelfTest(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(loopbackProtocol{p}), Storage(newMemStorage()),
+		WithPayloadCodecs(NewHMACCodec([]byte("key"))))
+	if err := d.SelfTest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	topic := p.published[0]["Topic"].(string)
+	if !strings.HasPrefix(topic, d.Topics.Loopback+"/") || d.Dispatch(topicResponse{topic: topic}) {
+		t.Fatalf("loopback subscription %s should be removed", topic)
+	}
+	// 未连接
+	p.setOffline(true)
+	if err, ok := d.SelfTest(context.Background()).(*SelfTestError); !ok || err.Stage != SelfTestConnect {
+		t.Fatalf("got %v, want connect failure", err)
+	}
+	// 服务端没有转发
+	p.setOffline(false)
+	d.Protocol = p
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err, ok := d.SelfTest(ctx).(*SelfTestError); !ok || err.Stage != SelfTestReceive {
+		t.Fatalf("got %v, want receive failure", err)
+	}
+}
+
+func TestLoginOnReconnect(t *testing.T) {
+	d := New(ProductKey, DeviceName, Version, Protocol(newFakeProtocol()), Storage(newMemStorage()))
+	if !d.shouldLoginOnReconnect(protocol.DisconnectNetwork) {
+		t.Fatal("LoginAlways should login on every reconnect")
+	}
+	WithLoginOnReconnect(LoginIfExpired)(d)
+	// 平台未返回有效期时使用原有 Token，认证失败时重新登录
+	if d.shouldLoginOnReconnect(protocol.DisconnectNetwork) || !d.shouldLoginOnReconnect(protocol.DisconnectAuthFailed) {
+		t.Fatal("LoginIfExpired should only login on auth failure when expiry is unknown")
+	}
+	d.tokenExpiresAt = time.Now().Add(time.Hour)
+	if d.shouldLoginOnReconnect(protocol.DisconnectBroker) {
+		t.Fatal("valid token should be reused")
+	}
+	d.tokenExpiresAt = time.Now().Add(TokenRefreshMargin / 2)
+	if !d.shouldLoginOnReconnect(protocol.DisconnectBroker) {
+		t.Fatal("token about to expire should be refreshed")
+	}
+	WithLoginOnReconnect(LoginNever)(d)
+	if d.shouldLoginOnReconnect(protocol.DisconnectAuthFailed) {
+		t.Fatal("LoginNever should never login")
+	}
+}
+
+func TestLastResponses(t *testing.T) {
+	var registered int32
+	srv := newTestServer(&registered)
+	defer srv.Close()
+	d := New(ProductKey, "responses", Version, Storage(newMemStorage()), Topics(topics.Topics{
+		Register: srv.URL + "/register",
+		Login:    srv.URL + "/login",
+	}))
+	if d.LastRegisterResponse() != nil || d.LastLoginResponse() != nil {
+		t.Fatal("responses should be nil before login")
+	}
+	if err := d.AutoLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if resp := d.LastRegisterResponse(); resp == nil || resp.Data.ID != 1 || resp.Data.Extra != nil {
+		t.Fatalf("unexpected register response: %+v", resp)
+	}
+	resp := d.LastLoginResponse()
+	if resp == nil || resp.Data.ExpiresIn != 3600 || !strings.Contains(string(resp.Raw), "cn-east") {
+		t.Fatalf("unexpected login response: %+v", resp)
+	}
+	if len(resp.Data.Extra) != 1 || string(resp.Data.Extra["region"]) != `"cn-east"` {
+		t.Fatalf("unexpected extra fields: %v", resp.Data.Extra)
+	}
+	if d.tokenExpiresAt.IsZero() {
+		t.Fatal("token expiry should be recorded")
+	}
+}
+
+func TestReportFromSource(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	defer d.Close()
+	ok := PropertySourceFunc(func() ([]Property, error) {
+		return []Property{{PropertyID: 1, Value: []interface{}{uint16(1)}}}, nil
+	})
+	if err := d.ReportFromSource(ok); err != nil || len(p.published) != 1 {
+		t.Fatalf("got %v, published %d", err, len(p.published))
+	}
+	// 部分读取失败、部分上报失败时仍上报其余属性
+	partial := PropertySourceFunc(func() ([]Property, error) {
+		return []Property{
+			{PropertyID: 2, Value: []interface{}{struct{}{}}},
+			{PropertyID: 3, Value: []interface{}{uint16(3)}},
+		}, errors.New("register 4 timeout")
+	})
+	err, isReport := d.ReportFromSource(partial).(*ReportError)
+	if !isReport || err.ReadErr == nil || err.Reported != 1 || len(err.Failed) != 1 || err.Failed[0].Property.PropertyID != 2 {
+		t.Fatalf("unexpected report error: %v", err)
+	}
+	errs := make(chan error, 1)
+	stop := d.ScheduleSourceReport(partial, 10*time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	defer stop()
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("scheduled report error not delivered")
+	}
+}
+
+func TestProvision(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/provision", func(w http.ResponseWriter, r *http.Request) {
+		args := ProvisionArgs{}
+		json.NewDecoder(r.Body).Decode(&args)
+		switch args.ClaimCode {
+		case "expired":
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprint(w, `{"code":1,"message":"claim code expired at 2026-01-01"}`)
+		case "ABCD-1234":
+			fmt.Fprintf(w, `{"code":0,"data":{"device_id":7,"device_code":"light-7","device_secret":"secret7"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883"}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	store := newMemStorage()
+	d := New(ProductKey, "", Version, Storage(store), Topics(topics.Topics{
+		Provision: srv.URL + "/provision",
+		Login:     srv.URL + "/login",
+	}))
+	if err, ok := d.Provision(context.Background(), "expired").(*ClaimCodeError); !ok || !err.Expired {
+		t.Fatalf("got %v, want expired claim code error", err)
+	}
+	if err, ok := d.Provision(context.Background(), "unknown").(*ClaimCodeError); !ok || err.Expired {
+		t.Fatalf("got %v, want invalid claim code error", err)
+	}
+	if err := d.Provision(context.Background(), "ABCD-1234"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "light-7" || d.ID != 7 || d.Token == nil {
+		t.Fatalf("unexpected device after provision: name %s, id %d, token %v", d.Name, d.ID, d.Token)
+	}
+	if secret, _ := store.Get("light-7.Secret"); secret != "secret7" {
+		t.Fatalf("stored secret is %v", secret)
+	}
+}
+
+func TestActivation(t *testing.T) {
+	var registered, activated int32
+	var steps []string
+	var mu sync.Mutex
+	step := func(name string) {
+		mu.Lock()
+		steps = append(steps, name)
+		mu.Unlock()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		step("register")
+		n := atomic.AddInt32(&registered, 1)
+		fmt.Fprintf(w, `{"code":0,"data":{"device_id":%d,"device_secret":"secret%d"}}`, n, n)
+	})
+	mux.HandleFunc("/activate", func(w http.ResponseWriter, r *http.Request) {
+		step("activate")
+		args := ActivateArgs{}
+		json.NewDecoder(r.Body).Decode(&args)
+		if args.ID == 0 || args.Secret == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":1,"message":"missing credentials"}`)
+			return
+		}
+		if atomic.AddInt32(&activated, 1) > 1 {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"code":2,"message":"device already activated"}`)
+			return
+		}
+		fmt.Fprint(w, `{"code":0}`)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		step("login")
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883"}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	d := New(ProductKey, "activate", Version, Storage(newMemStorage()), WithActivation(true), Topics(topics.Topics{
+		Register: srv.URL + "/register",
+		Activate: srv.URL + "/activate",
+		Login:    srv.URL + "/login",
+	}))
+	if err := d.Activate(context.Background()); err == nil {
+		t.Fatal("activate before register should fail")
+	}
+	if err := d.AutoLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(steps, ","); got != "register,activate,login" {
+		t.Fatalf("steps are %s", got)
+	}
+	// 已经激活的设备再次激活不返回错误
+	if err := d.Activate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBitmapParam(t *testing.T) {
+	ctx := CommandContext{Params: map[int]interface{}{0: "5", 1: uint16(0x8000), 2: "on"}}
+	if b, ok := ctx.BitmapParam(0); !ok || !b.Get(0) || b.Get(1) || !b.Get(2) {
+		t.Fatalf("param 0 is %b", b)
+	}
+	if b, ok := ctx.BitmapParam(1); !ok || !b.Get(15) {
+		t.Fatalf("param 1 is %b", b)
+	}
+	if _, ok := ctx.BitmapParam(2); ok {
+		t.Fatal("non integer param should not convert")
+	}
+	if _, ok := ctx.BitmapParam(3); ok {
+		t.Fatal("missing param should not convert")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s1, s2 := newMemStorage(), newMemStorage()
+	d1 := New(ProductKey, "d1", Version, Protocol(newFakeProtocol()), Storage(s1))
+	d2 := New(ProductKey, "d2", Version, Protocol(newFakeProtocol()), Storage(s2))
+	stop := InstallShutdownHook(d1, d2)
+	defer stop()
+	shutdown([]*Device{d1, d2})
+	if s1.flushed != 1 || s2.flushed != 1 {
+		t.Fatalf("flushed %d, %d, want 1, 1", s1.flushed, s2.flushed)
+	}
+	// 未实现 storage.Flusher 的存储视为已落盘
+	if err := New(ProductKey, "d3", Version, Storage(&storage.LocalStorage{})).Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordTracer 测试用追踪器，记录 Span 名称与属性
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []*recordSpan
+}
+
+type recordSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (t *recordTracer) Start(ctx context.Context, name string, attrs ...trace.Attribute) (context.Context, trace.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordSpan{name: name, attrs: map[string]interface{}{}}
+	span.SetAttributes(attrs...)
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordSpan) SetAttributes(attrs ...trace.Attribute) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordSpan) RecordError(err error) { s.err = err }
+func (s *recordSpan) End()                  { s.ended = true }
+
+func TestTracer(t *testing.T) {
+	p := newFakeProtocol()
+	tracer := &recordTracer{}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithTracer(tracer),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	if err := d.PostProperty(Property{PropertyID: 7, Value: []interface{}{"on"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OnCommand(Command{ID: 1, Callback: func(map[int]interface{}) {}}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte("1,2,on"))
+	names := []string{}
+	for _, span := range tracer.spans {
+		if !span.ended {
+			t.Fatalf("span %s not ended", span.name)
+		}
+		names = append(names, span.name)
+	}
+	if fmt.Sprint(names) != "[publish receive command]" {
+		t.Fatalf("unexpected spans: %v", names)
+	}
+	publish := tracer.spans[0].attrs
+	if publish[trace.AttrTopic] != d.Topics.PostProperty || publish[trace.AttrPropertyID] != 7 ||
+		publish[trace.AttrQos] != 1 || publish[trace.AttrPayloadSize] != len("7,0,on\n") {
+		t.Fatalf("unexpected publish attributes: %v", publish)
+	}
+}
+
+func TestApplyAndReport(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "quality", "0"})))
+	err := d.ApplyAndReport(map[uint16]interface{}{2: 150, 1: 80}, func(id uint16, v interface{}) (interface{}, error) {
+		if id == 2 {
+			return nil, errors.New("out of range")
+		}
+		return 75, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, msg := range p.published {
+		got = append(got, string(msg["Payload"].([]byte)))
+	}
+	// 属性 1 上报实际值，属性 2 应用失败，以 QualityBad 上报期望值
+	if fmt.Sprint(got) != fmt.Sprint([]string{"1,0,75\n", "2,2,150\n"}) {
+		t.Fatalf("unexpected reports: %q", got)
+	}
+}
+
+func TestMQTTClient(t *testing.T) {
+	if _, ok := New(ProductKey, DeviceName, Version, Protocol(newFakeProtocol())).MQTTClient(); ok {
+		t.Fatal("non-mqtt protocol should return false")
+	}
+	d := New(ProductKey, DeviceName, Version)
+	if _, ok := d.MQTTClient(); ok {
+		t.Fatal("uninitialized client should return false")
+	}
+	d.Protocol.(*protocol.MQTT).Client = mqtt.NewClient(mqtt.NewClientOptions())
+	if c, ok := d.MQTTClient(); !ok || c == nil {
+		t.Fatal("initialized mqtt client should be returned")
+	}
+}
+
+// discardedResponse 底层客户端丢弃了内容的测试消息
+type discardedResponse struct {
+	testResponse
+}
+
+func (r discardedResponse) PayloadDiscarded() int { return 1 << 20 }
+
+func TestMaxReceiveSize(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithMaxReceiveSize(4),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	errs := []error{}
+	d.OnCommandError(func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	handled := 0
+	if err := d.OnCommand(Command{ID: 1, Callback: func(map[int]interface{}) { handled++ }}); err != nil {
+		t.Fatal(err)
+	}
+	cb := p.callbacks[d.Topics.OnCommand]
+	cb(discardedResponse{})
+	p.deliver(d.Topics.OnCommand, []byte("1,2,on"))
+	p.deliver(d.Topics.OnCommand, []byte("1,2"))
+	if handled != 1 || len(errs) != 2 {
+		t.Fatalf("handled %d, errors %v", handled, errs)
+	}
+	for _, err := range errs {
+		if errors.Cause(err) != ErrPayloadTooLarge {
+			t.Fatalf("expect ErrPayloadTooLarge, got %v", err)
+		}
+	}
+}
+
+func TestReportSubDeviceStatus(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"sub_device_id", "0"})))
+	for _, id := range []int64{3, 1, 2} {
+		if err := d.ReportSubDeviceStatus(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.ReportSubDeviceStatus(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReportSubDeviceStatus(1<<16, true); err == nil {
+		t.Fatal("out of range sub device id should fail")
+	}
+	// 关闭时上报仍在线的子设备下线
+	d.reportSubDevicesOffline()
+	got := []string{}
+	for _, msg := range p.published {
+		if msg["Topic"] != d.Topics.SubDeviceStatus {
+			t.Fatalf("unexpected topic %v", msg["Topic"])
+		}
+		got = append(got, string(msg["Payload"].([]byte)))
+	}
+	want := []string{"3,true\n", "1,true\n", "2,true\n", "2,false\n", "1,false\n", "3,false\n"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("reports %q, want %q", got, want)
+	}
+	if len(d.subDevices.list()) != 0 {
+		t.Fatal("all sub devices should be offline")
+	}
+}
+
+// subDeviceRouter 按命令 ID 与子设备 ID 的组合分发
+type subDeviceRouter struct {
+	commands map[[2]uint16]Command
+}
+
+func (r *subDeviceRouter) Add(cmds ...Command) {}
+
+func (r *subDeviceRouter) Route(cmd CommandContext) (Command, bool) {
+	c, ok := r.commands[[2]uint16{cmd.ID, cmd.SubDeviceID}]
+	return c, ok
+}
+
+func TestCommandRouter(t *testing.T) {
+	p := newFakeProtocol()
+	received := []string{}
+	handler := func(name string) Command {
+		return Command{Callback: func(map[int]interface{}) { received = append(received, name) }}
+	}
+	router := &subDeviceRouter{commands: map[[2]uint16]Command{
+		{1, 1}: handler("lamp"),
+		{1, 2}: handler("fan"),
+	}}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithCommandRouter(router),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	if err := d.OnCommand(); err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"1,2,on", "1,1,on", "1,3,on"} {
+		p.deliver(d.Topics.OnCommand, []byte(payload))
+	}
+	if fmt.Sprint(received) != "[fan lamp]" {
+		t.Fatalf("unexpected dispatch: %v", received)
+	}
+}
+
+func TestMapRouter(t *testing.T) {
+	r := NewMapRouter()
+	r.Add(Command{ID: 1}, Command{ID: 2})
+	if _, ok := r.Route(CommandContext{ID: 2}); !ok {
+		t.Fatal("command 2 should be routed")
+	}
+	if _, ok := r.Route(CommandContext{ID: 3}); ok {
+		t.Fatal("command 3 should not be routed")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	csv := serializer.NewCSV([]string{"id", "sub_device_id", "0"})
+	newDevice := func(received *[]interface{}) (*Device, *fakeProtocol) {
+		p := newFakeProtocol()
+		d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(csv))
+		if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+			*received = append(*received, params[0])
+		}}); err != nil {
+			t.Fatal(err)
+		}
+		return d, p
+	}
+	var recorded, replayed []interface{}
+	d, p := newDevice(&recorded)
+	buf := new(bytes.Buffer)
+	d.RecordTo(buf)
+	for _, payload := range []string{"1,1,on", "1,1,off", "1,2,on"} {
+		p.deliver(d.Topics.OnCommand, []byte(payload))
+	}
+	d.RecordTo(nil)
+	p.deliver(d.Topics.OnCommand, []byte("1,3,on"))
+
+	d2, _ := newDevice(&replayed)
+	if err := Replay(buf, func(resp request.Response) {
+		if !d2.Dispatch(resp) {
+			t.Fatalf("no handler for %s", resp.Topic())
+		}
+	}, ReplaySpeed(0)); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(replayed) != fmt.Sprint(recorded[:3]) {
+		t.Fatalf("replayed %v, recorded %v", replayed, recorded)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	stream := `{"topic":"a","payload":"","timestamp":1000}
+{"topic":"a","payload":"","timestamp":1200}
+`
+	count := 0
+	start := time.Now()
+	if err := Replay(strings.NewReader(stream), func(request.Response) { count++ }, ReplaySpeed(10)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); count != 2 || elapsed < 20*time.Millisecond || elapsed > 150*time.Millisecond {
+		t.Fatalf("replayed %d messages in %v", count, elapsed)
+	}
+	if err := Replay(strings.NewReader("{"), func(request.Response) {}); err == nil {
+		t.Fatal("malformed stream should fail")
+	}
+}
+
+func TestDispatchWildcard(t *testing.T) {
+	d := New(ProductKey, DeviceName, Version, Protocol(newFakeProtocol()), Storage(newMemStorage()))
+	got := ""
+	if err := d.Subscribe(request.Request{Topic: "devices/+/event", Callback: func(resp request.Response) {
+		got = resp.Topic()
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Dispatch(topicResponse{topic: "devices/1/event"}) || got != "devices/1/event" {
+		t.Fatal("wildcard subscription should match")
+	}
+	d.Unsubscribe([]string{"devices/+/event"})
+	if d.Dispatch(topicResponse{topic: "devices/1/event"}) {
+		t.Fatal("unsubscribed topic should not match")
+	}
+	// 共享订阅按去掉 $share/{group}/ 前缀后的过滤器匹配
+	if err := d.Subscribe(request.Request{Topic: "$share/gateways/devices/+/event", Callback: func(resp request.Response) {
+		got = "shared"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Dispatch(topicResponse{topic: "devices/2/event"}) || got != "shared" {
+		t.Fatal("shared subscription should match")
+	}
+}
+
+// gateLimiter 第一次 Wait 时关闭 waiting 并阻塞到 open 关闭，用于让消息在队列中排队
+type gateLimiter struct {
+	once    sync.Once
+	waiting chan struct{}
+	open    chan struct{}
+}
+
+func (l *gateLimiter) Wait() {
+	l.once.Do(func() {
+		close(l.waiting)
+		<-l.open
+	})
+}
+
+func TestPublishPriority(t *testing.T) {
+	p := newFakeProtocol()
+	limiter := &gateLimiter{waiting: make(chan struct{}), open: make(chan struct{})}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithPublishLimiter(limiter))
+	publish := func(topic string, priority Priority) {
+		r := request.Request{}
+		r.Topic = topic
+		r.Payload = []byte{}
+		if err := d.publishWithPriority(priority, protocol.OptionsFormatter(r)); err != nil {
+			t.Error(err)
+		}
+	}
+	wg := sync.WaitGroup{}
+	enqueue := func(topic string, priority Priority, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publish(topic, priority)
+		}()
+		for d.queue.queued() != queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// 第一条消息被取出后阻塞在限流器上，其余消息排队
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		publish("first", PriorityLow)
+	}()
+	<-limiter.waiting
+	enqueue("low", PriorityLow, 1)
+	enqueue("normal", PriorityNormal, 2)
+	enqueue("high", PriorityHigh, 3)
+	close(limiter.open)
+	wg.Wait()
+	var got []string
+	for _, opts := range p.published {
+		got = append(got, opts["Topic"].(string))
+	}
+	if strings.Join(got, ",") != "first,high,normal,low" {
+		t.Fatalf("unexpected publish order: %v", got)
+	}
+}
+
+// slowProtocol 发布 topic 为 slow 的消息时关闭 blocked 并阻塞到 release 关闭，模拟等待确认的 QoS 1 消息
+type slowProtocol struct {
+	*fakeProtocol
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (p *slowProtocol) Publish(opts map[string]interface{}) error {
+	if opts["Topic"] == "slow" {
+		close(p.blocked)
+		<-p.release
+	}
+	return p.fakeProtocol.Publish(opts)
+}
+
+func TestPublishWithoutLimiterNotQueued(t *testing.T) {
+	p := &slowProtocol{fakeProtocol: newFakeProtocol(), blocked: make(chan struct{}), release: make(chan struct{})}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	publish := func(topic string, priority Priority) error {
+		r := request.Request{}
+		r.Topic = topic
+		r.Payload = []byte{}
+		return d.publishWithPriority(priority, protocol.OptionsFormatter(r))
+	}
+	slow := make(chan error, 1)
+	go func() {
+		slow <- publish("slow", PriorityLow)
+	}()
+	<-p.blocked
+	done := make(chan error, 1)
+	go func() {
+		done <- publish("alarm", PriorityHigh)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by a slow publish without limiter")
+	}
+	close(p.release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishContextCanceledNotSent(t *testing.T) {
+	p := newFakeProtocol()
+	limiter := &gateLimiter{waiting: make(chan struct{}), open: make(chan struct{})}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithPublishLimiter(limiter))
+	opts := func(topic string) map[string]interface{} {
+		r := request.Request{}
+		r.Topic = topic
+		r.Payload = []byte{}
+		return protocol.OptionsFormatter(r)
+	}
+	first := make(chan error, 1)
+	go func() {
+		first <- d.publishWithPriority(PriorityNormal, opts("first"))
+	}()
+	<-limiter.waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		canceled <- d.publishContext(ctx, PriorityNormal, opts("canceled"))
+	}()
+	for d.queue.queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	close(limiter.open)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	// 同一优先级先进先出，after 发送完成时已取消的消息已出队
+	if err := d.publishWithPriority(PriorityNormal, opts("after")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range p.published {
+		got = append(got, o["Topic"].(string))
+	}
+	if strings.Join(got, ",") != "first,after" {
+		t.Fatalf("unexpected publish order: %v", got)
+	}
+}
+
+func TestPublishQueueStarvation(t *testing.T) {
+	q := &publishQueue{}
+	for i := 0; i < MaxPrioritySkips+2; i++ {
+		q.lanes[PriorityHigh] = append(q.lanes[PriorityHigh], &queuedPublish{})
+	}
+	low := &queuedPublish{}
+	q.lanes[PriorityLow] = append(q.lanes[PriorityLow], low)
+	for i := 0; i < MaxPrioritySkips; i++ {
+		if q.next() == low {
+			t.Fatalf("low priority sent after %d skips", i)
+		}
+	}
+	if q.next() != low {
+		t.Fatalf("low priority starved after %d skips", MaxPrioritySkips)
+	}
+}
+
+// newRotateServer 模拟密钥轮换接口，只接受 valid 中的密钥登录，rotated 记录轮换次数
+func newRotateServer(valid map[string]bool, next string, rotated *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(rotated, 1)
+		fmt.Fprintf(w, `{"code":0,"data":{"device_secret":%q}}`, next)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		args := AuthArgs{}
+		json.NewDecoder(r.Body).Decode(&args)
+		if !valid[args.Secret] {
+			fmt.Fprint(w, `{"code":401,"message":"invalid secret"}`)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883"}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRotateSecret(t *testing.T) {
+	cases := []struct {
+		name    string
+		valid   map[string]bool
+		pending string
+		secret  string
+		rotated int32
+		failed  bool
+	}{
+		{"rotated", map[string]bool{"old": true, "new": true}, "", "new", 1, false},
+		{"rolled back", map[string]bool{"old": true}, "", "old", 1, true},
+		{"resumed", map[string]bool{"old": true, "interrupted": true}, "interrupted", "interrupted", 0, false},
+		{"stale pending", map[string]bool{"old": true, "new": true}, "stale", "new", 1, false},
+	}
+	for _, c := range cases {
+		var rotated int32
+		srv := newRotateServer(c.valid, "new", &rotated)
+		store := newMemStorage()
+		d := New(ProductKey, c.name, Version, Storage(store), Topics(topics.Topics{
+			Login:        srv.URL + "/login",
+			RotateSecret: srv.URL + "/rotate",
+		}))
+		d.ID = 1
+		d.Secret = "old"
+		d.SetDeviceInfo()
+		if c.pending != "" {
+			store.Set(d.pendingSecretKey(), c.pending)
+		}
+		err := d.RotateSecret(context.Background())
+		srv.Close()
+		if (err != nil) != c.failed {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+		stored, _ := store.Get(c.name + ".Secret")
+		pending, _ := store.Get(d.pendingSecretKey())
+		if d.Secret != c.secret || stored != c.secret || pending != nil {
+			t.Fatalf("%s: secret %q, stored %v, pending %v", c.name, d.Secret, stored, pending)
+		}
+		if rotated != c.rotated {
+			t.Fatalf("%s: rotated %d times, want %d", c.name, rotated, c.rotated)
+		}
+	}
+}
+
+func TestRotateSecretLoginUnavailable(t *testing.T) {
+	var rotated, unavailable int32 = 0, 1
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&rotated, 1)
+		fmt.Fprint(w, `{"code":0,"data":{"device_secret":"new"}}`)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&unavailable) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883"}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	store := newMemStorage()
+	d := New(ProductKey, DeviceName, Version, Storage(store), Topics(topics.Topics{
+		Login:        srv.URL + "/login",
+		RotateSecret: srv.URL + "/rotate",
+	}))
+	d.ID = 1
+	d.Secret = "old"
+	d.SetDeviceInfo()
+
+	// 平台可能已经轮换，保留待确认密钥
+	err := d.RotateSecret(context.Background())
+	if err == nil || errors.Cause(err) == ErrLoginRejected {
+		t.Fatalf("got %v, want unavailable error", err)
+	}
+	if pending, _ := store.Get(d.pendingSecretKey()); pending != "new" || d.Secret != "old" {
+		t.Fatalf("secret %q, pending %v", d.Secret, pending)
+	}
+	// 再次调用时先确认待确认密钥，不重新申请
+	if err := d.RotateSecret(context.Background()); err == nil || rotated != 1 {
+		t.Fatalf("rotated %d times, err %v", rotated, err)
+	}
+	atomic.StoreInt32(&unavailable, 0)
+	if err := d.RotateSecret(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.Get(DeviceName + ".Secret")
+	pending, _ := store.Get(d.pendingSecretKey())
+	if d.Secret != "new" || stored != "new" || pending != nil || rotated != 1 {
+		t.Fatalf("secret %q, stored %v, pending %v, rotated %d", d.Secret, stored, pending, rotated)
+	}
+}
+
+// retainedResponse 保留的测试消息
+type retainedResponse struct {
+	topicResponse
+}
+
+func (r retainedResponse) Retained() bool { return true }
+
+func TestIgnoreRetainedCommands(t *testing.T) {
+	for _, ignore := range []bool{false, true} {
+		p := newFakeProtocol()
+		d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithIgnoreRetainedCommands(ignore),
+			Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+		var errs []error
+		d.OnCommandError(func(topic string, err error) {
+			errs = append(errs, err)
+		})
+		handled := 0
+		if err := d.OnCommand(Command{ID: 1, Callback: func(map[int]interface{}) { handled++ }}); err != nil {
+			t.Fatal(err)
+		}
+		cb := p.callbacks[d.Topics.OnCommand]
+		cb(retainedResponse{topicResponse{testResponse{[]byte("1,2,on")}, d.Topics.OnCommand}})
+		p.deliver(d.Topics.OnCommand, []byte("1,2,off"))
+		if ignore && (handled != 1 || len(errs) != 1 || errs[0] != ErrRetainedCommand) {
+			t.Fatalf("ignore retained: handled %d, errors %v", handled, errs)
+		}
+		if !ignore && (handled != 2 || len(errs) != 0) {
+			t.Fatalf("execute retained: handled %d, errors %v", handled, errs)
+		}
+	}
+}
+
+func TestMaxConcurrentCommands(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})),
+		WithMaxConcurrentCommands(2), WithCommandQueueSize(1))
+	var mu sync.Mutex
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	release := make(chan struct{})
+	var handled int32
+	if err := d.OnCommand(Command{ID: 1, Handler: func(map[int]interface{}) (interface{}, error) {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil, nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		p.deliver(d.Topics.OnCommand, []byte(fmt.Sprintf("1,0,%d", i)))
+	}
+	// 2 个执行、1 个排队、1 个被拒绝
+	if running, waiting := d.RunningCommands(); running != 2 || waiting != 1 {
+		t.Fatalf("running %d, waiting %d, want 2, 1", running, waiting)
+	}
+	mu.Lock()
+	if len(errs) != 1 || errors.Cause(errs[0]) != ErrCommandBusy {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	mu.Unlock()
+	p.mu.Lock()
+	busy := len(p.published) == 1 && strings.Contains(string(p.published[0]["Payload"].([]byte)), `"code":503`)
+	p.mu.Unlock()
+	if !busy {
+		t.Fatal("rejected command should reply busy")
+	}
+	close(release)
+	d.Close()
+	if handled != 3 {
+		t.Fatalf("handled %d commands, want 3", handled)
+	}
+	if running, waiting := d.RunningCommands(); running != 0 || waiting != 0 {
+		t.Fatalf("running %d, waiting %d after close", running, waiting)
+	}
+}
+
+func TestOrderedCommands(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})),
+		WithOrderedCommands(), WithCommandQueueSize(2))
+	var mu sync.Mutex
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	release := make(chan struct{})
+	other := make(chan struct{})
+	var order []interface{}
+	if err := d.OnCommand(Command{ID: 1, Callback: func(params map[int]interface{}) {
+		if params[0] == "x" {
+			close(other)
+			return
+		}
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, params[0])
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// 子设备 1：1 个执行、2 个排队、1 个被拒绝
+	for _, v := range []string{"a", "b", "c", "d"} {
+		p.deliver(d.Topics.OnCommand, []byte("1,1,"+v))
+	}
+	if pending := d.PendingCommands(1); pending != 2 {
+		t.Fatalf("pending %d, want 2", pending)
+	}
+	// 子设备 1 阻塞时子设备 2 的命令仍然执行
+	p.deliver(d.Topics.OnCommand, []byte("1,2,x"))
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("command of another sub-device blocked")
+	}
+	close(release)
+	d.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Fatalf("executed %v, want [a b c]", order)
+	}
+	if len(errs) != 1 || errors.Cause(errs[0]) != ErrCommandBusy {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d.PendingCommands(1) != 0 {
+		t.Fatal("queue should be empty after close")
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := AuthArgs{}
+		json.NewDecoder(r.Body).Decode(&args)
+		compression := ""
+		// legacy 模拟不支持压缩的平台
+		if args.Compression == CompressionGzip && r.URL.Path != "/legacy" {
+			compression = CompressionGzip
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883","compression":%q}}`, compression)
+	}))
+	defer srv.Close()
+	cases := []struct {
+		name       string
+		login      string
+		request    bool
+		compressed bool
+	}{
+		{"negotiated", "/login", true, true},
+		{"legacy platform", "/legacy", true, false},
+		{"not requested", "/login", false, false},
+	}
+	csv := serializer.NewCSV([]string{"id", "0"})
+	property := Property{PropertyID: 1, Value: []interface{}{"on"}}
+	plain, _ := csv.MakePropertyData(property.toSerializerProperty())
+	for _, c := range cases {
+		p := newFakeProtocol()
+		opts := []func(*Device){Protocol(p), Storage(newMemStorage()), Serializer(csv), Topics(topics.Topics{Login: srv.URL + c.login})}
+		if c.request {
+			opts = append(opts, WithCompression())
+		}
+		d := New(ProductKey, DeviceName, Version, opts...)
+		d.ID = 1
+		d.Secret = "secret"
+		if err := d.Login(); err != nil {
+			t.Fatal(err)
+		}
+		d.negotiateCompression()
+		if d.CompressionEnabled() != c.compressed {
+			t.Fatalf("%s: compression enabled %v", c.name, d.CompressionEnabled())
+		}
+		if err := d.PostProperty(property); err != nil {
+			t.Fatal(err)
+		}
+		payload := p.published[0]["Payload"].([]byte)
+		if c.compressed {
+			r, err := gzip.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			payload, _ = ioutil.ReadAll(r)
+		}
+		if !bytes.Equal(payload, plain) {
+			t.Fatalf("%s: unexpected payload %q", c.name, payload)
+		}
+	}
+}
+
+func TestSchedulePropertyReport(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "0"})))
+	var sampled int32
+	d.SchedulePropertyReport(1, time.Millisecond, func() interface{} {
+		return atomic.AddInt32(&sampled, 1)
+	})
+	stop := d.SchedulePropertyReport(2, time.Millisecond, func() interface{} { return "on" })
+	stop()
+	stop()
+	published := func() int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.published)
+	}
+	waitFor := func(cond func() bool) {
+		for i := 0; i < 1000 && !cond(); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if !cond() {
+			t.Fatal("condition not met")
+		}
+	}
+	waitFor(func() bool { return published() >= 2 })
+	// 断开期间暂停采样
+	p.setOffline(true)
+	time.Sleep(5 * time.Millisecond)
+	paused := atomic.LoadInt32(&sampled)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&sampled) != paused {
+		t.Fatal("schedule should pause while disconnected")
+	}
+	p.setOffline(false)
+	waitFor(func() bool { return atomic.LoadInt32(&sampled) > paused })
+	d.Close()
+	waitFor(func() bool {
+		d.schedules.mu.Lock()
+		defer d.schedules.mu.Unlock()
+		return len(d.schedules.stops) == 0
+	})
+	n := published()
+	time.Sleep(10 * time.Millisecond)
+	if published() != n {
+		t.Fatal("schedule should stop on Close")
+	}
+	for _, opts := range p.published {
+		if string(opts["Payload"].([]byte))[:2] != "1," {
+			t.Fatalf("stopped schedule reported: %q", opts["Payload"])
+		}
+	}
+}
+
+func TestPostPropertyOrQueue(t *testing.T) {
+	p := newFakeProtocol()
+	limiter := &gateLimiter{waiting: make(chan struct{}), open: make(chan struct{})}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithOfflineQueue(3),
+		WithPublishLimiter(limiter), Serializer(serializer.NewCSV([]string{"id", "0"})))
+	post := func(value int) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		sent, err := d.PostPropertyOrQueue(ctx, Property{PropertyID: 1, Value: []interface{}{value}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sent
+	}
+	// 发送阻塞超过 ctx 超时时间，放入队列
+	if post(0) {
+		t.Fatal("timed out publish should be queued")
+	}
+	// 超时后仍在进行的发送完成后才模拟断开
+	close(limiter.open)
+	for i := 0; i < 1000 && d.Stats().MessagesSent == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	p.setOffline(true)
+	for i := 1; i <= 3; i++ {
+		if post(i) {
+			t.Fatal("offline publish should be queued")
+		}
+	}
+	if d.OfflineQueued() != 3 || d.Stats().OfflineDropped != 1 {
+		t.Fatalf("queued %d, dropped %d", d.OfflineQueued(), d.Stats().OfflineDropped)
+	}
+	p.setOffline(false)
+	p.mu.Lock()
+	p.published = nil
+	p.mu.Unlock()
+	d.flushOffline()
+	if !post(4) {
+		t.Fatal("online publish should be sent")
+	}
+	var got []string
+	for _, opts := range p.published {
+		got = append(got, strings.TrimSpace(string(opts["Payload"].([]byte))))
+	}
+	if strings.Join(got, ";") != "1,1;1,2;1,3;1,4" || d.OfflineQueued() != 0 {
+		t.Fatalf("unexpected publish order: %v", got)
+	}
+}
+
+// unackedProtocol 设置了 WaitTimeout 的发布返回 ErrPublishTimeout，模拟连接即将断开、收不到服务端确认
+type unackedProtocol struct {
+	*fakeProtocol
+}
+
+func (p *unackedProtocol) Publish(opts map[string]interface{}) error {
+	if _, ok := opts["WaitTimeout"].(time.Duration); ok {
+		return protocol.ErrPublishTimeout
+	}
+	return p.fakeProtocol.Publish(opts)
+}
+
+func TestPostPropertyOrQueueUnacked(t *testing.T) {
+	p := &unackedProtocol{newFakeProtocol()}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithOfflineQueue(3),
+		Serializer(serializer.NewCSV([]string{"id", "0"})))
+	for _, ctx := range []context.Context{context.Background(), context.TODO()} {
+		sent, err := d.PostPropertyOrQueue(ctx, Property{PropertyID: 1, Value: []interface{}{1}})
+		if err != nil || sent {
+			t.Fatalf("unacknowledged publish should be queued, sent %v, err %v", sent, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if sent, _ := d.PostPropertyOrQueue(ctx, Property{PropertyID: 1, Value: []interface{}{2}}); sent {
+		t.Fatal("unacknowledged publish should be queued")
+	}
+	if d.OfflineQueued() != 3 {
+		t.Fatalf("queued %d, want 3", d.OfflineQueued())
+	}
+	// 队列中的消息不带 WaitTimeout，重连后正常发送
+	d.flushOffline()
+	if d.OfflineQueued() != 0 || len(p.published) != 3 {
+		t.Fatalf("queued %d, published %d", d.OfflineQueued(), len(p.published))
+	}
+}
+
+func TestPostPropertyIncrement(t *testing.T) {
+	p := newFakeProtocol()
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv))
+	for _, delta := range []float64{1, 2.5} {
+		if err := d.PostPropertyIncrement(4, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 发送失败的增量不计入累计值
+	p.setOffline(true)
+	if err := d.PostPropertyIncrement(4, 10); err == nil {
+		t.Fatal("post increment while offline should fail")
+	}
+	if total := d.IncrementTotal(4); total != 3.5 {
+		t.Fatalf("total is %v, want 3.5", total)
+	}
+	var last uint64
+	for _, published := range p.published {
+		property, err := tlv.UnmarshalProperty(published["Payload"].([]byte))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !property.Increment || property.Sequence <= last {
+			t.Fatalf("unexpected increment property %+v after sequence %d", property, last)
+		}
+		last = property.Sequence
+	}
+}
+
+func TestAdaptiveKeepalive(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithAdaptiveKeepalive(5*time.Second, 60*time.Second))
+	if got := d.keepAlive(); got != 60*time.Second {
+		t.Fatalf("connect keepalive is %s, want max", got)
+	}
+	publish := func() error {
+		return d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}})
+	}
+	// 连续失败时减半直到下限
+	p.setOffline(true)
+	for _, want := range []time.Duration{30 * time.Second, 15 * time.Second, 7500 * time.Millisecond, 5 * time.Second, 5 * time.Second} {
+		publish()
+		if got := d.KeepaliveInterval(); got != want {
+			t.Fatalf("interval after failure is %s, want %s", got, want)
+		}
+	}
+	// 连续成功 AdaptiveKeepaliveStableCount 次后加倍
+	p.setOffline(false)
+	for i := 0; i < AdaptiveKeepaliveStableCount-1; i++ {
+		publish()
+	}
+	if got := d.KeepaliveInterval(); got != 5*time.Second {
+		t.Fatalf("interval changed before stable, got %s", got)
+	}
+	publish()
+	if got := d.KeepaliveInterval(); got != 10*time.Second {
+		t.Fatalf("interval after stable is %s, want 10s", got)
+	}
+	// 未开启时使用固定心跳
+	if got := New(ProductKey, DeviceName, Version).KeepaliveInterval(); got != DefaultKeepAlive {
+		t.Fatalf("default interval is %s", got)
+	}
+}
+
+func TestLogDedup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	warn := mqtt.WARN
+	mqtt.WARN = log.New(buf, "", 0)
+	defer func() { mqtt.WARN = warn }()
+	p := newFakeProtocol()
+	p.setOffline(true)
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	for i := 0; i < 5; i++ {
+		d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}})
+	}
+	if n := strings.Count(buf.String(), "failed"); n != 1 {
+		t.Fatalf("logged %d publish failures, want 1:\n%s", n, buf.String())
+	}
+	if d.Stats().LogsSuppressed != 4 {
+		t.Fatalf("suppressed %d logs, want 4", d.Stats().LogsSuppressed)
+	}
+	// 关闭时输出被合并的条数
+	d.Close()
+	if !strings.Contains(buf.String(), "last message repeated 4 times") {
+		t.Fatalf("suppression not reported:\n%s", buf.String())
+	}
+	// 关闭去重时每条都输出
+	buf.Reset()
+	d = New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithLogDedupWindow(0))
+	for i := 0; i < 3; i++ {
+		d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}})
+	}
+	if n := strings.Count(buf.String(), "failed"); n != 3 {
+		t.Fatalf("logged %d publish failures without dedup, want 3", n)
+	}
+}
+
+func TestOfflineTimestamp(t *testing.T) {
+	p := newFakeProtocol()
+	p.setOffline(true)
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv))
+	// 采集时间比重连发送早一小时
+	measured := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	if _, err := d.PostPropertyOrQueue(context.Background(), Property{PropertyID: 1, Value: []interface{}{uint8(1)}, Timestamp: measured}); err != nil {
+		t.Fatal(err)
+	}
+	// 未设置采集时间时以放入队列的时间作为采集时间
+	queuedAt := time.Now().Truncate(time.Millisecond)
+	if _, err := d.PostPropertyOrQueue(context.Background(), Property{PropertyID: 2, Value: []interface{}{uint8(2)}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	p.setOffline(false)
+	drainedAt := time.Now()
+	d.flushOffline()
+	if len(p.published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(p.published))
+	}
+	first, err := tlv.UnmarshalProperty(p.published[0]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Timestamp.Equal(measured) {
+		t.Fatalf("reported timestamp %v, want %v", first.Timestamp, measured)
+	}
+	second, err := tlv.UnmarshalProperty(p.published[1]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Timestamp.Before(queuedAt) || !second.Timestamp.Before(drainedAt) {
+		t.Fatalf("reported timestamp %v, want queue time between %v and %v", second.Timestamp, queuedAt, drainedAt)
+	}
+}
+
+func TestPostPropertyWithTimestamp(t *testing.T) {
+	p := newFakeProtocol()
+	for _, s := range []serializer.Serializer{serializer.NewTLV(), serializer.NewJSON()} {
+		p.published = nil
+		d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(s))
+		sampled := time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond)
+		if err := d.PostPropertyWithTimestamp(Property{PropertyID: 1, Value: []interface{}{uint8(1)}}, sampled); err != nil {
+			t.Fatal(err)
+		}
+		// 零值不覆盖属性自带的采集时间
+		if err := d.PostPropertyWithTimestamp(Property{PropertyID: 2, Value: []interface{}{uint8(2)}, Timestamp: sampled}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.published) != 2 {
+			t.Fatalf("%T: published %d messages, want 2", s, len(p.published))
+		}
+		for _, message := range p.published {
+			property, err := s.UnmarshalProperty(message["Payload"].([]byte))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !property.Timestamp.Equal(sampled) {
+				t.Fatalf("%T: property %d reported at %v, want %v", s, property.PropertyID, property.Timestamp, sampled)
+			}
+		}
+	}
+}
+
+func TestBlockingPublish(t *testing.T) {
+	defer func(interval time.Duration) { BlockingPublishPollInterval = interval }(BlockingPublishPollInterval)
+	BlockingPublishPollInterval = time.Millisecond
+	p := newFakeProtocol()
+	p.setOffline(true)
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithBlockingPublish(time.Second))
+	// 连接恢复后发送
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.setOffline(false)
+	}()
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(p.published))
+	}
+	p.setOffline(true)
+	d.BlockingPublish = 20 * time.Millisecond
+	if err := d.PostEvent("alarm", Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); errors.Cause(err) != ErrPublishWaitTimeout {
+		t.Fatalf("got %v, want ErrPublishWaitTimeout", err)
+	}
+	d.BlockingPublish = time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.PostPropertyContext(ctx, Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); errors.Cause(err) != context.Canceled {
+		t.Fatalf("got %v, want context canceled", err)
+	}
+	// 未开启时立即返回错误
+	d.BlockingPublish = 0
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); err == nil || errors.Cause(err) == ErrPublishWaitTimeout {
+		t.Fatalf("got %v, want publish error", err)
+	}
+}
+
+func TestOfflineMaxAge(t *testing.T) {
+	p := newFakeProtocol()
+	p.setOffline(true)
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithOfflineMaxAge(time.Millisecond),
+		Serializer(serializer.NewCSV([]string{"id", "0"})))
+	if _, err := d.PostPropertyOrQueue(context.Background(), Property{PropertyID: 1, Value: []interface{}{1}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	p.setOffline(false)
+	d.flushOffline()
+	if len(p.published) != 0 || d.OfflineDropped() != 1 {
+		t.Fatalf("expired message sent: %v", p.published)
+	}
+	d.OfflineQueueSize = 0
+	p.setOffline(true)
+	if _, err := d.PostPropertyOrQueue(context.Background(), Property{PropertyID: 1, Value: []interface{}{1}}); err == nil {
+		t.Fatal("disabled offline queue should return error")
+	}
+}
+
+func TestCommandParamByName(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		WithCommandParams(1, "power", "brightness"),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0", "1"})))
+	var got []interface{}
+	err := d.OnCommand(Command{ID: 1, ContextHandler: func(ctx CommandContext) (interface{}, error) {
+		for _, name := range []string{"power", "brightness", "sub_device_id", "missing"} {
+			v, ok := ctx.ParamByName(name)
+			got = append(got, v, ok)
+		}
+		return nil, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte("1,2,on,80"))
+	want := []interface{}{"on", true, "80", true, uint16(2), true, nil, false}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if len(p.published) != 1 || p.published[0]["Topic"] != d.Topics.CommandResponse {
+		t.Fatalf("context handler should reply: %v", p.published)
+	}
+	// 未注册参数名称时只能读取 SubDeviceID
+	if _, ok := (CommandContext{Params: map[int]interface{}{0: "on"}}).ParamByName("power"); ok {
+		t.Fatal("unregistered param name should not resolve")
+	}
+}
+
+func TestCloseWaitsForGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		p := newFakeProtocol()
+		d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithReceiveBuffer(4),
+			Serializer(serializer.NewCSV([]string{"id", "0"})))
+		r := request.Request{Topic: "test", Callback: func(request.Response) {}}
+		if err := d.Subscribe(r); err != nil {
+			t.Fatal(err)
+		}
+		p.deliver("test", []byte("1"))
+		d.SchedulePropertyReport(1, time.Millisecond, func() interface{} { return i })
+		if _, err := d.PostPropertyOrQueue(context.Background(), Property{PropertyID: 1, Value: []interface{}{i}}); err != nil {
+			t.Fatal(err)
+		}
+		if d.GoroutineCount() == 0 {
+			t.Fatal("receive buffer and schedule goroutines should be counted")
+		}
+		d.Close()
+		if n := d.GoroutineCount(); n != 0 {
+			t.Fatalf("%d goroutines still running after Close", n)
+		}
+	}
+	// 留出 httptest 等其他测试遗留协程退出的时间
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Fatalf("goroutines grew from %d to %d", before, after)
+	}
+}
+
+// propertiesResponse 携带 MQTT 5 请求/响应属性的测试消息
+type propertiesResponse struct {
+	topicResponse
+	responseTopic string
+	correlation   []byte
+}
+
+func (r propertiesResponse) ResponseTopic() string   { return r.responseTopic }
+func (r propertiesResponse) CorrelationData() []byte { return r.correlation }
+
+func TestCorrelatedReply(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	if err := d.OnDiagnosticRequest(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OnCommand(Command{ID: 1, Handler: func(map[int]interface{}) (interface{}, error) { return nil, nil }}); err != nil {
+		t.Fatal(err)
+	}
+	v5 := func(topic string, payload []byte) {
+		p.mu.Lock()
+		cb := p.callbacks[topic]
+		p.mu.Unlock()
+		cb(propertiesResponse{topicResponse{testResponse{payload}, topic}, "replies/r1", []byte("c1")})
+	}
+	v5(d.Topics.DiagnosticRequest, []byte(`{"request_id":"r1"}`))
+	v5(d.Topics.OnCommand, []byte("1,0,on"))
+	if len(p.published) != 2 {
+		t.Fatalf("unexpected published messages: %+v", p.published)
+	}
+	for _, opts := range p.published {
+		if opts["Topic"] != "replies/r1" || string(opts["CorrelationData"].([]byte)) != "c1" {
+			t.Fatalf("reply should use response topic and correlation data: %+v", opts)
+		}
+	}
+	reply := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.Unmarshal(p.published[0]["Payload"].([]byte), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reply.Data["request_id"]; ok {
+		t.Fatal("request_id should not be embedded when correlation data is present")
+	}
+
+	// MQTT 3.1.1 下回复到固定主题，不携带关联数据
+	p.deliver(d.Topics.OnCommand, []byte("1,0,on"))
+	if opts := p.published[2]; opts["Topic"] != d.Topics.CommandResponse || len(opts["CorrelationData"].([]byte)) != 0 {
+		t.Fatalf("unexpected fallback reply: %+v", opts)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	cycle := EnumCycle("on", "off")
+	if got := []interface{}{cycle.Next(), cycle.Next(), cycle.Next()}; fmt.Sprint(got) != "[on off on]" {
+		t.Fatalf("enum cycle got %v", got)
+	}
+	if v := Fixed(int32(5)).Next(); v != int32(5) {
+		t.Fatalf("fixed got %v", v)
+	}
+	if v := Sine(10, 20, 0).Next(); v != float64(20) {
+		t.Fatalf("sine without period got %v, want offset", v)
+	}
+	sine := Sine(10, 20, time.Hour)
+	if v := sine.Next().(float64); v < 20 || v > 20.1 {
+		t.Fatalf("sine should start at offset, got %v", v)
+	}
+	walk := RandomWalk(50, 5, 45, 52)
+	prev := walk.Next().(float64)
+	if prev != 50 {
+		t.Fatalf("random walk should start at 50, got %v", prev)
+	}
+	for i := 0; i < 100; i++ {
+		v := walk.Next().(float64)
+		if v < 45 || v > 52 || math.Abs(v-prev) > 5 {
+			t.Fatalf("random walk stepped from %v to %v", prev, v)
+		}
+		prev = v
+	}
+
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	stop := d.Simulate(SimConfig{
+		Interval: 5 * time.Millisecond,
+		Properties: map[uint16]Generator{
+			1: Sine(10, 20, time.Second),
+			2: EnumCycle(int32(0), int32(1)),
+		},
+	})
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		n := len(p.published)
+		p.mu.Unlock()
+		if n >= 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("published %d messages, want at least 4", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	d.Close()
+	if n := d.GoroutineCount(); n != 0 {
+		t.Fatalf("%d goroutines still running after stop", n)
+	}
+}
+
+func TestIncompleteResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	// 声明的长度大于实际写入的内容，模拟连接在响应中途断开
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, `{"code":0,"data":{"device_id":1`)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html>bad gateway</html>")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	d := New(ProductKey, DeviceName, Version, Storage(newMemStorage()), Topics(topics.Topics{
+		Register: srv.URL + "/register",
+		Login:    srv.URL + "/login",
+	}))
+	err := d.Register()
+	if errors.Cause(err) != ErrIncompleteResponse {
+		t.Fatalf("got %v, want ErrIncompleteResponse", err)
+	}
+	incomplete := &IncompleteResponseError{}
+	if !errors.As(err, &incomplete) || string(incomplete.Body) != `{"code":0,"data":{"device_id":1` {
+		t.Fatalf("got %v, want the bytes read", err)
+	}
+	d.ID, d.Secret = 1, "secret"
+	err = d.Login()
+	status := &HTTPStatusError{}
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got %v, want http status error", err)
+	}
+}
+
+func TestPropertyNamespace(t *testing.T) {
+	p := newFakeProtocol()
+	csv := serializer.NewCSV([]string{"sub_device_id", "id", "unit", "0"})
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(csv),
+		WithPropertyNamespace("thermometer", PropertyNamespace{
+			Names: map[uint16]string{1: "temperature"},
+			Units: map[uint16]string{1: "°C"},
+		}),
+		WithPropertyNamespace("switch", PropertyNamespace{
+			Names: map[uint16]string{1: "power"},
+		}))
+	d.BindSubDevice(7, "thermometer")
+	d.BindSubDevice(8, "switch")
+	// 相同的属性 ID 在不同子设备中表示不同的属性
+	if name, _ := d.PropertyName(7, 1); name != "temperature" {
+		t.Fatalf("sub device 7 property 1 is %q", name)
+	}
+	if name, _ := d.PropertyName(8, 1); name != "power" {
+		t.Fatalf("sub device 8 property 1 is %q", name)
+	}
+	if err := d.PostPropertyByName(7, "temperature", 21.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PostPropertyByName(8, "power", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PostPropertyByName(8, "temperature", 21.5); errors.Cause(err) != ErrUnknownProperty {
+		t.Fatalf("got %v, want ErrUnknownProperty", err)
+	}
+	if got := fmt.Sprintf("%s|%s", p.published[0]["Payload"], p.published[1]["Payload"]); got != "7,1,°C,21.5\n|8,1,,on\n" {
+		t.Fatalf("unexpected payloads: %q", got)
+	}
+
+	// 平台设置的属性不在子设备的命名空间中时不调用 handler
+	d.Topics.SetProperty = "sp"
+	var set []Property
+	var setErr error
+	d.OnCommandError(func(topic string, err error) { setErr = err })
+	if err := d.OnSetProperty(func(property Property) { set = append(set, property) }); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver("sp", []byte("8,1,,off\n"))
+	p.deliver("sp", []byte("8,2,,off\n"))
+	if len(set) != 1 || set[0].SubDeviceID != 8 || errors.Cause(setErr) != ErrUnknownProperty {
+		t.Fatalf("got %+v, %v", set, setErr)
+	}
+	d.BindSubDevice(8, "")
+	if d.SubDeviceNamespace(8) != "" {
+		t.Fatal("sub device 8 should be unbound")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), WithMaintenanceStatus(true),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id", "0"})))
+	handled := 0
+	if err := d.OnCommand(Command{ID: 1, Handler: func(map[int]interface{}) (interface{}, error) { handled++; return nil, nil }}); err != nil {
+		t.Fatal(err)
+	}
+	maintenance := func(opts map[string]interface{}) interface{} {
+		info := map[string]interface{}{}
+		if err := json.Unmarshal(opts["Payload"].([]byte), &info); err != nil {
+			t.Fatal(err)
+		}
+		return info["maintenance"]
+	}
+	if err := d.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Paused() || len(p.published) != 1 || maintenance(p.published[0]) != true {
+		t.Fatalf("pause should report maintenance status: %+v", p.published)
+	}
+
+	// 暂停期间上报放入离线队列，命令被拒绝但照常回复
+	if err := d.PostProperty(Property{PropertyID: 2, Value: []interface{}{"on"}}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte("1,0,on"))
+	if handled != 0 || d.OfflineQueued() != 1 || len(p.published) != 2 {
+		t.Fatalf("handled %d, queued %d, published %+v", handled, d.OfflineQueued(), p.published)
+	}
+	if !strings.Contains(string(p.published[1]["Payload"].([]byte)), `"code":503`) {
+		t.Fatalf("paused command should be rejected: %s", p.published[1]["Payload"])
+	}
+	d.PauseMode = PauseDrop
+	if err := d.PostProperty(Property{PropertyID: 2, Value: []interface{}{"off"}}); errors.Cause(err) != ErrPaused {
+		t.Fatalf("got %v, want ErrPaused", err)
+	}
+
+	if err := d.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Paused() || len(p.published) != 4 || maintenance(p.published[2]) != false || p.published[3]["Topic"] != d.Topics.PostProperty {
+		t.Fatalf("resume should report status and flush queued messages: %+v", p.published)
+	}
+	p.deliver(d.Topics.OnCommand, []byte("1,0,on"))
+	if handled != 1 {
+		t.Fatal("command should run after resume")
+	}
+}
+
+func TestOnProperty(t *testing.T) {
+	p := newFakeProtocol()
+	tlv := serializer.NewTLV()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv))
+	var got []Property
+	if err := d.OnProperty(func(property Property) { got = append(got, property) }); err != nil {
+		t.Fatal(err)
+	}
+	var decodeErr error
+	d.OnCommandError(func(topic string, err error) { decodeErr = err })
+	data, err := tlv.MakeBatchPropertyData([]*serializer.Property{
+		{PropertyID: 1, Value: []interface{}{uint8(3)}},
+		{SubDeviceID: 2, PropertyID: 5, Value: []interface{}{"on", int32(-1)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnProperty, data)
+	if len(got) != 2 {
+		t.Fatalf("got %d properties, want 2", len(got))
+	}
+	if got[0].PropertyID != 1 || fmt.Sprint(got[0].Value) != "[3]" {
+		t.Fatalf("unexpected first property: %+v", got[0])
+	}
+	if got[1].SubDeviceID != 2 || got[1].PropertyID != 5 || fmt.Sprint(got[1].Value) != "[on -1]" {
+		t.Fatalf("unexpected second property: %+v", got[1])
+	}
+	p.deliver(d.Topics.OnProperty, []byte{0x01})
+	if len(got) != 2 || decodeErr == nil {
+		t.Fatalf("malformed property should be reported, got %v", decodeErr)
+	}
+
+	d.Topics.OnProperty = ""
+	if err := d.OnProperty(func(Property) {}); err == nil {
+		t.Fatal("on property should fail without OnProperty topic")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	d := New(ProductKey, DeviceName, Version)
+	if err := d.Disconnect(); err != nil {
+		t.Fatalf("disconnect before connect: %v", err)
+	}
+	m := d.Protocol.(*protocol.MQTT)
+	opts, err := d.Protocol.MakeOpts(map[string]interface{}{
+		"Broker":           "127.0.0.1:1883",
+		"ClientID":         "1",
+		"Username":         "1",
+		"Password":         "1",
+		"KeepAlive":        30 * time.Second,
+		"OnConnectionLost": func() map[string]interface{} { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Client = mqtt.NewClient(opts.(*mqtt.ClientOptions))
+	for i := 0; i < 2; i++ {
+		if err := d.Disconnect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.IsConnected() {
+		t.Fatal("device should not be connected after disconnect")
+	}
+	err = d.PostProperty(Property{PropertyID: 1, Value: []interface{}{int32(1)}})
+	if errors.Cause(err) != protocol.ErrNotConnected {
+		t.Fatalf("got %v, want ErrNotConnected", err)
+	}
+
+	f := newFakeProtocol()
+	d.Protocol = f
+	if err := d.Disconnect(); err == nil {
+		t.Fatal("disconnect should fail when protocol does not support it")
+	}
+}
+
+func TestCloseDisconnects(t *testing.T) {
+	store := newMemStorage()
+	d := New(ProductKey, DeviceName, Version, Storage(store))
+	m := d.Protocol.(*protocol.MQTT)
+	opts, err := d.Protocol.MakeOpts(map[string]interface{}{
+		"Broker":           "127.0.0.1:1883",
+		"ClientID":         "1",
+		"Username":         "1",
+		"Password":         "1",
+		"KeepAlive":        30 * time.Second,
+		"OnConnectionLost": func() map[string]interface{} { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Client = mqtt.NewClient(opts.(*mqtt.ClientOptions))
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close 通过 Disconnect 断开，之后 AutoInit 会重新创建客户端
+	if m.Client != nil || d.IsConnected() {
+		t.Fatal("protocol client should be released after close")
+	}
+	err = d.Publish(request.Request{Topic: "test", Payload: []byte("1")})
+	if errors.Cause(err) != protocol.ErrNotConnected {
+		t.Fatalf("got %v, want ErrNotConnected", err)
+	}
+	if store.flushed != 1 {
+		t.Fatalf("flushed %d times, want 1", store.flushed)
+	}
+}
+
+func TestReplyCommand(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	tlv := serializer.NewTLV()
+	if err := d.ReplyCommand(CommandResponse{ID: 3, Code: serializer.ReplyCodeOK, Data: map[int]interface{}{0: int32(25)}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tlv.UnmarshalCommandResponse(p.published[0]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.published[0]["Topic"] != d.Topics.CommandResponse || resp.ID != 3 || resp.Code != serializer.ReplyCodeOK || resp.Data[0] != int32(25) {
+		t.Fatalf("unexpected reply %+v on %v", resp, p.published[0]["Topic"])
+	}
+
+	// 命令处理函数中先确认收到，处理完成后再回复结果
+	if err := d.OnCommand(Command{ID: 2, ReplyCallback: func(params map[int]interface{}, reply ReplyFunc) {
+		reply(202, nil)
+		reply(serializer.ReplyCodeOK, map[int]interface{}{0: "done"})
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := (&pkgprotocol.Command{Head: pkgprotocol.CommandEventHead{SubDeviceid: 1, No: 2}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, cmd)
+	if len(p.published) != 3 {
+		t.Fatalf("published %d messages, want 3", len(p.published))
+	}
+	ack, _ := tlv.UnmarshalCommandResponse(p.published[1]["Payload"].([]byte))
+	done, _ := tlv.UnmarshalCommandResponse(p.published[2]["Payload"].([]byte))
+	if ack == nil || ack.ID != 2 || ack.SubDeviceID != 1 || ack.Code != 202 || len(ack.Data) != 0 {
+		t.Fatalf("unexpected ack %+v", ack)
+	}
+	if done == nil || done.Code != serializer.ReplyCodeOK || done.Data[0] != "done" {
+		t.Fatalf("unexpected reply %+v", done)
+	}
+
+	// 序列化器不支持时使用 ReplySerializer 的信封
+	d.Serializer = serializer.NewCSV([]string{"id", "0"})
+	if err := d.ReplyCommand(CommandResponse{ID: 3, Code: serializer.ReplyCodeBusy}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(p.published[3]["Payload"].([]byte)); got != `{"command_id":3,"sub_device_id":0,"code":503}` {
+		t.Fatalf("unexpected fallback reply %s", got)
+	}
+}
+
+func TestReplyFormat(t *testing.T) {
+	commands := []Command{
+		{ID: 1, Handler: func(params map[int]interface{}) (interface{}, error) {
+			return map[int]interface{}{0: "on"}, nil
+		}},
+		{ID: 2, ReplyCallback: func(params map[int]interface{}, reply ReplyFunc) {
+			reply(serializer.ReplyCodeOK, map[int]interface{}{0: "on"})
+		}},
+	}
+
+	// 默认的 TLV：Handler 与 ReplyCallback 的回复都是 TLV 命令回复
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	if err := d.OnCommand(commands...); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint16{1, 2} {
+		cmd, err := (&pkgprotocol.Command{Head: pkgprotocol.CommandEventHead{SubDeviceid: 3, No: id}}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.deliver(d.Topics.OnCommand, cmd)
+	}
+	if len(p.published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(p.published))
+	}
+	for i, opts := range p.published {
+		resp, err := serializer.NewTLV().UnmarshalCommandResponse(opts["Payload"].([]byte))
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if opts["Topic"] != d.Topics.CommandResponse || resp.ID != uint16(i+1) || resp.SubDeviceID != 3 ||
+			resp.Code != serializer.ReplyCodeOK || resp.Data[0] != "on" {
+			t.Fatalf("unexpected reply %d: %+v", i, resp)
+		}
+	}
+
+	// 序列化器不支持命令回复时两者都使用 ReplySerializer 的信封
+	p = newFakeProtocol()
+	d = New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Serializer(serializer.NewCSV([]string{"id", "sub_device_id"})))
+	if err := d.OnCommand(commands...); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte("1,3"))
+	p.deliver(d.Topics.OnCommand, []byte("2,3"))
+	if len(p.published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(p.published))
+	}
+	for i, opts := range p.published {
+		want := fmt.Sprintf(`{"command_id":%d,"sub_device_id":3,"code":200,"data":{"0":"on"}}`, i+1)
+		if got := string(opts["Payload"].([]byte)); got != want {
+			t.Fatalf("reply %d is %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestPublishOptions(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	property := Property{PropertyID: 1, Value: []interface{}{int32(1)}}
+	check := func(i int, qos byte, retained bool) {
+		t.Helper()
+		if opts := p.published[i]; opts["Qos"] != qos || opts["Retained"] != retained {
+			t.Fatalf("message %d: qos %v retained %v, want %d %v", i, opts["Qos"], opts["Retained"], qos, retained)
+		}
+	}
+	if err := d.PostProperty(property); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PostEvent("alarm", property); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PostProperty(property, WithQos(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PostEvent("alarm", property, WithRetained(true), WithQos(2)); err != nil {
+		t.Fatal(err)
+	}
+	// 原始请求中的 QoS、Retained 原样发布，opts 覆盖
+	raw := request.Request{Topic: "custom", Qos: 2, Retained: true, Payload: []byte("1")}
+	if err := d.Publish(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Publish(raw, WithQos(0), WithRetained(false)); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 1, false)
+	check(1, 1, false)
+	check(2, 0, false)
+	check(3, 2, true)
+	check(4, 2, true)
+	check(5, 0, false)
+}
+
+// captureLogger 记录日志的 Logger
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) logf(level sdklog.Level, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level.String()+" "+fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) Debugf(format string, v ...interface{}) {
+	l.logf(sdklog.LevelDebug, format, v...)
+}
+func (l *captureLogger) Infof(format string, v ...interface{}) {
+	l.logf(sdklog.LevelInfo, format, v...)
+}
+func (l *captureLogger) Warnf(format string, v ...interface{}) {
+	l.logf(sdklog.LevelWarn, format, v...)
+}
+func (l *captureLogger) Errorf(format string, v ...interface{}) {
+	l.logf(sdklog.LevelError, format, v...)
+}
+
+func (l *captureLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// optsProtocol 记录创建客户端时的配置
+type optsProtocol struct {
+	*fakeProtocol
+	opts interface{}
+}
+
+func (p *optsProtocol) NewClient(opts interface{}) error {
+	p.opts = opts
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	logger := &captureLogger{}
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		Logger(logger), WithLoginOnReconnect(LoginNever))
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	lost := p.opts.(map[string]interface{})["OnConnectionLost"].(func(protocol.DisconnectReason) map[string]interface{})
+	lost(protocol.DisconnectNetwork)
+	if !logger.contains("WARN connection lost, reason: network") {
+		t.Fatalf("connection loss not logged: %v", logger.lines)
+	}
+
+	if err := d.OnCommand(Command{ID: 1, Callback: func(map[int]interface{}) {}}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte{1, 2})
+	if !logger.contains("ERROR " + d.Topics.OnCommand + ": unmarshal command failed") {
+		t.Fatalf("decode failure not logged: %v", logger.lines)
+	}
+}
+
+func TestQueueWhenOffline(t *testing.T) {
+	for _, c := range []struct {
+		policy DropPolicy
+		want   []uint8
+	}{
+		{DropOldest, []uint8{3, 4, 5}},
+		{DropNewest, []uint8{1, 2, 3}},
+	} {
+		p := newFakeProtocol()
+		tlv := serializer.NewTLV()
+		d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(tlv),
+			WithQueueWhenOffline(true), WithOfflineQueue(3), WithOfflineDropPolicy(c.policy))
+		p.setOffline(true)
+		for i := 1; i <= 5; i++ {
+			if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{uint8(i)}}); err != nil {
+				t.Fatalf("post while offline should queue: %v", err)
+			}
+		}
+		if d.OfflineQueued() != 3 || d.OfflineDropped() != 2 || len(p.published) != 0 {
+			t.Fatalf("policy %d: queued %d dropped %d published %d", c.policy, d.OfflineQueued(), d.OfflineDropped(), len(p.published))
+		}
+		// 重连后按顺序发送
+		p.setOffline(false)
+		d.flushOffline()
+		if len(p.published) != len(c.want) {
+			t.Fatalf("policy %d: flushed %d messages, want %d", c.policy, len(p.published), len(c.want))
+		}
+		for i, want := range c.want {
+			property, err := tlv.UnmarshalProperty(p.published[i]["Payload"].([]byte))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if property.Value[0] != want {
+				t.Fatalf("policy %d: message %d is %v, want %d", c.policy, i, property.Value[0], want)
+			}
+		}
+		if d.OfflineQueued() != 0 {
+			t.Fatalf("queue should be empty after flush, got %d", d.OfflineQueued())
+		}
+	}
+
+	// 未开启时未连接返回错误
+	p := newFakeProtocol()
+	p.setOffline(true)
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	if err := d.PostEvent("alarm", Property{PropertyID: 1, Value: []interface{}{uint8(1)}}); err == nil || d.OfflineQueued() != 0 {
+		t.Fatalf("post event should fail without QueueWhenOffline, err %v queued %d", err, d.OfflineQueued())
+	}
+}
+
+func TestJSONSerializer(t *testing.T) {
+	p := newFakeProtocol()
+	j := serializer.NewJSON()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()), Serializer(j))
+	values := []interface{}{int64(42), 36.6, "auto", true}
+	if err := d.PostProperty(Property{PropertyID: 1, Value: values}); err != nil {
+		t.Fatal(err)
+	}
+	property, err := j.UnmarshalProperty(p.published[0]["Payload"].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if property.PropertyID != 1 || fmt.Sprint(property.Value) != fmt.Sprint(values) {
+		t.Fatalf("unexpected property %+v", property)
+	}
+
+	var params map[int]interface{}
+	if err := d.OnCommand(Command{ID: 2, Callback: func(m map[int]interface{}) { params = m }}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver(d.Topics.OnCommand, []byte(`{"id":2,"params":["on",50]}`))
+	if params[0] != "on" || params[1] != int64(50) {
+		t.Fatalf("unexpected params %v", params)
+	}
+}
+
+func TestCoAPDevice(t *testing.T) {
+	server, err := coaptest.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	d := New(ProductKey, DeviceName, Version, Protocol(&protocol.CoAP{AckTimeout: 20 * time.Millisecond}), Storage(newMemStorage()))
+	d.ID = 1
+	d.Token = []byte{0x81, 0x7a}
+	d.Access = server.Addr
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Disconnect()
+	if !d.IsConnected() {
+		t.Fatal("device should be connected")
+	}
+
+	// 属性以 POST 请求到达服务端
+	if err := d.PostProperty(Property{PropertyID: 1, Value: []interface{}{int32(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	var post *coap.Message
+	timeout := time.After(time.Second)
+	for post == nil {
+		select {
+		case m := <-server.Requests:
+			if m.Code == coap.POST && m.Path() == strings.Trim(d.Topics.PostProperty, "/") {
+				post = m
+			}
+		case <-timeout:
+			t.Fatal("property not posted")
+		}
+	}
+	property, err := serializer.NewTLV().UnmarshalProperty(post.Payload)
+	if err != nil || property.PropertyID != 1 || property.Value[0] != int32(1) {
+		t.Fatalf("unexpected property %+v, %v", property, err)
+	}
+	if q := post.Queries(); len(q) != 3 || q[1] != "username=1" || q[2] != "password=817a" {
+		t.Fatalf("unexpected auth queries %v", q)
+	}
+
+	// 命令通过观察通知下发
+	received := make(chan map[int]interface{}, 1)
+	if err := d.OnCommand(Command{ID: 2, Callback: func(params map[int]interface{}) { received <- params }}); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := (&pkgprotocol.Command{Head: pkgprotocol.CommandEventHead{No: 2}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := server.Notify(d.Topics.OnCommand, cmd); err != nil || n != 1 {
+		t.Fatalf("notify %d %v", n, err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("command not delivered")
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	base := &tls.Config{ServerName: "broker"}
+	d := New(ProductKey, DeviceName, Version, Storage(newMemStorage()),
+		WithTLSConfig(base), WithCACert(certPath), WithClientCert(certPath, keyPath))
+	cfg, err := d.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg == base || cfg.ServerName != "broker" || cfg.RootCAs == nil || len(cfg.Certificates) != 1 || base.RootCAs != nil {
+		t.Fatalf("unexpected tls config %+v", cfg)
+	}
+
+	// 证书文件读取失败时创建客户端返回错误
+	d = New(ProductKey, DeviceName, Version, Storage(newMemStorage()), WithCACert(filepath.Join(dir, "missing.pem")))
+	d.Access = "127.0.0.1:8883"
+	if err := d.InitProtocolClient(); err == nil || !strings.Contains(err.Error(), "read ca cert failed") {
+		t.Fatalf("got %v, want read ca cert error", err)
+	}
+
+	// 接入地址要求 TLS 而没有 TLS 配置
+	d = New(ProductKey, DeviceName, Version, Storage(newMemStorage()))
+	d.Access = "mqtts://127.0.0.1:8883"
+	if err := d.InitProtocolClient(); errors.Cause(err) != protocol.ErrTLSConfigMissing {
+		t.Fatalf("got %v, want ErrTLSConfigMissing", err)
+	}
+}
+
+func TestLastWillOptions(t *testing.T) {
+	p := &optsProtocol{fakeProtocol: newFakeProtocol()}
+	statusTopic := "devices/" + DeviceName + "/status"
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()),
+		WithLastWill(statusTopic, []byte(`{"status":"offline"}`), 1, true))
+	d.Access = "127.0.0.1:1883"
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	params := p.opts.(map[string]interface{})
+	will, ok := params["Will"].(*protocol.Will)
+	if !ok || will.Topic != statusTopic || will.Qos != 1 || !will.Retained || string(will.Payload()) != `{"status":"offline"}` {
+		t.Fatalf("unexpected will in options: %+v", params["Will"])
+	}
+	opts, err := protocol.NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttOpts := opts.(*mqtt.ClientOptions)
+	if !mqttOpts.WillEnabled || mqttOpts.WillTopic != statusTopic || mqttOpts.WillQos != 1 || !mqttOpts.WillRetained ||
+		string(mqttOpts.WillPayload) != `{"status":"offline"}` {
+		t.Fatalf("will not applied to mqtt options: %+v", mqttOpts)
+	}
+
+	// 未设置遗嘱时不传递
+	p = &optsProtocol{fakeProtocol: newFakeProtocol()}
+	d = New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	d.Access = "127.0.0.1:1883"
+	if err := d.InitProtocolClient(); err != nil {
+		t.Fatal(err)
+	}
+	if will, _ := p.opts.(map[string]interface{})["Will"].(*protocol.Will); will != nil {
+		t.Fatalf("unexpected will %+v", will)
+	}
+}
+
+// initProtocol 创建客户端前 GetInstance 返回 nil，前 failures 次 NewClient 失败
+type initProtocol struct {
+	*fakeProtocol
+	failures int
+	created  bool
+}
+
+func (p *initProtocol) NewClient(opts interface{}) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker unavailable")
+	}
+	p.created = true
+	return nil
+}
+
+func (p *initProtocol) GetInstance() interface{} {
+	if !p.created {
+		return (*initProtocol)(nil)
+	}
+	return p
+}
+
+func TestAutoInitBackoff(t *testing.T) {
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	// 前 failures 次注册失败
+	var attempts int32
+	failures := int32(100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"data":{"device_id":1,"device_secret":"secret"}}`)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":0,"data":{"access_token":"817aecf06c023365","access_addr":"127.0.0.1:1883"}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	newDevice := func() *Device {
+		return New(ProductKey, DeviceName, Version, Protocol(&initProtocol{fakeProtocol: newFakeProtocol()}), Storage(newMemStorage()),
+			Topics(topics.Topics{Register: srv.URL + "/register", Login: srv.URL + "/login"}))
+	}
+	opts := InitOptions{
+		AutoRelogin:        true,
+		ReregisterInterval: time.Hour,
+		ReloginInterval:    100 * time.Millisecond,
+		BackoffFactor:      2,
+		MaxInterval:        300 * time.Millisecond,
+		MaxAttempts:        5,
+	}
+
+	// 达到 MaxAttempts 后返回最后一次的错误，间隔按 BackoffFactor 增长且不超过 MaxInterval
+	err := newDevice().AutoInit(opts)
+	if err == nil || !strings.Contains(err.Error(), "after 5 attempts") {
+		t.Fatalf("got %v, want error after 5 attempts", err)
+	}
+	if attempts != 5 || len(slept) != 4 {
+		t.Fatalf("attempts %d, slept %v", attempts, slept)
+	}
+	base := []time.Duration{100, 200, 300, 300}
+	for i, d := range slept {
+		want := base[i] * time.Millisecond
+		low := time.Duration(float64(want) * (1 - BackoffJitter))
+		if d < low || d > want*6/5 || d > opts.MaxInterval {
+			t.Fatalf("interval %d = %v, want about %v", i, d, want)
+		}
+	}
+
+	// 重试成功后继续初始化
+	atomic.StoreInt32(&attempts, 0)
+	atomic.StoreInt32(&failures, 2)
+	slept = nil
+	if err := newDevice().AutoInit(opts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(slept) != 2 {
+		t.Fatalf("attempts %d, slept %v", attempts, slept)
+	}
+
+	// 创建协议客户端失败时按 ReInitProtocolClientInterval 重试
+	slept = nil
+	d := newDevice()
+	d.Protocol = &initProtocol{fakeProtocol: newFakeProtocol(), failures: 2}
+	opts.AutoReInitProtocolClient = true
+	opts.ReInitProtocolClientInterval = 10 * time.Millisecond
+	if err := d.AutoInit(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) != 2 || slept[0] > 12*time.Millisecond || slept[1] < 16*time.Millisecond {
+		t.Fatalf("slept %v", slept)
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	jitter := BackoffJitter
+	BackoffJitter = 0
+	defer func() { BackoffJitter = jitter }()
+	o := InitOptions{BackoffFactor: 3, MaxInterval: time.Minute}
+	for attempt, want := range []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 27 * time.Second, time.Minute} {
+		if got := o.retryInterval(time.Second, attempt); got != want {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, got, want)
+		}
+	}
+	// BackoffFactor 未设置时保持固定间隔
+	if got := (InitOptions{}).retryInterval(time.Second, 10); got != time.Second {
+		t.Fatalf("got %v, want fixed interval", got)
+	}
+	if got := (InitOptions{BackoffFactor: 10}).retryInterval(time.Hour, 100); got <= 0 {
+		t.Fatalf("got %v, want no overflow", got)
+	}
+}
@@ -0,0 +1,96 @@
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/serializer"
+	"runtime"
+	"time"
+)
+
+// startedAt 进程启动时间，用于计算运行时长
+var startedAt = time.Now()
+
+// diagnosticRequest 诊断请求，请求未携带 MQTT 5 Correlation Data 时 request_id 原样写入回复，用于关联请求与回复
+type diagnosticRequest struct {
+	RequestID string `json:"request_id"`
+}
+
+// OnDiagnosticRequest 响应诊断请求，收到 Topics.DiagnosticRequest 上的请求后，
+// 将 SDK 提供的默认诊断信息与 callback 返回的字段合并，通过 Topics.DiagnosticReply 回复，
+// 同名字段以 callback 返回的为准，callback 可以为 nil
+func (d *Device) OnDiagnosticRequest(callback func() map[string]interface{}) error {
+	callbackFn := func(resp request.Response) {
+		if err := d.replyDiagnostic(resp, callback); err != nil {
+			d.logf(log.LevelWarn, "reply diagnostic request failed: %v", err)
+		}
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.DiagnosticRequest
+	r.Qos = 1
+	r.Callback = d.bufferCallback(callbackFn)
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
+
+// replyDiagnostic 收集诊断信息并回复
+func (d *Device) replyDiagnostic(resp request.Response, callback func() map[string]interface{}) error {
+	diagnostics := d.Diagnostics()
+	if callback != nil {
+		for k, v := range callback() {
+			diagnostics[k] = v
+		}
+	}
+	// 请求不是 JSON 时仍然回复，只是不携带 request_id
+	req := diagnosticRequest{}
+	if err := json.Unmarshal(resp.Payload(), &req); err == nil && req.RequestID != "" && !correlated(resp) {
+		diagnostics["request_id"] = req.RequestID
+	}
+	data, err := d.marshalReply(&serializer.Reply{
+		Code: serializer.ReplyCodeOK,
+		Data: diagnostics,
+	})
+	if err != nil {
+		return err
+	}
+	r := replyRequest(resp, d.Topics.DiagnosticReply, data)
+	return d.publishControl(PriorityNormal, protocol.OptionsFormatter(*r))
+}
+
+// Diagnostics SDK 提供的默认诊断信息，包括运行时内存、协程数与 Stats 中的连接统计
+func (d *Device) Diagnostics() map[string]interface{} {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+	stats := d.Stats()
+	diagnostics := map[string]interface{}{
+		"go_version":             runtime.Version(),
+		"uptime_seconds":         int64(time.Since(startedAt).Seconds()),
+		"sdk_goroutines":         d.GoroutineCount(),
+		"goroutines":             runtime.NumGoroutine(),
+		"mem_alloc_bytes":        mem.Alloc,
+		"mem_sys_bytes":          mem.Sys,
+		"gc_count":               mem.NumGC,
+		"connected":              !typeconv.IsNil(d.Protocol.GetInstance()),
+		"messages_sent":          stats.MessagesSent,
+		"messages_received":      stats.MessagesReceived,
+		"publish_errors":         stats.PublishErrors,
+		"disconnects":            stats.Disconnects,
+		"dropped_messages":       stats.DroppedMessages,
+		"last_disconnect_reason": stats.LastDisconnectReason.String(),
+		"last_error":             "",
+	}
+	if stats.LastError != nil {
+		diagnostics["last_error"] = stats.LastError.Error()
+		diagnostics["last_error_at"] = stats.LastErrorAt.Unix() * 1000
+	}
+	if d.Breaker != nil {
+		diagnostics["circuit_state"] = d.Breaker.State().String()
+	}
+	return diagnostics
+}
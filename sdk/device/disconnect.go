@@ -0,0 +1,40 @@
+package device
+
+import (
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+
+	"github.com/pkg/errors"
+)
+
+// Disconnect 断开连接并停止自动重连与重连时的重新登录。断开前发送批量上报缓冲中的属性，
+// 作为网关时上报所有在线的子设备下线。保留订阅回调、定时任务等状态，
+// 之后 Publish 等返回 protocol.ErrNotConnected，AutoInit 会重新登录并创建客户端；不再使用设备时调用 Close。
+// 重复调用或尚未连接时不做任何处理。协议未实现 protocol.Disconnector 时返回错误
+func (d *Device) Disconnect() error {
+	if typeconv.IsNil(d.Protocol.GetInstance()) {
+		return nil
+	}
+	c, ok := d.Protocol.(protocol.Disconnector)
+	if !ok {
+		return errors.Errorf("disconnect failed, protocol %s does not support disconnect", d.Protocol.GetName())
+	}
+	if err := d.FlushTelemetry(); err != nil {
+		d.logf(log.LevelError, "%v", err)
+	}
+	d.reportSubDevicesOffline()
+	return errors.Wrap(c.Disconnect(), "disconnect failed")
+}
+
+// IsConnected 是否已连接。协议实现了 IsConnected 时以其为准，否则以 MQTT 客户端的连接状态为准，
+// 都没有时以协议客户端是否已创建为准
+func (d *Device) IsConnected() bool {
+	if c, ok := d.Protocol.(interface{ IsConnected() bool }); ok {
+		return c.IsConnected()
+	}
+	if c, ok := d.MQTTClient(); ok {
+		return c.IsConnected()
+	}
+	return !typeconv.IsNil(d.Protocol.GetInstance())
+}
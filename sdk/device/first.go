@@ -0,0 +1,55 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/request"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFirstMessageTimeout ctx 未设置超时时等待第一条消息的超时时间
+const DefaultFirstMessageTimeout = 10 * time.Second
+
+// ErrAlreadySubscribed 主题已经订阅，SubscribeFirst 不能覆盖已有订阅的回调
+var ErrAlreadySubscribed = errors.New("topic already subscribed")
+
+// SubscribeFirst 订阅 topic 并等待第一条消息，收到后或超时后取消订阅。
+// 适用于订阅后预期很快收到一条消息的场景，如调试时订阅影子获取结果、开通后等待初始配置。
+// ctx 未设置超时时使用 DefaultFirstMessageTimeout，超时返回的错误可以通过 errors.Cause 得到 context.DeadlineExceeded。
+// topic 已经订阅时返回 ErrAlreadySubscribed
+func (d *Device) SubscribeFirst(ctx context.Context, topic string, qos byte) (request.Response, error) {
+	if d.handlers.has(topic) {
+		return nil, errors.Wrapf(ErrAlreadySubscribed, "subscribe first %s failed", topic)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultFirstMessageTimeout)
+		defer cancel()
+	}
+	received := make(chan request.Response, 1)
+	if err := d.Subscribe(request.Request{
+		Topic: topic,
+		Qos:   qos,
+		Callback: func(resp request.Response) {
+			select {
+			case received <- resp:
+			default:
+			}
+		},
+	}); err != nil {
+		return nil, errors.Wrapf(err, "subscribe first %s failed", topic)
+	}
+	defer func() {
+		if err := d.Unsubscribe([]string{topic}); err != nil {
+			d.logf(log.LevelWarn, "unsubscribe %s failed: %v", topic, err)
+		}
+	}()
+	select {
+	case resp := <-received:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "no message received on %s", topic)
+	}
+}
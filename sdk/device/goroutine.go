@@ -0,0 +1,85 @@
+package device
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// goroutines SDK 创建的协程，Close 时通知常驻协程退出并等待所有协程结束，
+// 为 nil 时（未通过 New 创建设备）不跟踪
+type goroutines struct {
+	// running 放在首位，保证 32 位平台上原子操作的对齐
+	running int64
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	done    chan struct{}
+	// waiting 正在等待协程退出，此时启动的协程不加入 wg，避免与 Wait 并发调用 Add
+	waiting bool
+}
+
+// newGoroutines 创建 goroutines 对象
+func newGoroutines() *goroutines {
+	return &goroutines{done: make(chan struct{})}
+}
+
+// spawn 启动协程并跟踪
+func (g *goroutines) spawn(fn func()) {
+	if g == nil {
+		go fn()
+		return
+	}
+	g.mu.Lock()
+	tracked := !g.waiting
+	if tracked {
+		g.wg.Add(1)
+	}
+	g.mu.Unlock()
+	atomic.AddInt64(&g.running, 1)
+	go func() {
+		if tracked {
+			defer g.wg.Done()
+		}
+		defer atomic.AddInt64(&g.running, -1)
+		fn()
+	}()
+}
+
+// closing 设备关闭时关闭的 channel，常驻协程收到后退出。为 nil 时返回永远不会关闭的 nil channel
+func (g *goroutines) closing() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.done
+}
+
+// closeAndWait 通知常驻协程退出并等待所有协程结束，之后可以重新启动协程
+func (g *goroutines) closeAndWait() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	if g.waiting {
+		// 并发调用 Close 时只关闭一次 done
+		g.mu.Unlock()
+		g.wg.Wait()
+		return
+	}
+	g.waiting = true
+	close(g.done)
+	g.mu.Unlock()
+	g.wg.Wait()
+	g.mu.Lock()
+	g.waiting = false
+	g.done = make(chan struct{})
+	g.mu.Unlock()
+}
+
+// GoroutineCount SDK 为该设备创建、仍在运行的协程数，不包括底层 MQTT 客户端的协程
+func (d *Device) GoroutineCount() int {
+	if d.goroutines == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&d.goroutines.running))
+}
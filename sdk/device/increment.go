@@ -0,0 +1,68 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+// increments 增量上报的序号与本地累计值，key 为属性 ID
+type increments struct {
+	mu       sync.Mutex
+	sequence map[uint16]uint64
+	totals   map[uint16]float64
+}
+
+// newIncrements 创建 increments 对象
+func newIncrements() *increments {
+	return &increments{sequence: map[uint16]uint64{}, totals: map[uint16]float64{}}
+}
+
+// next 分配属性的下一个增量序号，首次分配时以当前纳秒时间为起点，
+// 设备重启后序号仍然大于重启前的序号，不需要持久化
+func (i *increments) next(propertyID uint16) uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	seq, ok := i.sequence[propertyID]
+	if !ok {
+		seq = uint64(time.Now().UnixNano())
+	}
+	seq++
+	i.sequence[propertyID] = seq
+	return seq
+}
+
+// add 累加上报成功的增量
+func (i *increments) add(propertyID uint16, delta float64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.totals[propertyID] += delta
+}
+
+// total 属性的本地累计值
+func (i *increments) total(propertyID uint16) float64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.totals[propertyID]
+}
+
+// PostPropertyIncrement 以增量方式上报属性，平台将 delta 累加到属性的当前值而不是替换，适用于电能脉冲等计数器。
+// 每次上报带递增的增量序号，平台据此丢弃重复投递的同一条增量；上报成功（开启批量上报时为放入缓冲）后
+// delta 计入 IncrementTotal 返回的本地累计值
+func (d *Device) PostPropertyIncrement(propertyID uint16, delta float64) error {
+	property := Property{
+		PropertyID: propertyID,
+		Value:      []interface{}{delta},
+		Increment:  true,
+		Sequence:   d.increments.next(propertyID),
+	}
+	if err := d.PostProperty(property); err != nil {
+		return err
+	}
+	d.increments.add(propertyID, delta)
+	return nil
+}
+
+// IncrementTotal 本次运行期间通过 PostPropertyIncrement 成功上报的增量之和，用于与平台上的累计值对账
+func (d *Device) IncrementTotal(propertyID uint16) float64 {
+	return d.increments.total(propertyID)
+}
@@ -0,0 +1,176 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReplyTTL 默认的命令回复有效期，从收到命令开始计算
+const DefaultReplyTTL = 5 * time.Minute
+
+// ReplyAckTimeout 等待命令回复确认（PUBACK）的超时时间，超时未确认的回复等待重连后重发
+var ReplyAckTimeout = 10 * time.Second
+
+// inflight 处理中的命令与等待重连后发送的回复，以每次投递的序号为 key，为 nil 时（未通过 New 创建设备）不跟踪
+type inflight struct {
+	seq     uint64
+	mu      sync.Mutex
+	running map[string]time.Time
+	pending []pendingReply
+}
+
+// pendingReply 发送失败、等待重连后重发的回复
+type pendingReply struct {
+	id          string
+	topic       string
+	payload     []byte
+	correlation []byte
+	deadline    time.Time
+}
+
+// WithReplyTTL 设置命令回复的有效期，连接断开期间发送失败的回复在重连后重发，超过有效期后丢弃
+func WithReplyTTL(ttl time.Duration) Option {
+	return func(d *Device) {
+		d.ReplyTTL = ttl
+	}
+}
+
+// newInflight 创建 inflight 对象
+func newInflight() *inflight {
+	return &inflight{running: make(map[string]time.Time)}
+}
+
+// deliveryID 为收到的每条命令生成唯一的投递序号，内容相同的命令同时处理时互不影响
+func (f *inflight) deliveryID() string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatUint(atomic.AddUint64(&f.seq, 1), 10)
+}
+
+// begin 记录开始处理的命令，返回收到命令的时间
+func (f *inflight) begin(id string) time.Time {
+	now := time.Now()
+	if f == nil {
+		return now
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running[id] = now
+	return now
+}
+
+// end 命令处理结束
+func (f *inflight) end(id string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.running, id)
+}
+
+// postpone 回复发送失败，等待重连后重发，同一命令只保留最新的回复
+func (f *inflight) postpone(reply pendingReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, p := range f.pending {
+		if p.id == reply.id {
+			f.pending[i] = reply
+			return
+		}
+	}
+	f.pending = append(f.pending, reply)
+}
+
+// takePending 取出未过期的待发送回复，过期的直接丢弃
+func (f *inflight) takePending() []pendingReply {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	ret := make([]pendingReply, 0, len(f.pending))
+	for _, p := range f.pending {
+		if now.Before(p.deadline) {
+			ret = append(ret, p)
+		}
+	}
+	f.pending = nil
+	return ret
+}
+
+// InflightCommands 正在处理的命令数
+func (d *Device) InflightCommands() int {
+	if d.inflight == nil {
+		return 0
+	}
+	d.inflight.mu.Lock()
+	defer d.inflight.mu.Unlock()
+	return len(d.inflight.running)
+}
+
+// PendingReplies 等待重连后发送的命令回复数
+func (d *Device) PendingReplies() int {
+	if d.inflight == nil {
+		return 0
+	}
+	d.inflight.mu.Lock()
+	defer d.inflight.mu.Unlock()
+	return len(d.inflight.pending)
+}
+
+// sendReply 发送命令回复到 Topics.CommandResponse，发送失败且未超过有效期时等待重连后重发
+func (d *Device) sendReply(id string, receivedAt time.Time, payload []byte) error {
+	r := &request.Request{}
+	r.Topic = d.Topics.CommandResponse
+	r.Qos = 1
+	r.Payload = payload
+	return d.sendReplyTo(id, receivedAt, r)
+}
+
+// sendReplyTo 发送回复 r 并等待确认，发送失败、ReplyAckTimeout 内未确认且未超过有效期时等待重连后重发
+func (d *Device) sendReplyTo(id string, receivedAt time.Time, r *request.Request) error {
+	payload, _ := r.Payload.([]byte)
+	err := d.publishControl(PriorityNormal, replyOpts(r))
+	if err == nil {
+		return nil
+	}
+	deadline := receivedAt.Add(d.ReplyTTL)
+	if d.inflight != nil && d.ReplyTTL > 0 && time.Now().Before(deadline) {
+		d.inflight.postpone(pendingReply{
+			id:          id,
+			topic:       r.Topic,
+			payload:     payload,
+			correlation: r.CorrelationData,
+			deadline:    deadline,
+		})
+	}
+	return err
+}
+
+// flushReplies 重连后重发待发送的回复，再次失败的继续等待下次重连
+func (d *Device) flushReplies() {
+	if d.inflight == nil {
+		return
+	}
+	for _, p := range d.inflight.takePending() {
+		r := &request.Request{}
+		r.Topic = p.topic
+		r.Qos = 1
+		r.Payload = p.payload
+		r.CorrelationData = p.correlation
+		if err := d.publishControl(PriorityNormal, replyOpts(r)); err != nil {
+			d.inflight.postpone(p)
+		}
+	}
+}
+
+// replyOpts 回复的发布参数，等待服务端确认最多 ReplyAckTimeout
+func replyOpts(r *request.Request) map[string]interface{} {
+	opts := protocol.OptionsFormatter(*r)
+	opts["WaitTimeout"] = ReplyAckTimeout
+	return opts
+}
@@ -0,0 +1,75 @@
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// SDKVersion SDK 版本
+const SDKVersion = "0.1.0"
+
+// WithReportDeviceInfo 设置每次连接成功后是否向 Topics.DeviceInfo 上报设备信息
+func WithReportDeviceInfo(enable bool) Option {
+	return func(d *Device) {
+		d.ReportDeviceInfo = enable
+	}
+}
+
+// WithDeviceInfoFields 设置上报设备信息时附加的字段，同名字段以 fields 返回的为准
+func WithDeviceInfoFields(fields func() map[string]interface{}) Option {
+	return func(d *Device) {
+		d.DeviceInfoFields = fields
+	}
+}
+
+// DeviceInfo 上报的设备信息，包括固件版本、SDK 版本、Go 版本、操作系统与架构，以及 DeviceInfoFields 返回的字段。
+// 开启 ReportMaintenance 时带 maintenance 字段，表示设备是否已暂停
+func (d *Device) DeviceInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"version":     d.Version,
+		"sdk_version": SDKVersion,
+		"go_version":  runtime.Version(),
+		"os":          runtime.GOOS,
+		"arch":        runtime.GOARCH,
+	}
+	if d.ReportMaintenance {
+		info["maintenance"] = d.Paused()
+	}
+	if d.DeviceInfoFields != nil {
+		for k, v := range d.DeviceInfoFields() {
+			info[k] = v
+		}
+	}
+	return info
+}
+
+// publishDeviceInfo 以 JSON 格式发布设备信息
+func (d *Device) publishDeviceInfo() error {
+	data, err := json.Marshal(d.DeviceInfo())
+	if err != nil {
+		return errors.Wrap(err, "marshal device info failed")
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.DeviceInfo
+	r.Qos = 1
+	r.Payload = data
+	if err := d.publishControl(PriorityNormal, protocol.OptionsFormatter(*r)); err != nil {
+		return errors.Wrap(err, "publish device info failed")
+	}
+	return nil
+}
+
+// reportDeviceInfo 连接成功后上报设备信息，未开启时不上报
+func (d *Device) reportDeviceInfo() {
+	if !d.ReportDeviceInfo {
+		return
+	}
+	if err := d.publishDeviceInfo(); err != nil {
+		d.logf(log.LevelError, "%v", err)
+	}
+}
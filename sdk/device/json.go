@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/sdk/request"
+
+	"github.com/pkg/errors"
+)
+
+// OnJSON 以 QoS 1 订阅 topic，将消息内容按 JSON 解析为 T 后调用 handler。
+// 不经过 Serializer 与 PayloadCodecs，消息内容需为 JSON。T 为指针类型时解析到新分配的对象，避免复制较大的结构体。
+// 消息超长、解析失败或 handler 返回错误时调用 OnCommandError 设置的回调
+func OnJSON[T any](d *Device, topic string, handler func(T) error) error {
+	return d.Subscribe(request.Request{
+		Topic: topic,
+		Qos:   1,
+		Callback: func(resp request.Response) {
+			if err := d.checkPayloadSize(resp); err != nil {
+				d.commandError(resp.Topic(), err)
+				return
+			}
+			var v T
+			if err := json.Unmarshal(resp.Payload(), &v); err != nil {
+				d.commandError(resp.Topic(), errors.Wrapf(err, "unmarshal json to %T failed", v))
+				return
+			}
+			if err := handler(v); err != nil {
+				d.commandError(resp.Topic(), err)
+			}
+		},
+	})
+}
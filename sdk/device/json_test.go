@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package device
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type jsonConfig struct {
+	Interval int    `json:"interval"`
+	Mode     string `json:"mode"`
+}
+
+func TestOnJSON(t *testing.T) {
+	p := newFakeProtocol()
+	d := New(ProductKey, DeviceName, Version, Protocol(p), Storage(newMemStorage()))
+	var errs []error
+	d.OnCommandError(func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	var configs []*jsonConfig
+	if err := OnJSON(d, "config", func(c *jsonConfig) error {
+		if c.Interval <= 0 {
+			return errors.New("invalid interval")
+		}
+		configs = append(configs, c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver("config", []byte(`{"interval":30,"mode":"eco"}`))
+	if len(configs) != 1 || configs[0].Interval != 30 || configs[0].Mode != "eco" || len(errs) != 0 {
+		t.Fatalf("got configs %+v, errors %v", configs, errs)
+	}
+	// 解析失败与处理失败都调用错误回调
+	p.deliver("config", []byte("not json"))
+	p.deliver("config", []byte(`{"interval":0}`))
+	if len(configs) != 1 || len(errs) != 2 {
+		t.Fatalf("got %d configs, %d errors, want 1, 2", len(configs), len(errs))
+	}
+
+	var values []jsonConfig
+	if err := OnJSON(d, "value", func(c jsonConfig) error {
+		values = append(values, c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.deliver("value", []byte(`{"mode":"auto"}`))
+	if len(values) != 1 || values[0].Mode != "auto" {
+		t.Fatalf("got values %+v", values)
+	}
+}
@@ -0,0 +1,107 @@
+package device
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultKeepAlive 未开启自适应心跳时的 MQTT 心跳间隔
+const DefaultKeepAlive = 30 * time.Second
+
+// AdaptiveKeepaliveStableCount 自适应心跳连续发布成功多少次后延长心跳间隔
+var AdaptiveKeepaliveStableCount = 10
+
+// WithAdaptiveKeepalive 开启自适应心跳，心跳间隔在 [min, max] 之间调整：发布失败时减半，尽快发现半开连接；
+// 连续发布成功 AdaptiveKeepaliveStableCount 次后加倍，减少稳定链路上的心跳流量。
+// 连接时以 max 作为 MQTT KeepAlive，之后只调整客户端发送 PINGREQ 的空闲间隔，不需要重新连接。
+// 心跳按秒计时，min 小于 1 秒或大于 max 时使用 max，即不调整
+func WithAdaptiveKeepalive(min, max time.Duration) Option {
+	return func(d *Device) {
+		d.KeepaliveMin = min
+		d.KeepaliveMax = max
+	}
+}
+
+// adaptiveKeepalive 自适应心跳的当前间隔
+type adaptiveKeepalive struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	successes int
+}
+
+// update 根据发布结果调整心跳间隔，changed 为 false 表示间隔不变
+func (a *adaptiveKeepalive) update(err error, min, max time.Duration) (interval time.Duration, changed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old := a.interval
+	if old == 0 {
+		old = max
+	}
+	a.interval = old
+	if err != nil {
+		a.successes = 0
+		if a.interval /= 2; a.interval < min {
+			a.interval = min
+		}
+	} else if a.successes++; a.successes >= AdaptiveKeepaliveStableCount {
+		a.successes = 0
+		if a.interval *= 2; a.interval > max {
+			a.interval = max
+		}
+	}
+	return a.interval, a.interval != old
+}
+
+// get 当前心跳间隔，未调整过时为 max
+func (a *adaptiveKeepalive) get(max time.Duration) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.interval == 0 {
+		return max
+	}
+	return a.interval
+}
+
+// keepaliveBounds 自适应心跳的上下限，未开启时 ok 为 false
+func (d *Device) keepaliveBounds() (min, max time.Duration, ok bool) {
+	if d.KeepaliveMax < time.Second {
+		return 0, 0, false
+	}
+	min = d.KeepaliveMin
+	if min < time.Second || min > d.KeepaliveMax {
+		min = d.KeepaliveMax
+	}
+	return min, d.KeepaliveMax, true
+}
+
+// keepAlive 连接时使用的 MQTT KeepAlive，开启自适应心跳时为上限
+func (d *Device) keepAlive() time.Duration {
+	if _, max, ok := d.keepaliveBounds(); ok {
+		return max
+	}
+	return DefaultKeepAlive
+}
+
+// KeepaliveInterval 当前的心跳间隔，未开启自适应心跳时为连接时的 KeepAlive
+func (d *Device) KeepaliveInterval() time.Duration {
+	_, max, ok := d.keepaliveBounds()
+	if !ok {
+		return DefaultKeepAlive
+	}
+	return d.keepalive.get(max)
+}
+
+// adaptKeepalive 根据发布结果调整心跳间隔，并应用到 MQTT 客户端
+func (d *Device) adaptKeepalive(err error) {
+	min, max, ok := d.keepaliveBounds()
+	if !ok {
+		return
+	}
+	interval, changed := d.keepalive.update(err, min, max)
+	if !changed {
+		return
+	}
+	if c, ok := d.MQTTClient(); ok {
+		c.SetPingInterval(interval)
+	}
+}
@@ -0,0 +1,49 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/request"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxReceiveSize 默认的接收消息最大长度，单位字节
+const DefaultMaxReceiveSize = 256 << 10
+
+// ErrPayloadTooLarge 接收的消息超过 MaxReceiveSize
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// payloadDiscarder 底层客户端因超长丢弃了消息内容，MQTT 客户端的消息实现了该接口
+type payloadDiscarder interface {
+	PayloadDiscarded() int
+}
+
+// WithMaxReceiveSize 设置接收消息的最大长度，超过时 MQTT 客户端直接丢弃消息内容而不分配完整的缓冲区，
+// 命令不会被解析，并以 ErrPayloadTooLarge 调用 OnCommandError 设置的回调。n 为 0 时不限制
+func WithMaxReceiveSize(n int) Option {
+	return func(d *Device) {
+		d.MaxReceiveSize = n
+	}
+}
+
+// OnCommandError 设置命令处理失败的回调，如消息超长、解析失败
+func (d *Device) OnCommandError(callback func(topic string, err error)) {
+	d.onCommandError = callback
+}
+
+// commandError 调用命令处理失败的回调
+func (d *Device) commandError(topic string, err error) {
+	if d.onCommandError != nil {
+		d.onCommandError(topic, err)
+	}
+}
+
+// checkPayloadSize 检查消息是否超长，非 MQTT 协议的消息按实际长度检查
+func (d *Device) checkPayloadSize(resp request.Response) error {
+	size := len(resp.Payload())
+	if r, ok := resp.(payloadDiscarder); ok && r.PayloadDiscarded() > 0 {
+		size = r.PayloadDiscarded()
+	} else if d.MaxReceiveSize <= 0 || size <= d.MaxReceiveSize {
+		return nil
+	}
+	return errors.Wrapf(ErrPayloadTooLarge, "%d bytes exceeds %d", size, d.MaxReceiveSize)
+}
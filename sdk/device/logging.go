@@ -0,0 +1,143 @@
+package device
+
+import (
+	"fmt"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/sdk/log"
+	"sync"
+	"time"
+)
+
+// DefaultLogDedupWindow 默认的日志去重窗口
+const DefaultLogDedupWindow = time.Minute
+
+// WithLogDedupWindow 设置日志去重窗口，同一级别的日志在窗口内与上一条完全相同时不输出，
+// 窗口结束或出现不同的日志时输出一条 "last message repeated N times"。window 不大于 0 时不去重
+func WithLogDedupWindow(window time.Duration) Option {
+	return func(d *Device) {
+		d.LogDedupWindow = window
+	}
+}
+
+// Logger 设置日志输出，默认输出到 pkg/mqtt 的 DEBUG、WARN、ERROR（INFO 输出到 DEBUG），
+// 与 MQTT 客户端的日志一起通过替换 mqtt.DEBUG 等开启。不需要日志时可以设置为 log.Nop
+func Logger(l log.Logger) Option {
+	return func(d *Device) {
+		d.Logger = l
+	}
+}
+
+// mqttLogger 默认的 Logger，输出到 pkg/mqtt 的日志
+type mqttLogger struct{}
+
+func (mqttLogger) Debugf(format string, v ...interface{}) {
+	mqtt.DEBUG.Println(mqtt.CLI, fmt.Sprintf(format, v...))
+}
+
+func (mqttLogger) Infof(format string, v ...interface{}) {
+	mqtt.DEBUG.Println(mqtt.CLI, fmt.Sprintf(format, v...))
+}
+
+func (mqttLogger) Warnf(format string, v ...interface{}) {
+	mqtt.WARN.Println(mqtt.CLI, fmt.Sprintf(format, v...))
+}
+
+func (mqttLogger) Errorf(format string, v ...interface{}) {
+	mqtt.ERROR.Println(mqtt.CLI, fmt.Sprintf(format, v...))
+}
+
+// logEntry 某个级别最近输出的一条日志
+type logEntry struct {
+	msg      string
+	at       time.Time
+	repeated int
+}
+
+// logDedup 按日志级别合并重复的日志，为 nil 时（未通过 New 创建设备）不去重
+type logDedup struct {
+	mu         sync.Mutex
+	last       map[log.Level]*logEntry
+	suppressed uint64
+}
+
+// newLogDedup 创建 logDedup 对象
+func newLogDedup() *logDedup {
+	return &logDedup{last: map[log.Level]*logEntry{}}
+}
+
+// check 记录即将输出的日志 msg，返回是否需要输出，repeated 为此前被合并、需要先输出汇总的条数
+func (l *logDedup) check(level log.Level, msg string, window time.Duration) (print bool, repeated int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	e := l.last[level]
+	if e != nil && e.msg == msg && now.Sub(e.at) < window {
+		e.repeated++
+		l.suppressed++
+		return false, 0
+	}
+	if e != nil {
+		repeated = e.repeated
+	}
+	l.last[level] = &logEntry{msg: msg, at: now}
+	return true, repeated
+}
+
+// flush 取出所有级别中被合并、尚未输出汇总的条数
+func (l *logDedup) flush() map[log.Level]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := map[log.Level]int{}
+	for level, e := range l.last {
+		if e.repeated > 0 {
+			ret[level] = e.repeated
+		}
+	}
+	l.last = map[log.Level]*logEntry{}
+	return ret
+}
+
+// logger 日志输出，未设置时使用默认的 Logger
+func (d *Device) logger() log.Logger {
+	if d.Logger == nil {
+		return mqttLogger{}
+	}
+	return d.Logger
+}
+
+// logf 按级别输出日志，开启日志去重时合并窗口内重复的日志
+func (d *Device) logf(level log.Level, format string, v ...interface{}) {
+	logger := d.logger()
+	if d.logs == nil || d.LogDedupWindow <= 0 {
+		log.Logf(logger, level, format, v...)
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	print, repeated := d.logs.check(level, msg, d.LogDedupWindow)
+	if repeated > 0 {
+		log.Logf(logger, level, "last message repeated %d times", repeated)
+	}
+	if print {
+		log.Logf(logger, level, "%s", msg)
+	}
+}
+
+// flushLogs 输出被合并、尚未输出汇总的日志条数，关闭设备时调用
+func (d *Device) flushLogs() {
+	if d.logs == nil {
+		return
+	}
+	for level, repeated := range d.logs.flush() {
+		log.Logf(d.logger(), level, "last message repeated %d times", repeated)
+	}
+}
+
+// LogsSuppressed 因日志去重而未输出的日志条数
+func (d *Device) LogsSuppressed() uint64 {
+	if d.logs == nil {
+		return 0
+	}
+	d.logs.mu.Lock()
+	defer d.logs.mu.Unlock()
+	return d.logs.suppressed
+}
@@ -0,0 +1,120 @@
+package device
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownProperty 属性 ID 或名称不在子设备所属的属性命名空间中
+var ErrUnknownProperty = errors.New("unknown property")
+
+// PropertyNamespace 一类子设备的属性 ID 空间。网关下不同类型的子设备可以使用相同的属性 ID 表示不同的属性，
+// 按子设备类型分别注册属性名称与单位，子设备通过 BindSubDevice 绑定到类型后按类型解析属性 ID
+type PropertyNamespace struct {
+	// Names 属性名称，key 为属性 ID
+	Names map[uint16]string
+	// Units 属性单位，key 为属性 ID，为空时使用 Device.Units
+	Units map[uint16]string
+}
+
+// id 按名称查找属性 ID
+func (n PropertyNamespace) id(name string) (uint16, bool) {
+	for id, v := range n.Names {
+		if v == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// WithPropertyNamespace 注册名为 name 的属性命名空间，通常以子设备类型（如产品 Key）命名，重复注册时覆盖
+func WithPropertyNamespace(name string, namespace PropertyNamespace) Option {
+	return func(d *Device) {
+		if d.PropertyNamespaces == nil {
+			d.PropertyNamespaces = make(map[string]PropertyNamespace)
+		}
+		d.PropertyNamespaces[name] = namespace
+	}
+}
+
+// namespaceBindings 子设备与属性命名空间的绑定，为 nil 时（未通过 New 创建设备）所有子设备都不属于任何命名空间
+type namespaceBindings struct {
+	mu       sync.RWMutex
+	bindings map[uint16]string
+}
+
+// newNamespaceBindings 创建 namespaceBindings 对象
+func newNamespaceBindings() *namespaceBindings {
+	return &namespaceBindings{bindings: make(map[uint16]string)}
+}
+
+// BindSubDevice 将子设备绑定到属性命名空间，之后该子设备的属性 ID 按命名空间解析，namespace 为空时解除绑定。
+// 子设备通常在接入网关、确定类型后绑定
+func (d *Device) BindSubDevice(subDeviceID uint16, namespace string) {
+	if d.bindings == nil {
+		return
+	}
+	d.bindings.mu.Lock()
+	defer d.bindings.mu.Unlock()
+	if namespace == "" {
+		delete(d.bindings.bindings, subDeviceID)
+		return
+	}
+	d.bindings.bindings[subDeviceID] = namespace
+}
+
+// SubDeviceNamespace 子设备绑定的属性命名空间，未绑定时返回空字符串
+func (d *Device) SubDeviceNamespace(subDeviceID uint16) string {
+	if d.bindings == nil {
+		return ""
+	}
+	d.bindings.mu.RLock()
+	defer d.bindings.mu.RUnlock()
+	return d.bindings.bindings[subDeviceID]
+}
+
+// namespaceOf 子设备绑定的属性命名空间，未绑定或命名空间未注册时返回 false
+func (d *Device) namespaceOf(subDeviceID uint16) (PropertyNamespace, bool) {
+	name := d.SubDeviceNamespace(subDeviceID)
+	if name == "" {
+		return PropertyNamespace{}, false
+	}
+	namespace, ok := d.PropertyNamespaces[name]
+	return namespace, ok
+}
+
+// PropertyName 按子设备所属的命名空间查找属性名称，子设备未绑定命名空间或属性未注册时返回 false
+func (d *Device) PropertyName(subDeviceID, propertyID uint16) (string, bool) {
+	namespace, ok := d.namespaceOf(subDeviceID)
+	if !ok {
+		return "", false
+	}
+	name, ok := namespace.Names[propertyID]
+	return name, ok
+}
+
+// PostPropertyByName 按名称上报子设备的属性，属性 ID 在子设备所属的命名空间中解析，
+// 上报的属性带 SubDeviceID，平台据此区分不同子设备的相同属性 ID。
+// 子设备未绑定命名空间或名称不存在时返回 ErrUnknownProperty
+func (d *Device) PostPropertyByName(subDeviceID uint16, name string, value ...interface{}) error {
+	namespace, _ := d.namespaceOf(subDeviceID)
+	propertyID, ok := namespace.id(name)
+	if !ok {
+		return errors.Wrapf(ErrUnknownProperty, "post property failed, property %s of sub device %d", name, subDeviceID)
+	}
+	return d.PostProperty(Property{SubDeviceID: subDeviceID, PropertyID: propertyID, Value: value})
+}
+
+// checkNamespace 检查平台设置的属性是否属于子设备的命名空间，子设备未绑定命名空间或命名空间没有注册名称时不检查
+func (d *Device) checkNamespace(property Property) error {
+	namespace, ok := d.namespaceOf(property.SubDeviceID)
+	if !ok || len(namespace.Names) == 0 {
+		return nil
+	}
+	if _, ok := namespace.Names[property.PropertyID]; !ok {
+		return errors.Wrapf(ErrUnknownProperty, "property %d of sub device %d (%s)",
+			property.PropertyID, property.SubDeviceID, d.SubDeviceNamespace(property.SubDeviceID))
+	}
+	return nil
+}
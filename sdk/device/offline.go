@@ -0,0 +1,241 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/trace"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultOfflineQueueSize 默认的离线队列长度
+const DefaultOfflineQueueSize = 100
+
+// ErrOfflineQueueDisabled 离线队列长度为 0，无法缓存消息
+var ErrOfflineQueueDisabled = errors.New("offline queue disabled")
+
+// OfflineAckTimeout ctx 没有截止时间时，PostPropertyOrQueue 等待服务端确认的时间，超时未确认时放入离线队列
+var OfflineAckTimeout = 10 * time.Second
+
+// WithOfflineQueue 设置离线队列长度，队列满时按 OfflineDropPolicy 丢弃消息，为 0 时不缓存
+func WithOfflineQueue(size int) Option {
+	return func(d *Device) {
+		d.OfflineQueueSize = size
+	}
+}
+
+// DropPolicy 离线队列满时的丢弃策略
+type DropPolicy int
+
+const (
+	// DropOldest 丢弃最早的消息，保留最新的数据，默认值
+	DropOldest DropPolicy = iota
+	// DropNewest 丢弃新加入的消息，保留最早的数据
+	DropNewest
+)
+
+// WithOfflineDropPolicy 设置离线队列满时的丢弃策略，默认 DropOldest
+func WithOfflineDropPolicy(policy DropPolicy) Option {
+	return func(d *Device) {
+		d.OfflineDropPolicy = policy
+	}
+}
+
+// WithQueueWhenOffline 设置未连接时 PostProperty、PostEvent 是否将消息放入离线队列，重连后按顺序发送，
+// 开启后放入队列时返回 nil。默认不开启，未连接时返回发送失败的错误
+func WithQueueWhenOffline(enable bool) Option {
+	return func(d *Device) {
+		d.QueueWhenOffline = enable
+	}
+}
+
+// WithOfflineMaxAge 设置离线消息的最长保存时间，重连后超过该时间的消息不再发送，为 0 时不限制
+func WithOfflineMaxAge(maxAge time.Duration) Option {
+	return func(d *Device) {
+		d.OfflineMaxAge = maxAge
+	}
+}
+
+// offlineQueue 发送失败、等待重连后发送的消息，为 nil 时（未通过 New 创建设备）不缓存
+type offlineQueue struct {
+	mu       sync.Mutex
+	messages []offlineMessage
+	dropped  uint64
+	// flushing 保证同一时间只有一个协程发送队列中的消息，避免乱序
+	flushing sync.Mutex
+}
+
+// offlineMessage 离线队列中的消息
+type offlineMessage struct {
+	opts     map[string]interface{}
+	attrs    []trace.Attribute
+	priority Priority
+	queuedAt time.Time
+}
+
+// push 加入队列，超过 size 时按 policy 丢弃最早或最新的消息
+func (q *offlineQueue) push(m offlineMessage, size int, policy DropPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(q.messages, m)
+	q.trim(size, policy)
+}
+
+// trim 超过 size 时按 policy 丢弃最早或最新的消息，调用时需持有 mu
+func (q *offlineQueue) trim(size int, policy DropPolicy) {
+	over := len(q.messages) - size
+	if over <= 0 {
+		return
+	}
+	if policy == DropNewest {
+		q.messages = q.messages[:size]
+	} else {
+		q.messages = append(q.messages[:0], q.messages[over:]...)
+	}
+	q.dropped += uint64(over)
+}
+
+// takeAll 取出所有消息，超过 maxAge 的直接丢弃
+func (q *offlineQueue) takeAll(maxAge time.Duration) []offlineMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ret := make([]offlineMessage, 0, len(q.messages))
+	for _, m := range q.messages {
+		if maxAge > 0 && time.Since(m.queuedAt) > maxAge {
+			q.dropped++
+			continue
+		}
+		ret = append(ret, m)
+	}
+	q.messages = nil
+	return ret
+}
+
+// requeue 发送失败的消息放回队列头部，保持原有顺序，超过 size 时按 policy 丢弃最早或最新的消息
+func (q *offlineQueue) requeue(messages []offlineMessage, size int, policy DropPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = append(append([]offlineMessage{}, messages...), q.messages...)
+	q.trim(size, policy)
+}
+
+// OfflineQueued 离线队列中等待发送的消息数
+func (d *Device) OfflineQueued() int {
+	if d.offline == nil {
+		return 0
+	}
+	d.offline.mu.Lock()
+	defer d.offline.mu.Unlock()
+	return len(d.offline.messages)
+}
+
+// OfflineDropped 离线队列满或消息过期丢弃的消息数
+func (d *Device) OfflineDropped() uint64 {
+	if d.offline == nil {
+		return 0
+	}
+	d.offline.mu.Lock()
+	defer d.offline.mu.Unlock()
+	return d.offline.dropped
+}
+
+// PostPropertyOrQueue 上报属性，未连接、发送失败或 ctx 超时时放入离线队列，重连后按顺序发送。
+// 发送后等待服务端确认（QoS 1、2），最多等到 ctx 的截止时间，没有截止时间时等待 OfflineAckTimeout，未确认时同样放入队列。
+// 未设置 Timestamp 时以调用时间作为采集时间，重连后发送的消息保留原来的采集时间。
+// sent 为 true 表示已立即发送，为 false 表示已放入队列；序列化失败或离线队列长度为 0 时返回错误。
+// ctx 超时后仍在进行的发送不会取消，可能与重连后发送的消息重复
+func (d *Device) PostPropertyOrQueue(ctx context.Context, property Property) (sent bool, err error) {
+	property = d.withUnit(stamped(property))
+	request, err := d.makePropertyRequest(property)
+	if err != nil {
+		return false, err
+	}
+	attrs := []trace.Attribute{trace.Int(trace.AttrPropertyID, int(property.PropertyID))}
+	if ctx.Err() == nil && d.IsConnected() && !d.Paused() {
+		if err = d.publishContext(ctx, PriorityNormal, withAckTimeout(ctx, request), attrs...); err == nil {
+			return true, nil
+		}
+	}
+	if !d.enqueueOffline(PriorityNormal, request, attrs...) {
+		if err == nil {
+			err = ErrOfflineQueueDisabled
+		}
+		return false, errors.Wrap(err, "post property failed, offline queue disabled")
+	}
+	return false, nil
+}
+
+// withAckTimeout 返回设置了 WaitTimeout 的 opts 副本，等待时间为 ctx 的剩余时间，没有截止时间时为 OfflineAckTimeout
+func withAckTimeout(ctx context.Context, opts map[string]interface{}) map[string]interface{} {
+	timeout := OfflineAckTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	waiting := make(map[string]interface{}, len(opts)+1)
+	for k, v := range opts {
+		waiting[k] = v
+	}
+	waiting["WaitTimeout"] = timeout
+	return waiting
+}
+
+// enqueueOffline 放入离线队列，离线队列长度为 0 时返回 false
+func (d *Device) enqueueOffline(p Priority, opts map[string]interface{}, attrs ...trace.Attribute) bool {
+	if d.offline == nil || d.OfflineQueueSize <= 0 {
+		return false
+	}
+	d.offline.push(offlineMessage{
+		opts:     opts,
+		attrs:    attrs,
+		priority: p,
+		queuedAt: time.Now(),
+	}, d.OfflineQueueSize, d.OfflineDropPolicy)
+	return true
+}
+
+// queueWhenOffline 开启 QueueWhenOffline 且未连接时放入离线队列，返回是否已放入。暂停期间由 publishPaused 处理
+func (d *Device) queueWhenOffline(p Priority, opts map[string]interface{}, attrs ...trace.Attribute) bool {
+	if !d.QueueWhenOffline || d.Paused() || d.IsConnected() {
+		return false
+	}
+	return d.enqueueOffline(p, opts, attrs...)
+}
+
+// publishContext 发布消息，ctx 结束时不再等待发送结果，仍在发布队列中排队的消息不再发送
+func (d *Device) publishContext(ctx context.Context, p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	done := make(chan error, 1)
+	d.goroutines.spawn(func() {
+		done <- d.publishWithPriorityContext(ctx, p, opts, attrs...)
+	})
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushOffline 重连后按顺序发送离线队列中的消息，发送失败时剩余消息放回队列等待下次重连
+func (d *Device) flushOffline() {
+	if d.offline == nil || d.Paused() {
+		return
+	}
+	d.offline.flushing.Lock()
+	defer d.offline.flushing.Unlock()
+	messages := d.offline.takeAll(d.OfflineMaxAge)
+	for i, m := range messages {
+		if err := d.publishWithPriority(m.priority, m.opts, m.attrs...); err != nil {
+			d.offline.requeue(messages[i:], d.OfflineQueueSize, d.OfflineDropPolicy)
+			return
+		}
+	}
+}
+
+// stamped 未设置采集时间时以当前时间作为采集时间，用于延迟序列化或发送的属性
+func stamped(property Property) Property {
+	if property.Timestamp.IsZero() {
+		property.Timestamp = time.Now()
+	}
+	return property
+}
@@ -0,0 +1,96 @@
+package device
+
+import "sync"
+
+// WithOrderedCommands 同一子设备（SubDeviceID 相同）的命令按到达顺序依次执行，不同子设备的命令在各自的协程中并发执行，
+// 避免网关对同一个物理子设备乱序操作。每个子设备最多排队 CommandQueueSize 条命令，
+// 排队已满时拒绝并回复 ReplyCodeBusy。设置了 MaxConcurrentCommands 时，所有子设备同时执行的命令数不超过该值
+func WithOrderedCommands() Option {
+	return func(d *Device) {
+		d.OrderedCommands = true
+	}
+}
+
+// orderedCommands 按子设备排队的命令，子设备在 queues 中表示其执行协程正在运行，
+// 切片为执行协程之后要依次执行的命令
+type orderedCommands struct {
+	mu     sync.Mutex
+	queues map[uint16][]func()
+}
+
+// newOrderedCommands 创建 orderedCommands 对象
+func newOrderedCommands() *orderedCommands {
+	return &orderedCommands{queues: map[uint16][]func(){}}
+}
+
+// push 将命令放入子设备的队列，子设备没有正在运行的执行协程时 start 为 true，由调用方启动执行协程并先执行该命令。
+// 队列已满时 ok 为 false
+func (o *orderedCommands) push(subDeviceID uint16, run func(), size int) (ok, start bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue, running := o.queues[subDeviceID]
+	if !running {
+		o.queues[subDeviceID] = nil
+		return true, true
+	}
+	if len(queue) >= size {
+		return false, false
+	}
+	o.queues[subDeviceID] = append(queue, run)
+	return true, false
+}
+
+// next 取出子设备的下一条命令，队列为空时结束该子设备的执行协程
+func (o *orderedCommands) next(subDeviceID uint16) (func(), bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue := o.queues[subDeviceID]
+	if len(queue) == 0 {
+		delete(o.queues, subDeviceID)
+		return nil, false
+	}
+	o.queues[subDeviceID] = queue[1:]
+	return queue[0], true
+}
+
+// pending 排队中的命令数，不包括正在执行的命令
+func (o *orderedCommands) pending(subDeviceID uint16) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queues[subDeviceID])
+}
+
+// dispatchOrdered 将命令放入子设备的队列，由该子设备的执行协程按顺序执行，队列已满时拒绝并返回 false
+func (d *Device) dispatchOrdered(cmd Command, ctx CommandContext, id string, run func()) bool {
+	ok, start := d.ordered.push(ctx.SubDeviceID, run, d.CommandQueueSize)
+	if !ok {
+		d.rejectBusy(cmd, ctx, id)
+		return false
+	}
+	if !start {
+		return true
+	}
+	d.goroutines.spawn(func() {
+		for ok := true; ok; run, ok = d.ordered.next(ctx.SubDeviceID) {
+			d.runOrdered(run)
+		}
+	})
+	return true
+}
+
+// runOrdered 执行一条命令，设置了 MaxConcurrentCommands 时先等待执行位置
+func (d *Device) runOrdered(run func()) {
+	if limit := d.MaxConcurrentCommands; limit > 0 && d.commands != nil {
+		d.commands.take(limit)
+		defer d.commands.release()
+	}
+	run()
+}
+
+// PendingCommands 开启 OrderedCommands 时子设备排队中的命令数，不包括正在执行的命令
+func (d *Device) PendingCommands(subDeviceID uint16) int {
+	if d.ordered == nil {
+		return 0
+	}
+	return d.ordered.pending(subDeviceID)
+}
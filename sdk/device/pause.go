@@ -0,0 +1,88 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/serializer"
+	"iot-sdk-go/sdk/trace"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPaused 设备已暂停，消息被丢弃或命令被拒绝
+var ErrPaused = errors.New("device paused")
+
+// PauseMode 暂停期间发布的消息的处理方式
+type PauseMode int
+
+const (
+	// PauseQueue 放入离线队列，恢复后按顺序发送，默认值。离线队列长度为 0 时丢弃
+	PauseQueue PauseMode = iota
+	// PauseDrop 丢弃并返回 ErrPaused
+	PauseDrop
+)
+
+// WithPauseMode 设置暂停期间发布的消息的处理方式
+func WithPauseMode(mode PauseMode) Option {
+	return func(d *Device) {
+		d.PauseMode = mode
+	}
+}
+
+// WithMaintenanceStatus 设置暂停、恢复时是否向 Topics.DeviceInfo 上报设备信息，
+// 开启后设备信息中带 maintenance 字段，暂停期间为 true
+func WithMaintenanceStatus(enable bool) Option {
+	return func(d *Device) {
+		d.ReportMaintenance = enable
+	}
+}
+
+// Pause 暂停设备，用于维护窗口：定时上报停止采样，发布的消息按 PauseMode 放入离线队列或丢弃，
+// 收到的命令被拒绝，MQTT 连接与心跳保持，平台上设备仍然在线。
+// 调用时已经开始发送的消息、正在执行的命令不受影响，命令回复、诊断回复等控制消息照常发送。
+// 重复调用时不做任何处理
+func (d *Device) Pause() error {
+	if !atomic.CompareAndSwapInt32(&d.paused, 0, 1) {
+		return nil
+	}
+	return d.reportMaintenance()
+}
+
+// Resume 恢复设备，暂停期间放入离线队列的消息按顺序发送后返回，发送失败的留在队列中等待重连。
+// 设备未暂停时不做任何处理
+func (d *Device) Resume() error {
+	if !atomic.CompareAndSwapInt32(&d.paused, 1, 0) {
+		return nil
+	}
+	err := d.reportMaintenance()
+	d.flushOffline()
+	return err
+}
+
+// Paused 设备是否已暂停
+func (d *Device) Paused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+// reportMaintenance 开启 ReportMaintenance 时上报维护状态
+func (d *Device) reportMaintenance() error {
+	if !d.ReportMaintenance {
+		return nil
+	}
+	return errors.Wrap(d.publishDeviceInfo(), "report maintenance status failed")
+}
+
+// publishPaused 暂停期间按 PauseMode 处理发布的消息
+func (d *Device) publishPaused(p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	topic, _ := opts["Topic"].(string)
+	if d.PauseMode == PauseDrop || !d.enqueueOffline(p, opts, attrs...) {
+		d.logf(log.LevelDebug, "device paused, drop message on %s", topic)
+		return errors.Wrapf(ErrPaused, "publish %s failed", topic)
+	}
+	return nil
+}
+
+// rejectPaused 暂停期间拒绝命令，带回复的命令回复 ReplyCodeBusy
+func (d *Device) rejectPaused(cmd Command, ctx CommandContext, id string) {
+	d.rejectCommand(cmd, ctx, id, errors.Wrapf(ErrPaused, "command %d rejected", ctx.ID), serializer.ReplyCodeBusy)
+}
@@ -0,0 +1,137 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+
+	"github.com/pkg/errors"
+)
+
+// PersistFailure 注册、登录成功后保存设备信息失败时的处理方式
+type PersistFailure int
+
+const (
+	// PersistWarn 记录 warning 日志，注册、登录仍然成功，默认值
+	PersistWarn PersistFailure = iota
+	// PersistFail 注册、登录返回错误，已得到的凭证仍保留在内存中
+	PersistFail
+	// PersistIgnore 忽略保存失败
+	PersistIgnore
+)
+
+// WithPersistFailure 设置注册、登录成功后保存设备信息失败时的处理方式，默认 PersistWarn
+func WithPersistFailure(policy PersistFailure) Option {
+	return func(d *Device) {
+		d.PersistFailure = policy
+	}
+}
+
+// persistDeviceInfo 保存设备信息，按 PersistFailure 处理保存失败，op 为注册或登录
+func (d *Device) persistDeviceInfo(op string) error {
+	err := d.SetDeviceInfo()
+	if err == nil {
+		return nil
+	}
+	err = errors.Wrapf(err, "device %s succeeded but save device info failed", op)
+	switch d.PersistFailure {
+	case PersistFail:
+		return err
+	case PersistIgnore:
+		return nil
+	default:
+		d.logf(log.LevelWarn, "%v", err)
+		return nil
+	}
+}
+
+// DeviceField LoadDeviceInfo 加载的设备信息字段，值为 Storage 中的 key 后缀
+type DeviceField string
+
+// 设备信息字段
+const (
+	FieldProductKey DeviceField = "ProductKey"
+	FieldName       DeviceField = "Name"
+	FieldVersion    DeviceField = "Version"
+	FieldID         DeviceField = "ID"
+	FieldSecret     DeviceField = "Secret"
+	FieldToken      DeviceField = "Token"
+	FieldAccess     DeviceField = "Access"
+)
+
+// Precedence LoadDeviceInfo 中代码设置的值与 Storage 中的值同时存在时使用哪一个
+type Precedence int
+
+const (
+	// PreferCode 使用代码设置的值，代码中为零值时使用 Storage 中的值
+	PreferCode Precedence = iota
+	// PreferStorage 使用 Storage 中的值，Storage 中不存在时保留代码设置的值
+	PreferStorage
+)
+
+// defaultPrecedence LoadDeviceInfo 默认的字段优先级：产品、名称、版本随固件更新，以代码为准；
+// 注册、登录得到的凭证以 Storage 为准
+var defaultPrecedence = map[DeviceField]Precedence{
+	FieldProductKey: PreferCode,
+	FieldName:       PreferCode,
+	FieldVersion:    PreferCode,
+	FieldID:         PreferStorage,
+	FieldSecret:     PreferStorage,
+	FieldToken:      PreferStorage,
+	FieldAccess:     PreferStorage,
+}
+
+// LoadOption LoadDeviceInfo 的配置函数
+type LoadOption func(precedence map[DeviceField]Precedence)
+
+// WithPrecedence 设置字段的优先级，覆盖默认值
+func WithPrecedence(p Precedence, fields ...DeviceField) LoadOption {
+	return func(precedence map[DeviceField]Precedence) {
+		for _, f := range fields {
+			precedence[f] = p
+		}
+	}
+}
+
+// LoadDeviceInfo 从 Storage 加载设备信息，按字段优先级与代码设置的值合并，Storage 中不存在的字段保留代码设置的值。
+// 默认 ProductKey、Name、Version 以代码为准，ID、Secret、Token、Access 以 Storage 为准，可以通过 WithPrecedence 修改
+func (d *Device) LoadDeviceInfo(opts ...LoadOption) error {
+	stored, err := d.GetDeviceInfo()
+	if err != nil {
+		return err
+	}
+	precedence := make(map[DeviceField]Precedence, len(defaultPrecedence))
+	for f, p := range defaultPrecedence {
+		precedence[f] = p
+	}
+	for _, opt := range opts {
+		opt(precedence)
+	}
+	// load 字段在 Storage 中存在，且代码中为零值或以 Storage 为准时返回 true
+	load := func(f DeviceField, codeZero, storedZero bool) bool {
+		return !storedZero && (codeZero || precedence[f] == PreferStorage)
+	}
+	if load(FieldProductKey, d.ProductKey == "", stored.ProductKey == "") {
+		d.ProductKey = stored.ProductKey
+	}
+	if load(FieldName, d.Name == "", stored.Name == "") {
+		d.Name = stored.Name
+	}
+	if load(FieldVersion, d.Version == "", stored.Version == "") {
+		d.Version = stored.Version
+	}
+	mu := d.authLock()
+	mu.Lock()
+	defer mu.Unlock()
+	if load(FieldID, d.ID == 0, stored.ID == 0) {
+		d.ID = stored.ID
+	}
+	if load(FieldSecret, d.Secret == "", stored.Secret == "") {
+		d.Secret = stored.Secret
+	}
+	if load(FieldToken, d.Token == nil, len(stored.Token) == 0) {
+		d.Token = stored.Token
+	}
+	if load(FieldAccess, d.Access == "", stored.Access == "") {
+		d.Access = stored.Access
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/serializer"
+	"iot-sdk-go/sdk/trace"
+
+	"github.com/pkg/errors"
+)
+
+// PostProperties 将多个属性序列化为一条消息立即上报，减少每个周期上报大量数据点时的发布次数。
+// 不同子设备的属性可以放在同一批中，由序列化器区分（TLV 每个内嵌数据带子设备 ID，JSON 子设备变化时开始新的对象）。
+// properties 为空时不上报；序列化器不支持批量时逐条上报，返回第一个错误。不经过批量上报缓冲
+func (d *Device) PostProperties(properties []Property, opts ...PublishOption) error {
+	return d.PostPropertiesWithPriority(properties, PriorityNormal, opts...)
+}
+
+// PostPropertiesWithPriority 按优先级批量上报属性
+func (d *Device) PostPropertiesWithPriority(properties []Property, p Priority, opts ...PublishOption) error {
+	if len(properties) == 0 {
+		return nil
+	}
+	properties = append([]Property(nil), properties...)
+	for i := range properties {
+		properties[i] = d.withUnit(properties[i])
+	}
+	request, err := d.makeBatchPropertyRequest(properties, opts...)
+	if errors.Cause(err) == errBatchUnsupported {
+		var first error
+		for _, property := range properties {
+			if err := d.postOneProperty(property, p, opts...); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	if err != nil {
+		return err
+	}
+	return d.publishBlocking(context.Background(), p, request, trace.Int(trace.AttrPropertyID, int(properties[0].PropertyID)))
+}
+
+// postOneProperty 立即上报单个属性，不经过批量上报缓冲
+func (d *Device) postOneProperty(property Property, p Priority, opts ...PublishOption) error {
+	request, err := d.makePropertyRequest(property, opts...)
+	if err != nil {
+		return err
+	}
+	return d.publishBlocking(context.Background(), p, request, trace.Int(trace.AttrPropertyID, int(property.PropertyID)))
+}
+
+// makeBatchPropertyRequest 将多个属性序列化为一条消息并创建发布参数，
+// 序列化器不支持批量时返回 errBatchUnsupported
+func (d *Device) makeBatchPropertyRequest(properties []Property, opts ...PublishOption) (map[string]interface{}, error) {
+	batch := make([]*serializer.Property, len(properties))
+	for i := range properties {
+		batch[i] = properties[i].toSerializerProperty()
+	}
+	data, err := d.serializerFor(d.Topics.PostProperty).(serializer.BatchSerializer).MakeBatchPropertyData(batch)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = d.compress(data); err != nil {
+		return nil, err
+	}
+	if data, err = d.encodePayload(data); err != nil {
+		return nil, err
+	}
+	return protocol.OptionsFormatter(*makePostPropertyRequest(d, data, opts...)), nil
+}
@@ -0,0 +1,90 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ClaimCodeError 认领码无效或已过期
+type ClaimCodeError struct {
+	// Expired 为 true 表示认领码已过期，否则为认领码不存在或已被使用
+	Expired bool
+	Message string
+}
+
+// Error 错误信息
+func (e *ClaimCodeError) Error() string {
+	if e.Expired {
+		return "claim code expired: " + e.Message
+	}
+	return "claim code invalid: " + e.Message
+}
+
+// Provision 使用安装人员提供的一次性认领码开通设备：携带 ProductKey 与认领码请求 Topics.Provision，
+// 平台将设备开通到认领码所属的账号下并返回设备 ID、名称与密钥，保存到 Storage 后登录。
+// 认领码不存在、已使用（404）或已过期（410）时返回 *ClaimCodeError
+func (d *Device) Provision(ctx context.Context, claimCode string) error {
+	return d.flightDo("Provision", func() error {
+		return d.traced("provision", func() error {
+			return d.provision(ctx, claimCode)
+		})
+	})
+}
+
+func (d *Device) provision(ctx context.Context, claimCode string) error {
+	if d.ProductKey == "" || claimCode == "" {
+		return errors.New("device provision failed, field ProductKey and claim code cannot be empty")
+	}
+	args, err := json.Marshal(ProvisionArgs{ProductKey: d.ProductKey, ClaimCode: claimCode, DeviceCode: d.Name})
+	if err != nil {
+		return errors.Wrap(err, "device provision failed, provision arguments convert to json failed")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Topics.Provision, strings.NewReader(string(args)))
+	if err != nil {
+		return errors.Wrap(err, "device provision failed, create request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	jsonresp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "device provision failed, request provision rest api failed")
+	}
+	defer jsonresp.Body.Close()
+	body, err := ioutil.ReadAll(jsonresp.Body)
+	if err != nil {
+		return errors.Wrap(err, "device provision failed, read response failed")
+	}
+	response := ProvisionResponse{}
+	// 认领码错误时平台可能不返回 JSON，此时 Message 为空
+	jsonErr := json.Unmarshal(body, &response)
+	switch jsonresp.StatusCode {
+	case http.StatusNotFound:
+		return &ClaimCodeError{Message: response.Message}
+	case http.StatusGone:
+		return &ClaimCodeError{Expired: true, Message: response.Message}
+	}
+	if jsonErr != nil {
+		return errors.Wrap(jsonErr, "device provision failed, provision rest api response convert to json failed")
+	}
+	if err := HTTPIsOK(response); err != nil {
+		return errors.Wrap(err, "device provision failed, provision rest api state not is ok")
+	}
+	if response.Data.ID == 0 || response.Data.Secret == "" {
+		return errors.New("device provision failed, provision rest api returned empty device id or secret")
+	}
+	if response.Data.Name != "" {
+		d.Name = response.Data.Name
+	}
+	d.lockAuth(func() {
+		d.ID = response.Data.ID
+		d.Secret = response.Data.Secret
+	})
+	if err := d.SetDeviceInfo(); err != nil {
+		return errors.Wrap(err, "device provision failed, save device info failed")
+	}
+	return errors.Wrap(d.Login(), "device provision failed")
+}
@@ -0,0 +1,28 @@
+package device
+
+import "iot-sdk-go/sdk/request"
+
+// PublishOption 单次发布的配置项，未设置时使用各发布方法的默认值
+type PublishOption func(*request.Request)
+
+// WithQos 设置发布的 QoS，如高频上报使用 0 以减轻服务端的压力
+func WithQos(qos byte) PublishOption {
+	return func(r *request.Request) {
+		r.Qos = qos
+	}
+}
+
+// WithRetained 设置是否为保留消息，服务端保留主题上的最后一条保留消息，新订阅者订阅后立即收到
+func WithRetained(retained bool) PublishOption {
+	return func(r *request.Request) {
+		r.Retained = retained
+	}
+}
+
+// applyPublishOptions 依次应用 opts
+func applyPublishOptions(r *request.Request, opts []PublishOption) *request.Request {
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
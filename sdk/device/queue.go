@@ -0,0 +1,139 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/pkg/ratelimit"
+	"sync"
+)
+
+// Priority 发布优先级
+type Priority int
+
+const (
+	// PriorityLow 低优先级，适用于可以延后发送的例行属性
+	PriorityLow Priority = iota
+	// PriorityNormal 默认优先级
+	PriorityNormal
+	// PriorityHigh 高优先级，适用于告警等事件
+	PriorityHigh
+)
+
+// priorityLevels 优先级数量
+const priorityLevels = int(PriorityHigh) + 1
+
+// MaxPrioritySkips 有待发送消息的优先级连续被更高优先级跳过的最大次数，
+// 超过后优先发送一条该优先级的消息，避免低优先级消息一直得不到发送
+var MaxPrioritySkips = 8
+
+// WithPublishLimiter 设置发布限流器，每次发布前等待限流器允许，
+// 等待期间排队的消息按优先级发送
+func WithPublishLimiter(limiter ratelimit.Limiter) Option {
+	return func(d *Device) {
+		d.PublishLimiter = limiter
+	}
+}
+
+// publishQueue 按优先级排队的发布队列，设置了限流器或已有消息排队时，启动一个协程依次发送，队列为空后协程退出。
+// 为 nil 时（未通过 New 创建设备）直接发送
+type publishQueue struct {
+	mu         sync.Mutex
+	lanes      [priorityLevels][]*queuedPublish
+	skips      [priorityLevels]int
+	busy       bool
+	goroutines *goroutines
+}
+
+// queuedPublish 排队中的发布，ctx 结束后调用方不再等待，出队时丢弃
+type queuedPublish struct {
+	ctx  context.Context
+	send func() error
+	done chan error
+}
+
+// do 按优先级发送，阻塞直到发送完成。limited 为 false 且队列空闲时在调用方协程中直接发送，
+// 否则排队等待，ctx 结束时返回 ctx 的错误，排队中的消息不再发送
+func (q *publishQueue) do(ctx context.Context, p Priority, limited bool, send func() error) error {
+	if p < PriorityLow || p > PriorityHigh {
+		p = PriorityNormal
+	}
+	q.mu.Lock()
+	if !limited && !q.busy {
+		q.mu.Unlock()
+		return send()
+	}
+	item := &queuedPublish{ctx: ctx, send: send, done: make(chan error, 1)}
+	q.lanes[p] = append(q.lanes[p], item)
+	if !q.busy {
+		q.busy = true
+		q.goroutines.spawn(q.drain)
+	}
+	q.mu.Unlock()
+	select {
+	case err := <-item.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain 依次发送排队的消息，直到队列为空
+func (q *publishQueue) drain() {
+	for {
+		q.mu.Lock()
+		item := q.next()
+		if item == nil {
+			q.busy = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+		if item.ctx.Err() != nil {
+			continue
+		}
+		item.done <- item.send()
+	}
+}
+
+// next 取出下一条待发送的消息，调用时需持有锁。优先发送被跳过次数达到 MaxPrioritySkips 的低优先级消息，
+// 否则发送优先级最高的消息
+func (q *publishQueue) next() *queuedPublish {
+	pick := -1
+	for p := 0; p < priorityLevels; p++ {
+		if len(q.lanes[p]) > 0 && q.skips[p] >= MaxPrioritySkips {
+			pick = p
+			break
+		}
+	}
+	if pick < 0 {
+		for p := priorityLevels - 1; p >= 0; p-- {
+			if len(q.lanes[p]) > 0 {
+				pick = p
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	for p := 0; p < priorityLevels; p++ {
+		if p != pick && len(q.lanes[p]) > 0 {
+			q.skips[p]++
+		}
+	}
+	q.skips[pick] = 0
+	item := q.lanes[pick][0]
+	q.lanes[pick][0] = nil
+	q.lanes[pick] = q.lanes[pick][1:]
+	return item
+}
+
+// queued 排队中的消息数
+func (q *publishQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return n
+}
@@ -0,0 +1,47 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/storage"
+)
+
+// WithStorageQuota 限制 Storage 的占用空间，SDK 写入 Storage 后超过 bytes 时按优先级从低到高淘汰数据并记录 warning 日志。
+// 需要 Storage 实现 storage.Sizer，未实现时不限制。bytes 不大于 0 时不限制
+func WithStorageQuota(bytes int64) Option {
+	return func(d *Device) {
+		d.StorageQuota = bytes
+	}
+}
+
+// StorageSize Storage 占用的字节数，Storage 未实现 storage.Sizer 时返回 storage.ErrSizeUnsupported
+func (d *Device) StorageSize() (int64, error) {
+	return storage.Size(d.Storage)
+}
+
+// enforceStorageQuota 超过 StorageQuota 时淘汰数据：先按处理顺序淘汰命令去重记录，
+// 设备凭证等不可恢复的数据不淘汰，淘汰后仍然超过时只记录日志
+func (d *Device) enforceStorageQuota() {
+	if d.StorageQuota <= 0 {
+		return
+	}
+	over := func() bool {
+		size, err := storage.Size(d.Storage)
+		return err == nil && size > d.StorageQuota
+	}
+	if !over() {
+		return
+	}
+	if d.CommandLog != nil {
+		evicted, err := d.CommandLog.evict(d.Storage, over)
+		if err != nil {
+			d.logf(log.LevelWarn, "evict command log failed: %v", err)
+		}
+		if evicted > 0 {
+			d.logf(log.LevelWarn, "storage quota %d exceeded, evicted %d command log entries", d.StorageQuota, evicted)
+		}
+	}
+	if over() {
+		size, _ := storage.Size(d.Storage)
+		d.logf(log.LevelWarn, "storage quota %d exceeded, size %d, no more evictable data", d.StorageQuota, size)
+	}
+}
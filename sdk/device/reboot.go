@@ -0,0 +1,74 @@
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/serializer"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RebootAckTimeout 重启前等待确认发送完成的超时时间
+var RebootAckTimeout = 5 * time.Second
+
+// rebootRequest 重启命令，命令未携带 MQTT 5 Correlation Data 时 request_id 原样写入确认，用于关联命令与确认
+type rebootRequest struct {
+	RequestID string `json:"request_id"`
+}
+
+// OnReboot 订阅 Topics.Reboot，收到平台的重启命令后依次：
+// 在 Topics.RebootReply 上发送确认并等待发送完成（最多 RebootAckTimeout），
+// 发送批量上报缓冲中的属性，将 Storage 落盘，最后调用 handler 执行重启。
+// 重启后设备无法再补发确认，因此确认必须在 handler 之前发出；确认发送失败时仍然调用 handler
+func (d *Device) OnReboot(handler func() error) error {
+	callbackFn := func(resp request.Response) {
+		if err := d.ackReboot(resp); err != nil {
+			d.logf(log.LevelError, "%v", err)
+		}
+		if err := d.FlushTelemetry(); err != nil {
+			d.logf(log.LevelError, "%v", err)
+		}
+		if err := d.Flush(); err != nil {
+			d.logf(log.LevelError, "%v", err)
+		}
+		if err := handler(); err != nil {
+			d.commandError(resp.Topic(), errors.Wrap(err, "reboot failed"))
+		}
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.Reboot
+	r.Qos = 1
+	r.Callback = d.bufferCallback(callbackFn)
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
+
+// ackReboot 发送重启确认并等待发送完成
+func (d *Device) ackReboot(resp request.Response) error {
+	data := map[string]interface{}{}
+	// 命令不是 JSON 时仍然确认，只是不携带 request_id
+	req := rebootRequest{}
+	if err := json.Unmarshal(resp.Payload(), &req); err == nil && req.RequestID != "" && !correlated(resp) {
+		data["request_id"] = req.RequestID
+	}
+	ack, err := d.marshalReply(&serializer.Reply{
+		Code: serializer.ReplyCodeOK,
+		Data: data,
+	})
+	if err == nil {
+		ack, err = d.encodePayload(ack)
+	}
+	if err != nil {
+		return errors.Wrap(err, "make reboot ack failed")
+	}
+	r := replyRequest(resp, d.Topics.RebootReply, ack)
+	opts := protocol.OptionsFormatter(*r)
+	opts["WaitTimeout"] = RebootAckTimeout
+	return errors.Wrap(d.publishControl(PriorityHigh, opts), "send reboot ack failed")
+}
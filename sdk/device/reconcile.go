@@ -0,0 +1,44 @@
+package device
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ApplyAndReport 应用平台下发的期望属性值并上报实际值，只上报 desired 中的属性。
+// apply 返回的 actual 为应用后的实际值，为 []interface{} 时作为多个参数上报；
+// apply 返回错误时以 QualityBad 上报，actual 为 nil 时上报期望值。
+// 属性按 ID 升序处理，单个属性上报失败不影响其他属性，所有上报错误合并返回
+func (d *Device) ApplyAndReport(desired map[uint16]interface{}, apply func(id uint16, v interface{}) (actual interface{}, err error)) error {
+	ids := make([]int, 0, len(desired))
+	for id := range desired {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	var msgs []string
+	for _, i := range ids {
+		id := uint16(i)
+		property := Property{PropertyID: id, Quality: QualityGood}
+		actual, err := apply(id, desired[id])
+		if err != nil {
+			property.Quality = QualityBad
+			if actual == nil {
+				actual = desired[id]
+			}
+		}
+		if values, ok := actual.([]interface{}); ok {
+			property.Value = values
+		} else {
+			property.Value = []interface{}{actual}
+		}
+		if err := d.PostProperty(property); err != nil {
+			msgs = append(msgs, errors.Wrapf(err, "property %d", id).Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New("apply and report failed: " + strings.Join(msgs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package device
+
+import (
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/sdk/serializer"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSerializerPanic 序列化器发生 panic，错误信息中包含 panic 的值与调用栈
+var ErrSerializerPanic = errors.New("serializer panic")
+
+// errBatchUnsupported 序列化器不支持批量序列化、解析属性
+var errBatchUnsupported = errors.New("serializer does not support batch")
+
+// errCommandResponseUnsupported 序列化器不支持序列化命令回复
+var errCommandResponseUnsupported = errors.New("serializer does not support command response")
+
+// safeSerializer 捕获序列化器的 panic 并转换为 ErrSerializerPanic，避免自定义序列化器的错误导致进程退出
+type safeSerializer struct {
+	serializer.Serializer
+}
+
+// recoverSerializer 将 panic 转换为 err，在 defer 中调用
+func recoverSerializer(op string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	mqtt.ERROR.Println(mqtt.CLI, "serializer panic in", op, r, string(stack))
+	*err = errors.Wrapf(ErrSerializerPanic, "%s: %v\n%s", op, r, stack)
+}
+
+// Marshal 序列化
+func (s safeSerializer) Marshal(data interface{}) (ret interface{}, err error) {
+	defer recoverSerializer("Marshal", &err)
+	return s.Serializer.Marshal(data)
+}
+
+// Unmarshal 反序列化
+func (s safeSerializer) Unmarshal(data interface{}) (ret interface{}, err error) {
+	defer recoverSerializer("Unmarshal", &err)
+	return s.Serializer.Unmarshal(data)
+}
+
+// MakePropertyData 序列化属性
+func (s safeSerializer) MakePropertyData(data *serializer.Property) (ret []byte, err error) {
+	defer recoverSerializer("MakePropertyData", &err)
+	return s.Serializer.MakePropertyData(data)
+}
+
+// MakeEventData 序列化事件
+func (s safeSerializer) MakeEventData(data *serializer.Property) (ret []byte, err error) {
+	defer recoverSerializer("MakeEventData", &err)
+	return s.Serializer.MakeEventData(data)
+}
+
+// UnmarshalCommand 反序列化命令
+func (s safeSerializer) UnmarshalCommand(data []byte) (ret *serializer.Command, err error) {
+	defer recoverSerializer("UnmarshalCommand", &err)
+	return s.Serializer.UnmarshalCommand(data)
+}
+
+// UnmarshalProperty 反序列化属性
+func (s safeSerializer) UnmarshalProperty(data []byte) (ret *serializer.Property, err error) {
+	defer recoverSerializer("UnmarshalProperty", &err)
+	return s.Serializer.UnmarshalProperty(data)
+}
+
+// UnmarshalBatchProperty 反序列化一条消息中的所有属性，序列化器未实现 serializer.BatchDeserializer 时返回 errBatchUnsupported
+func (s safeSerializer) UnmarshalBatchProperty(data []byte) (ret []*serializer.Property, err error) {
+	b, ok := s.Serializer.(serializer.BatchDeserializer)
+	if !ok {
+		return nil, errBatchUnsupported
+	}
+	defer recoverSerializer("UnmarshalBatchProperty", &err)
+	return b.UnmarshalBatchProperty(data)
+}
+
+// MakeBatchPropertyData 批量序列化属性，序列化器未实现 serializer.BatchSerializer 时返回 errBatchUnsupported
+func (s safeSerializer) MakeBatchPropertyData(data []*serializer.Property) (ret []byte, err error) {
+	b, ok := s.Serializer.(serializer.BatchSerializer)
+	if !ok {
+		return nil, errBatchUnsupported
+	}
+	defer recoverSerializer("MakeBatchPropertyData", &err)
+	return b.MakeBatchPropertyData(data)
+}
+
+// supportsCommandResponse 序列化器是否实现了 serializer.CommandResponseSerializer
+func (s safeSerializer) supportsCommandResponse() bool {
+	_, ok := s.Serializer.(serializer.CommandResponseSerializer)
+	return ok
+}
+
+// MakeCommandResponseData 序列化命令回复，序列化器未实现 serializer.CommandResponseSerializer 时返回 errCommandResponseUnsupported
+func (s safeSerializer) MakeCommandResponseData(data *serializer.CommandResponse) (ret []byte, err error) {
+	c, ok := s.Serializer.(serializer.CommandResponseSerializer)
+	if !ok {
+		return nil, errCommandResponseUnsupported
+	}
+	defer recoverSerializer("MakeCommandResponseData", &err)
+	return c.MakeCommandResponseData(data)
+}
@@ -0,0 +1,56 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"time"
+)
+
+// ReconnectLogin 断线重连前是否重新登录
+type ReconnectLogin int
+
+const (
+	// LoginAlways 每次重连前都重新登录，刷新 Token
+	LoginAlways ReconnectLogin = iota
+	// LoginIfExpired Token 即将过期或因认证失败断开时才重新登录，否则使用原有 Token 重连
+	LoginIfExpired
+	// LoginNever 重连前不登录，始终使用原有 Token，由调用方自行调用 Login 刷新
+	LoginNever
+)
+
+// TokenRefreshMargin Token 剩余有效期不足该时间时视为即将过期
+var TokenRefreshMargin = 30 * time.Second
+
+// WithLoginOnReconnect 设置断线重连前是否重新登录，默认 LoginAlways
+func WithLoginOnReconnect(mode ReconnectLogin) Option {
+	return func(d *Device) {
+		d.LoginOnReconnect = mode
+	}
+}
+
+// shouldLoginOnReconnect 根据 LoginOnReconnect 与断开原因判断重连前是否重新登录
+func (d *Device) shouldLoginOnReconnect(reason protocol.DisconnectReason) bool {
+	switch d.LoginOnReconnect {
+	case LoginNever:
+		return false
+	case LoginIfExpired:
+		expired, expiresAt := d.tokenExpired()
+		login := reason == protocol.DisconnectAuthFailed || expired
+		d.logf(log.LevelDebug, "reconnect login: %v, reason: %v, token expires at: %v", login, reason, expiresAt)
+		return login
+	default:
+		return true
+	}
+}
+
+// tokenExpired Token 是否已过期或即将过期，以及过期时间，平台未返回有效期时视为未过期
+func (d *Device) tokenExpired() (bool, time.Time) {
+	mu := d.authLock()
+	mu.RLock()
+	expiresAt := d.tokenExpiresAt
+	mu.RUnlock()
+	if expiresAt.IsZero() {
+		return false, expiresAt
+	}
+	return time.Now().Add(TokenRefreshMargin).After(expiresAt), expiresAt
+}
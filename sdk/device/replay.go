@@ -0,0 +1,180 @@
+package device
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/topics"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RecordedMessage 录制的消息，每条消息编码为一行 JSON，Payload 为 base64
+type RecordedMessage struct {
+	Topic    string `json:"topic"`
+	Qos      byte   `json:"qos"`
+	Retained bool   `json:"retained"`
+	Payload  []byte `json:"payload"`
+	// Timestamp 收到消息的毫秒时间戳
+	Timestamp int64 `json:"timestamp"`
+}
+
+// replayedMessage 回放的消息，实现 request.Response
+type replayedMessage struct {
+	msg *RecordedMessage
+}
+
+func (m replayedMessage) Duplicate() bool   { return false }
+func (m replayedMessage) Qos() byte         { return m.msg.Qos }
+func (m replayedMessage) Retained() bool    { return m.msg.Retained }
+func (m replayedMessage) Topic() string     { return m.msg.Topic }
+func (m replayedMessage) MessageID() uint16 { return 0 }
+func (m replayedMessage) Payload() []byte   { return m.msg.Payload }
+
+// recorder 录制收到的消息
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// RecordTo 将之后收到的每条消息（主题、内容、时间）写入 w，每条消息一行 JSON，
+// w 为 nil 时停止录制。写入失败时停止录制
+func (d *Device) RecordTo(w io.Writer) {
+	d.recorder.mu.Lock()
+	defer d.recorder.mu.Unlock()
+	if w == nil {
+		d.recorder.enc = nil
+		return
+	}
+	d.recorder.enc = json.NewEncoder(w)
+}
+
+// record 录制一条消息，写入失败时停止录制并返回错误
+func (r *recorder) record(resp request.Response) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		return nil
+	}
+	err := r.enc.Encode(&RecordedMessage{
+		Topic:     resp.Topic(),
+		Qos:       resp.Qos(),
+		Retained:  resp.Retained(),
+		Payload:   resp.Payload(),
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		r.enc = nil
+		return errors.Wrap(err, "record message failed, recording stopped")
+	}
+	return nil
+}
+
+// handlers 已订阅主题的回调，用于 Dispatch 分发
+type handlers struct {
+	mu sync.RWMutex
+	m  map[string]func(request.Response)
+}
+
+// newHandlers 创建 handlers 对象
+func newHandlers() *handlers {
+	return &handlers{m: make(map[string]func(request.Response))}
+}
+
+// set 记录订阅主题的回调
+func (h *handlers) set(topic string, callback func(request.Response)) {
+	if h == nil || callback == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m[topic] = callback
+}
+
+// has 主题是否已订阅
+func (h *handlers) has(topic string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.m[topic]
+	return ok
+}
+
+// del 删除订阅主题的回调
+func (h *handlers) del(topics ...string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		delete(h.m, topic)
+	}
+}
+
+// Dispatch 将消息分发给订阅了该主题的回调，与从服务端收到消息的处理路径相同，
+// 主题支持 + 和 # 通配符匹配。没有匹配的订阅时返回 false
+func (d *Device) Dispatch(resp request.Response) bool {
+	if d.handlers == nil {
+		return false
+	}
+	d.handlers.mu.RLock()
+	matched := []func(request.Response){}
+	for filter, callback := range d.handlers.m {
+		if topics.Match(filter, resp.Topic()) {
+			matched = append(matched, callback)
+		}
+	}
+	d.handlers.mu.RUnlock()
+	for _, callback := range matched {
+		callback(resp)
+	}
+	return len(matched) > 0
+}
+
+// replayOptions 回放配置
+type replayOptions struct {
+	speed float64
+}
+
+// ReplayOption 回放配置项
+type ReplayOption func(*replayOptions)
+
+// ReplaySpeed 设置回放倍速，如 10 表示按录制时间间隔的十分之一回放，小于等于 0 时不等待
+func ReplaySpeed(speed float64) ReplayOption {
+	return func(o *replayOptions) {
+		o.speed = speed
+	}
+}
+
+// Replay 读取 RecordTo 录制的消息，按录制时的时间间隔依次交给 dispatch 处理，
+// dispatch 通常为 Device.Dispatch。默认按原始时间间隔回放
+func Replay(r io.Reader, dispatch func(request.Response), opts ...ReplayOption) error {
+	o := replayOptions{speed: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var last int64
+	for i := 0; ; i++ {
+		msg := &RecordedMessage{}
+		if err := dec.Decode(msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "replay message %d failed", i)
+		}
+		if i > 0 && o.speed > 0 && msg.Timestamp > last {
+			time.Sleep(time.Duration(float64(msg.Timestamp-last) / o.speed * float64(time.Millisecond)))
+		}
+		last = msg.Timestamp
+		dispatch(replayedMessage{msg})
+	}
+}
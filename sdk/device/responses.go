@@ -0,0 +1,60 @@
+package device
+
+import "sync"
+
+// responses 最近一次注册、登录的返回内容，为 nil 时（未通过 New 创建设备）不记录
+type responses struct {
+	mu       sync.Mutex
+	register *RegisterResponse
+	login    *AuthResponse
+}
+
+// setRegister 记录注册返回内容
+func (r *responses) setRegister(resp *RegisterResponse) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.register = resp
+}
+
+// setLogin 记录登录返回内容
+func (r *responses) setLogin(resp *AuthResponse) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.login = resp
+}
+
+// LastRegisterResponse 最近一次成功注册的返回内容，尚未注册成功时返回 nil。
+// 返回值为副本，Raw 与 Data.Extra 与其他调用方共享，不要修改
+func (d *Device) LastRegisterResponse() *RegisterResponse {
+	if d.responses == nil {
+		return nil
+	}
+	d.responses.mu.Lock()
+	defer d.responses.mu.Unlock()
+	if d.responses.register == nil {
+		return nil
+	}
+	resp := *d.responses.register
+	return &resp
+}
+
+// LastLoginResponse 最近一次成功登录的返回内容，尚未登录成功时返回 nil。
+// 断线重连时的重新登录也会更新。返回值为副本，Raw 与 Data.Extra 与其他调用方共享，不要修改
+func (d *Device) LastLoginResponse() *AuthResponse {
+	if d.responses == nil {
+		return nil
+	}
+	d.responses.mu.Lock()
+	defer d.responses.mu.Unlock()
+	if d.responses.login == nil {
+		return nil
+	}
+	resp := *d.responses.login
+	return &resp
+}
@@ -0,0 +1,15 @@
+package device
+
+import "github.com/pkg/errors"
+
+// ErrRetainedCommand 设置 IgnoreRetainedCommands 后收到的保留命令被当作过期命令跳过
+var ErrRetainedCommand = errors.New("retained command ignored")
+
+// WithIgnoreRetainedCommands 设置是否跳过保留（retained）的命令消息。命令主题上有保留消息时，
+// 设备每次连接都会立即收到该消息，频繁重启的设备会重复执行过期的命令。
+// 跳过的命令以 ErrRetainedCommand 调用 OnCommandError 设置的回调
+func WithIgnoreRetainedCommands(ignore bool) Option {
+	return func(d *Device) {
+		d.IgnoreRetainedCommands = ignore
+	}
+}
@@ -0,0 +1,92 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/serializer"
+	"sync"
+)
+
+// CommandContext 待分发的命令
+type CommandContext struct {
+	// Topic 命令所在的主题
+	Topic       string
+	ID          uint16
+	SubDeviceID uint16
+	// Params 命令参数，-1 对应 SubDeviceID
+	Params map[int]interface{}
+	// ParamNames 通过 WithCommandParams 注册的参数名称，未注册时为 nil
+	ParamNames []string
+	// Payload 命令原始数据
+	Payload []byte
+	// ResponseTopic 命令携带的 MQTT 5 Response Topic，不为空时回复发送到该主题而不是 Topics.CommandResponse
+	ResponseTopic string
+	// CorrelationData 命令携带的 MQTT 5 Correlation Data，回复时原样带回
+	CorrelationData []byte
+	// Token 序列化格式携带的命令唯一标识，见 serializer.Command.Token
+	Token string
+}
+
+// BitmapParam 以 Bitmap 读取第 index 个参数，可以按位读取标志。
+// 参数不存在或不是 32 位以内的非负整数时返回 false
+func (c CommandContext) BitmapParam(index int) (Bitmap, bool) {
+	v, ok := c.Params[index]
+	if !ok {
+		return 0, false
+	}
+	return serializer.ToBitmap(v)
+}
+
+// ParamByName 按名称读取参数，名称为 sub_device_id 时返回 SubDeviceID。
+// 未注册参数名称、名称不存在或命令中没有该参数时返回 false
+func (c CommandContext) ParamByName(name string) (interface{}, bool) {
+	if name == "sub_device_id" {
+		return c.SubDeviceID, true
+	}
+	for i, n := range c.ParamNames {
+		if n == name {
+			v, ok := c.Params[i]
+			return v, ok
+		}
+	}
+	return nil, false
+}
+
+// CommandRouter 命令路由，OnCommand 通过 Add 注册命令，收到命令后通过 Route 选择处理的命令
+type CommandRouter interface {
+	Add(cmds ...Command)
+	Route(cmd CommandContext) (Command, bool)
+}
+
+// MapRouter 默认的命令路由，按命令 ID 分发，相同 ID 后注册的覆盖先注册的
+type MapRouter struct {
+	mu       sync.RWMutex
+	commands map[uint16]Command
+}
+
+// NewMapRouter 创建 MapRouter 对象
+func NewMapRouter() *MapRouter {
+	return &MapRouter{commands: make(map[uint16]Command)}
+}
+
+// Add 注册命令
+func (r *MapRouter) Add(cmds ...Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cmd := range cmds {
+		r.commands[cmd.ID] = cmd
+	}
+}
+
+// Route 按命令 ID 选择处理的命令
+func (r *MapRouter) Route(cmd CommandContext) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.commands[cmd.ID]
+	return c, ok
+}
+
+// WithCommandRouter 设置命令路由，可以按命令 ID 与子设备 ID 的组合、命令内容等自定义分发
+func WithCommandRouter(router CommandRouter) Option {
+	return func(d *Device) {
+		d.CommandRouter = router
+	}
+}
@@ -0,0 +1,107 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"sync"
+	"time"
+)
+
+// schedules 定时上报任务，Close 时全部停止，为 nil 时（未通过 New 创建设备）不跟踪
+type schedules struct {
+	mu    sync.Mutex
+	next  int
+	stops map[int]func()
+}
+
+// newSchedules 创建 schedules 对象
+func newSchedules() *schedules {
+	return &schedules{stops: make(map[int]func())}
+}
+
+// add 记录任务的停止函数，返回任务编号
+func (s *schedules) add(stop func()) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.stops[s.next] = stop
+	return s.next
+}
+
+// remove 任务结束后删除
+func (s *schedules) remove(id int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stops, id)
+}
+
+// stopAll 停止所有任务
+func (s *schedules) stopAll() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	stops := make([]func(), 0, len(s.stops))
+	for _, stop := range s.stops {
+		stops = append(stops, stop)
+	}
+	s.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// SchedulePropertyReport 按 interval 定时调用 sample 采样并上报属性，sample 返回 []interface{} 时作为多个值上报。
+// 连接断开期间跳过采样与上报，重连后继续；上报失败不会停止任务。
+// 返回的 stop 用于停止任务，可重复调用，Close 时所有任务自动停止。interval 不大于 0 时不启动任务
+func (d *Device) SchedulePropertyReport(propertyID uint16, interval time.Duration, sample func() interface{}) (stop func()) {
+	return d.schedule(interval, func() {
+		v := sample()
+		value, ok := v.([]interface{})
+		if !ok {
+			value = []interface{}{v}
+		}
+		if err := d.PostProperty(Property{PropertyID: propertyID, Value: value}); err != nil {
+			d.logf(log.LevelWarn, "scheduled report of property %d failed: %v", propertyID, err)
+		}
+	})
+}
+
+// schedule 按 interval 定时调用 task，连接断开期间与设备暂停期间跳过。返回的 stop 用于停止任务，可重复调用，
+// Close 时所有任务自动停止。interval 不大于 0 时不启动任务
+func (d *Device) schedule(interval time.Duration, task func()) (stop func()) {
+	done := make(chan struct{})
+	once := sync.Once{}
+	stop = func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+	if interval <= 0 {
+		stop()
+		return stop
+	}
+	id := d.schedules.add(stop)
+	d.goroutines.spawn(func() {
+		defer d.schedules.remove(id)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if !d.IsConnected() || d.Paused() {
+				continue
+			}
+			task()
+		}
+	})
+	return stop
+}
@@ -1,6 +1,7 @@
 package device
 
 import (
+	"encoding/json"
 	"errors"
 	serializer "iot-sdk-go/sdk/serializer"
 )
@@ -14,19 +15,24 @@ type RegisterArgs struct {
 
 // RegisterArgsFromDevice 从设备构建 RegisterArgs
 func RegisterArgsFromDevice(device Device) (*RegisterArgs, error) {
-	if device.ProductKey == "" {
+	return newRegisterArgs(device.ProductKey, device.Name, device.Version)
+}
+
+// newRegisterArgs 使用产品、名称与版本构建 RegisterArgs
+func newRegisterArgs(productKey, name, version string) (*RegisterArgs, error) {
+	if productKey == "" {
 		return nil, errors.New("field ProductKey cannot be empty")
 	}
-	if device.Name == "" {
+	if name == "" {
 		return nil, errors.New("field Name cannot be empty")
 	}
-	if device.Version == "" {
+	if version == "" {
 		return nil, errors.New("field Version cannot be empty")
 	}
 	r := &RegisterArgs{}
-	r.ProductKey = device.ProductKey
-	r.DeviceCode = device.Name
-	r.Version = device.Version
+	r.ProductKey = productKey
+	r.DeviceCode = name
+	r.Version = version
 	return r, nil
 }
 
@@ -34,6 +40,8 @@ func RegisterArgsFromDevice(device Device) (*RegisterArgs, error) {
 type RegisterResponse struct {
 	Common
 	Data RegisterData `json:"data"`
+	// Raw 完整的返回内容
+	Raw json.RawMessage `json:"-"`
 }
 
 // RegisterData 注册返回数据
@@ -42,6 +50,69 @@ type RegisterData struct {
 	Secret     string `json:"device_secret"`
 	Key        string `json:"device_key"`
 	Identifier string `json:"device_identifier"`
+	// Extra data 中未映射到以上字段的内容，如平台返回的区域、接入点、功能开关
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// StatusResponse 设备注册状态返回数据
+type StatusResponse struct {
+	Common
+	Data StatusData `json:"data"`
+}
+
+// StatusData 设备注册状态返回数据
+type StatusData struct {
+	Registered bool  `json:"registered"`
+	ID         int64 `json:"device_id"`
+}
+
+// RotateSecretArgs 密钥轮换参数，Secret 为当前密钥
+type RotateSecretArgs struct {
+	ID     int64  `json:"device_id" binding:"required"`
+	Secret string `json:"device_secret" binding:"required"`
+}
+
+// RotateSecretResponse 密钥轮换返回数据
+type RotateSecretResponse struct {
+	Common
+	Data RotateSecretData `json:"data"`
+}
+
+// RotateSecretData 密钥轮换返回数据
+type RotateSecretData struct {
+	Secret string `json:"device_secret"`
+}
+
+// ProvisionArgs 认领码开通参数
+type ProvisionArgs struct {
+	ProductKey string `json:"product_key" binding:"required"`
+	ClaimCode  string `json:"claim_code" binding:"required"`
+	// DeviceCode 设备名称，为空时由平台分配
+	DeviceCode string `json:"device_code,omitempty"`
+}
+
+// ProvisionResponse 认领码开通返回数据
+type ProvisionResponse struct {
+	Common
+	Data ProvisionData `json:"data"`
+}
+
+// ProvisionData 认领码开通返回数据
+type ProvisionData struct {
+	ID     int64  `json:"device_id"`
+	Name   string `json:"device_code"`
+	Secret string `json:"device_secret"`
+}
+
+// ActivateArgs 设备激活参数，使用注册得到的凭证
+type ActivateArgs struct {
+	ID     int64  `json:"device_id" binding:"required"`
+	Secret string `json:"device_secret" binding:"required"`
+}
+
+// ActivateResponse 设备激活返回数据
+type ActivateResponse struct {
+	Common
 }
 
 // AuthArgs 认证参数
@@ -49,24 +120,33 @@ type AuthArgs struct {
 	ID       int64  `json:"device_id" binding:"required"`
 	Secret   string `json:"device_secret" binding:"required"`
 	Protocol string `json:"protocol" binding:"required"`
+	// Compression 设备支持的压缩算法，为空时不压缩
+	Compression string `json:"compression,omitempty"`
 }
 
 // AuthArgsFromDevice 使用 Device 构建 AuthArgs
 func AuthArgsFromDevice(device Device) (*AuthArgs, error) {
-	if device.ID == 0 {
+	return newAuthArgs(Credentials{ID: device.ID, Secret: device.Secret}, device.Protocol.GetName(), device.Compression)
+}
+
+// newAuthArgs 使用认证信息构建 AuthArgs，protocol 为协议名称，compression 为 true 时声明支持 gzip
+func newAuthArgs(c Credentials, protocol string, compression bool) (*AuthArgs, error) {
+	if c.ID == 0 {
 		return nil, errors.New("field ID cannot be empty")
 	}
-	if device.Secret == "" {
+	if c.Secret == "" {
 		return nil, errors.New("field Secret cannot be empty")
 	}
-	protocol := device.Protocol.GetName()
 	if protocol == "" {
 		return nil, errors.New("field protocol cannot be empty")
 	}
 	ret := &AuthArgs{}
-	ret.ID = device.ID
-	ret.Secret = device.Secret
-	ret.Protocol = device.Protocol.GetName()
+	ret.ID = c.ID
+	ret.Secret = c.Secret
+	ret.Protocol = protocol
+	if compression {
+		ret.Compression = CompressionGzip
+	}
 	return ret, nil
 }
 
@@ -80,13 +160,31 @@ type Common struct {
 type AuthResponse struct {
 	Common
 	Data AuthData `json:"data"`
+	// Raw 完整的返回内容
+	Raw json.RawMessage `json:"-"`
 }
 
 // AuthData 认证返回数据
 type AuthData struct {
 	AccessToken string `json:"access_token"`
 	AccessAddr  string `json:"access_addr"`
+	// Compression 平台确认使用的压缩算法，不支持压缩的平台不返回
+	Compression string `json:"compression"`
+	// ExpiresIn Token 有效期，单位秒，不返回时视为未知
+	ExpiresIn int64 `json:"expires_in"`
+	// Extra data 中未映射到以上字段的内容，如平台返回的区域、接入点、功能开关
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 // Property 属性
 type Property serializer.Property
+
+// Bitmap 位图属性值，最多 serializer.BitmapWidth（32）位，TLV 中作为一个 uint32 传输
+type Bitmap = serializer.Bitmap
+
+// 属性数据质量码
+const (
+	QualityGood      = serializer.QualityGood
+	QualityUncertain = serializer.QualityUncertain
+	QualityBad       = serializer.QualityBad
+)
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/storage"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pendingSecretKey 轮换中、尚未确认的新密钥在 Storage 中的 key
+func (d *Device) pendingSecretKey() string {
+	return d.Name + ".PendingSecret"
+}
+
+// RotateSecret 轮换设备密钥。向平台申请新密钥后先保存为待确认密钥，使用新密钥登录成功后
+// 才替换内存与 Storage 中的密钥；平台拒绝新密钥时丢弃新密钥，继续使用旧密钥，
+// 网络、存储等其他错误时保留待确认密钥，下次调用时重新确认。
+// 上次轮换在确认前中断时，先尝试确认保存的待确认密钥，成功则不再申请新密钥
+func (d *Device) RotateSecret(ctx context.Context) error {
+	return d.flightDo("RotateSecret", func() error {
+		return d.traced("rotate_secret", func() error {
+			return d.rotateSecret(ctx)
+		})
+	})
+}
+
+func (d *Device) rotateSecret(ctx context.Context) error {
+	if c := d.Credentials(); c.ID == 0 || c.Secret == "" {
+		return errors.New("rotate secret failed, field ID and Secret cannot be empty")
+	}
+	if v, err := d.Storage.Get(d.pendingSecretKey()); err == nil && v != nil {
+		if pending, _ := typeconv.InterfaceToString(v); pending != "" {
+			// 平台拒绝时待确认密钥已失效，重新申请；其他错误时平台可能已经轮换，不能再申请
+			if err := d.confirmSecret(pending); err == nil || errors.Cause(err) != ErrLoginRejected {
+				return err
+			}
+		}
+	}
+	secret, err := d.requestSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if err := d.Storage.Set(d.pendingSecretKey(), secret); err != nil {
+		return errors.Wrap(err, "rotate secret failed, save pending secret failed")
+	}
+	return d.confirmSecret(secret)
+}
+
+// requestSecret 请求平台生成新密钥
+func (d *Device) requestSecret(ctx context.Context) (string, error) {
+	c := d.Credentials()
+	args, err := json.Marshal(RotateSecretArgs{ID: c.ID, Secret: c.Secret})
+	if err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, rotate arguments convert to json failed")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Topics.RotateSecret, strings.NewReader(string(args)))
+	if err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, create request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	jsonresp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, request rotate rest api failed")
+	}
+	defer jsonresp.Body.Close()
+	body, err := ioutil.ReadAll(jsonresp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, read response failed")
+	}
+	response := RotateSecretResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, rotate rest api response convert to json failed")
+	}
+	if err := HTTPIsOK(response); err != nil {
+		return "", errors.Wrap(err, "rotate secret failed, rotate rest api state not is ok")
+	}
+	if response.Data.Secret == "" {
+		return "", errors.New("rotate secret failed, rotate rest api returned empty secret")
+	}
+	return response.Data.Secret, nil
+}
+
+// confirmSecret 以新密钥登录，成功后一次性替换内存中的密钥与凭证并写入 Storage。
+// 平台拒绝新密钥时删除待确认密钥并恢复内存中的旧密钥，返回的错误以 ErrLoginRejected 为 Cause；
+// 网络、存储等其他错误时保留待确认密钥，下次轮换时重新确认
+func (d *Device) confirmSecret(secret string) error {
+	old := d.Credentials().Secret
+	if err := d.loginWith(secret); err != nil {
+		if errors.Cause(err) != ErrLoginRejected {
+			return errors.Wrap(err, "rotate secret failed, confirm new secret failed, pending secret kept")
+		}
+		d.lockAuth(func() {
+			d.Secret = old
+		})
+		d.Storage.Del(d.pendingSecretKey())
+		return errors.Wrap(err, "rotate secret failed, new secret rejected, rolled back")
+	}
+	if err := d.Storage.Set(d.Name+".Secret", secret); err != nil {
+		// 待确认密钥保留在 Storage 中，下次轮换时重新确认
+		return errors.Wrap(err, "rotate secret failed, save new secret failed")
+	}
+	d.Storage.Del(d.pendingSecretKey())
+	return errors.Wrap(storage.Flush(d.Storage), "rotate secret failed, flush storage failed")
+}
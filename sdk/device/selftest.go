@@ -0,0 +1,116 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSelfTestTimeout ctx 未设置超时时自检的超时时间
+const DefaultSelfTestTimeout = 10 * time.Second
+
+// 自检阶段
+const (
+	SelfTestConnect   = "connect"
+	SelfTestSubscribe = "subscribe"
+	SelfTestPublish   = "publish"
+	SelfTestReceive   = "receive"
+	SelfTestDecode    = "decode"
+)
+
+// SelfTestError 自检失败的阶段与原因
+type SelfTestError struct {
+	Stage string
+	Err   error
+}
+
+// Error 错误信息
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("self test failed at %s: %v", e.Stage, e.Err)
+}
+
+// Cause 失败原因，供 errors.Cause 使用
+func (e *SelfTestError) Cause() error {
+	return e.Err
+}
+
+// SelfTest 端到端自检：订阅 Topics.Loopback 下的临时主题，发布一个随机令牌，确认在超时前收到并解析出相同的令牌，
+// 验证发布、服务端转发、订阅与序列化的完整链路，结束后取消临时订阅。
+// 令牌作为属性序列化，经过 PayloadCodecs 变换，与实际上报的数据使用相同的格式。
+// 失败时返回 *SelfTestError，Stage 为失败的阶段
+func (d *Device) SelfTest(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultSelfTestTimeout)
+		defer cancel()
+	}
+	if !d.IsConnected() {
+		return &SelfTestError{Stage: SelfTestConnect, Err: errors.New("protocol client not connected")}
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return &SelfTestError{Stage: SelfTestPublish, Err: errors.Wrap(err, "generate token failed")}
+	}
+	token := hex.EncodeToString(buf)
+	topic := d.Topics.Loopback + "/" + token
+
+	received := make(chan []byte, 1)
+	if err := d.Subscribe(request.Request{
+		Topic: topic,
+		Qos:   1,
+		Callback: func(resp request.Response) {
+			select {
+			case received <- resp.Payload():
+			default:
+			}
+		},
+	}); err != nil {
+		return &SelfTestError{Stage: SelfTestSubscribe, Err: err}
+	}
+	defer func() {
+		if err := d.Unsubscribe([]string{topic}); err != nil {
+			d.logf(log.LevelWarn, "unsubscribe %s failed: %v", topic, err)
+		}
+	}()
+
+	probe := Property{Value: []interface{}{token}}
+	data, err := d.serializerFor(topic).MakePropertyData(probe.toSerializerProperty())
+	if err == nil {
+		data, err = d.encodePayload(data)
+	}
+	if err != nil {
+		return &SelfTestError{Stage: SelfTestPublish, Err: errors.Wrap(err, "encode token failed")}
+	}
+	r := &request.Request{}
+	r.Topic = topic
+	r.Qos = 1
+	r.Payload = data
+	if err := d.publishContext(ctx, PriorityNormal, protocol.OptionsFormatter(*r)); err != nil {
+		return &SelfTestError{Stage: SelfTestPublish, Err: err}
+	}
+
+	var payload []byte
+	select {
+	case payload = <-received:
+	case <-ctx.Done():
+		return &SelfTestError{Stage: SelfTestReceive, Err: errors.Wrap(ctx.Err(), "loopback message not received")}
+	}
+	if payload, err = d.decodePayload(payload); err != nil {
+		return &SelfTestError{Stage: SelfTestDecode, Err: err}
+	}
+	property, err := d.decoderFor(topic, payload).UnmarshalProperty(payload)
+	if err != nil {
+		return &SelfTestError{Stage: SelfTestDecode, Err: errors.Wrap(err, "unmarshal token failed")}
+	}
+	if len(property.Value) == 0 || fmt.Sprint(property.Value[0]) != token {
+		return &SelfTestError{Stage: SelfTestDecode, Err: errors.Errorf("token mismatch, got %v, want %s", property.Value, token)}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/storage"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Flush 将 Storage 中缓冲的数据落盘，保证凭证等状态在退出后不丢失，Storage 未实现 storage.Flusher 时不做任何操作
+func (d *Device) Flush() error {
+	if d.Storage == nil {
+		return nil
+	}
+	return errors.Wrap(storage.Flush(d.Storage), "flush storage failed")
+}
+
+// Close 关闭设备：停止所有定时上报任务，通过 Disconnect 断开连接，等待 SDK 创建的协程全部退出，
+// 最后将 Storage 落盘。Storage 可能由多个设备共用，Close 不关闭 Storage。
+// 之后 Publish 等返回 protocol.ErrNotConnected，AutoInit 会重新登录并创建客户端。
+// 返回前等待 SDK 创建的协程全部退出，因此不能在订阅回调、命令处理函数中调用
+func (d *Device) Close() error {
+	d.schedules.stopAll()
+	err := d.Disconnect()
+	d.goroutines.closeAndWait()
+	d.flushLogs()
+	if flushErr := d.Flush(); err == nil {
+		err = flushErr
+	}
+	return errors.Wrap(err, "close device failed")
+}
+
+// shutdownSignals 触发关闭钩子的信号
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// InstallShutdownHook 安装信号处理，收到 SIGINT、SIGTERM 后先恢复默认的信号处理，再依次对每个设备调用 Close，
+// 然后重新发送该信号，使进程按原有方式退出。Close 卡住时再次发送信号可以直接结束进程。
+// 返回的 stop 函数用于卸载信号处理，不需要时也可以不安装而自行调用 Close
+func InstallShutdownHook(devices ...*Device) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, shutdownSignals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			signal.Stop(ch)
+			shutdown(devices)
+			reraise(sig)
+		case <-done:
+		}
+	}()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// shutdown 关闭设备，单个设备失败不影响其他设备
+func shutdown(devices []*Device) {
+	for _, d := range devices {
+		if err := d.Close(); err != nil {
+			d.logf(log.LevelError, "%v", err)
+		}
+	}
+}
+
+// reraise 重新发送信号，无法发送时直接退出
+func reraise(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,144 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Generator 模拟属性值的生成器，每次上报调用一次 Next 得到属性值，返回 []interface{} 时作为多个值上报
+type Generator interface {
+	Next() interface{}
+}
+
+// GeneratorFunc 以函数实现 Generator
+type GeneratorFunc func() interface{}
+
+// Next 调用 f
+func (f GeneratorFunc) Next() interface{} {
+	return f()
+}
+
+// sineGenerator 正弦波生成器
+type sineGenerator struct {
+	amplitude float64
+	offset    float64
+	period    time.Duration
+	once      sync.Once
+	start     time.Time
+}
+
+// Sine 正弦波生成器，值为 offset + amplitude*sin(2π*t/period)，t 为首次生成以来的时间，
+// 适合模拟温度等周期变化的数据。period 不大于 0 时始终返回 offset
+func Sine(amplitude, offset float64, period time.Duration) Generator {
+	return &sineGenerator{amplitude: amplitude, offset: offset, period: period}
+}
+
+// Next 生成下一个值
+func (g *sineGenerator) Next() interface{} {
+	g.once.Do(func() {
+		g.start = time.Now()
+	})
+	if g.period <= 0 {
+		return g.offset
+	}
+	phase := float64(time.Since(g.start)) / float64(g.period)
+	return g.offset + g.amplitude*math.Sin(2*math.Pi*phase)
+}
+
+// randomWalkGenerator 随机游走生成器
+type randomWalkGenerator struct {
+	mu    sync.Mutex
+	value float64
+	step  float64
+	min   float64
+	max   float64
+	rand  *rand.Rand
+}
+
+// RandomWalk 随机游走生成器，首个值为 start，之后每次在上一个值的基础上加减不超过 step 的随机量，
+// 并限制在 [min, max] 之内，适合模拟湿度、电压等缓慢漂移的数据
+func RandomWalk(start, step, min, max float64) Generator {
+	return &randomWalkGenerator{
+		value: start,
+		step:  step,
+		min:   min,
+		max:   max,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next 生成下一个值
+func (g *randomWalkGenerator) Next() interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	v := g.value
+	g.value = math.Max(g.min, math.Min(g.max, v+(g.rand.Float64()*2-1)*g.step))
+	return v
+}
+
+// Fixed 固定值生成器，每次返回 value
+func Fixed(value interface{}) Generator {
+	return GeneratorFunc(func() interface{} {
+		return value
+	})
+}
+
+// enumCycleGenerator 枚举循环生成器
+type enumCycleGenerator struct {
+	mu     sync.Mutex
+	values []interface{}
+	next   int
+}
+
+// EnumCycle 枚举循环生成器，依次循环返回 values 中的值，适合模拟开关、运行模式等枚举属性。
+// values 为空时返回 nil
+func EnumCycle(values ...interface{}) Generator {
+	return &enumCycleGenerator{values: values}
+}
+
+// Next 生成下一个值
+func (g *enumCycleGenerator) Next() interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.values) == 0 {
+		return nil
+	}
+	v := g.values[g.next]
+	g.next = (g.next + 1) % len(g.values)
+	return v
+}
+
+// SimConfig 模拟上报配置
+type SimConfig struct {
+	// Interval 上报间隔，不大于 0 时不启动模拟
+	Interval time.Duration
+	// Properties 每个属性的生成器，key 为属性 ID
+	Properties map[uint16]Generator
+}
+
+// Simulate 以模拟数据驱动属性上报，用于没有真实传感器时对平台做压测：每隔 Interval 对 Properties 中的
+// 每个属性调用生成器并上报，行为与 SchedulePropertyReport 相同，连接断开期间跳过，上报失败不会停止模拟。
+// 返回的 stop 用于停止模拟，可重复调用，Close 时自动停止
+func (d *Device) Simulate(config SimConfig) (stop func()) {
+	ids := make([]uint16, 0, len(config.Properties))
+	for id := range config.Properties {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return d.schedule(config.Interval, func() {
+		for _, id := range ids {
+			v := config.Properties[id].Next()
+			value, ok := v.([]interface{})
+			if !ok {
+				value = []interface{}{v}
+			}
+			if err := d.PostProperty(Property{PropertyID: id, Value: value}); err != nil {
+				d.logf(log.LevelWarn, "simulate property %d failed: %v", id, err)
+			}
+		}
+	})
+}
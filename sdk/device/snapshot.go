@@ -0,0 +1,80 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/serializer"
+
+	"github.com/pkg/errors"
+)
+
+// PropertySnapshotCommandID 平台"上报全部属性"命令的 ID，在 Topics.OnCommand 上下发，不带参数
+var PropertySnapshotCommandID uint16 = 0xFFFF
+
+// ErrNoPropertySnapshot 未通过 WithPropertySnapshot 注册属性快照
+var ErrNoPropertySnapshot = errors.New("property snapshot provider not registered")
+
+// ReplyError 带回复状态码的命令处理错误，Handler、ContextHandler 返回该错误时以 Code 回复，而不是 ReplyCodeError
+type ReplyError struct {
+	Code    int
+	Message string
+}
+
+// Error 错误信息
+func (e *ReplyError) Error() string {
+	return e.Message
+}
+
+// WithPropertySnapshot 注册属性快照，snapshot 返回设备当前所有属性的值。
+// 注册后 OnCommand 内置处理 PropertySnapshotCommandID 命令，收到时调用 PostPropertySnapshot 上报
+func WithPropertySnapshot(snapshot func() []Property) Option {
+	return func(d *Device) {
+		d.PropertySnapshot = snapshot
+	}
+}
+
+// PostPropertySnapshot 调用 PropertySnapshot 获取所有属性并作为一批立即上报，返回上报的属性数，
+// 序列化器不支持批量时逐条上报。未注册属性快照时返回 ErrNoPropertySnapshot
+func (d *Device) PostPropertySnapshot() (int, error) {
+	if d.PropertySnapshot == nil {
+		return 0, ErrNoPropertySnapshot
+	}
+	properties := d.PropertySnapshot()
+	if len(properties) == 0 {
+		return 0, nil
+	}
+	for i := range properties {
+		properties[i] = d.withUnit(properties[i])
+	}
+	request, err := d.makeBatchPropertyRequest(properties)
+	if errors.Cause(err) == errBatchUnsupported {
+		for _, property := range properties {
+			if err := d.PostPropertyWithPriority(property, PriorityHigh); err != nil {
+				return 0, errors.Wrap(err, "post property snapshot failed")
+			}
+		}
+		return len(properties), nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "post property snapshot failed")
+	}
+	if err := d.publishWithPriority(PriorityHigh, request); err != nil {
+		return 0, errors.Wrap(err, "post property snapshot failed")
+	}
+	return len(properties), nil
+}
+
+// snapshotCommand 内置的"上报全部属性"命令，未注册属性快照时回复 ReplyCodeNotImplemented
+func (d *Device) snapshotCommand() Command {
+	return Command{
+		ID: PropertySnapshotCommandID,
+		ContextHandler: func(ctx CommandContext) (interface{}, error) {
+			n, err := d.PostPropertySnapshot()
+			if err == ErrNoPropertySnapshot {
+				return nil, &ReplyError{Code: serializer.ReplyCodeNotImplemented, Message: err.Error()}
+			}
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"count": n}, nil
+		},
+	}
+}
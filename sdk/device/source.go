@@ -0,0 +1,78 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PropertySource 属性数据源，如 Modbus、OPC UA 等现场总线的读取适配器。
+// Read 可以同时返回已读取的属性与错误，表示部分读取失败，返回的属性仍然上报
+type PropertySource interface {
+	Read() ([]Property, error)
+}
+
+// PropertySourceFunc 函数形式的 PropertySource
+type PropertySourceFunc func() ([]Property, error)
+
+// Read 调用函数读取属性
+func (f PropertySourceFunc) Read() ([]Property, error) {
+	return f()
+}
+
+// ReportError 从数据源上报属性时部分或全部失败
+type ReportError struct {
+	// ReadErr 数据源 Read 返回的错误，为 nil 表示读取成功
+	ReadErr error
+	// Failed 上报失败的属性
+	Failed []PropertyError
+	// Reported 上报成功的属性数
+	Reported int
+}
+
+// PropertyError 上报失败的属性及原因
+type PropertyError struct {
+	Property Property
+	Err      error
+}
+
+// Error 错误信息
+func (e *ReportError) Error() string {
+	var msgs []string
+	if e.ReadErr != nil {
+		msgs = append(msgs, "read source: "+e.ReadErr.Error())
+	}
+	for _, f := range e.Failed {
+		msgs = append(msgs, fmt.Sprintf("post property %d of sub device %d: %v", f.Property.PropertyID, f.Property.SubDeviceID, f.Err))
+	}
+	return fmt.Sprintf("report from source failed, %d reported, %d failed: %s", e.Reported, len(e.Failed), strings.Join(msgs, "; "))
+}
+
+// ReportFromSource 从数据源读取属性并逐个上报。读取部分失败时仍上报已读取的属性，
+// 单个属性上报失败时继续上报其余属性，存在任何失败时返回 *ReportError
+func (d *Device) ReportFromSource(source PropertySource) error {
+	properties, readErr := source.Read()
+	report := &ReportError{ReadErr: readErr}
+	for _, p := range properties {
+		if err := d.PostProperty(p); err != nil {
+			report.Failed = append(report.Failed, PropertyError{Property: p, Err: err})
+			continue
+		}
+		report.Reported++
+	}
+	if report.ReadErr == nil && len(report.Failed) == 0 {
+		return nil
+	}
+	return report
+}
+
+// ScheduleSourceReport 按 interval 定时调用 ReportFromSource，连接断开期间跳过读取与上报。
+// 失败时以 *ReportError 调用 onError，onError 可以为 nil，失败不会停止任务。
+// 返回的 stop 用于停止任务，可重复调用，Close 时所有任务自动停止。interval 不大于 0 时不启动任务
+func (d *Device) ScheduleSourceReport(source PropertySource, interval time.Duration, onError func(error)) (stop func()) {
+	return d.schedule(interval, func() {
+		if err := d.ReportFromSource(source); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
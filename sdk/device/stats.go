@@ -0,0 +1,160 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/trace"
+	"sync"
+	"time"
+)
+
+// Stats 设备运行统计
+type Stats struct {
+	// MessagesSent 发布成功的消息数
+	MessagesSent uint64
+	// MessagesReceived 订阅收到的消息数
+	MessagesReceived uint64
+	// PublishErrors 发布失败的消息数
+	PublishErrors uint64
+	// Disconnects 连接断开次数
+	Disconnects uint64
+	// DroppedMessages 接收缓冲区满时丢弃的消息数
+	DroppedMessages uint64
+	// OfflineDropped 离线队列满或消息过期丢弃的消息数
+	OfflineDropped uint64
+	// LogsSuppressed 因日志去重而未输出的日志条数
+	LogsSuppressed uint64
+	// LastDisconnectReason 最近一次连接断开原因
+	LastDisconnectReason protocol.DisconnectReason
+	// LastError 最近一次发布失败或连接断开的错误，没有错误时为 nil
+	LastError error
+	// LastErrorAt 最近一次错误的时间
+	LastErrorAt time.Time
+}
+
+// stats 运行统计，为 nil 时（未通过 New 创建设备）不统计
+type stats struct {
+	mu    sync.Mutex
+	value Stats
+}
+
+// Stats 获取设备运行统计
+func (d *Device) Stats() Stats {
+	ret := Stats{}
+	if d.stats != nil {
+		d.stats.mu.Lock()
+		ret = d.stats.value
+		d.stats.mu.Unlock()
+	}
+	ret.DroppedMessages = d.DroppedMessages()
+	ret.OfflineDropped = d.OfflineDropped()
+	ret.LogsSuppressed = d.LogsSuppressed()
+	return ret
+}
+
+// recordError 记录最近一次错误，调用方需持有锁
+func (s *stats) recordError(err error) {
+	if err == nil {
+		return
+	}
+	s.value.LastError = err
+	s.value.LastErrorAt = time.Now()
+}
+
+// recordPublish 记录发布结果
+func (s *stats) recordPublish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.value.PublishErrors++
+		s.recordError(err)
+		return
+	}
+	s.value.MessagesSent++
+}
+
+// recordReceive 记录收到的消息
+func (s *stats) recordReceive() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value.MessagesReceived++
+}
+
+// recordDisconnect 记录连接断开
+func (s *stats) recordDisconnect(reason protocol.DisconnectReason, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value.Disconnects++
+	s.value.LastDisconnectReason = reason
+	s.recordError(err)
+}
+
+// publish 以默认优先级发布消息
+func (d *Device) publish(opts map[string]interface{}, attrs ...trace.Attribute) error {
+	return d.publishWithPriority(PriorityNormal, opts, attrs...)
+}
+
+// publishWithPriority 按优先级排队发布消息，发送前等待 PublishLimiter，设备暂停时按 PauseMode 处理
+func (d *Device) publishWithPriority(p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	return d.publishWithPriorityContext(context.Background(), p, opts, attrs...)
+}
+
+// publishWithPriorityContext 与 publishWithPriority 相同，ctx 结束时排队中的消息不再发送
+func (d *Device) publishWithPriorityContext(ctx context.Context, p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	if d.Paused() {
+		return d.publishPaused(p, opts, attrs...)
+	}
+	return d.publishControlContext(ctx, p, opts, attrs...)
+}
+
+// publishControl 按优先级排队发布不受暂停影响的控制消息，如命令回复、诊断回复、重启确认与维护状态
+func (d *Device) publishControl(p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	return d.publishControlContext(context.Background(), p, opts, attrs...)
+}
+
+// publishControlContext 与 publishControl 相同，ctx 结束时排队中的消息不再发送
+func (d *Device) publishControlContext(ctx context.Context, p Priority, opts map[string]interface{}, attrs ...trace.Attribute) error {
+	limiter := d.PublishLimiter
+	send := func() error {
+		if limiter != nil {
+			limiter.Wait()
+		}
+		return d.send(opts, attrs...)
+	}
+	if d.queue == nil {
+		return send()
+	}
+	return d.queue.do(ctx, p, limiter != nil, send)
+}
+
+// send 发布消息并记录统计与 Span，attrs 为 Span 的附加属性
+func (d *Device) send(opts map[string]interface{}, attrs ...trace.Attribute) error {
+	topic, _ := opts["Topic"].(string)
+	qos, _ := opts["Qos"].(byte)
+	payload, _ := opts["Payload"].([]byte)
+	attrs = append(attrs,
+		trace.String(trace.AttrTopic, topic),
+		trace.Int(trace.AttrQos, int(qos)),
+		trace.Int(trace.AttrPayloadSize, len(payload)),
+	)
+	span := d.startSpan("publish", attrs...)
+	defer span.End()
+	err := d.Protocol.Publish(opts)
+	span.RecordError(err)
+	d.stats.recordPublish(err)
+	d.adaptKeepalive(err)
+	if err != nil {
+		d.logf(log.LevelWarn, "publish %s failed: %v", topic, err)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// subDevices 网关下在线的子设备
+type subDevices struct {
+	mu     sync.Mutex
+	online map[int64]struct{}
+}
+
+// newSubDevices 创建 subDevices 对象
+func newSubDevices() *subDevices {
+	return &subDevices{online: make(map[int64]struct{})}
+}
+
+// set 记录子设备状态
+func (s *subDevices) set(id int64, online bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if online {
+		s.online[id] = struct{}{}
+		return
+	}
+	delete(s.online, id)
+}
+
+// list 在线的子设备，按 ID 升序排列
+func (s *subDevices) list() []int64 {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int64, 0, len(s.online))
+	for id := range s.online {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ReportSubDeviceStatus 网关上报子设备上线、下线，发布到 Topics.SubDeviceStatus。
+// 状态作为事件序列化，SubDeviceID 为子设备 ID，参数为是否在线。
+// 序列化格式中子设备 ID 为 uint16，超出范围时返回错误
+func (d *Device) ReportSubDeviceStatus(subDeviceID int64, online bool) error {
+	if subDeviceID < 0 || subDeviceID > math.MaxUint16 {
+		return errors.Errorf("report sub device status failed, sub device id %d out of range", subDeviceID)
+	}
+	status := Property{
+		SubDeviceID: uint16(subDeviceID),
+		Value:       []interface{}{online},
+	}
+	data, err := d.serializerFor(d.Topics.SubDeviceStatus).MakeEventData(status.toSerializerProperty())
+	if err == nil {
+		data, err = d.encodePayload(data)
+	}
+	if err != nil {
+		return errors.Wrap(err, "report sub device status failed")
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.SubDeviceStatus
+	r.Qos = 1
+	r.Payload = data
+	if err := d.publish(protocol.OptionsFormatter(*r)); err != nil {
+		return errors.Wrap(err, "report sub device status failed")
+	}
+	d.subDevices.set(subDeviceID, online)
+	return nil
+}
+
+// reportSubDevicesOffline 上报所有在线的子设备下线
+func (d *Device) reportSubDevicesOffline() {
+	for _, id := range d.subDevices.list() {
+		if err := d.ReportSubDeviceStatus(id, false); err != nil {
+			d.logf(log.LevelWarn, "report sub device %d offline failed: %v", id, err)
+		}
+	}
+}
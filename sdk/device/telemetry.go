@@ -0,0 +1,193 @@
+package device
+
+import (
+	"iot-sdk-go/sdk/log"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/serializer"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxBatch 批量上报时每批默认的最大属性数
+const DefaultMaxBatch = 50
+
+// BatcherConfig 属性批量上报配置
+type BatcherConfig struct {
+	// MaxBatch 每批最多的属性数，缓冲达到该数量时立即发送，不大于 0 时使用 DefaultMaxBatch
+	MaxBatch int
+	// FlushInterval 定时发送缓冲的间隔，为 0 时只在缓冲满、调用 FlushTelemetry 或 Close 时发送
+	FlushInterval time.Duration
+	// Compress 使用 gzip 压缩每批数据，不经过平台协商，双方需事先约定
+	Compress bool
+}
+
+// WithTelemetryBatcher 开启属性批量上报，PostProperty 上报的属性先放入缓冲，
+// 每 FlushInterval、缓冲达到 MaxBatch 时作为一条消息发送
+func WithTelemetryBatcher(config BatcherConfig) Option {
+	return func(d *Device) {
+		d.TelemetryBatcher = &config
+	}
+}
+
+// maxBatch 每批最多的属性数
+func (c *BatcherConfig) maxBatch() int {
+	if c.MaxBatch <= 0 {
+		return DefaultMaxBatch
+	}
+	return c.MaxBatch
+}
+
+// telemetry 批量上报缓冲，为 nil 时（未通过 New 创建设备）不缓冲
+type telemetry struct {
+	mu         sync.Mutex
+	properties []Property
+	dropped    uint64
+	started    bool
+	// flushing 保证同一时间只有一个协程发送，避免批次乱序
+	flushing sync.Mutex
+}
+
+// add 放入缓冲，返回缓冲是否已满，首次放入时 start 为 true，需要启动定时发送
+func (t *telemetry) add(property Property, max int) (full, start bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.properties = append(t.properties, property)
+	start = !t.started
+	t.started = true
+	return len(t.properties) >= max, start
+}
+
+// take 取出最多 max 个属性
+func (t *telemetry) take(max int) []Property {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.properties)
+	if n > max {
+		n = max
+	}
+	ret := append([]Property{}, t.properties[:n]...)
+	t.properties = append(t.properties[:0], t.properties[n:]...)
+	return ret
+}
+
+// bufferTelemetry 放入批量上报缓冲，缓冲满时在当前协程发送。未设置采集时间时以放入缓冲的时间作为采集时间
+func (d *Device) bufferTelemetry(property Property) error {
+	full, start := d.telemetry.add(stamped(property), d.TelemetryBatcher.maxBatch())
+	if start {
+		d.schedule(d.TelemetryBatcher.FlushInterval, func() {
+			if err := d.FlushTelemetry(); err != nil {
+				d.logf(log.LevelError, "%v", err)
+			}
+		})
+	}
+	if !full {
+		return nil
+	}
+	return d.FlushTelemetry()
+}
+
+// FlushTelemetry 立即发送批量上报缓冲中的属性，超过 MaxBatch 时分多批发送。
+// 发送失败的批次在设置了离线队列时放入离线队列，否则丢弃并计入 TelemetryDropped
+func (d *Device) FlushTelemetry() error {
+	if d.telemetry == nil || d.TelemetryBatcher == nil {
+		return nil
+	}
+	d.telemetry.flushing.Lock()
+	defer d.telemetry.flushing.Unlock()
+	for {
+		batch := d.telemetry.take(d.TelemetryBatcher.maxBatch())
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := d.publishBatch(batch); err != nil {
+			return errors.Wrapf(err, "flush telemetry failed, %d properties", len(batch))
+		}
+	}
+}
+
+// TelemetryBuffered 批量上报缓冲中等待发送的属性数
+func (d *Device) TelemetryBuffered() int {
+	if d.telemetry == nil {
+		return 0
+	}
+	d.telemetry.mu.Lock()
+	defer d.telemetry.mu.Unlock()
+	return len(d.telemetry.properties)
+}
+
+// TelemetryDropped 批量上报发送失败且未放入离线队列而丢弃的属性数
+func (d *Device) TelemetryDropped() uint64 {
+	if d.telemetry == nil {
+		return 0
+	}
+	d.telemetry.mu.Lock()
+	defer d.telemetry.mu.Unlock()
+	return d.telemetry.dropped
+}
+
+// publishBatch 将一批属性序列化为一条消息发送，序列化器不支持批量时逐条发送
+func (d *Device) publishBatch(batch []Property) error {
+	properties := make([]*serializer.Property, len(batch))
+	for i := range batch {
+		properties[i] = batch[i].toSerializerProperty()
+	}
+	s := d.serializerFor(d.Topics.PostProperty).(serializer.BatchSerializer)
+	data, err := s.MakeBatchPropertyData(properties)
+	if errors.Cause(err) == errBatchUnsupported {
+		return d.publishEach(batch)
+	}
+	if err == nil {
+		if d.TelemetryBatcher.Compress && !d.CompressionEnabled() {
+			data, err = gzipCodec{}.Encode(data)
+		} else {
+			data, err = d.compress(data)
+		}
+	}
+	if err == nil {
+		data, err = d.encodePayload(data)
+	}
+	if err != nil {
+		d.dropTelemetry(len(batch))
+		return err
+	}
+	return d.publishTelemetry(protocol.OptionsFormatter(*makePostPropertyRequest(d, data)), len(batch))
+}
+
+// publishEach 逐条发送，单条失败不影响其他属性，返回第一个错误
+func (d *Device) publishEach(batch []Property) error {
+	var first error
+	for _, property := range batch {
+		request, err := d.makePropertyRequest(property)
+		if err == nil {
+			err = d.publishTelemetry(request, 1)
+		} else {
+			d.dropTelemetry(1)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// publishTelemetry 发送包含 n 个属性的消息，失败时放入离线队列，离线队列长度为 0 时丢弃
+func (d *Device) publishTelemetry(request map[string]interface{}, n int) error {
+	err := d.publish(request)
+	if err == nil {
+		return nil
+	}
+	if !d.enqueueOffline(PriorityNormal, request) {
+		d.dropTelemetry(n)
+		return err
+	}
+	return nil
+}
+
+// dropTelemetry 记录丢弃的属性数
+func (d *Device) dropTelemetry(n int) {
+	d.telemetry.mu.Lock()
+	defer d.telemetry.mu.Unlock()
+	d.telemetry.dropped += uint64(n)
+}
@@ -0,0 +1,64 @@
+package device
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// WithTLSConfig 使用 cfg 建立 TLS 连接，登录返回的接入地址没有协议时按 TLS 连接，
+// tcp://、mqtt:// 的接入地址也升级为 TLS。不能与 WithTLSPSK 同时使用
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(d *Device) {
+		d.TLSConfig = cfg
+	}
+}
+
+// WithCACert 使用 path 中 PEM 格式的 CA 证书校验服务端证书，代替系统的根证书，
+// 证书在创建协议客户端时读取，读取失败时 InitProtocolClient 返回错误
+func WithCACert(path string) Option {
+	return func(d *Device) {
+		d.CACertFile = path
+	}
+}
+
+// WithClientCert 使用 PEM 格式的客户端证书与私钥进行双向认证（mTLS），
+// 证书在创建协议客户端时读取，读取失败时 InitProtocolClient 返回错误
+func WithClientCert(certPath, keyPath string) Option {
+	return func(d *Device) {
+		d.ClientCertFile = certPath
+		d.ClientKeyFile = keyPath
+	}
+}
+
+// tlsConfig 合并 TLSConfig 与证书文件，都没有设置时返回 nil，不修改 TLSConfig
+func (d *Device) tlsConfig() (*tls.Config, error) {
+	if d.TLSConfig == nil && d.CACertFile == "" && d.ClientCertFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if d.TLSConfig != nil {
+		cfg = d.TLSConfig.Clone()
+	}
+	if d.CACertFile != "" {
+		pem, err := ioutil.ReadFile(d.CACertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca cert failed")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in ca cert %s", d.CACertFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if d.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(d.ClientCertFile, d.ClientKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client cert failed")
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,50 @@
+package device
+
+import (
+	"context"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/trace"
+)
+
+// WithTracer 设置链路追踪器，对发布、订阅回调、注册、登录创建 Span
+func WithTracer(tracer trace.Tracer) Option {
+	return func(d *Device) {
+		d.Tracer = tracer
+	}
+}
+
+// startSpan 创建 Span，未设置追踪器时返回不记录数据的 Span
+func (d *Device) startSpan(name string, attrs ...trace.Attribute) trace.Span {
+	tracer := d.Tracer
+	if tracer == nil {
+		tracer = trace.NopTracer{}
+	}
+	attrs = append(attrs, trace.String(trace.AttrDeviceName, d.Name))
+	_, span := tracer.Start(context.Background(), name, attrs...)
+	return span
+}
+
+// traced 在 Span 中执行 fn，记录返回的错误
+func (d *Device) traced(name string, fn func() error) error {
+	span := d.startSpan(name)
+	defer span.End()
+	err := fn()
+	span.RecordError(err)
+	return err
+}
+
+// traceCallback 包装订阅回调，每条消息的处理创建一个 Span
+func (d *Device) traceCallback(callback func(request.Response)) func(request.Response) {
+	if callback == nil || d.Tracer == nil {
+		return callback
+	}
+	return func(resp request.Response) {
+		span := d.startSpan("receive",
+			trace.String(trace.AttrTopic, resp.Topic()),
+			trace.Int(trace.AttrQos, int(resp.Qos())),
+			trace.Int(trace.AttrPayloadSize, len(resp.Payload())),
+		)
+		defer span.End()
+		callback(resp)
+	}
+}
@@ -1,10 +1,64 @@
 package device
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"reflect"
+	"strings"
 )
 
+// ErrIncompleteResponse 平台的 HTTP 响应体没有完整读取，通常是连接在响应中途断开
+var ErrIncompleteResponse = errors.New("incomplete http response")
+
+// IncompleteResponseError 读取响应体失败，Body 为已读取的部分，errors.Cause 返回 ErrIncompleteResponse
+type IncompleteResponseError struct {
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+// Error 错误信息
+func (e *IncompleteResponseError) Error() string {
+	return fmt.Sprintf("%s, read %d bytes: %v", ErrIncompleteResponse, len(e.Body), e.Err)
+}
+
+// Cause 返回 ErrIncompleteResponse
+func (e *IncompleteResponseError) Cause() error {
+	return ErrIncompleteResponse
+}
+
+// Unwrap 返回 ErrIncompleteResponse
+func (e *IncompleteResponseError) Unwrap() error {
+	return ErrIncompleteResponse
+}
+
+// HTTPStatusError 平台返回了非 2xx 状态码，且响应体不是 JSON，无法得到平台的错误信息
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Error 错误信息
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %d: %q", e.StatusCode, e.Body)
+}
+
+// readResponse 读取响应体，读取失败时返回 *IncompleteResponseError；
+// 状态码不是 2xx 且响应体不是 JSON 时返回 *HTTPStatusError，是 JSON 时由调用方按平台的错误码处理
+func readResponse(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return body, &IncompleteResponseError{StatusCode: resp.StatusCode, Body: body, Err: err}
+	}
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && !json.Valid(body) {
+		return body, &HTTPStatusError{StatusCode: resp.StatusCode, Body: body}
+	}
+	return body, nil
+}
+
 // HTTPIsOK 状态码是否正常
 func HTTPIsOK(resp interface{}) error {
 	res := reflect.ValueOf(resp)
@@ -20,3 +74,24 @@ func HTTPIsOK(resp interface{}) error {
 	}
 	return errors.New("response format error")
 }
+
+// extraFields 返回内容 body 的 data 中没有映射到 known 结构体字段的内容，没有时返回 nil
+func extraFields(body []byte, known interface{}) map[string]json.RawMessage {
+	envelope := struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil
+	}
+	t := reflect.TypeOf(known)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			delete(envelope.Data, name)
+		}
+	}
+	if len(envelope.Data) == 0 {
+		return nil
+	}
+	return envelope.Data
+}
@@ -0,0 +1,99 @@
+package device
+
+import (
+	"encoding/json"
+	"iot-sdk-go/sdk/protocol"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/serializer"
+
+	"github.com/pkg/errors"
+)
+
+// PropertyConflict 平台拒绝的带版本属性上报，平台上的属性版本比上报时期望的版本新
+type PropertyConflict struct {
+	SubDeviceID uint16 `json:"sub_device_id"`
+	PropertyID  uint16 `json:"property_id"`
+	// ExpectedVersion 上报时期望的版本
+	ExpectedVersion uint64 `json:"expected_version"`
+	// CurrentVersion 平台上的当前版本
+	CurrentVersion uint64 `json:"version"`
+	Message        string `json:"message,omitempty"`
+}
+
+// propertyReply 属性上报回复
+type propertyReply struct {
+	Code int `json:"code"`
+	PropertyConflict
+}
+
+// PostPropertyVersioned 带版本上报属性，平台上的版本与 expectedVersion 不一致时拒绝更新，
+// 在 Topics.PropertyReply 上回复冲突，通过 OnPropertyConflict 接收。expectedVersion 为 0 时与 PostProperty 相同
+func (d *Device) PostPropertyVersioned(property Property, expectedVersion uint64) error {
+	property.Version = expectedVersion
+	return d.PostProperty(property)
+}
+
+// OnPropertyConflict 订阅 Topics.PropertyReply，带版本的属性上报被平台拒绝时调用 callback，其他回复忽略
+func (d *Device) OnPropertyConflict(callback func(PropertyConflict)) error {
+	callbackFn := func(resp request.Response) {
+		reply := propertyReply{}
+		if err := json.Unmarshal(resp.Payload(), &reply); err != nil {
+			d.commandError(resp.Topic(), errors.Wrap(err, "unmarshal property reply failed"))
+			return
+		}
+		if reply.Code == serializer.ReplyCodeConflict {
+			callback(reply.PropertyConflict)
+		}
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.PropertyReply
+	r.Qos = 1
+	r.Callback = d.bufferCallback(callbackFn)
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
+
+// OnSetProperty 订阅 Topics.SetProperty，收到平台设置的属性后调用 handler，Property.Version 为平台上的当前版本，
+// 应用后以该版本调用 PostPropertyVersioned 上报。解析失败时调用 OnCommandError 设置的回调
+func (d *Device) OnSetProperty(handler func(Property)) error {
+	if d.Topics.SetProperty == "" {
+		return errors.New("on set property failed, topic SetProperty is empty")
+	}
+	callbackFn := func(resp request.Response) {
+		if err := d.checkPayloadSize(resp); err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		p, err := d.decodePayload(resp.Payload())
+		if err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		property, err := d.decoderFor(resp.Topic(), p).UnmarshalProperty(p)
+		if err != nil {
+			d.commandError(resp.Topic(), errors.Wrap(err, "unmarshal property failed"))
+			return
+		}
+		if d.Paused() {
+			d.commandError(resp.Topic(), errors.Wrapf(ErrPaused, "set property %d ignored", property.PropertyID))
+			return
+		}
+		if err := d.checkNamespace(Property(*property)); err != nil {
+			d.commandError(resp.Topic(), err)
+			return
+		}
+		handler(Property(*property))
+	}
+	r := &request.Request{}
+	r.Topic = d.Topics.SetProperty
+	r.Qos = 1
+	r.Callback = d.bufferCallback(callbackFn)
+	if err := d.Protocol.Subscribe(protocol.OptionsFormatter(*r)); err != nil {
+		return err
+	}
+	d.handlers.set(r.Topic, r.Callback)
+	return nil
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger 日志接口，SDK 通过它输出运行日志，实现需要支持并发调用
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// Level 日志级别
+type Level int
+
+const (
+	// LevelDebug 调试信息，如协商结果、被忽略的选项
+	LevelDebug Level = iota
+	// LevelInfo 运行状态，如连接建立
+	LevelInfo
+	// LevelWarn 可以自动恢复的错误，如连接断开、发布失败
+	LevelWarn
+	// LevelError 需要处理的错误，如命令解析失败
+	LevelError
+)
+
+// String 日志级别名称
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Logf 按级别调用 logger 对应的方法
+func Logf(logger Logger, level Level, format string, v ...interface{}) {
+	switch level {
+	case LevelDebug:
+		logger.Debugf(format, v...)
+	case LevelInfo:
+		logger.Infof(format, v...)
+	case LevelWarn:
+		logger.Warnf(format, v...)
+	default:
+		logger.Errorf(format, v...)
+	}
+}
+
+// nop 不输出任何日志
+type nop struct{}
+
+func (nop) Debugf(format string, v ...interface{}) {}
+func (nop) Infof(format string, v ...interface{})  {}
+func (nop) Warnf(format string, v ...interface{})  {}
+func (nop) Errorf(format string, v ...interface{}) {}
+
+// Nop 不输出任何日志的 Logger
+var Nop Logger = nop{}
+
+// std 以标准库 log.Logger 输出的 Logger
+type std struct {
+	logger *log.Logger
+	level  Level
+}
+
+// NewStd 创建以标准库 log.Logger 输出的 Logger，低于 level 的日志不输出，每条日志以级别名称开头
+func NewStd(logger *log.Logger, level Level) Logger {
+	return &std{logger: logger, level: level}
+}
+
+// output 输出不低于 s.level 的日志
+func (s *std) output(level Level, format string, v ...interface{}) {
+	if level < s.level {
+		return
+	}
+	s.logger.Output(3, "["+level.String()+"] "+fmt.Sprintf(format, v...))
+}
+
+// Debugf 输出调试日志
+func (s *std) Debugf(format string, v ...interface{}) { s.output(LevelDebug, format, v...) }
+
+// Infof 输出运行状态日志
+func (s *std) Infof(format string, v ...interface{}) { s.output(LevelInfo, format, v...) }
+
+// Warnf 输出警告日志
+func (s *std) Warnf(format string, v ...interface{}) { s.output(LevelWarn, format, v...) }
+
+// Errorf 输出错误日志
+func (s *std) Errorf(format string, v ...interface{}) { s.output(LevelError, format, v...) }
@@ -0,0 +1,19 @@
+package log
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestStd(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewStd(log.New(buf, "", 0), LevelWarn)
+	l.Debugf("debug %d", 1)
+	l.Infof("info %d", 2)
+	l.Warnf("warn %d", 3)
+	Logf(l, LevelError, "error %d", 4)
+	if got := buf.String(); got != "[WARN] warn 3\n[ERROR] error 4\n" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,378 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"iot-sdk-go/pkg/coap"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/topics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDTLSUnsupported CoAP 客户端不支持 DTLS，设置了 TLS 配置时返回，避免以明文发送
+var ErrDTLSUnsupported = errors.New("coap over dtls is not supported")
+
+// ErrCoAPTopic CoAP 的主题对应资源路径，不支持通配符与共享订阅
+var ErrCoAPTopic = errors.New("coap does not support wildcard or shared topics")
+
+// CoAP 实现，发布为 POST 请求，订阅为观察（Observe）资源，主题即资源路径。
+// QoS 为 0 时以 NON 消息发送，否则以 CON 消息发送并等待服务端确认。
+// 认证参数 clientid、username、password 作为 URIQuery 附加到每个请求，
+// 服务端返回 4.01 时按 OnConnectionLost 重新获取密码并重试一次
+type CoAP struct {
+	// Server 服务端地址，为空时使用 MakeOpts 参数中的 Broker，即登录返回的接入地址
+	Server string
+	// AckTimeout 首次等待 ACK 的时间，为 0 时使用 coap.DefaultAckTimeout
+	AckTimeout time.Duration
+	// MaxRetransmit 最大重传次数，为 0 时使用 coap.DefaultMaxRetransmit
+	MaxRetransmit int
+
+	mu           sync.Mutex
+	client       *coap.Client
+	opts         *CoAPOptions
+	observations map[string]*coap.Observation
+}
+
+// CoAPOptions CoAP 客户端配置，由 MakeOpts 创建
+type CoAPOptions struct {
+	coap.ClientOptions
+	ClientID string
+	Username string
+	Password string
+	// OnConnect 客户端创建后调用
+	OnConnect func()
+	// OnDisconnect 服务端返回 4.01 时以 DisconnectAuthFailed 调用
+	OnDisconnect func(DisconnectReason, error)
+	// OnConnectionLost 服务端返回 4.01 时调用，返回值中的 Password（[]byte）为新的密码
+	OnConnectionLost func(DisconnectReason) map[string]interface{}
+}
+
+// queries 认证参数
+func (o *CoAPOptions) queries() []string {
+	var ret []string
+	for _, kv := range [][2]string{{"clientid", o.ClientID}, {"username", o.Username}, {"password", o.Password}} {
+		if kv[1] != "" {
+			ret = append(ret, kv[0]+"="+kv[1])
+		}
+	}
+	return ret
+}
+
+// NewCoAP 创建 CoAP 对象
+func NewCoAP() *CoAP {
+	return &CoAP{}
+}
+
+// MakeOpts 创建配置项，只使用 Broker、ClientID、Username、Password、OnConnect、OnDisconnect、
+// OnConnectionLost，其他 MQTT 专用的参数忽略。设置了 TLSConfig 时返回 ErrDTLSUnsupported
+func (c *CoAP) MakeOpts(params map[string]interface{}) (interface{}, error) {
+	if tlsConfig, _ := (params["TLSConfig"]).(*tls.Config); tlsConfig != nil {
+		return nil, errors.Wrap(ErrDTLSUnsupported, "make coap options failed")
+	}
+	server := c.Server
+	if server == "" {
+		broker, err := typeconv.InterfaceToString(params["Broker"])
+		if err != nil {
+			return nil, errors.Wrap(err, "make coap options failed")
+		}
+		server = broker
+	}
+	opts := &CoAPOptions{
+		ClientOptions: coap.ClientOptions{
+			Server:        server,
+			AckTimeout:    c.AckTimeout,
+			MaxRetransmit: c.MaxRetransmit,
+		},
+	}
+	opts.ClientID, _ = typeconv.InterfaceToString(params["ClientID"])
+	opts.Username, _ = typeconv.InterfaceToString(params["Username"])
+	opts.Password, _ = typeconv.InterfaceToString(params["Password"])
+	opts.OnConnect, _ = (params["OnConnect"]).(func())
+	opts.OnDisconnect, _ = (params["OnDisconnect"]).(func(DisconnectReason, error))
+	opts.OnConnectionLost, _ = (params["OnConnectionLost"]).(func(DisconnectReason) map[string]interface{})
+	if will, ok := (params["Will"]).(*Will); ok && will != nil {
+		mqtt.DEBUG.Println(mqtt.CLI, "will requires MQTT, ignored by coap:", will.Topic)
+	}
+	return opts, nil
+}
+
+// NewClient 创建客户端，已有的客户端先断开
+func (c *CoAP) NewClient(opts interface{}) error {
+	typedOpts, ok := opts.(*CoAPOptions)
+	if !ok {
+		return errors.New("coap options conversion failed")
+	}
+	clientOpts := typedOpts.ClientOptions
+	clientOpts.Queries = append(typedOpts.queries(), clientOpts.Queries...)
+	client, err := coap.Dial(clientOpts)
+	if err != nil {
+		return errors.Wrap(err, "new coap client failed")
+	}
+	c.Disconnect()
+	c.mu.Lock()
+	c.client = client
+	c.opts = typedOpts
+	c.observations = make(map[string]*coap.Observation)
+	c.mu.Unlock()
+	if typedOpts.OnConnect != nil {
+		typedOpts.OnConnect()
+	}
+	return nil
+}
+
+// current 当前的客户端，未创建或已断开时为 nil
+func (c *CoAP) current() *coap.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// Disconnect 关闭客户端，之后 GetInstance 返回 nil，发布、订阅返回 ErrNotConnected。
+// 不逐个注销观察，服务端的通知得不到确认后会删除观察关系。重复调用不做任何处理
+func (c *CoAP) Disconnect() error {
+	c.mu.Lock()
+	client := c.client
+	c.client, c.observations = nil, nil
+	c.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// do 发送请求，服务端返回 4.01 时刷新认证参数后重试一次，非 2.xx 的响应返回 *coap.ResponseError
+func (c *CoAP) do(client *coap.Client, build func() *coap.Message) (*coap.Message, error) {
+	resp, err := client.Do(build())
+	if err == nil && resp != nil && resp.Code == coap.Unauthorized && c.refreshAuth(client, resp) {
+		resp, err = client.Do(build())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil && !resp.Code.IsSuccess() {
+		return nil, &coap.ResponseError{Code: resp.Code, Payload: resp.Payload}
+	}
+	return resp, nil
+}
+
+// refreshAuth 认证失败时通过 OnConnectionLost 获取新的密码，返回是否需要重试
+func (c *CoAP) refreshAuth(client *coap.Client, resp *coap.Message) bool {
+	c.mu.Lock()
+	opts := c.opts
+	c.mu.Unlock()
+	if opts == nil || opts.OnConnectionLost == nil {
+		return false
+	}
+	if opts.OnDisconnect != nil {
+		opts.OnDisconnect(DisconnectAuthFailed, &coap.ResponseError{Code: resp.Code, Payload: resp.Payload})
+	}
+	pswd, ok := (opts.OnConnectionLost(DisconnectAuthFailed)["Password"]).([]byte)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	opts.Password = hex.EncodeToString(pswd)
+	queries := opts.queries()
+	c.mu.Unlock()
+	client.SetQueries(queries)
+	return true
+}
+
+// coapPayload 发布内容转换为字节
+func coapPayload(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, errors.Errorf("unknown payload type %T", payload)
+}
+
+// checkCoAPTopic 主题必须是确定的资源路径
+func checkCoAPTopic(topic string) error {
+	if topics.IsShared(topic) || strings.ContainsAny(topic, "+#") {
+		return errors.Wrapf(ErrCoAPTopic, "topic %s", topic)
+	}
+	return nil
+}
+
+// Publish 发布，以 POST 请求发送到主题对应的资源路径
+func (c *CoAP) Publish(opts map[string]interface{}) error {
+	finllyOpts, err := getOpts(opts)
+	if err != nil {
+		return errors.Wrap(err, "coap publish failed")
+	}
+	payload, err := coapPayload(finllyOpts.Payload)
+	if err != nil {
+		return errors.Wrapf(err, "coap publish %s failed", finllyOpts.Topic)
+	}
+	if err := checkCoAPTopic(finllyOpts.Topic); err != nil {
+		return errors.Wrap(err, "coap publish failed")
+	}
+	client := c.current()
+	if client == nil {
+		return errors.Wrapf(ErrNotConnected, "coap publish %s failed", finllyOpts.Topic)
+	}
+	t := coap.Confirmable
+	if finllyOpts.Qos == 0 {
+		t = coap.NonConfirmable
+	}
+	_, err = c.do(client, func() *coap.Message {
+		m := client.NewRequest(t, coap.POST, finllyOpts.Topic)
+		m.SetOption(coap.ContentFormat, coap.EncodeUint(coap.OctetStream))
+		m.Payload = payload
+		return m
+	})
+	return errors.Wrapf(err, "coap publish %s failed", finllyOpts.Topic)
+}
+
+// coapMessage 观察通知，实现 request.Response
+type coapMessage struct {
+	topic string
+	m     *coap.Message
+}
+
+func (m *coapMessage) Duplicate() bool { return false }
+
+// Qos CON 通知为 1，NON 通知为 0
+func (m *coapMessage) Qos() byte {
+	if m.m.Type == coap.Confirmable {
+		return 1
+	}
+	return 0
+}
+
+func (m *coapMessage) Retained() bool    { return false }
+func (m *coapMessage) Topic() string     { return m.topic }
+func (m *coapMessage) MessageID() uint16 { return m.m.MessageID }
+func (m *coapMessage) Payload() []byte   { return m.m.Payload }
+
+// observe 观察主题对应的资源，已观察的主题先取消原来的观察
+func (c *CoAP) observe(client *coap.Client, topic string, callback func(request.Response)) error {
+	if err := checkCoAPTopic(topic); err != nil {
+		return err
+	}
+	handler := func(m *coap.Message) {
+		if callback != nil {
+			callback(&coapMessage{topic: topic, m: m})
+		}
+	}
+	o, err := client.Observe(topic, handler)
+	if rerr, ok := err.(*coap.ResponseError); ok && rerr.Code == coap.Unauthorized &&
+		c.refreshAuth(client, &coap.Message{Code: rerr.Code, Payload: rerr.Payload}) {
+		o, err = client.Observe(topic, handler)
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.client != client {
+		c.mu.Unlock()
+		o.Cancel()
+		return ErrNotConnected
+	}
+	old := c.observations[topic]
+	c.observations[topic] = o
+	c.mu.Unlock()
+	if old != nil {
+		old.Cancel()
+	}
+	return nil
+}
+
+// Subscribe 订阅，观察主题对应的资源，服务端的每个通知回调一次
+func (c *CoAP) Subscribe(opts map[string]interface{}) error {
+	finllyOpts, err := getOpts(opts)
+	if err != nil {
+		return err
+	}
+	client := c.current()
+	if client == nil {
+		return errors.Wrapf(ErrNotConnected, "coap subscribe %s failed", finllyOpts.Topic)
+	}
+	return errors.Wrapf(c.observe(client, finllyOpts.Topic, finllyOpts.Callback), "coap subscribe %s failed", finllyOpts.Topic)
+}
+
+// SubscribeMultiple 逐个观察多个主题，返回每个主题的订阅结果，部分主题失败时成功的订阅仍然有效
+func (c *CoAP) SubscribeMultiple(opts map[string]interface{}) (map[string]SubscribeResult, error) {
+	filters, ok := (opts["Topics"]).(map[string]byte)
+	if !ok {
+		return nil, errors.New("coap subscribe multiple failed, topics must be map[string]byte")
+	}
+	client := c.current()
+	if client == nil {
+		return nil, errors.Wrap(ErrNotConnected, "coap subscribe multiple failed")
+	}
+	callback, err := InterfaceToCallbackFn(opts["Callback"])
+	if err != nil {
+		callback = nil
+	}
+	results := make(map[string]SubscribeResult, len(filters))
+	for topic, qos := range filters {
+		if err := c.observe(client, topic, callback); err != nil {
+			results[topic] = SubscribeResult{Qos: packets.ErrSubscribeFailure, Err: err}
+			continue
+		}
+		results[topic] = SubscribeResult{Qos: qos}
+	}
+	return results, nil
+}
+
+// Subscriptions 当前观察的主题
+func (c *CoAP) Subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([]string, 0, len(c.observations))
+	for topic := range c.observations {
+		ret = append(ret, topic)
+	}
+	return ret
+}
+
+// Unsubscribe 取消订阅，向服务端注销观察，注销失败时本地仍不再回调
+func (c *CoAP) Unsubscribe(opts map[string]interface{}) error {
+	topics, err := typeconv.InterfaceToSliceString(opts["topics"])
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.client == nil {
+		c.mu.Unlock()
+		return errors.Wrap(ErrNotConnected, "coap unsubscribe failed")
+	}
+	var observations []*coap.Observation
+	for _, topic := range topics {
+		if o := c.observations[topic]; o != nil {
+			observations = append(observations, o)
+			delete(c.observations, topic)
+		}
+	}
+	c.mu.Unlock()
+	var first error
+	for _, o := range observations {
+		if err := o.Cancel(); err != nil && first == nil {
+			first = errors.Wrapf(err, "coap unsubscribe %s failed", o.Path())
+		}
+	}
+	return first
+}
+
+// GetName 获取协议名
+func (c *CoAP) GetName() string {
+	return "coap"
+}
+
+// GetInstance 获取协议客户端实例，未创建或已断开时为 nil 的 *coap.Client
+func (c *CoAP) GetInstance() interface{} {
+	return c.current()
+}
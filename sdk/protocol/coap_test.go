@@ -0,0 +1,209 @@
+package protocol
+
+import (
+	"iot-sdk-go/pkg/coap"
+	"iot-sdk-go/pkg/coap/coaptest"
+	"iot-sdk-go/sdk/request"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestCoAP 创建连接到本地测试服务端的 CoAP 协议
+func newTestCoAP(t *testing.T, handler coaptest.Handler, params map[string]interface{}) (*CoAP, *coaptest.Server) {
+	t.Helper()
+	server, err := coaptest.NewServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &CoAP{AckTimeout: 20 * time.Millisecond}
+	base := map[string]interface{}{
+		"Broker":   server.Addr,
+		"ClientID": "1",
+		"Username": "1",
+		"Password": "817aecf06c023365",
+	}
+	for k, v := range params {
+		base[k] = v
+	}
+	opts, err := c.MakeOpts(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NewClient(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Disconnect()
+		server.Close()
+	})
+	return c, server
+}
+
+// nextRequest 等待服务端收到下一个请求
+func nextRequest(t *testing.T, server *coaptest.Server) *coap.Message {
+	t.Helper()
+	select {
+	case m := <-server.Requests:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("server received no request")
+	}
+	return nil
+}
+
+func TestCoAPPublish(t *testing.T) {
+	c, server := newTestCoAP(t, nil, nil)
+	if err := c.Publish(map[string]interface{}{"Topic": "/1/property", "Qos": byte(1), "Payload": []byte{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	m := nextRequest(t, server)
+	if m.Type != coap.Confirmable || m.Code != coap.POST || m.Path() != "1/property" || !reflect.DeepEqual(m.Payload, []byte{1, 2}) {
+		t.Fatalf("unexpected request %v %v %q %v", m.Type, m.Code, m.Path(), m.Payload)
+	}
+	if q := m.Queries(); !reflect.DeepEqual(q, []string{"clientid=1", "username=1", "password=817aecf06c023365"}) {
+		t.Fatalf("unexpected queries %v", q)
+	}
+
+	// QoS 0 以 NON 发送
+	if err := c.Publish(map[string]interface{}{"Topic": "/1/event", "Payload": []byte{3}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := nextRequest(t, server); m.Type != coap.NonConfirmable || m.Path() != "1/event" {
+		t.Fatalf("unexpected request %v %q", m.Type, m.Path())
+	}
+
+	// 丢失的 CON 请求会重传
+	server.Drop(1)
+	if err := c.Publish(map[string]interface{}{"Topic": "/1/property", "Qos": byte(1), "Payload": []byte{4}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := nextRequest(t, server); !reflect.DeepEqual(m.Payload, []byte{4}) {
+		t.Fatalf("unexpected retransmitted payload %v", m.Payload)
+	}
+
+	if err := c.Publish(map[string]interface{}{"Topic": "/1/+", "Payload": []byte{5}}); errors.Cause(err) != ErrCoAPTopic {
+		t.Fatalf("wildcard topic err = %v", err)
+	}
+}
+
+func TestCoAPPublishError(t *testing.T) {
+	c, _ := newTestCoAP(t, func(m *coap.Message) (coap.Code, []byte) {
+		return coap.NotFound, []byte("no such resource")
+	}, nil)
+	err := c.Publish(map[string]interface{}{"Topic": "/1/property", "Qos": byte(1), "Payload": []byte{1}})
+	if rerr, ok := errors.Cause(err).(*coap.ResponseError); !ok || rerr.Code != coap.NotFound {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCoAPUnauthorized(t *testing.T) {
+	var passwords []string
+	var reasons []DisconnectReason
+	c, server := newTestCoAP(t, func(m *coap.Message) (coap.Code, []byte) {
+		if m.Queries()[2] != "password=0102" {
+			return coap.Unauthorized, nil
+		}
+		return coap.Changed, nil
+	}, map[string]interface{}{
+		"OnDisconnect": func(reason DisconnectReason, err error) { reasons = append(reasons, reason) },
+		"OnConnectionLost": func(reason DisconnectReason) map[string]interface{} {
+			return map[string]interface{}{"Password": []byte{1, 2}}
+		},
+	})
+	if err := c.Publish(map[string]interface{}{"Topic": "/1/property", "Qos": byte(1), "Payload": []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		passwords = append(passwords, nextRequest(t, server).Queries()[2])
+	}
+	if !reflect.DeepEqual(passwords, []string{"password=817aecf06c023365", "password=0102"}) {
+		t.Fatalf("passwords = %v", passwords)
+	}
+	if !reflect.DeepEqual(reasons, []DisconnectReason{DisconnectAuthFailed}) {
+		t.Fatalf("reasons = %v", reasons)
+	}
+}
+
+func TestCoAPObserve(t *testing.T) {
+	c, server := newTestCoAP(t, nil, nil)
+	received := make(chan request.Response, 1)
+	err := c.Subscribe(map[string]interface{}{
+		"Topic":    "/1/command",
+		"Callback": func(r request.Response) { received <- r },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := nextRequest(t, server); m.Code != coap.GET || m.Path() != "1/command" {
+		t.Fatalf("unexpected observe request %v %q", m.Code, m.Path())
+	}
+	if n, err := server.Notify("1/command", []byte("cmd")); err != nil || n != 1 {
+		t.Fatalf("notify %d %v", n, err)
+	}
+	select {
+	case r := <-received:
+		if r.Topic() != "/1/command" || string(r.Payload()) != "cmd" || r.Qos() != 1 {
+			t.Fatalf("unexpected notification %q %q %d", r.Topic(), r.Payload(), r.Qos())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not delivered")
+	}
+	if ack := <-server.Replies; ack.Type != coap.Acknowledgement {
+		t.Fatalf("notification not acknowledged, got %v", ack.Type)
+	}
+
+	if err := c.Unsubscribe(map[string]interface{}{"topics": []string{"/1/command"}}); err != nil {
+		t.Fatal(err)
+	}
+	if server.Observers("1/command") != 0 {
+		t.Fatal("observation not cancelled on server")
+	}
+	if len(c.Subscriptions()) != 0 {
+		t.Fatalf("subscriptions = %v", c.Subscriptions())
+	}
+}
+
+func TestCoAPSubscribeMultiple(t *testing.T) {
+	c, server := newTestCoAP(t, func(m *coap.Message) (coap.Code, []byte) {
+		if m.Path() == "1/missing" {
+			return coap.NotFound, nil
+		}
+		return coap.Content, nil
+	}, nil)
+	results, err := c.SubscribeMultiple(map[string]interface{}{
+		"Topics":   map[string]byte{"/1/command": 1, "/1/missing": 1},
+		"Callback": func(request.Response) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results["/1/command"].Err != nil || results["/1/command"].Qos != 1 || results["/1/missing"].Err == nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if server.Observers("1/command") != 1 || server.Observers("1/missing") != 0 {
+		t.Fatal("unexpected observers on server")
+	}
+}
+
+func TestCoAPDisconnect(t *testing.T) {
+	c, _ := newTestCoAP(t, nil, nil)
+	if c.GetInstance().(*coap.Client) == nil {
+		t.Fatal("client should be created")
+	}
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetInstance().(*coap.Client) != nil {
+		t.Fatal("instance should be nil after disconnect")
+	}
+	err := c.Publish(map[string]interface{}{"Topic": "/1/property", "Payload": []byte{1}})
+	if errors.Cause(err) != ErrNotConnected {
+		t.Fatalf("publish after disconnect err = %v", err)
+	}
+	if err := c.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,21 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+)
+
+// Dialer 自定义建立网络连接，network 为 "tcp"，addr 为 host:port。
+// 可用于 SOCKS5 代理、绑定源地址或网卡、设置 TCP 选项，超时由实现自行控制
+type Dialer func(network, addr string) (net.Conn, error)
+
+// ConnectionOriented 基于连接的协议，SupportsDialer 返回 true 时支持通过 Dialer 建立连接
+type ConnectionOriented interface {
+	SupportsDialer() bool
+}
+
+// ErrDialerUnsupported 协议不是基于连接的，不支持自定义 Dialer
+var ErrDialerUnsupported = errors.New("protocol does not support custom dialer")
+
+// ErrDialerWithPSK 同时设置了自定义 Dialer 与 TLS-PSK
+var ErrDialerWithPSK = errors.New("custom dialer and tls-psk cannot be both set")
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"io"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DisconnectReason 连接断开原因
+type DisconnectReason int
+
+const (
+	// DisconnectUnknown 未知原因
+	DisconnectUnknown DisconnectReason = iota
+	// DisconnectNetwork 网络错误，如读写失败、超时、心跳无响应
+	DisconnectNetwork
+	// DisconnectBroker 服务端主动断开连接
+	DisconnectBroker
+	// DisconnectIdentityConflict 身份冲突，相同 ClientID 的客户端互相挤占连接，不会自动重连
+	DisconnectIdentityConflict
+	// DisconnectAuthFailed 认证失败
+	DisconnectAuthFailed
+)
+
+// IdentityConflictWindow 连接建立后在该时间内被服务端关闭，计为一次疑似身份冲突的断开
+var IdentityConflictWindow = 5 * time.Second
+
+// IdentityConflictDrops 连续多少次连接都在 IdentityConflictWindow 内被服务端关闭，视为身份冲突，
+// 网络错误等其他原因的断开不计数。为 0 时不识别
+var IdentityConflictDrops = 3
+
+// ErrIdentityConflict 身份冲突，断开原因为 DisconnectIdentityConflict 时 OnDisconnect 收到的错误，
+// 可通过 errors.Cause 判断，原始错误包含在错误信息中
+var ErrIdentityConflict = errors.New("identity conflict, another client is using the same client id")
+
+// ErrNotConnected 客户端尚未创建或已经断开，需要重新创建客户端
+var ErrNotConnected = errors.New("protocol client not connected")
+
+// DisconnectQuiesce Disconnect 时等待未完成工作的时间，单位毫秒
+var DisconnectQuiesce uint = 250
+
+// Disconnector 可以主动断开的协议，断开后 GetInstance 返回 nil，停止自动重连，重复调用不做任何处理
+type Disconnector interface {
+	Disconnect() error
+}
+
+// String 断开原因名称
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectNetwork:
+		return "network"
+	case DisconnectBroker:
+		return "broker"
+	case DisconnectIdentityConflict:
+		return "identity conflict"
+	case DisconnectAuthFailed:
+		return "auth failed"
+	}
+	return "unknown"
+}
+
+// ClassifyDisconnect 根据 mqtt 客户端返回的错误判断断开原因
+//
+//	io.EOF                                  服务端关闭连接       DisconnectBroker
+//	io.ErrUnexpectedEOF、net.Error           读写失败或超时       DisconnectNetwork
+//	mqtt.ErrPingTimeout                     心跳无响应           DisconnectNetwork
+//	CONNACK Bad user name or password       用户名或密码错误     DisconnectAuthFailed
+//	CONNACK Not Authorized                  未授权               DisconnectAuthFailed
+//	CONNACK Identifier rejected             ClientID 被拒绝      DisconnectIdentityConflict
+//	其他                                                         DisconnectUnknown
+func ClassifyDisconnect(err error) DisconnectReason {
+	switch err {
+	case nil:
+		return DisconnectUnknown
+	case io.EOF:
+		return DisconnectBroker
+	case io.ErrUnexpectedEOF, mqtt.ErrPingTimeout:
+		return DisconnectNetwork
+	case packets.ConnErrors[packets.ErrRefusedBadUsernameOrPassword],
+		packets.ConnErrors[packets.ErrRefusedNotAuthorised]:
+		return DisconnectAuthFailed
+	case packets.ConnErrors[packets.ErrRefusedIDRejected]:
+		return DisconnectIdentityConflict
+	}
+	if _, ok := err.(net.Error); ok {
+		return DisconnectNetwork
+	}
+	return DisconnectUnknown
+}
@@ -1,10 +1,18 @@
 package protocol
 
 import (
+	"crypto/tls"
 	"encoding/hex"
 	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
 	"iot-sdk-go/pkg/typeconv"
 	"iot-sdk-go/sdk/request"
+	"iot-sdk-go/sdk/topics"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -12,6 +20,37 @@ import (
 // MQTT 实现
 type MQTT struct {
 	Client *mqtt.Client
+
+	mu          sync.Mutex
+	connectedAt time.Time
+	reconnected bool
+	lostReason  DisconnectReason
+	// shortDrops 连续在 IdentityConflictWindow 内被服务端关闭的连接数
+	shortDrops    int
+	subscriptions map[string]byte
+	// connections 连接建立的次数，重新订阅时用于判断连接是否已经更替
+	connections uint64
+}
+
+// SubscribeTimeout 等待订阅结果的超时时间
+var SubscribeTimeout = 30 * time.Second
+
+// ErrSubscribeRejected 服务端拒绝订阅
+var ErrSubscribeRejected = errors.New("subscribe rejected by broker")
+
+// ErrSubscribeTimeout 等待订阅结果超时
+var ErrSubscribeTimeout = errors.New("subscribe timeout")
+
+// ErrPublishTimeout 等待发布结果超时
+var ErrPublishTimeout = errors.New("publish timeout")
+
+// ErrSharedSubscription 共享订阅需要 MQTT 5，MQTT 客户端只支持 MQTT 3.1.1
+var ErrSharedSubscription = errors.New("shared subscription ($share/...) requires MQTT 5, the mqtt client only supports MQTT 3.1.1")
+
+// SubscribeResult 单个主题的订阅结果
+type SubscribeResult struct {
+	Qos byte
+	Err error
 }
 
 // NewMQTT 创建 MQTT 对象
@@ -41,17 +80,123 @@ func (m *MQTT) MakeOpts(params map[string]interface{}) (interface{}, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "make mqtt options failed")
 	}
-	OnConnectionLost, ok := (params["OnConnectionLost"]).(func() map[string]interface{})
+	// OnConnectionLost 可以接收断开原因，也兼容不带参数的旧形式
+	OnConnectionLost, ok := (params["OnConnectionLost"]).(func(DisconnectReason) map[string]interface{})
 	if !ok {
+		legacy, ok := (params["OnConnectionLost"]).(func() map[string]interface{})
+		if !ok {
+			return nil, errors.New("make mqtt options failed, OnConnectionLost missing")
+		}
+		OnConnectionLost = func(DisconnectReason) map[string]interface{} { return legacy() }
+	}
+	psk, _ := (params["PSK"]).(*PSK)
+	dialer, _ := (params["Dialer"]).(Dialer)
+	if dialer != nil && psk != nil {
+		return nil, errors.Wrap(ErrDialerWithPSK, "make mqtt options failed")
+	}
+	if psk != nil {
+		if PSKDialer == nil {
+			return nil, errors.Wrap(ErrPSKDialerMissing, "make mqtt options failed")
+		}
+		if tlsConfig, _ := (params["TLSConfig"]).(*tls.Config); tlsConfig != nil {
+			return nil, errors.Wrap(ErrPSKWithTLSConfig, "make mqtt options failed")
+		}
+	}
+	tlsConfig, _ := (params["TLSConfig"]).(*tls.Config)
+	brokerURL, err := brokerURL(Broker, tlsConfig)
+	if err != nil {
 		return nil, errors.Wrap(err, "make mqtt options failed")
 	}
-	opts := mqtt.NewClientOptions().AddBroker("tcp://" + Broker)
+	opts := mqtt.NewClientOptions().AddBroker(brokerURL)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if dialer != nil {
+		// 使用自定义 Dialer 建立 tcp 连接，需要 TLS 时在该连接上握手
+		opts.SetDialer(func(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+			conn, err := dialer("tcp", uri.Host)
+			if err != nil || !isTLSScheme(uri.Scheme) {
+				return conn, err
+			}
+			return clientTLS(conn, uri.Host, tlsc, timeout)
+		})
+	}
+	if psk != nil {
+		// 使用 PSK 完成 TLS 握手，替代默认的 tcp 连接
+		opts.SetDialer(func(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+			return PSKDialer("tcp", uri.Host, psk, timeout)
+		})
+	}
 	opts.SetClientID(ClientID)
 	opts.SetUsername(Username)
 	opts.SetPassword(Password)
 	opts.SetKeepAlive(KeepAlive)
+	// 持久化未确认的消息需要保留会话，重连后才能重发
+	if store, ok := (params["Store"]).(mqtt.Store); ok && store != nil {
+		opts.SetStore(store)
+		opts.SetCleanSession(false)
+	}
+	if maxReceiveSize, ok := (params["MaxReceiveSize"]).(int); ok {
+		opts.SetMaxIncomingPayload(maxReceiveSize)
+	}
+	if will, ok := (params["Will"]).(*Will); ok && will != nil {
+		opts.SetBinaryWill(will.Topic, will.MakePayload(), will.Qos, will.Retained)
+		if will.DelayInterval > 0 {
+			mqtt.DEBUG.Println(mqtt.CLI, "will delay interval requires MQTT 5, ignored:", will.DelayInterval)
+		}
+	}
+	OnConnect, _ := (params["OnConnect"]).(func())
+	OnDisconnect, _ := (params["OnDisconnect"]).(func(DisconnectReason, error))
+	// 自动重连的每次尝试前调用 OnReconnecting，返回错误时跳过本次尝试；尝试失败后调用 OnReconnectFailed
+	if reconnecting, ok := (params["OnReconnecting"]).(func() error); ok && reconnecting != nil {
+		opts.SetReconnectingHandler(func(*mqtt.Client) error {
+			return reconnecting()
+		})
+	}
+	if failed, ok := (params["OnReconnectFailed"]).(func()); ok && failed != nil {
+		opts.SetReconnectFailedHandler(func(*mqtt.Client) {
+			failed()
+		})
+	}
+	batch, _ := (params["ResubscribeBatch"]).(int)
+	delay, _ := (params["ResubscribeDelay"]).(time.Duration)
+	opts.SetOnConnectHandler(func(c *mqtt.Client) {
+		// 清除会话的连接重连后服务端不再保留订阅，需要重新订阅
+		if reconnected, connection := m.onConnect(); reconnected && opts.CleanSession {
+			go m.resubscribe(c, connection, batch, delay)
+		}
+		if OnConnect != nil {
+			OnConnect()
+		}
+	})
+	// 身份冲突时重连只会与另一个客户端互相挤占，不再重连，并清除客户端，之后可以重新创建
+	opts.SetShouldReconnectHandler(func(c *mqtt.Client, err error) bool {
+		if m.onConnectionLost(err) != DisconnectIdentityConflict {
+			return true
+		}
+		m.mu.Lock()
+		if m.Client == c {
+			m.Client = nil
+		}
+		m.mu.Unlock()
+		return false
+	})
 	opts.SetConnectionLostHandler(func(c *mqtt.Client, err error) {
-		newOpts := OnConnectionLost()
+		reason := m.lastLostReason()
+		if reason == DisconnectIdentityConflict {
+			if err == nil {
+				err = ErrIdentityConflict
+			} else {
+				err = errors.Wrap(ErrIdentityConflict, err.Error())
+			}
+		}
+		if OnDisconnect != nil {
+			OnDisconnect(reason, err)
+		}
+		if reason == DisconnectIdentityConflict {
+			return
+		}
+		newOpts := OnConnectionLost(reason)
 		pswd, ok := (newOpts["Password"]).([]byte)
 		if ok {
 			c.RefreshPassword(hex.EncodeToString(pswd))
@@ -61,10 +206,85 @@ func (m *MQTT) MakeOpts(params map[string]interface{}) (interface{}, error) {
 	return opts, nil
 }
 
+// SupportsDialer MQTT 基于 TCP 连接，支持自定义 Dialer
+func (m *MQTT) SupportsDialer() bool {
+	return true
+}
+
+// onConnect 记录连接建立时间，返回是否为重连以及本次连接的序号
+func (m *MQTT) onConnect() (reconnected bool, connection uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnected = !m.connectedAt.IsZero()
+	m.connectedAt = time.Now()
+	m.connections++
+	return m.reconnected, m.connections
+}
+
+// onConnectionLost 判断并记录断开原因。连续 IdentityConflictDrops 次连接都在 IdentityConflictWindow 内
+// 被服务端关闭，视为身份冲突；网络错误等其他原因的断开不计数，并重新计数
+func (m *MQTT) onConnectionLost(err error) DisconnectReason {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reason := ClassifyDisconnect(err)
+	if reason == DisconnectBroker && time.Since(m.connectedAt) < IdentityConflictWindow {
+		m.shortDrops++
+	} else {
+		m.shortDrops = 0
+	}
+	if IdentityConflictDrops > 0 && m.shortDrops >= IdentityConflictDrops {
+		reason = DisconnectIdentityConflict
+	}
+	m.lostReason = reason
+	return reason
+}
+
+// lastLostReason 最近一次断开原因
+func (m *MQTT) lastLostReason() DisconnectReason {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lostReason
+}
+
 func a() {
 
 }
 
+// Will 遗嘱消息
+type Will struct {
+	Topic    string
+	Qos      byte
+	Retained bool
+	// Payload 遗嘱内容生成函数，在 MakeOpts 创建配置项时调用
+	Payload func() []byte
+	// DelayInterval MQTT 5 遗嘱延迟，连接断开后延迟发布遗嘱，期间重连成功则不发布。
+	// 内置的 MQTT 3.1.1 客户端不支持，忽略该字段
+	DelayInterval time.Duration
+}
+
+// DelaySeconds MQTT 5 Will Delay Interval 属性值，单位秒，不足一秒按一秒计算，超出范围时取最大值
+func (w *Will) DelaySeconds() uint32 {
+	if w.DelayInterval <= 0 {
+		return 0
+	}
+	seconds := w.DelayInterval / time.Second
+	if w.DelayInterval%time.Second != 0 {
+		seconds++
+	}
+	if seconds > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(seconds)
+}
+
+// MakePayload 生成遗嘱内容
+func (w *Will) MakePayload() []byte {
+	if w.Payload == nil {
+		return nil
+	}
+	return w.Payload()
+}
+
 // NewClient 创建客户端
 func (m *MQTT) NewClient(opts interface{}) error {
 	typedOpts, ok := opts.(*mqtt.ClientOptions)
@@ -76,7 +296,29 @@ func (m *MQTT) NewClient(opts interface{}) error {
 		return errors.Wrap(token.Error(), "new mqtt client failed")
 	}
 
+	m.mu.Lock()
 	m.Client = c
+	m.mu.Unlock()
+	return nil
+}
+
+// client 当前的客户端，未创建或已断开时为 nil
+func (m *MQTT) client() *mqtt.Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Client
+}
+
+// Disconnect 断开连接并停止自动重连，之后 GetInstance 返回 nil，发布、订阅返回 ErrNotConnected，
+// 需要重新调用 NewClient 创建客户端。重复调用不做任何处理
+func (m *MQTT) Disconnect() error {
+	m.mu.Lock()
+	c := m.Client
+	m.Client = nil
+	m.mu.Unlock()
+	if c != nil {
+		c.Disconnect(DisconnectQuiesce)
+	}
 	return nil
 }
 
@@ -89,13 +331,27 @@ type Options struct {
 	Callback func(request.Response)
 }
 
-// Publish 发布
+// Publish 发布，opts["WaitTimeout"] 为 time.Duration 时等待发布完成（QoS 1、2 为收到服务端确认），超时返回 ErrPublishTimeout
 func (m *MQTT) Publish(opts map[string]interface{}) error {
 	finllyOpts, err := getOpts(opts)
 	if err != nil {
 		return errors.Wrap(err, "mqtt publish failed")
 	}
-	return m.Client.Publish(finllyOpts.Topic, finllyOpts.Qos, finllyOpts.Retained, finllyOpts.Payload).Error()
+	if topic, _ := opts["ResponseTopic"].(string); topic != "" {
+		mqtt.DEBUG.Println(mqtt.CLI, "response topic requires MQTT 5, ignored:", topic)
+	}
+	if data, _ := opts["CorrelationData"].([]byte); len(data) > 0 {
+		mqtt.DEBUG.Println(mqtt.CLI, "correlation data requires MQTT 5, ignored")
+	}
+	c := m.client()
+	if c == nil {
+		return errors.Wrapf(ErrNotConnected, "mqtt publish %s failed", finllyOpts.Topic)
+	}
+	token := c.Publish(finllyOpts.Topic, finllyOpts.Qos, finllyOpts.Retained, finllyOpts.Payload)
+	if timeout, ok := opts["WaitTimeout"].(time.Duration); ok && timeout > 0 && !token.WaitTimeout(timeout) {
+		return errors.Wrapf(ErrPublishTimeout, "mqtt publish %s failed", finllyOpts.Topic)
+	}
+	return token.Error()
 }
 
 // InterfaceToMqttMessageHandler 接口转函数
@@ -144,18 +400,111 @@ func getOpts(opts map[string]interface{}) (*Options, error) {
 	}, nil
 }
 
-// Subscribe 订阅
+// Subscribe 订阅，等待服务端确认，服务端拒绝时返回 ErrSubscribeRejected，超时返回 ErrSubscribeTimeout
 func (m *MQTT) Subscribe(opts map[string]interface{}) error {
 	finllyOpts, err := getOpts(opts)
 	if err != nil {
 		return err
 	}
+	if topics.IsShared(finllyOpts.Topic) {
+		return errors.Wrapf(ErrSharedSubscription, "mqtt subscribe %s failed", finllyOpts.Topic)
+	}
+	client := m.client()
+	if client == nil {
+		return errors.Wrapf(ErrNotConnected, "mqtt subscribe %s failed", finllyOpts.Topic)
+	}
 	var cb mqtt.MessageHandler = func(c *mqtt.Client, m mqtt.Message) {
 		if finllyOpts.Callback != nil {
 			finllyOpts.Callback(m)
 		}
 	}
-	return m.Client.Subscribe(finllyOpts.Topic, finllyOpts.Qos, cb).Error()
+	granted, err := m.waitSubscribe(client.Subscribe(finllyOpts.Topic, finllyOpts.Qos, cb))
+	if err != nil {
+		return errors.Wrapf(err, "mqtt subscribe %s failed", finllyOpts.Topic)
+	}
+	if qos, ok := granted[finllyOpts.Topic]; !ok || qos == packets.ErrSubscribeFailure {
+		return errors.Wrapf(ErrSubscribeRejected, "mqtt subscribe %s failed", finllyOpts.Topic)
+	}
+	return nil
+}
+
+// SubscribeMultiple 同时订阅多个主题，返回每个主题的订阅结果，
+// 部分主题被拒绝时成功的订阅仍然有效
+func (m *MQTT) SubscribeMultiple(opts map[string]interface{}) (map[string]SubscribeResult, error) {
+	filters, ok := (opts["Topics"]).(map[string]byte)
+	if !ok {
+		return nil, errors.New("mqtt subscribe multiple failed, topics must be map[string]byte")
+	}
+	for filter := range filters {
+		if topics.IsShared(filter) {
+			return nil, errors.Wrapf(ErrSharedSubscription, "mqtt subscribe %s failed", filter)
+		}
+	}
+	client := m.client()
+	if client == nil {
+		return nil, errors.Wrap(ErrNotConnected, "mqtt subscribe multiple failed")
+	}
+	callback, err := InterfaceToCallbackFn(opts["Callback"])
+	if err != nil {
+		callback = nil
+	}
+	var cb mqtt.MessageHandler = func(c *mqtt.Client, m mqtt.Message) {
+		if callback != nil {
+			callback(m)
+		}
+	}
+	granted, err := m.waitSubscribe(client.SubscribeMultiple(filters, cb))
+	if err != nil {
+		return nil, errors.Wrap(err, "mqtt subscribe multiple failed")
+	}
+	return makeSubscribeResults(filters, granted), nil
+}
+
+// makeSubscribeResults 根据服务端返回的 QoS 生成每个主题的订阅结果
+func makeSubscribeResults(filters map[string]byte, granted map[string]byte) map[string]SubscribeResult {
+	results := make(map[string]SubscribeResult, len(filters))
+	for topic := range filters {
+		qos, ok := granted[topic]
+		if !ok || qos == packets.ErrSubscribeFailure {
+			results[topic] = SubscribeResult{Qos: packets.ErrSubscribeFailure, Err: ErrSubscribeRejected}
+			continue
+		}
+		results[topic] = SubscribeResult{Qos: qos}
+	}
+	return results
+}
+
+// waitSubscribe 等待订阅结果，只记录服务端接受的订阅
+func (m *MQTT) waitSubscribe(token mqtt.Token) (map[string]byte, error) {
+	if !token.WaitTimeout(SubscribeTimeout) {
+		return nil, ErrSubscribeTimeout
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	granted := token.(*mqtt.SubscribeToken).Result()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subscriptions == nil {
+		m.subscriptions = make(map[string]byte)
+	}
+	for topic, qos := range granted {
+		if qos != packets.ErrSubscribeFailure {
+			m.subscriptions[topic] = qos
+		}
+	}
+	return granted, nil
+}
+
+// Subscriptions 服务端已接受的订阅，key 为主题，value 为授予的 QoS
+func (m *MQTT) Subscriptions() map[string]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make(map[string]byte, len(m.subscriptions))
+	for topic, qos := range m.subscriptions {
+		ret[topic] = qos
+	}
+	return ret
 }
 
 // Unsubscribe 取消订阅
@@ -164,7 +513,16 @@ func (m *MQTT) Unsubscribe(opts map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
-	return m.Client.Unsubscribe(topics...).Error()
+	m.mu.Lock()
+	for _, topic := range topics {
+		delete(m.subscriptions, topic)
+	}
+	c := m.Client
+	m.mu.Unlock()
+	if c == nil {
+		return errors.Wrap(ErrNotConnected, "mqtt unsubscribe failed")
+	}
+	return c.Unsubscribe(topics...).Error()
 }
 
 // GetName 获取协议名
@@ -174,5 +532,5 @@ func (m *MQTT) GetName() string {
 
 // GetInstance 获取协议客户端实例
 func (m *MQTT) GetInstance() interface{} {
-	return m.Client
+	return m.client()
 }
@@ -0,0 +1,375 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"io"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/request"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func makeTestParams() map[string]interface{} {
+	return map[string]interface{}{
+		"Broker":           "127.0.0.1:1883",
+		"ClientID":         "1",
+		"Username":         "1",
+		"Password":         "817aecf06c023365",
+		"KeepAlive":        30 * time.Second,
+		"OnConnectionLost": func() map[string]interface{} { return nil },
+	}
+}
+
+func TestMakeOptsWillFunc(t *testing.T) {
+	calls := 0
+	params := makeTestParams()
+	params["Will"] = &Will{
+		Topic: "will",
+		Qos:   1,
+		Payload: func() []byte {
+			calls++
+			return []byte("offline")
+		},
+	}
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttOpts := opts.(*mqtt.ClientOptions)
+	if !mqttOpts.WillEnabled || mqttOpts.WillTopic != "will" || string(mqttOpts.WillPayload) != "offline" {
+		t.Fatalf("unexpected will options: %+v", mqttOpts)
+	}
+	if calls != 1 {
+		t.Fatalf("will payload generated %d times, want 1", calls)
+	}
+}
+
+func TestWillDelaySeconds(t *testing.T) {
+	for _, c := range []struct {
+		delay time.Duration
+		want  uint32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{500 * time.Millisecond, 1},
+		{30 * time.Second, 30},
+		{1<<63 - 1, math.MaxUint32},
+	} {
+		w := &Will{DelayInterval: c.delay}
+		if got := w.DelaySeconds(); got != c.want {
+			t.Errorf("DelaySeconds(%v) = %d, want %d", c.delay, got, c.want)
+		}
+	}
+	// 3.1.1 客户端忽略遗嘱延迟，遗嘱仍然生效
+	params := makeTestParams()
+	params["Will"] = &Will{Topic: "will", DelayInterval: time.Minute}
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.(*mqtt.ClientOptions).WillEnabled {
+		t.Fatal("will should be enabled")
+	}
+}
+
+func TestResubscribeBatches(t *testing.T) {
+	subs := map[string]byte{"a": 0, "b": 1, "c": 1, "d": 0, "e": 2}
+	batches := resubscribeBatches(subs, 2)
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[2]) != 1 || batches[2]["e"] != 2 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+	if _, ok := batches[0]["a"]; !ok {
+		t.Fatalf("batches should be sorted by topic: %v", batches)
+	}
+	if batches := resubscribeBatches(subs, 0); len(batches) != 1 || len(batches[0]) != 5 {
+		t.Fatalf("size 0 should resubscribe all at once: %v", batches)
+	}
+	if batches := resubscribeBatches(nil, 2); batches != nil {
+		t.Fatalf("no subscriptions should produce no batches: %v", batches)
+	}
+}
+
+func TestMakeOptsReconnectHooks(t *testing.T) {
+	errOpen := errors.New("open")
+	failed := 0
+	params := makeTestParams()
+	params["OnReconnecting"] = func() error { return errOpen }
+	params["OnReconnectFailed"] = func() { failed++ }
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := opts.(*mqtt.ClientOptions)
+	if o.Reconnecting == nil || o.Reconnecting(nil) != errOpen {
+		t.Fatal("reconnecting handler not set")
+	}
+	if o.ReconnectFailed == nil {
+		t.Fatal("reconnect failed handler not set")
+	}
+	o.ReconnectFailed(nil)
+	if failed != 1 {
+		t.Fatalf("failed called %d times, want 1", failed)
+	}
+}
+
+func TestMakeOptsWithoutWill(t *testing.T) {
+	opts, err := NewMQTT().MakeOpts(makeTestParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.(*mqtt.ClientOptions).WillEnabled {
+		t.Fatal("will should be disabled")
+	}
+}
+
+func TestClassifyDisconnect(t *testing.T) {
+	cases := map[error]DisconnectReason{
+		nil:                 DisconnectUnknown,
+		io.EOF:              DisconnectBroker,
+		io.ErrUnexpectedEOF: DisconnectNetwork,
+		mqtt.ErrPingTimeout: DisconnectNetwork,
+		packets.ConnErrors[packets.ErrRefusedNotAuthorised]: DisconnectAuthFailed,
+		packets.ConnErrors[packets.ErrRefusedIDRejected]:    DisconnectIdentityConflict,
+		&net.OpError{Op: "read", Err: errors.New("reset")}:  DisconnectNetwork,
+	}
+	for err, want := range cases {
+		if got := ClassifyDisconnect(err); got != want {
+			t.Errorf("ClassifyDisconnect(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestIdentityConflict(t *testing.T) {
+	m := NewMQTT()
+	for i := 1; i < IdentityConflictDrops; i++ {
+		m.onConnect()
+		if reason := m.onConnectionLost(io.EOF); reason != DisconnectBroker {
+			t.Fatalf("drop %d is %v, want broker", i, reason)
+		}
+	}
+	m.onConnect()
+	if reason := m.onConnectionLost(io.EOF); reason != DisconnectIdentityConflict {
+		t.Fatalf("repeated immediate broker drop is %v, want identity conflict", reason)
+	}
+	// 连接保持超过 IdentityConflictWindow 后重新计数
+	m.onConnect()
+	m.connectedAt = time.Now().Add(-IdentityConflictWindow)
+	if reason := m.onConnectionLost(io.EOF); reason != DisconnectBroker {
+		t.Fatalf("drop after stable connection is %v, want broker", reason)
+	}
+}
+
+func TestIdentityConflictIgnoresNetworkDrops(t *testing.T) {
+	m := NewMQTT()
+	for i := 0; i < IdentityConflictDrops*2; i++ {
+		m.onConnect()
+		if reason := m.onConnectionLost(io.ErrUnexpectedEOF); reason != DisconnectNetwork {
+			t.Fatalf("drop %d is %v, want network", i, reason)
+		}
+		m.onConnect()
+		if reason := m.onConnectionLost(mqtt.ErrPingTimeout); reason != DisconnectNetwork {
+			t.Fatalf("drop %d is %v, want network", i, reason)
+		}
+	}
+	// 网络错误打断连续的服务端断开
+	for i := 0; i < IdentityConflictDrops*2; i++ {
+		m.onConnect()
+		err := io.EOF
+		if i%2 == 1 {
+			err = io.ErrUnexpectedEOF
+		}
+		if reason := m.onConnectionLost(err); reason == DisconnectIdentityConflict {
+			t.Fatalf("drop %d is identity conflict", i)
+		}
+	}
+}
+
+func TestIdentityConflictClearsClient(t *testing.T) {
+	m := NewMQTT()
+	opts, err := m.MakeOpts(makeTestParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := opts.(*mqtt.ClientOptions)
+	c := mqtt.NewClient(o)
+	m.Client = c
+	for i := 1; i < IdentityConflictDrops; i++ {
+		m.onConnect()
+		if !o.ShouldReconnect(c, io.EOF) {
+			t.Fatalf("drop %d should reconnect", i)
+		}
+	}
+	m.onConnect()
+	if o.ShouldReconnect(c, io.EOF) {
+		t.Fatal("identity conflict should stop reconnecting")
+	}
+	if m.client() != nil {
+		t.Fatal("client should be cleared after identity conflict")
+	}
+}
+
+func TestMakeSubscribeResults(t *testing.T) {
+	filters := map[string]byte{"a": 1, "b": 1, "c": 0}
+	granted := map[string]byte{"a": 1, "b": packets.ErrSubscribeFailure, "c": 0}
+	results := makeSubscribeResults(filters, granted)
+	if results["a"].Err != nil || results["a"].Qos != 1 {
+		t.Fatalf("a should be granted: %+v", results["a"])
+	}
+	if results["b"].Err != ErrSubscribeRejected {
+		t.Fatalf("b should be rejected: %+v", results["b"])
+	}
+	if results["c"].Err != nil || results["c"].Qos != 0 {
+		t.Fatalf("c should be granted: %+v", results["c"])
+	}
+}
+
+func TestMakeOptsPSK(t *testing.T) {
+	params := makeTestParams()
+	params["PSK"] = &PSK{Identity: "relay", Key: []byte{1, 2, 3}}
+	if _, err := NewMQTT().MakeOpts(params); errors.Cause(err) != ErrPSKDialerMissing {
+		t.Fatalf("expect ErrPSKDialerMissing, got %v", err)
+	}
+	var dialed *PSK
+	PSKDialer = func(network, addr string, psk *PSK, timeout time.Duration) (net.Conn, error) {
+		dialed = psk
+		return nil, errors.New("dial failed")
+	}
+	defer func() { PSKDialer = nil }()
+	params["TLSConfig"] = &tls.Config{}
+	if _, err := NewMQTT().MakeOpts(params); errors.Cause(err) != ErrPSKWithTLSConfig {
+		t.Fatalf("expect ErrPSKWithTLSConfig, got %v", err)
+	}
+	delete(params, "TLSConfig")
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttOpts := opts.(*mqtt.ClientOptions)
+	mqttOpts.Dialer(mqttOpts.Servers[0], nil, time.Second)
+	if dialed == nil || dialed.Identity != "relay" {
+		t.Fatal("psk dialer should be used")
+	}
+}
+
+func TestMakeOptsDialer(t *testing.T) {
+	var network, addr string
+	params := makeTestParams()
+	params["Dialer"] = Dialer(func(n, a string) (net.Conn, error) {
+		network, addr = n, a
+		return nil, errors.New("dial failed")
+	})
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttOpts := opts.(*mqtt.ClientOptions)
+	mqttOpts.Dialer(mqttOpts.Servers[0], nil, time.Second)
+	if network != "tcp" || addr != "127.0.0.1:1883" {
+		t.Fatalf("dialed %s %s, want tcp 127.0.0.1:1883", network, addr)
+	}
+	params["PSK"] = &PSK{Identity: "relay", Key: []byte{1, 2, 3}}
+	if _, err := NewMQTT().MakeOpts(params); errors.Cause(err) != ErrDialerWithPSK {
+		t.Fatalf("expect ErrDialerWithPSK, got %v", err)
+	}
+}
+
+func TestSharedSubscriptionUnsupported(t *testing.T) {
+	m := NewMQTT()
+	err := m.Subscribe(map[string]interface{}{"Topic": "$share/gateways/c", "Qos": byte(1)})
+	if errors.Cause(err) != ErrSharedSubscription {
+		t.Fatalf("expect ErrSharedSubscription, got %v", err)
+	}
+	_, err = m.SubscribeMultiple(map[string]interface{}{"Topics": map[string]byte{"c": 1, "$share/gateways/e": 1}})
+	if errors.Cause(err) != ErrSharedSubscription {
+		t.Fatalf("expect ErrSharedSubscription, got %v", err)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	m := NewMQTT()
+	if err := m.Publish(map[string]interface{}{"Topic": "s", "Payload": []byte("1")}); errors.Cause(err) != ErrNotConnected {
+		t.Fatalf("got %v, want ErrNotConnected before NewClient", err)
+	}
+	opts, err := m.MakeOpts(makeTestParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Client = mqtt.NewClient(opts.(*mqtt.ClientOptions))
+	for i := 0; i < 2; i++ {
+		if err := m.Disconnect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !typeconv.IsNil(m.GetInstance()) {
+		t.Fatal("instance should be nil after disconnect")
+	}
+	if err := m.Publish(map[string]interface{}{"Topic": "s", "Payload": []byte("1")}); errors.Cause(err) != ErrNotConnected {
+		t.Fatalf("got %v, want ErrNotConnected after disconnect", err)
+	}
+	if err := m.Subscribe(map[string]interface{}{"Topic": "c", "Callback": func(request.Response) {}}); errors.Cause(err) != ErrNotConnected {
+		t.Fatalf("got %v, want ErrNotConnected after disconnect", err)
+	}
+}
+
+// subackBroker 通过 net.Pipe 模拟服务端，接受连接，订阅的每个主题都返回 granted
+func subackBroker(granted byte) Dialer {
+	return func(network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			for {
+				p, err := packets.ReadPacket(server)
+				if err != nil {
+					return
+				}
+				switch p := p.(type) {
+				case *packets.ConnectPacket:
+					packets.NewControlPacket(packets.Connack).Write(server)
+				case *packets.SubscribePacket:
+					ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+					ack.MessageID = p.MessageID
+					for range p.Topics {
+						ack.GrantedQoss = append(ack.GrantedQoss, granted)
+					}
+					ack.Write(server)
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func TestSubscribeWaitsSuback(t *testing.T) {
+	for _, c := range []struct {
+		granted byte
+		want    error
+	}{
+		{1, nil},
+		{packets.ErrSubscribeFailure, ErrSubscribeRejected},
+	} {
+		m := NewMQTT()
+		params := makeTestParams()
+		params["Dialer"] = subackBroker(c.granted)
+		opts, err := m.MakeOpts(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.NewClient(opts); err != nil {
+			t.Fatal(err)
+		}
+		err = m.Subscribe(map[string]interface{}{"Topic": "c", "Qos": byte(1), "Callback": func(request.Response) {}})
+		if errors.Cause(err) != c.want {
+			t.Fatalf("granted %#x: got %v, want %v", c.granted, err, c.want)
+		}
+		if _, ok := m.Subscriptions()["c"]; ok != (c.want == nil) {
+			t.Fatalf("granted %#x: subscriptions %v", c.granted, m.Subscriptions())
+		}
+		m.Disconnect()
+	}
+}
@@ -0,0 +1,311 @@
+package protocol
+
+import (
+	"fmt"
+	"hash/fnv"
+	"iot-sdk-go/pkg/typeconv"
+	"iot-sdk-go/sdk/request"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PublishPolicy 多协议发布时判定成功的方式
+type PublishPolicy int
+
+const (
+	// PublishAll 所有协议都发布成功才算成功，默认值
+	PublishAll PublishPolicy = iota
+	// PublishAny 任一协议发布成功即算成功
+	PublishAny
+)
+
+// DefaultMultiDedupWindow 多协议订阅时相同消息的去重窗口
+var DefaultMultiDedupWindow = 10 * time.Second
+
+// MultiError 多协议操作中各协议返回的错误，按协议顺序排列
+type MultiError struct {
+	Errors []error
+}
+
+// Error 错误信息
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "multi protocol: " + strings.Join(msgs, "; ")
+}
+
+// Multi 同时使用多个协议，用于协议迁移期间验证新协议：Publish 发布到所有协议，
+// Subscribe 在所有协议上订阅，同一条消息从多个协议到达时只回调一次。
+// 第一个协议为主协议，GetName、GetInstance 返回主协议的值，登录时按主协议获取接入地址
+type Multi struct {
+	Protocols []Protocol
+	// Policy 发布、订阅成功的判定方式，默认 PublishAll
+	Policy PublishPolicy
+	// DedupWindow 去重窗口，窗口内从其他协议到达的主题与内容都相同的消息只回调一次，为 0 时不去重
+	DedupWindow time.Duration
+
+	mu   sync.Mutex
+	seen map[uint64]*dedupEntry
+}
+
+// dedupEntry 去重窗口内收到的消息，counts 为每个协议收到的次数，delivered 为已回调的次数
+type dedupEntry struct {
+	at        time.Time
+	counts    []int
+	delivered int
+}
+
+// NewMulti 创建 Multi 对象，protocols 至少一个，第一个为主协议
+func NewMulti(protocols ...Protocol) *Multi {
+	return &Multi{Protocols: protocols, DedupWindow: DefaultMultiDedupWindow}
+}
+
+// each 在每个协议上并发执行 fn，按 Policy 汇总错误
+func (m *Multi) each(op string, fn func(i int, p Protocol) error) error {
+	if len(m.Protocols) == 0 {
+		return errors.Errorf("multi protocol %s failed, no protocols", op)
+	}
+	errs := make([]error, len(m.Protocols))
+	wg := sync.WaitGroup{}
+	for i, p := range m.Protocols {
+		wg.Add(1)
+		go func(i int, p Protocol) {
+			defer wg.Done()
+			errs[i] = fn(i, p)
+		}(i, p)
+	}
+	wg.Wait()
+	return m.aggregate(op, errs)
+}
+
+// aggregate 按 Policy 汇总各协议的错误，errs 与 Protocols 一一对应
+func (m *Multi) aggregate(op string, errs []error) error {
+	failed := &MultiError{}
+	for i, err := range errs {
+		if err != nil {
+			failed.Errors = append(failed.Errors, errors.Wrapf(err, "%s %s", m.Protocols[i].GetName(), op))
+		}
+	}
+	if len(failed.Errors) == 0 || (m.Policy == PublishAny && len(failed.Errors) < len(errs)) {
+		return nil
+	}
+	return failed
+}
+
+// Publish 发布到所有协议
+func (m *Multi) Publish(opts map[string]interface{}) error {
+	return m.each("publish", func(i int, p Protocol) error {
+		return p.Publish(opts)
+	})
+}
+
+// dedup 包装第 index 个协议的回调，去重窗口内其他协议已经回调过的相同主题与内容的消息不再回调。
+// 同一协议重复收到的消息（如两次相同的开关命令）都会回调
+func (m *Multi) dedup(callback func(request.Response), index int) func(request.Response) {
+	if callback == nil || m.DedupWindow <= 0 {
+		return callback
+	}
+	return func(resp request.Response) {
+		h := fnv.New64a()
+		h.Write([]byte(resp.Topic()))
+		h.Write([]byte{0})
+		h.Write(resp.Payload())
+		if m.duplicate(h.Sum64(), index) {
+			return
+		}
+		callback(resp)
+	}
+}
+
+// duplicate 第 index 个协议收到的消息是否为其他协议已经回调过的副本，同时清理过期的记录。
+// 某个协议第 n 次收到窗口内相同的消息时，只有其他协议都还没有收到第 n 次才回调
+func (m *Multi) duplicate(key uint64, index int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.seen == nil {
+		m.seen = map[uint64]*dedupEntry{}
+	}
+	for k, e := range m.seen {
+		if now.Sub(e.at) > m.DedupWindow {
+			delete(m.seen, k)
+		}
+	}
+	e, ok := m.seen[key]
+	if !ok {
+		e = &dedupEntry{counts: make([]int, len(m.Protocols))}
+		m.seen[key] = e
+	}
+	e.at = now
+	if index >= len(e.counts) {
+		e.counts = append(e.counts, make([]int, index+1-len(e.counts))...)
+	}
+	e.counts[index]++
+	if e.counts[index] <= e.delivered {
+		return true
+	}
+	e.delivered++
+	return false
+}
+
+// withCallback 复制 opts 并将 Callback 替换为第 index 个协议去重后的回调
+func (m *Multi) withCallback(opts map[string]interface{}, index int) map[string]interface{} {
+	callback, err := InterfaceToCallbackFn(opts["Callback"])
+	if err != nil {
+		return opts
+	}
+	ret := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		ret[k] = v
+	}
+	ret["Callback"] = m.dedup(callback, index)
+	return ret
+}
+
+// Subscribe 在所有协议上订阅，同一条消息只回调一次
+func (m *Multi) Subscribe(opts map[string]interface{}) error {
+	return m.each("subscribe", func(i int, p Protocol) error {
+		return p.Subscribe(m.withCallback(opts, i))
+	})
+}
+
+// SubscribeMultiple 在所有协议上订阅多个主题，同一条消息只回调一次。
+// 每个主题的结果按 Policy 合并：PublishAll 时任一协议订阅失败即为失败，PublishAny 时任一协议订阅成功即为成功
+func (m *Multi) SubscribeMultiple(opts map[string]interface{}) (map[string]SubscribeResult, error) {
+	results := make([]map[string]SubscribeResult, len(m.Protocols))
+	err := m.each("subscribe multiple", func(i int, p Protocol) error {
+		var err error
+		results[i], err = SubscribeMultiple(p, m.withCallback(opts, i))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]SubscribeResult{}
+	for _, result := range results {
+		for topic, r := range result {
+			prev, ok := merged[topic]
+			switch {
+			case !ok:
+				merged[topic] = r
+			case m.Policy == PublishAny && prev.Err != nil && r.Err == nil:
+				merged[topic] = r
+			case m.Policy == PublishAll && prev.Err == nil && r.Err != nil:
+				merged[topic] = r
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Unsubscribe 在所有协议上取消订阅
+func (m *Multi) Unsubscribe(opts map[string]interface{}) error {
+	return m.each("unsubscribe", func(i int, p Protocol) error {
+		return p.Unsubscribe(opts)
+	})
+}
+
+// MakeOpts 使用同一份配置生成每个协议的配置，返回 []interface{}，任一协议失败时返回错误
+func (m *Multi) MakeOpts(opts map[string]interface{}) (interface{}, error) {
+	ret := make([]interface{}, len(m.Protocols))
+	for i, p := range m.Protocols {
+		o, err := p.MakeOpts(opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "multi protocol make opts failed, %s", p.GetName())
+		}
+		ret[i] = o
+	}
+	return ret, nil
+}
+
+// NewClient 创建每个协议的客户端，opts 为与 Protocols 等长的 []interface{} 时按顺序分别使用，
+// 否则所有协议使用同一份配置。任一协议失败时返回错误，与 Policy 无关
+func (m *Multi) NewClient(opts interface{}) error {
+	each, ok := opts.([]interface{})
+	if ok && len(each) != len(m.Protocols) {
+		return fmt.Errorf("multi protocol new client failed, got %d options for %d protocols", len(each), len(m.Protocols))
+	}
+	for i, p := range m.Protocols {
+		o := opts
+		if ok {
+			o = each[i]
+		}
+		if err := p.NewClient(o); err != nil {
+			return errors.Wrapf(err, "multi protocol new client failed, %s", p.GetName())
+		}
+	}
+	return nil
+}
+
+// Disconnect 断开所有实现了 Disconnector 的协议，任一协议失败时返回错误，与 Policy 无关
+func (m *Multi) Disconnect() error {
+	failed := &MultiError{}
+	for _, p := range m.Protocols {
+		if d, ok := p.(Disconnector); ok {
+			if err := d.Disconnect(); err != nil {
+				failed.Errors = append(failed.Errors, errors.Wrapf(err, "%s disconnect", p.GetName()))
+			}
+		}
+	}
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// GetName 主协议的名称
+func (m *Multi) GetName() string {
+	if len(m.Protocols) == 0 {
+		return ""
+	}
+	return m.Protocols[0].GetName()
+}
+
+// GetInstance 主协议的客户端实例
+func (m *Multi) GetInstance() interface{} {
+	if len(m.Protocols) == 0 {
+		return nil
+	}
+	return m.Protocols[0].GetInstance()
+}
+
+// SupportsDialer 所有协议都支持自定义 Dialer 时返回 true
+func (m *Multi) SupportsDialer() bool {
+	for _, p := range m.Protocols {
+		if c, ok := p.(ConnectionOriented); !ok || !c.SupportsDialer() {
+			return false
+		}
+	}
+	return len(m.Protocols) > 0
+}
+
+// IsConnected 按 Policy 判断是否已连接：PublishAll 时所有协议都已连接，PublishAny 时任一协议已连接
+func (m *Multi) IsConnected() bool {
+	connected := 0
+	for _, p := range m.Protocols {
+		if isConnected(p) {
+			connected++
+		}
+	}
+	if m.Policy == PublishAny {
+		return connected > 0
+	}
+	return connected > 0 && connected == len(m.Protocols)
+}
+
+// isConnected 协议是否已连接，协议未提供连接状态时以客户端是否已创建判断
+func isConnected(p Protocol) bool {
+	if c, ok := p.(interface{ IsConnected() bool }); ok {
+		return c.IsConnected()
+	}
+	if m, ok := p.(*MQTT); ok {
+		c := m.client()
+		return c != nil && c.IsConnected()
+	}
+	return !typeconv.IsNil(p.GetInstance())
+}
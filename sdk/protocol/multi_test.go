@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"iot-sdk-go/sdk/request"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// stubResponse 测试用的消息
+type stubResponse struct {
+	topic   string
+	payload []byte
+}
+
+func (r stubResponse) Duplicate() bool   { return false }
+func (r stubResponse) Qos() byte         { return 1 }
+func (r stubResponse) Retained() bool    { return false }
+func (r stubResponse) Topic() string     { return r.topic }
+func (r stubResponse) MessageID() uint16 { return 0 }
+func (r stubResponse) Payload() []byte   { return r.payload }
+
+// stubProtocol 记录发布、保存订阅回调的协议，err 不为 nil 时发布失败
+type stubProtocol struct {
+	name      string
+	err       error
+	mu        sync.Mutex
+	published int
+	callbacks map[string]func(request.Response)
+}
+
+func (p *stubProtocol) Publish(opts map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.published++
+	return nil
+}
+
+func (p *stubProtocol) Subscribe(opts map[string]interface{}) error {
+	callback, _ := InterfaceToCallbackFn(opts["Callback"])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.callbacks == nil {
+		p.callbacks = map[string]func(request.Response){}
+	}
+	p.callbacks[opts["Topic"].(string)] = callback
+	return nil
+}
+
+func (p *stubProtocol) Unsubscribe(opts map[string]interface{}) error {
+	return nil
+}
+
+func (p *stubProtocol) MakeOpts(opts map[string]interface{}) (interface{}, error) {
+	return p.name, nil
+}
+
+func (p *stubProtocol) NewClient(opts interface{}) error {
+	if opts != p.name {
+		return errors.Errorf("got options %v", opts)
+	}
+	return nil
+}
+
+func (p *stubProtocol) GetName() string          { return p.name }
+func (p *stubProtocol) GetInstance() interface{} { return p }
+
+func (p *stubProtocol) deliver(topic string, payload []byte) {
+	p.callbacks[topic](stubResponse{topic: topic, payload: payload})
+}
+
+func TestMulti(t *testing.T) {
+	primary, secondary := &stubProtocol{name: "mqtt"}, &stubProtocol{name: "coap"}
+	m := NewMulti(primary, secondary)
+	if m.GetName() != "mqtt" {
+		t.Fatalf("name is %s, want primary protocol name", m.GetName())
+	}
+	opts, err := m.MakeOpts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewClient(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Publish(map[string]interface{}{"Topic": "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.published != 1 || secondary.published != 1 {
+		t.Fatalf("published %d, %d, want fan out to both", primary.published, secondary.published)
+	}
+
+	// 一个协议失败：PublishAll 返回错误，PublishAny 成功
+	secondary.err = errors.New("unreachable")
+	err = m.Publish(map[string]interface{}{"Topic": "s"})
+	if e, ok := err.(*MultiError); !ok || len(e.Errors) != 1 {
+		t.Fatalf("got %v, want multi error with one error", err)
+	}
+	m.Policy = PublishAny
+	if err := m.Publish(map[string]interface{}{"Topic": "s"}); err != nil {
+		t.Fatal(err)
+	}
+	primary.err = errors.New("unreachable")
+	if err := m.Publish(map[string]interface{}{"Topic": "s"}); err == nil {
+		t.Fatal("publish should fail when all protocols fail")
+	}
+
+	// 同一条命令从两个协议到达时只回调一次
+	received := 0
+	if err := m.Subscribe(map[string]interface{}{"Topic": "c", "Callback": func(request.Response) { received++ }}); err != nil {
+		t.Fatal(err)
+	}
+	primary.deliver("c", []byte("cmd1"))
+	secondary.deliver("c", []byte("cmd1"))
+	secondary.deliver("c", []byte("cmd2"))
+	if received != 2 {
+		t.Fatalf("received %d, want 2", received)
+	}
+	// 同一协议重复收到的相同命令（两次相同的开关命令）都回调，另一个协议的副本仍然去重
+	primary.deliver("c", []byte("toggle"))
+	primary.deliver("c", []byte("toggle"))
+	secondary.deliver("c", []byte("toggle"))
+	secondary.deliver("c", []byte("toggle"))
+	if received != 4 {
+		t.Fatalf("received %d, want 4", received)
+	}
+	received = 2
+	m.DedupWindow = 0
+	if err := m.Subscribe(map[string]interface{}{"Topic": "c", "Callback": func(request.Response) { received++ }}); err != nil {
+		t.Fatal(err)
+	}
+	primary.deliver("c", []byte("cmd1"))
+	secondary.deliver("c", []byte("cmd1"))
+	if received != 4 {
+		t.Fatalf("received %d, want 4 without dedup", received)
+	}
+}
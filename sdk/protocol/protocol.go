@@ -1,6 +1,11 @@
 package protocol
 
-import "reflect"
+import (
+	"iot-sdk-go/pkg/mqtt/packets"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
 
 // Protocol 协议
 type Protocol interface {
@@ -13,6 +18,42 @@ type Protocol interface {
 	GetInstance() interface{}
 }
 
+// MultiSubscriber 可以在一个请求中订阅多个主题的协议，为可选接口，未实现时 SubscribeMultiple 逐个调用 Subscribe
+type MultiSubscriber interface {
+	// SubscribeMultiple opts["Topics"] 为 map[string]byte，value 为 QoS，返回每个主题的订阅结果
+	SubscribeMultiple(opts map[string]interface{}) (map[string]SubscribeResult, error)
+}
+
+// SubscribeMultiple 订阅多个主题，opts["Topics"] 为 map[string]byte，value 为 QoS。
+// 协议实现了 MultiSubscriber 时一次订阅，否则逐个调用 Subscribe，单个主题失败记录在其结果中，
+// 未连接时返回 ErrNotConnected
+func SubscribeMultiple(p Protocol, opts map[string]interface{}) (map[string]SubscribeResult, error) {
+	if m, ok := p.(MultiSubscriber); ok {
+		return m.SubscribeMultiple(opts)
+	}
+	filters, ok := (opts["Topics"]).(map[string]byte)
+	if !ok {
+		return nil, errors.Errorf("%s subscribe multiple failed, topics must be map[string]byte", p.GetName())
+	}
+	results := make(map[string]SubscribeResult, len(filters))
+	for topic, qos := range filters {
+		err := p.Subscribe(map[string]interface{}{
+			"Topic":    topic,
+			"Qos":      qos,
+			"Callback": opts["Callback"],
+		})
+		if errors.Cause(err) == ErrNotConnected {
+			return nil, errors.Wrapf(err, "%s subscribe multiple failed", p.GetName())
+		}
+		if err != nil {
+			results[topic] = SubscribeResult{Qos: packets.ErrSubscribeFailure, Err: err}
+			continue
+		}
+		results[topic] = SubscribeResult{Qos: qos}
+	}
+	return results, nil
+}
+
 // OptionsFormatter 参数格式化
 func OptionsFormatter(s interface{}) map[string]interface{} {
 	t := reflect.TypeOf(s)
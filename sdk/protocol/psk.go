@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// PSK TLS-PSK 预共享密钥
+type PSK struct {
+	Identity string
+	Key      []byte
+}
+
+// PSKDialer 建立 TLS-PSK 连接。标准库 crypto/tls 不支持 PSK 密码套件，
+// 使用 TLS-PSK 前需由支持 PSK 的 TLS 库实现并赋值
+var PSKDialer func(network, addr string, psk *PSK, timeout time.Duration) (net.Conn, error)
+
+// ErrPSKDialerMissing 未设置 PSKDialer
+var ErrPSKDialerMissing = errors.New("tls-psk requires protocol.PSKDialer to be set")
+
+// ErrPSKWithTLSConfig 同时设置了 PSK 与证书 TLS 配置
+var ErrPSKWithTLSConfig = errors.New("tls-psk and certificate based tls config cannot be both set")
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"iot-sdk-go/pkg/mqtt"
+	"sort"
+	"time"
+)
+
+// resubscribe 重连后按批重新订阅服务端已接受的订阅，每批 batch 个主题，批次之间间隔 delay，batch 为 0 时一次订阅全部主题。
+// 连接再次断开或已建立新的连接时停止，由新连接重新开始，避免多轮重新订阅同时进行
+func (m *MQTT) resubscribe(c *mqtt.Client, connection uint64, batch int, delay time.Duration) {
+	batches := resubscribeBatches(m.Subscriptions(), batch)
+	total := 0
+	for _, filters := range batches {
+		total += len(filters)
+	}
+	for i, filters := range batches {
+		if !m.isConnection(c, connection) {
+			mqtt.DEBUG.Println(mqtt.CLI, "resubscribe interrupted, connection replaced")
+			return
+		}
+		if _, err := m.waitSubscribe(c.SubscribeMultiple(filters, nil)); err != nil {
+			mqtt.ERROR.Println(mqtt.CLI, "resubscribe batch", i+1, "of", len(batches), "failed:", err)
+		} else {
+			mqtt.DEBUG.Println(mqtt.CLI, "resubscribed batch", i+1, "of", len(batches), "topics:", len(filters), "total:", total)
+		}
+		if delay > 0 && i < len(batches)-1 {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// isConnection 客户端是否仍处于序号为 connection 的连接上
+func (m *MQTT) isConnection(c *mqtt.Client, connection uint64) bool {
+	m.mu.Lock()
+	current := m.connections
+	m.mu.Unlock()
+	return current == connection && c.IsConnected()
+}
+
+// resubscribeBatches 将订阅按主题排序后分成每批 size 个，size 不大于 0 时全部放在一批
+func resubscribeBatches(subscriptions map[string]byte, size int) []map[string]byte {
+	if len(subscriptions) == 0 {
+		return nil
+	}
+	filters := make([]string, 0, len(subscriptions))
+	for topic := range subscriptions {
+		filters = append(filters, topic)
+	}
+	sort.Strings(filters)
+	if size <= 0 {
+		size = len(filters)
+	}
+	var batches []map[string]byte
+	for start := 0; start < len(filters); start += size {
+		end := start + size
+		if end > len(filters) {
+			end = len(filters)
+		}
+		batch := make(map[string]byte, end-start)
+		for _, topic := range filters[start:end] {
+			batch[topic] = subscriptions[topic]
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
+	"sync"
+)
+
+// KeyValue StorageStore 使用的键值存储，storage.Storage 满足该接口。
+// 在此声明而不是引用 sdk/storage，避免协议包依赖存储的实现
+type KeyValue interface {
+	Get(key string) (interface{}, error)
+	Set(key string, value interface{}) error
+	Del(key string) error
+}
+
+// StorageStore 基于 KeyValue（如 storage.Storage）的 mqtt 消息存储，
+// 使凭证与未确认的 QoS 1/2 消息可以保存在同一个存储中
+type StorageStore struct {
+	Storage KeyValue
+	Prefix  string
+	mu      sync.Mutex
+}
+
+// NewStorageStore 创建消息存储，消息保存在 prefix 开头的 key 下
+func NewStorageStore(s KeyValue, prefix string) *StorageStore {
+	return &StorageStore{
+		Storage: s,
+		Prefix:  prefix,
+	}
+}
+
+func (s *StorageStore) indexKey() string {
+	return s.Prefix + ".keys"
+}
+
+func (s *StorageStore) messageKey(key string) string {
+	return s.Prefix + "." + key
+}
+
+// keys 读取已保存消息的 key 列表
+func (s *StorageStore) keys() []string {
+	v, err := s.Storage.Get(s.indexKey())
+	if err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store get keys failed:", err)
+		return []string{}
+	}
+	switch keys := v.(type) {
+	case []string:
+		return keys
+	case []interface{}:
+		ret := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if str, ok := key.(string); ok {
+				ret = append(ret, str)
+			}
+		}
+		return ret
+	}
+	return []string{}
+}
+
+func (s *StorageStore) setKeys(keys []string) {
+	if err := s.Storage.Set(s.indexKey(), keys); err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store set keys failed:", err)
+	}
+}
+
+// Open 打开存储
+func (s *StorageStore) Open() {}
+
+// Close 关闭存储
+func (s *StorageStore) Close() {}
+
+// Put 保存消息，先写消息再写索引，崩溃时最多残留无索引的消息
+func (s *StorageStore) Put(key string, message packets.ControlPacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := new(bytes.Buffer)
+	if err := message.Write(buf); err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store encode message failed:", err)
+		return
+	}
+	if err := s.Storage.Set(s.messageKey(key), base64.StdEncoding.EncodeToString(buf.Bytes())); err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store put message failed:", err)
+		return
+	}
+	keys := s.keys()
+	for _, k := range keys {
+		if k == key {
+			return
+		}
+	}
+	s.setKeys(append(keys, key))
+}
+
+// Get 读取消息，不存在时返回 nil
+func (s *StorageStore) Get(key string) packets.ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.Storage.Get(s.messageKey(key))
+	if err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store get message failed:", err)
+		return nil
+	}
+	str, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store decode message failed:", err)
+		return nil
+	}
+	message, err := packets.ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store read message failed:", err)
+		return nil
+	}
+	return message
+}
+
+// All 所有消息的 key
+func (s *StorageStore) All() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys()
+}
+
+// Del 删除消息，先删索引再删消息
+func (s *StorageStore) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.del(key)
+}
+
+func (s *StorageStore) del(key string) {
+	keys := s.keys()
+	for i, k := range keys {
+		if k == key {
+			s.setKeys(append(keys[:i], keys[i+1:]...))
+			break
+		}
+	}
+	if err := s.Storage.Del(s.messageKey(key)); err != nil {
+		mqtt.ERROR.Println(mqtt.STR, "storage store del message failed:", err)
+	}
+}
+
+// Reset 清空所有消息
+func (s *StorageStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys() {
+		s.del(key)
+	}
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"iot-sdk-go/pkg/mqtt/packets"
+	"testing"
+)
+
+// mapStorage 测试用内存存储
+type mapStorage map[string]interface{}
+
+func (s mapStorage) Get(key string) (interface{}, error) {
+	return s[key], nil
+}
+
+func (s mapStorage) Set(key string, value interface{}) error {
+	s[key] = value
+	return nil
+}
+
+func (s mapStorage) Del(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestStorageStore(t *testing.T) {
+	store := NewStorageStore(mapStorage{}, "relay.messages")
+	store.Open()
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.Qos = 1
+	pub.MessageID = 7
+	pub.TopicName = "s"
+	pub.Payload = []byte{1, 2, 3}
+	store.Put("o.7", pub)
+	if keys := store.All(); len(keys) != 1 || keys[0] != "o.7" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	got, ok := store.Get("o.7").(*packets.PublishPacket)
+	if !ok || got.MessageID != 7 || got.TopicName != "s" || string(got.Payload) != string(pub.Payload) {
+		t.Fatalf("unexpected message: %v", got)
+	}
+	store.Del("o.7")
+	if store.Get("o.7") != nil || len(store.All()) != 0 {
+		t.Fatal("message should be deleted")
+	}
+	store.Put("o.8", pub)
+	store.Put("i.9", pub)
+	store.Reset()
+	if len(store.All()) != 0 {
+		t.Fatal("store should be empty after reset")
+	}
+}
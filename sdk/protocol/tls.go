@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTLSConfigMissing 接入地址要求 TLS（如 mqtts://），但没有设置 TLS 配置
+var ErrTLSConfigMissing = errors.New("broker requires tls but no tls config is set, use device.WithTLSConfig or device.WithCACert")
+
+// ErrUnknownBrokerScheme 接入地址的协议不支持
+var ErrUnknownBrokerScheme = errors.New("unknown broker scheme")
+
+// brokerSchemes 接入地址的协议转换为 mqtt 客户端的协议，value 为 true 时使用 TLS
+var brokerSchemes = map[string]struct {
+	scheme string
+	tls    bool
+}{
+	"tcp":   {"tcp", false},
+	"mqtt":  {"tcp", false},
+	"ssl":   {"ssl", true},
+	"tls":   {"ssl", true},
+	"tcps":  {"ssl", true},
+	"mqtts": {"ssl", true},
+	"ws":    {"ws", false},
+	"wss":   {"wss", true},
+}
+
+// brokerURL 将接入地址转换为 mqtt 客户端的地址。没有协议的 host:port 在设置了 TLS 配置时使用 TLS；
+// 设置了 TLS 配置时 tcp、ws 升级为 ssl、wss；要求 TLS 的地址没有 TLS 配置时返回 ErrTLSConfigMissing
+func brokerURL(broker string, tlsc *tls.Config) (string, error) {
+	i := strings.Index(broker, "://")
+	if i < 0 {
+		if tlsc != nil {
+			return "ssl://" + broker, nil
+		}
+		return "tcp://" + broker, nil
+	}
+	s, ok := brokerSchemes[strings.ToLower(broker[:i])]
+	if !ok {
+		return "", errors.Wrapf(ErrUnknownBrokerScheme, "broker %s", broker)
+	}
+	if s.tls && tlsc == nil {
+		return "", errors.Wrapf(ErrTLSConfigMissing, "broker %s", broker)
+	}
+	scheme := s.scheme
+	if tlsc != nil && !s.tls {
+		scheme = map[string]string{"tcp": "ssl", "ws": "wss"}[scheme]
+	}
+	return scheme + broker[i:], nil
+}
+
+// isTLSScheme mqtt 客户端的地址是否使用 TLS
+func isTLSScheme(scheme string) bool {
+	return scheme == "ssl" || scheme == "wss"
+}
+
+// clientTLS 在已建立的连接上完成 TLS 握手，未设置 ServerName 时使用 addr 中的主机名
+func clientTLS(conn net.Conn, addr string, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+	config := tlsc.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "tls handshake failed")
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,194 @@
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"iot-sdk-go/pkg/mqtt"
+	"iot-sdk-go/pkg/mqtt/packets"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testCert 测试用的证书，由 ca 签发
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newTestCert 创建证书，parent 为 nil 时创建自签名的 CA
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// newTLSBroker 启动要求客户端证书的 TLS 服务端，收到 CONNECT 后回复 CONNACK，
+// 通过 clients 返回客户端证书的 CN
+func newTLSBroker(t *testing.T, ca *testCert) (string, chan string) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "broker", ca).tls},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	clients := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn *tls.Conn) {
+				defer conn.Close()
+				if err := conn.Handshake(); err != nil {
+					return
+				}
+				if _, err := packets.ReadPacket(conn); err != nil {
+					return
+				}
+				clients <- conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+				connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+				connack.Write(conn)
+				packets.ReadPacket(conn)
+			}(conn.(*tls.Conn))
+		}
+	}()
+	return ln.Addr().String(), clients
+}
+
+func TestBrokerURL(t *testing.T) {
+	tlsc := &tls.Config{}
+	for _, c := range []struct {
+		broker string
+		tlsc   *tls.Config
+		want   string
+		err    error
+	}{
+		{"127.0.0.1:1883", nil, "tcp://127.0.0.1:1883", nil},
+		{"127.0.0.1:8883", tlsc, "ssl://127.0.0.1:8883", nil},
+		{"mqtt://127.0.0.1:1883", nil, "tcp://127.0.0.1:1883", nil},
+		{"tcp://127.0.0.1:1883", tlsc, "ssl://127.0.0.1:1883", nil},
+		{"mqtts://127.0.0.1:8883", tlsc, "ssl://127.0.0.1:8883", nil},
+		{"MQTTS://127.0.0.1:8883", nil, "", ErrTLSConfigMissing},
+		{"ssl://127.0.0.1:8883", nil, "", ErrTLSConfigMissing},
+		{"ws://127.0.0.1:8083/mqtt", tlsc, "wss://127.0.0.1:8083/mqtt", nil},
+		{"quic://127.0.0.1:1883", nil, "", ErrUnknownBrokerScheme},
+	} {
+		got, err := brokerURL(c.broker, c.tlsc)
+		if got != c.want || errors.Cause(err) != c.err {
+			t.Errorf("brokerURL(%s, %v) = %s, %v, want %s, %v", c.broker, c.tlsc != nil, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestMakeOptsTLS(t *testing.T) {
+	params := makeTestParams()
+	params["Broker"] = "mqtts://127.0.0.1:8883"
+	if _, err := NewMQTT().MakeOpts(params); errors.Cause(err) != ErrTLSConfigMissing {
+		t.Fatalf("expect ErrTLSConfigMissing, got %v", err)
+	}
+	params["TLSConfig"] = &tls.Config{ServerName: "broker"}
+	opts, err := NewMQTT().MakeOpts(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mqttOpts := opts.(*mqtt.ClientOptions)
+	if mqttOpts.Servers[0].Scheme != "ssl" || mqttOpts.TLSConfig.ServerName != "broker" {
+		t.Fatalf("tls config not applied, %v %q", mqttOpts.Servers[0], mqttOpts.TLSConfig.ServerName)
+	}
+	if _, err := NewCoAP().MakeOpts(params); errors.Cause(err) != ErrDTLSUnsupported {
+		t.Fatalf("expect ErrDTLSUnsupported, got %v", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	addr, clients := newTLSBroker(t, ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	connect := func(params map[string]interface{}) error {
+		params["Broker"] = addr
+		m := NewMQTT()
+		opts, err := m.MakeOpts(params)
+		if err != nil {
+			return err
+		}
+		opts.(*mqtt.ClientOptions).SetConnectTimeout(2 * time.Second).SetAutoReconnect(false)
+		if err := m.NewClient(opts); err != nil {
+			return err
+		}
+		m.Disconnect()
+		return nil
+	}
+
+	params := makeTestParams()
+	params["TLSConfig"] = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{newTestCert(t, "device-1", ca).tls}}
+	if err := connect(params); err != nil {
+		t.Fatal(err)
+	}
+	if cn := <-clients; cn != "device-1" {
+		t.Fatalf("broker saw client cert %q", cn)
+	}
+
+	// 自定义 Dialer 建立的连接上同样完成 TLS 握手
+	dialed := 0
+	params["Dialer"] = Dialer(func(network, addr string) (net.Conn, error) {
+		dialed++
+		return net.Dial(network, addr)
+	})
+	if err := connect(params); err != nil {
+		t.Fatal(err)
+	}
+	if cn := <-clients; cn != "device-1" || dialed != 1 {
+		t.Fatalf("broker saw client cert %q, dialed %d", cn, dialed)
+	}
+
+	// 没有客户端证书时服务端拒绝握手
+	params = makeTestParams()
+	params["TLSConfig"] = &tls.Config{RootCAs: pool}
+	if err := connect(params); err == nil {
+		t.Fatal("connect without client cert should fail")
+	}
+}
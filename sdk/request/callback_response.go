@@ -9,3 +9,25 @@ type Response interface {
 	MessageID() uint16
 	Payload() []byte
 }
+
+// Properties MQTT 5 请求/响应属性，支持 MQTT 5 的 Protocol 实现可以让 Response 同时实现该接口
+type Properties interface {
+	ResponseTopic() string
+	CorrelationData() []byte
+}
+
+// ResponseTopic 消息携带的 MQTT 5 Response Topic，未携带或协议不支持时返回空字符串
+func ResponseTopic(resp Response) string {
+	if p, ok := resp.(Properties); ok {
+		return p.ResponseTopic()
+	}
+	return ""
+}
+
+// CorrelationData 消息携带的 MQTT 5 Correlation Data，未携带或协议不支持时返回 nil
+func CorrelationData(resp Response) []byte {
+	if p, ok := resp.(Properties); ok {
+		return p.CorrelationData()
+	}
+	return nil
+}
@@ -7,4 +7,8 @@ type Request struct {
 	Retained bool
 	Payload  interface{}
 	Callback func(Response)
+	// ResponseTopic MQTT 5 Response Topic 属性，请求方希望收到回复的主题，MQTT 3.1.1 客户端忽略
+	ResponseTopic string
+	// CorrelationData MQTT 5 Correlation Data 属性，用于关联请求与回复，MQTT 3.1.1 客户端忽略
+	CorrelationData []byte
 }
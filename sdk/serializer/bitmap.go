@@ -0,0 +1,93 @@
+package serializer
+
+import (
+	"iot-sdk-go/pkg/typeconv"
+	"math"
+	"strconv"
+)
+
+// BitmapWidth Bitmap 的位数，可用的位为 0 到 BitmapWidth-1
+const BitmapWidth = 32
+
+// Bitmap 位图属性值，每一位表示一个布尔标志，如 16 位状态字。
+// TLV 中作为一个 uint32 传输，CSV 中为十进制整数
+type Bitmap uint32
+
+// Set 将第 bit 位置 1，bit 超出 0 到 BitmapWidth-1 时不做任何操作
+func (b *Bitmap) Set(bit int) {
+	if bit >= 0 && bit < BitmapWidth {
+		*b |= 1 << uint(bit)
+	}
+}
+
+// Clear 将第 bit 位置 0，bit 超出范围时不做任何操作
+func (b *Bitmap) Clear(bit int) {
+	if bit >= 0 && bit < BitmapWidth {
+		*b &^= 1 << uint(bit)
+	}
+}
+
+// Get 第 bit 位是否为 1，bit 超出范围时返回 false
+func (b Bitmap) Get(bit int) bool {
+	if bit < 0 || bit >= BitmapWidth {
+		return false
+	}
+	return b&(1<<uint(bit)) != 0
+}
+
+// ToBitmap 将解析得到的参数值转换为 Bitmap，支持整数与十进制整数字符串，
+// 负数、超过 BitmapWidth 位或其他类型时返回 false
+func ToBitmap(v interface{}) (Bitmap, bool) {
+	var n uint64
+	switch v := v.(type) {
+	case Bitmap:
+		return v, true
+	case uint8:
+		n = uint64(v)
+	case uint16:
+		n = uint64(v)
+	case uint32:
+		n = uint64(v)
+	case uint64:
+		n = v
+	case uint:
+		n = uint64(v)
+	case int8, int16, int32, int64, int:
+		i, err := typeconv.InterfaceToInt64(v)
+		if err != nil || i < 0 {
+			return 0, false
+		}
+		n = uint64(i)
+	case string:
+		u, err := strconv.ParseUint(v, 10, BitmapWidth)
+		if err != nil {
+			return 0, false
+		}
+		n = u
+	default:
+		return 0, false
+	}
+	if n > math.MaxUint32 {
+		return 0, false
+	}
+	return Bitmap(n), true
+}
+
+// bitmapsToUint32 将值中的 Bitmap 转换为 uint32，没有 Bitmap 时返回原切片
+func bitmapsToUint32(values []interface{}) []interface{} {
+	var ret []interface{}
+	for i, v := range values {
+		b, ok := v.(Bitmap)
+		if !ok {
+			continue
+		}
+		if ret == nil {
+			ret = append([]interface{}{}, values...)
+		}
+		ret[i] = uint32(b)
+	}
+	if ret == nil {
+		return values
+	}
+	return ret
+}
@@ -0,0 +1,65 @@
+package serializer
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize 超过该容量的缓冲区不放回池中，避免偶发的大消息长期占用内存
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Options 序列化器配置
+type Options struct {
+	// BufferHint 预期的序列化数据长度，用于预分配编码缓冲区，为 0 时按需扩容
+	BufferHint int
+	// IDWidth TLV 中 ID 占用的字节数，为 0 时使用 DefaultIDWidth，其他序列化器忽略
+	IDWidth int
+}
+
+// Option 序列化器配置项
+type Option func(*Options)
+
+// WithBufferHint 设置预期的序列化数据长度，批量上报大量属性时可减少缓冲区扩容
+func WithBufferHint(n int) Option {
+	return func(o *Options) {
+		o.BufferHint = n
+	}
+}
+
+// apply 应用配置项
+func (o *Options) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// getBuffer 从池中取出缓冲区，并按 BufferHint 预分配容量
+func (o *Options) getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if o.BufferHint > 0 {
+		buf.Grow(o.BufferHint)
+	}
+	return buf
+}
+
+// putBuffer 缓冲区放回池中，返回的数据需在此之前复制
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// copyBytes 复制缓冲区中的数据，缓冲区可继续复用
+func copyBytes(buf *bytes.Buffer) []byte {
+	ret := make([]byte, buf.Len())
+	copy(ret, buf.Bytes())
+	return ret
+}
@@ -0,0 +1,283 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// CSV 列名，数字列名表示第几个参数值，如 "0" 为第一个参数
+const (
+	CSVTimestamp   = "timestamp"
+	CSVSubDeviceID = "sub_device_id"
+	CSVID          = "id"
+	CSVQuality     = "quality"
+	CSVUnit        = "unit"
+	CSVVersion     = "version"
+	// CSVIncrement 增量序号，不为空时该行的属性值为增量
+	CSVIncrement = "increment"
+	// CSVToken 命令的唯一标识，用于重复投递去重
+	CSVToken = "token"
+)
+
+// CSV CSV对象，按 Columns 的顺序将属性、事件编码为一行 CSV，
+// 字段转义遵循 RFC 4180：包含逗号、双引号或换行的字段使用双引号包裹，双引号写作两个双引号
+type CSV struct {
+	Columns []string
+}
+
+// NewCSV 创建CSV对象
+func NewCSV(columns []string) *CSV {
+	return &CSV{Columns: columns}
+}
+
+// Marshal 序列化，data 为按列顺序排列的字段值
+func (c *CSV) Marshal(data interface{}) (interface{}, error) {
+	values, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.New("csv marshal failed, data must be []interface{}")
+	}
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatCSVValue(v)
+	}
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if err := w.Write(record); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal 反序列化，返回一行 CSV 的字段
+func (c *CSV) Unmarshal(data interface{}) (interface{}, error) {
+	b, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("csv unmarshal failed, data must be []byte")
+	}
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	record, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(record) > len(c.Columns) {
+		return nil, fmt.Errorf("csv unmarshal failed, got %d fields, but only %d columns", len(record), len(c.Columns))
+	}
+	return record, nil
+}
+
+// formatCSVValue 字段值转字符串，[]byte 使用十六进制
+func formatCSVValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// makeRow 按列顺序生成一行数据，属性中不存在的列为空
+func (c *CSV) makeRow(property *Property) []interface{} {
+	row := make([]interface{}, len(c.Columns))
+	for i, column := range c.Columns {
+		switch column {
+		case CSVTimestamp:
+			row[i] = timestampOf(property)
+		case CSVSubDeviceID:
+			row[i] = property.SubDeviceID
+		case CSVID:
+			row[i] = property.PropertyID
+		case CSVQuality:
+			row[i] = uint8(property.Quality)
+		case CSVUnit:
+			row[i] = property.Unit
+		case CSVVersion:
+			row[i] = property.Version
+		case CSVIncrement:
+			if property.Increment {
+				row[i] = property.Sequence
+			}
+		default:
+			if index, err := strconv.Atoi(column); err == nil && index >= 0 && index < len(property.Value) {
+				row[i] = property.Value[index]
+			}
+		}
+	}
+	return row
+}
+
+func (c *CSV) marshalProperty(property *Property) ([]byte, error) {
+	// 没有增量列时平台无法区分增量与绝对值
+	if property.Increment && !c.hasColumn(CSVIncrement) {
+		return nil, fmt.Errorf("csv marshal failed, increment property requires column %s", CSVIncrement)
+	}
+	// 没有子设备列时不同子设备的相同属性 ID 无法区分
+	if property.SubDeviceID != 0 && !c.hasColumn(CSVSubDeviceID) {
+		return nil, fmt.Errorf("csv marshal failed, sub device property requires column %s", CSVSubDeviceID)
+	}
+	data, err := c.Marshal(c.makeRow(property))
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// hasColumn 是否包含列 column
+func (c *CSV) hasColumn(column string) bool {
+	for _, v := range c.Columns {
+		if v == column {
+			return true
+		}
+	}
+	return false
+}
+
+// MakePropertyData 创建序列化后的属性数据
+func (c *CSV) MakePropertyData(property *Property) ([]byte, error) {
+	return c.marshalProperty(property)
+}
+
+// MakeBatchPropertyData 将多个属性序列化为多行 CSV，每行一个属性
+func (c *CSV) MakeBatchPropertyData(properties []*Property) ([]byte, error) {
+	var ret []byte
+	for _, property := range properties {
+		data, err := c.marshalProperty(property)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, data...)
+	}
+	return ret, nil
+}
+
+// MakeEventData 创建序列化后的事件数据
+func (c *CSV) MakeEventData(property *Property) ([]byte, error) {
+	return c.marshalProperty(property)
+}
+
+// parseRow 按列名解析一行 CSV，返回列名到字段的映射，缺失的列不出现在结果中
+func (c *CSV) parseRow(data []byte) (map[string]string, error) {
+	record, err := c.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for i, v := range record.([]string) {
+		fields[c.Columns[i]] = v
+	}
+	return fields, nil
+}
+
+// parseUint16 解析 uint16 字段，缺失或为空时为 0
+func parseUint16(fields map[string]string, column string) (uint16, error) {
+	n, err := parseUint(fields, column, 16)
+	return uint16(n), err
+}
+
+// parseUint 解析 bitSize 位以内的无符号整数字段，缺失或为空时为 0
+func parseUint(fields map[string]string, column string, bitSize int) (uint64, error) {
+	v, ok := fields[column]
+	if !ok || v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("csv column %s: %v", column, err)
+	}
+	return n, nil
+}
+
+// UnmarshalCommand 命令反序列化，参数值均为字符串，key 为参数序号
+func (c *CSV) UnmarshalCommand(data []byte) (*Command, error) {
+	fields, err := c.parseRow(data)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseUint16(fields, CSVID)
+	if err != nil {
+		return nil, err
+	}
+	subDeviceID, err := parseUint16(fields, CSVSubDeviceID)
+	if err != nil {
+		return nil, err
+	}
+	params := map[int]interface{}{}
+	for column, v := range fields {
+		if index, err := strconv.Atoi(column); err == nil && index >= 0 {
+			params[index] = v
+		}
+	}
+	return &Command{
+		ID:          id,
+		SubDeviceID: subDeviceID,
+		Params:      params,
+		Token:       fields[CSVToken],
+	}, nil
+}
+
+// UnmarshalProperty 属性反序列化，参数值均为字符串，按参数序号排列，缺失的参数为 nil
+func (c *CSV) UnmarshalProperty(data []byte) (*Property, error) {
+	fields, err := c.parseRow(data)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseUint16(fields, CSVID)
+	if err != nil {
+		return nil, err
+	}
+	subDeviceID, err := parseUint16(fields, CSVSubDeviceID)
+	if err != nil {
+		return nil, err
+	}
+	quality, err := parseUint16(fields, CSVQuality)
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseUint(fields, CSVVersion, 64)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := parseUint(fields, CSVTimestamp, 63)
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := parseUint(fields, CSVIncrement, 64)
+	if err != nil {
+		return nil, err
+	}
+	property := &Property{
+		SubDeviceID: subDeviceID,
+		PropertyID:  id,
+		Value:       []interface{}{},
+		Quality:     Quality(quality),
+		Unit:        fields[CSVUnit],
+		Version:     version,
+		Increment:   fields[CSVIncrement] != "",
+		Sequence:    sequence,
+	}
+	if timestamp != 0 {
+		property.Timestamp = fromMillis(int64(timestamp))
+	}
+	for column, v := range fields {
+		index, err := strconv.Atoi(column)
+		if err != nil || index < 0 {
+			continue
+		}
+		for len(property.Value) <= index {
+			property.Value = append(property.Value, nil)
+		}
+		property.Value[index] = v
+	}
+	return property, nil
+}
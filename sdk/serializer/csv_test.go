@@ -0,0 +1,47 @@
+package serializer
+
+import "testing"
+
+func TestCSVProperty(t *testing.T) {
+	c := NewCSV([]string{CSVSubDeviceID, CSVID, "0", "1", CSVUnit})
+	data, err := c.MakePropertyData(&Property{
+		SubDeviceID: 1,
+		PropertyID:  2,
+		Value:       []interface{}{uint16(88), `say "hi", ok`},
+		Unit:        "%",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1,2,88,\"say \"\"hi\"\", ok\",%\n" {
+		t.Fatalf("unexpected csv: %q", data)
+	}
+	p, err := c.UnmarshalProperty(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SubDeviceID != 1 || p.PropertyID != 2 || p.Unit != "%" || len(p.Value) != 2 || p.Value[1] != `say "hi", ok` {
+		t.Fatalf("unexpected property: %+v", p)
+	}
+}
+
+func TestCSVCommand(t *testing.T) {
+	c := NewCSV([]string{CSVID, CSVSubDeviceID, "0", "1"})
+	cmd, err := c.UnmarshalCommand([]byte("1,3,88\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ID != 1 || cmd.SubDeviceID != 3 || cmd.Params[0] != "88" {
+		t.Fatalf("unexpected command: %+v", cmd)
+	}
+	if _, ok := cmd.Params[1]; ok {
+		t.Fatal("missing column should not be a param")
+	}
+	if _, err := c.UnmarshalCommand([]byte("1,3,88,1,extra")); err == nil {
+		t.Fatal("extra fields should fail")
+	}
+	cmd, err = NewCSV([]string{CSVID, CSVToken, "0"}).UnmarshalCommand([]byte("1,uuid-1,88"))
+	if err != nil || cmd.Token != "uuid-1" || len(cmd.Params) != 1 {
+		t.Fatalf("command with token: %+v, %v", cmd, err)
+	}
+}
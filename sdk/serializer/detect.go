@@ -0,0 +1,61 @@
+package serializer
+
+import "errors"
+
+// Format 消息的序列化格式
+type Format int
+
+const (
+	// FormatUnknown 无法识别的格式
+	FormatUnknown Format = iota
+	// FormatJSON JSON 对象或数组
+	FormatJSON
+	// FormatTLV TLV 二进制格式
+	FormatTLV
+)
+
+// String 格式名称
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatTLV:
+		return "tlv"
+	default:
+		return "unknown"
+	}
+}
+
+// tlvFlag TLV 命令、属性、事件头部的第一个字节，当前版本固定为 0
+const tlvFlag = 0x00
+
+// tlvMinSize TLV 最短头部的长度：1 字节标记、8 字节时间戳、8 字节 Token
+const tlvMinSize = 17
+
+// ErrUnknownFormat 无法识别消息的序列化格式
+var ErrUnknownFormat = errors.New("unknown serialization format")
+
+// Detect 根据开头的字节识别消息的序列化格式：
+// 跳过空白后以 { 或 [ 开头的为 JSON；第一个字节为 TLV 头部标记 0x00 且长度不小于 TLV 头部的为 TLV。
+// 只检查开头，不保证消息能够按识别出的格式解析成功；其他内容（如 CSV、文本、压缩数据）返回 ErrUnknownFormat
+func Detect(payload []byte) (Format, error) {
+	if len(payload) == 0 {
+		return FormatUnknown, ErrUnknownFormat
+	}
+	if payload[0] == tlvFlag {
+		if len(payload) < tlvMinSize {
+			return FormatUnknown, ErrUnknownFormat
+		}
+		return FormatTLV, nil
+	}
+	for _, b := range payload {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{', '[':
+			return FormatJSON, nil
+		}
+		return FormatUnknown, ErrUnknownFormat
+	}
+	return FormatUnknown, ErrUnknownFormat
+}
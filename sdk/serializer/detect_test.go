@@ -0,0 +1,29 @@
+package serializer
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tlvData, err := NewTLV().MakePropertyData(&Property{PropertyID: 1, Value: []interface{}{uint8(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		payload []byte
+		want    Format
+	}{
+		{tlvData, FormatTLV},
+		{[]byte(`{"id":1}`), FormatJSON},
+		{[]byte("\r\n [1,2]"), FormatJSON},
+		{[]byte("1,2,on"), FormatUnknown},
+		{[]byte{0x00, 0x01}, FormatUnknown},
+		{[]byte{0x1f, 0x8b, 0x08}, FormatUnknown},
+		{[]byte("   "), FormatUnknown},
+		{nil, FormatUnknown},
+	}
+	for _, c := range cases {
+		got, err := Detect(c.payload)
+		if got != c.want || (err != nil) != (c.want == FormatUnknown) {
+			t.Errorf("Detect(%q) = %v, %v, want %v", c.payload, got, err, c.want)
+		}
+	}
+}
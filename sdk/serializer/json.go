@@ -0,0 +1,348 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// JSON JSON对象，属性按子设备分组，以属性 ID 为 key：
+//
+//	{"sub_device_id":1,"properties":{"2":{"value":[25.5],"timestamp":1700000000000,"unit":"°C"}}}
+//
+// 批量上报时为上述对象的数组，子设备变化或属性 ID 重复时开始新的对象。
+// 事件与命令为单个对象：
+//
+//	{"id":1,"sub_device_id":1,"timestamp":1700000000000,"value":["overheat"]}
+//	{"id":1,"sub_device_id":1,"params":{"0":"on","1":50}}
+//
+// 命令参数也可以是数组，按顺序编号。解析时整数为 int64，其他数字为 float64，
+// []byte 按 encoding/json 的规则编码为 base64 字符串，解析后为字符串
+type JSON struct {
+	Options
+}
+
+// NewJSON 创建JSON对象
+func NewJSON(opts ...Option) *JSON {
+	j := &JSON{}
+	j.apply(opts)
+	return j
+}
+
+// jsonProperty 属性对象中的一个属性
+type jsonProperty struct {
+	Value     []interface{} `json:"value"`
+	Timestamp int64         `json:"timestamp"`
+	Quality   Quality       `json:"quality,omitempty"`
+	Unit      string        `json:"unit,omitempty"`
+	Version   uint64        `json:"version,omitempty"`
+	Increment bool          `json:"increment,omitempty"`
+	Sequence  uint64        `json:"sequence,omitempty"`
+}
+
+// jsonProperties 属性对象，同一子设备的属性以属性 ID 为 key
+type jsonProperties struct {
+	SubDeviceID uint16                   `json:"sub_device_id"`
+	Properties  map[string]*jsonProperty `json:"properties"`
+}
+
+// jsonEvent 事件对象，未设置发生时间时省略 timestamp
+type jsonEvent struct {
+	ID          uint16        `json:"id"`
+	SubDeviceID uint16        `json:"sub_device_id"`
+	Timestamp   int64         `json:"timestamp,omitempty"`
+	Value       []interface{} `json:"value"`
+}
+
+// jsonCommand 命令对象，Params 为以参数序号为 key 的对象或数组
+type jsonCommand struct {
+	ID          uint16          `json:"id"`
+	SubDeviceID uint16          `json:"sub_device_id"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Token       string          `json:"token,omitempty"`
+}
+
+// jsonCommandResponse 命令回复对象
+type jsonCommandResponse struct {
+	ID          uint16                 `json:"id"`
+	SubDeviceID uint16                 `json:"sub_device_id"`
+	Code        int                    `json:"code"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// Marshal 序列化
+func (j *JSON) Marshal(data interface{}) (interface{}, error) {
+	return j.encode(data)
+}
+
+// Unmarshal 反序列化，data 为 []byte，返回解析得到的值
+func (j *JSON) Unmarshal(data interface{}) (interface{}, error) {
+	b, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("json unmarshal failed, data must be []byte")
+	}
+	var v interface{}
+	if err := decodeJSON(b, &v); err != nil {
+		return nil, err
+	}
+	return normalizeJSON(v), nil
+}
+
+// encode 编码为不带换行的 JSON
+func (j *JSON) encode(v interface{}) ([]byte, error) {
+	buf := j.getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode 会追加换行，去掉后与 json.Marshal 的结果保持一致
+	buf.Truncate(buf.Len() - 1)
+	return copyBytes(buf), nil
+}
+
+// decodeJSON 解析 JSON，数字解析为 json.Number
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// normalizeJSON 将 json.Number 转换为 int64 或 float64
+func normalizeJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeJSON(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeJSON(v[k])
+		}
+	}
+	return v
+}
+
+// makeJSONProperty 创建属性对象中的一个属性
+func makeJSONProperty(property *Property) *jsonProperty {
+	p := &jsonProperty{
+		Value:     bitmapsToUint32(property.Value),
+		Timestamp: timestampOf(property),
+		Quality:   property.Quality,
+		Unit:      property.Unit,
+		Version:   property.Version,
+		Increment: property.Increment,
+	}
+	if p.Value == nil {
+		p.Value = []interface{}{}
+	}
+	if property.Increment {
+		p.Sequence = property.Sequence
+	}
+	return p
+}
+
+// MakePropertyData 创建序列化后的属性数据
+func (j *JSON) MakePropertyData(property *Property) ([]byte, error) {
+	return j.encode(&jsonProperties{
+		SubDeviceID: property.SubDeviceID,
+		Properties: map[string]*jsonProperty{
+			strconv.Itoa(int(property.PropertyID)): makeJSONProperty(property),
+		},
+	})
+}
+
+// MakeBatchPropertyData 将多个属性序列化为属性对象的数组，子设备变化或属性 ID 重复时开始新的对象
+func (j *JSON) MakeBatchPropertyData(properties []*Property) ([]byte, error) {
+	groups := []*jsonProperties{}
+	var group *jsonProperties
+	for _, property := range properties {
+		key := strconv.Itoa(int(property.PropertyID))
+		if group == nil || group.SubDeviceID != property.SubDeviceID || group.Properties[key] != nil {
+			group = &jsonProperties{SubDeviceID: property.SubDeviceID, Properties: map[string]*jsonProperty{}}
+			groups = append(groups, group)
+		}
+		group.Properties[key] = makeJSONProperty(property)
+	}
+	return j.encode(groups)
+}
+
+// MakeEventData 创建序列化后的事件数据
+func (j *JSON) MakeEventData(property *Property) ([]byte, error) {
+	event := &jsonEvent{
+		ID:          property.PropertyID,
+		SubDeviceID: property.SubDeviceID,
+		Value:       bitmapsToUint32(property.Value),
+	}
+	if event.Value == nil {
+		event.Value = []interface{}{}
+	}
+	if !property.Timestamp.IsZero() {
+		event.Timestamp = timestampOf(property)
+	}
+	return j.encode(event)
+}
+
+// MakeCommandResponseData 创建序列化后的命令回复，data 以参数序号为 key：
+//
+//	{"id":1,"sub_device_id":0,"code":200,"data":{"0":25}}
+func (j *JSON) MakeCommandResponseData(resp *CommandResponse) ([]byte, error) {
+	params, err := responseParams(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	r := &jsonCommandResponse{
+		ID:          resp.ID,
+		SubDeviceID: resp.SubDeviceID,
+		Code:        resp.Code,
+	}
+	if len(params) > 0 {
+		r.Data = make(map[string]interface{}, len(params))
+		for i, v := range bitmapsToUint32(params) {
+			r.Data[strconv.Itoa(i)] = v
+		}
+	}
+	return j.encode(r)
+}
+
+// UnmarshalCommandResponse 命令回复反序列化，用于平台侧或测试解析 MakeCommandResponseData 的结果
+func (j *JSON) UnmarshalCommandResponse(data []byte) (*CommandResponse, error) {
+	r := &jsonCommandResponse{}
+	if err := decodeJSON(data, r); err != nil {
+		return nil, err
+	}
+	params, err := indexedParams(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &CommandResponse{
+		ID:          r.ID,
+		SubDeviceID: r.SubDeviceID,
+		Code:        r.Code,
+		Data:        params,
+	}, nil
+}
+
+// indexedParams 将以参数序号为 key 的对象转换为参数表
+func indexedParams(m map[string]interface{}) (map[int]interface{}, error) {
+	params := make(map[int]interface{}, len(m))
+	for k, v := range m {
+		index, err := strconv.Atoi(k)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("json param key %q is not an index", k)
+		}
+		params[index] = normalizeJSON(v)
+	}
+	return params, nil
+}
+
+// UnmarshalCommand 命令反序列化，参数为对象时 key 为参数序号，为数组时按顺序编号
+func (j *JSON) UnmarshalCommand(data []byte) (*Command, error) {
+	c := &jsonCommand{}
+	if err := decodeJSON(data, c); err != nil {
+		return nil, err
+	}
+	cmd := &Command{ID: c.ID, SubDeviceID: c.SubDeviceID, Params: map[int]interface{}{}, Token: c.Token}
+	if len(c.Params) == 0 || string(c.Params) == "null" {
+		return cmd, nil
+	}
+	var params interface{}
+	if err := decodeJSON(c.Params, &params); err != nil {
+		return nil, err
+	}
+	switch params := params.(type) {
+	case []interface{}:
+		for i, v := range params {
+			cmd.Params[i] = normalizeJSON(v)
+		}
+	case map[string]interface{}:
+		indexed, err := indexedParams(params)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Params = indexed
+	default:
+		return nil, errors.New("json command params must be an object or an array")
+	}
+	return cmd, nil
+}
+
+// UnmarshalProperty 属性反序列化，消息包含多个属性时返回属性 ID 最小的一个
+func (j *JSON) UnmarshalProperty(data []byte) (*Property, error) {
+	properties, err := j.UnmarshalBatchProperty(data)
+	if err != nil {
+		return nil, err
+	}
+	return properties[0], nil
+}
+
+// UnmarshalBatchProperty 反序列化一条消息中的所有属性，消息可以是属性对象或属性对象的数组，
+// 同一对象中的属性按属性 ID 排列
+func (j *JSON) UnmarshalBatchProperty(data []byte) ([]*Property, error) {
+	var groups []*jsonProperties
+	format, err := Detect(data)
+	if err != nil || format != FormatJSON {
+		return nil, errors.New("json unmarshal property failed, not a json object or array")
+	}
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); trimmed[0] == '[' {
+		err = decodeJSON(data, &groups)
+	} else {
+		group := &jsonProperties{}
+		err = decodeJSON(data, group)
+		groups = []*jsonProperties{group}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Property
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		ids := make([]int, 0, len(group.Properties))
+		for k := range group.Properties {
+			id, err := strconv.ParseUint(k, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("json property id %q: %v", k, err)
+			}
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			p := group.Properties[strconv.Itoa(id)]
+			if p == nil {
+				continue
+			}
+			property := &Property{
+				SubDeviceID: group.SubDeviceID,
+				PropertyID:  uint16(id),
+				Value:       p.Value,
+				Quality:     p.Quality,
+				Unit:        p.Unit,
+				Version:     p.Version,
+				Increment:   p.Increment,
+				Sequence:    p.Sequence,
+			}
+			if property.Value == nil {
+				property.Value = []interface{}{}
+			}
+			normalizeJSON(property.Value)
+			if p.Timestamp != 0 {
+				property.Timestamp = fromMillis(p.Timestamp)
+			}
+			ret = append(ret, property)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("property data is empty")
+	}
+	return ret, nil
+}
@@ -0,0 +1,126 @@
+package serializer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONProperty(t *testing.T) {
+	j := NewJSON()
+	measured := time.Unix(1700000000, 0)
+	data, err := j.MakePropertyData(&Property{
+		SubDeviceID: 1,
+		PropertyID:  2,
+		Value:       []interface{}{int32(-5), 25.5, "on", true, Bitmap(6)},
+		Unit:        "°C",
+		Timestamp:   measured,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"sub_device_id":1,"properties":{"2":{"value":[-5,25.5,"on",true,6],"timestamp":1700000000000,"unit":"°C"}}}`
+	if string(data) != want {
+		t.Fatalf("got %s", data)
+	}
+	p, err := j.UnmarshalProperty(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SubDeviceID != 1 || p.PropertyID != 2 || p.Unit != "°C" || !p.Timestamp.Equal(measured) {
+		t.Fatalf("unexpected property: %+v", p)
+	}
+	// 整数解析为 int64，其他数字为 float64
+	if !reflect.DeepEqual(p.Value, []interface{}{int64(-5), 25.5, "on", true, int64(6)}) {
+		t.Fatalf("unexpected values: %#v", p.Value)
+	}
+	if b, ok := ToBitmap(p.Value[4]); !ok || !b.Get(1) || !b.Get(2) {
+		t.Fatalf("bitmap round trip failed: %v", p.Value[4])
+	}
+}
+
+func TestJSONBatchProperty(t *testing.T) {
+	j := NewJSON()
+	data, err := j.MakeBatchPropertyData([]*Property{
+		{PropertyID: 2, Value: []interface{}{1}, Version: 3},
+		{PropertyID: 1, Value: []interface{}{2}, Increment: true, Sequence: 7},
+		{PropertyID: 1, Value: []interface{}{3}},
+		{SubDeviceID: 1, PropertyID: 1, Value: []interface{}{4}, Quality: QualityBad},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties, err := j.UnmarshalBatchProperty(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 4 {
+		t.Fatalf("got %d properties, want 4: %s", len(properties), data)
+	}
+	// 同一对象中的属性按 ID 排列
+	got := []interface{}{}
+	for _, p := range properties {
+		got = append(got, p.Value[0])
+	}
+	if !reflect.DeepEqual(got, []interface{}{int64(2), int64(1), int64(3), int64(4)}) {
+		t.Fatalf("unexpected order: %v", got)
+	}
+	if properties[0].Sequence != 7 || !properties[0].Increment || properties[1].Version != 3 ||
+		properties[3].SubDeviceID != 1 || properties[3].Quality != QualityBad {
+		t.Fatalf("unexpected properties: %+v %+v %+v", properties[0], properties[1], properties[3])
+	}
+}
+
+func TestJSONCommand(t *testing.T) {
+	j := NewJSON()
+	for _, data := range []string{
+		`{"id":1,"sub_device_id":3,"params":{"0":"on","1":50,"2":0.5,"3":false}}`,
+		`{"id":1,"sub_device_id":3,"params":["on",50,0.5,false]}`,
+	} {
+		cmd, err := j.UnmarshalCommand([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.ID != 1 || cmd.SubDeviceID != 3 ||
+			!reflect.DeepEqual(cmd.Params, map[int]interface{}{0: "on", 1: int64(50), 2: 0.5, 3: false}) {
+			t.Fatalf("unexpected command: %+v", cmd)
+		}
+	}
+	if cmd, err := j.UnmarshalCommand([]byte(`{"id":2}`)); err != nil || len(cmd.Params) != 0 || cmd.Token != "" {
+		t.Fatalf("command without params: %+v, %v", cmd, err)
+	}
+	if cmd, err := j.UnmarshalCommand([]byte(`{"id":2,"token":"uuid-1"}`)); err != nil || cmd.Token != "uuid-1" {
+		t.Fatalf("command with token: %+v, %v", cmd, err)
+	}
+	if _, err := j.UnmarshalCommand([]byte(`{"id":1,"params":{"a":1}}`)); err == nil {
+		t.Fatal("non-index param key should fail")
+	}
+
+	data, err := j.MakeCommandResponseData(&CommandResponse{ID: 1, SubDeviceID: 3, Code: ReplyCodeOK, Data: map[int]interface{}{0: "done"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":1,"sub_device_id":3,"code":200,"data":{"0":"done"}}` {
+		t.Fatalf("got %s", data)
+	}
+	resp, err := j.UnmarshalCommandResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 1 || resp.Code != ReplyCodeOK || resp.Data[0] != "done" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestJSONEvent(t *testing.T) {
+	data, err := NewJSON().MakeEventData(&Property{PropertyID: 4, Value: []interface{}{"overheat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":4,"sub_device_id":0,"value":["overheat"]}` {
+		t.Fatalf("got %s", data)
+	}
+	if f, err := Detect(data); err != nil || f != FormatJSON {
+		t.Fatalf("detect got %v, %v", f, err)
+	}
+}
@@ -0,0 +1,112 @@
+package serializer
+
+import (
+	"bytes"
+	"sync"
+)
+
+// MigrationMarker 新格式数据的前缀标记。TLV 数据以值为 0 的 Flag 开头，
+// JSON、CSV 等文本格式不会以 0xFE 开头，旧格式数据不会与标记冲突
+var MigrationMarker = []byte{0xFE, 0x01}
+
+// Migration 序列化格式迁移，按比例将部分上报数据使用新格式序列化并加上 MigrationMarker，
+// 其余仍使用旧格式；接收时根据标记选择对应的格式解析，两种格式均可接收
+type Migration struct {
+	From Serializer
+	To   Serializer
+
+	mu sync.Mutex
+	// toFraction 使用新格式的比例，0 到 1
+	toFraction float64
+	// credit 加权轮询的累计值，达到 1 时使用一次新格式
+	credit float64
+}
+
+// NewMigration 创建 Migration 对象，toFraction 为使用新格式的比例，超出 0 到 1 时取边界值
+func NewMigration(from, to Serializer, toFraction float64) *Migration {
+	m := &Migration{From: from, To: to}
+	m.SetFraction(toFraction)
+	return m
+}
+
+// SetFraction 修改使用新格式的比例，设置为 0 即回滚到旧格式
+func (m *Migration) SetFraction(toFraction float64) {
+	if toFraction < 0 {
+		toFraction = 0
+	}
+	if toFraction > 1 {
+		toFraction = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toFraction = toFraction
+	m.credit = 0
+}
+
+// useTo 按加权轮询决定本次是否使用新格式，比例为 0.25 时每 4 次使用 1 次
+func (m *Migration) useTo() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.credit += m.toFraction
+	if m.credit >= 1 {
+		m.credit--
+		return true
+	}
+	return false
+}
+
+// mark 新格式数据加上标记
+func mark(data []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, 0, len(MigrationMarker)+len(data))
+	ret = append(ret, MigrationMarker...)
+	return append(ret, data...), nil
+}
+
+// sniff 根据标记选择解析使用的格式，返回去掉标记后的数据
+func (m *Migration) sniff(data []byte) (Serializer, []byte) {
+	if bytes.HasPrefix(data, MigrationMarker) {
+		return m.To, data[len(MigrationMarker):]
+	}
+	return m.From, data
+}
+
+// Marshal 序列化，使用旧格式
+func (m *Migration) Marshal(data interface{}) (interface{}, error) {
+	return m.From.Marshal(data)
+}
+
+// Unmarshal 反序列化，使用旧格式
+func (m *Migration) Unmarshal(data interface{}) (interface{}, error) {
+	return m.From.Unmarshal(data)
+}
+
+// MakePropertyData 创建序列化后的属性数据
+func (m *Migration) MakePropertyData(property *Property) ([]byte, error) {
+	if m.useTo() {
+		return mark(m.To.MakePropertyData(property))
+	}
+	return m.From.MakePropertyData(property)
+}
+
+// MakeEventData 创建序列化后的事件数据
+func (m *Migration) MakeEventData(property *Property) ([]byte, error) {
+	if m.useTo() {
+		return mark(m.To.MakeEventData(property))
+	}
+	return m.From.MakeEventData(property)
+}
+
+// UnmarshalCommand 命令反序列化
+func (m *Migration) UnmarshalCommand(data []byte) (*Command, error) {
+	s, data := m.sniff(data)
+	return s.UnmarshalCommand(data)
+}
+
+// UnmarshalProperty 属性反序列化
+func (m *Migration) UnmarshalProperty(data []byte) (*Property, error) {
+	s, data := m.sniff(data)
+	return s.UnmarshalProperty(data)
+}
@@ -0,0 +1,41 @@
+package serializer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMigration(t *testing.T) {
+	m := NewMigration(NewTLV(), NewCSV([]string{"id", "0"}), 0.25)
+	property := &Property{PropertyID: 1, Value: []interface{}{uint16(5)}}
+	marked := 0
+	for i := 0; i < 8; i++ {
+		data, err := m.MakePropertyData(property)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(data, MigrationMarker) {
+			marked++
+		}
+		p, err := m.UnmarshalProperty(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.PropertyID != 1 || len(p.Value) != 1 {
+			t.Fatalf("unexpected property: %+v", p)
+		}
+	}
+	if marked != 2 {
+		t.Fatalf("%d of 8 in new format, want 2", marked)
+	}
+	// 回滚后全部使用旧格式，仍可解析新格式
+	m.SetFraction(0)
+	data, _ := m.MakePropertyData(property)
+	if bytes.HasPrefix(data, MigrationMarker) {
+		t.Fatal("rolled back migration should use old format")
+	}
+	cmd, err := m.UnmarshalCommand(append(append([]byte{}, MigrationMarker...), "3,on"...))
+	if err != nil || cmd.ID != 3 || cmd.Params[0] != "on" {
+		t.Fatalf("marked command should be decoded by new format: %+v, %v", cmd, err)
+	}
+}
@@ -0,0 +1,95 @@
+package serializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// 命令回复状态码
+const (
+	ReplyCodeOK    = 200
+	ReplyCodeError = 500
+	// ReplyCodeConflict 带版本的属性上报冲突，平台上的版本已更新
+	ReplyCodeConflict = 409
+	// ReplyCodeBusy 设备繁忙，命令未执行
+	ReplyCodeBusy = 503
+	// ReplyCodeNotImplemented 设备不支持该命令
+	ReplyCodeNotImplemented = 501
+)
+
+// Reply 命令回复
+type Reply struct {
+	CommandID   uint16      `json:"command_id"`
+	SubDeviceID uint16      `json:"sub_device_id"`
+	Code        int         `json:"code"`
+	Message     string      `json:"message,omitempty"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+// ReplySerializer 命令回复序列化，与属性、事件的序列化相互独立
+type ReplySerializer interface {
+	MarshalReply(reply *Reply) ([]byte, error)
+}
+
+// JSONReply 默认的命令回复序列化，使用 JSON 信封
+//
+//	{"command_id":1,"sub_device_id":1,"code":200,"message":"...","data":...}
+//
+// message、data 为空时省略
+type JSONReply struct {
+	Options
+}
+
+// NewJSONReply 创建 JSONReply 对象
+func NewJSONReply(opts ...Option) *JSONReply {
+	j := &JSONReply{}
+	j.apply(opts)
+	return j
+}
+
+// MarshalReply 序列化命令回复
+func (j *JSONReply) MarshalReply(reply *Reply) ([]byte, error) {
+	buf := j.getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(reply); err != nil {
+		return nil, err
+	}
+	// Encode 会追加换行，去掉后与 json.Marshal 的结果保持一致
+	buf.Truncate(buf.Len() - 1)
+	return copyBytes(buf), nil
+}
+
+// CommandResponse 命令的类型化回复，与命令使用相同的序列化格式
+type CommandResponse struct {
+	ID          uint16
+	SubDeviceID uint16
+	Code        int
+	// Data 回复参数，key 为参数序号，与命令参数相同从 0 开始连续编号
+	Data map[int]interface{}
+}
+
+// CommandResponseSerializer 支持将命令回复序列化为与命令相同格式的序列化器
+type CommandResponseSerializer interface {
+	MakeCommandResponseData(resp *CommandResponse) ([]byte, error)
+}
+
+// responseParams 按序号排列回复参数，负数序号为 SDK 保留（如 -1 为子设备 ID），忽略；
+// 其余序号不从 0 开始连续编号时返回错误
+func responseParams(data map[int]interface{}) ([]interface{}, error) {
+	keys := make([]int, 0, len(data))
+	for k := range data {
+		if k >= 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	params := make([]interface{}, 0, len(keys))
+	for i, k := range keys {
+		if k != i {
+			return nil, fmt.Errorf("command response param %d is missing", i)
+		}
+		params = append(params, data[k])
+	}
+	return params, nil
+}
@@ -0,0 +1,46 @@
+package serializer
+
+import "testing"
+
+func TestJSONReply(t *testing.T) {
+	data, err := NewJSONReply().MarshalReply(&Reply{CommandID: 1, SubDeviceID: 2, Code: ReplyCodeOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"command_id":1,"sub_device_id":2,"code":200}` {
+		t.Fatalf("unexpected reply: %s", data)
+	}
+}
+
+func TestCommandResponse(t *testing.T) {
+	for _, width := range []int{1, 2, 4} {
+		s := NewTLV(WithIDWidth(width))
+		data, err := s.MakeCommandResponseData(&CommandResponse{
+			ID:          3,
+			SubDeviceID: 1,
+			Code:        ReplyCodeOK,
+			Data:        map[int]interface{}{-1: uint16(1), 0: int32(25), 1: "done"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 与命令格式相同
+		cmd, err := s.UnmarshalCommand(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.ID != 3 || cmd.SubDeviceID != 1 || len(cmd.Params) != 3 {
+			t.Fatalf("width %d: got %+v", width, cmd)
+		}
+		resp, err := s.UnmarshalCommandResponse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ID != 3 || resp.Code != ReplyCodeOK || len(resp.Data) != 2 || resp.Data[0] != int32(25) || resp.Data[1] != "done" {
+			t.Fatalf("width %d: got %+v", width, resp)
+		}
+	}
+	if _, err := NewTLV().MakeCommandResponseData(&CommandResponse{Data: map[int]interface{}{1: int32(1)}}); err == nil {
+		t.Fatal("non-contiguous params should fail")
+	}
+}
@@ -1,5 +1,7 @@
 package serializer
 
+import "time"
+
 // Serializer 序列化
 type Serializer interface {
 	Marshal(data interface{}) (interface{}, error)
@@ -7,13 +9,47 @@ type Serializer interface {
 	MakePropertyData(data *Property) ([]byte, error)
 	MakeEventData(data *Property) ([]byte, error)
 	UnmarshalCommand(data []byte) (*Command, error)
+	UnmarshalProperty(data []byte) (*Property, error)
+}
+
+// BatchSerializer 支持将多个属性序列化为一条消息的序列化器
+type BatchSerializer interface {
+	MakeBatchPropertyData(data []*Property) ([]byte, error)
 }
 
+// BatchDeserializer 支持从一条消息中解析多个属性的序列化器
+type BatchDeserializer interface {
+	UnmarshalBatchProperty(data []byte) ([]*Property, error)
+}
+
+// Quality 属性数据质量码
+type Quality uint8
+
+const (
+	// QualityGood 数据正常，未设置时的默认值
+	QualityGood Quality = 0
+	// QualityUncertain 数据不确定，如传感器未校准、数据超出量程
+	QualityUncertain Quality = 1
+	// QualityBad 数据异常，如传感器故障
+	QualityBad Quality = 2
+)
+
 // Property 属性
 type Property struct {
 	SubDeviceID uint16
 	PropertyID  uint16
 	Value       []interface{}
+	Quality     Quality
+	// Unit 属性单位，如 °C、%、kPa，不支持单位的序列化器（如 TLV）会忽略
+	Unit string
+	// Version 属性版本，用于乐观并发控制，为 0 时不带版本
+	Version uint64
+	// Timestamp 属性的采集时间，与发送时间无关，为零值时使用序列化时的时间
+	Timestamp time.Time
+	// Increment 为 true 时 Value 为增量，平台将其累加到当前值而不是替换
+	Increment bool
+	// Sequence 增量序号，同一子设备的同一属性内递增，平台据此丢弃重复投递的增量，Increment 为 false 时忽略
+	Sequence uint64
 }
 
 // Command 命令
@@ -21,4 +57,24 @@ type Command struct {
 	ID          uint16
 	SubDeviceID uint16
 	Params      map[int]interface{}
+	// Token 命令的唯一标识，用于重复投递去重，如 TLV 头部的 Token、JSON 的 token 字段，格式不携带时为空
+	Token string
+}
+
+// timestampOf 属性采集时间的毫秒时间戳，未设置时使用当前时间
+func timestampOf(property *Property) int64 {
+	return timestampAt(property, time.Now())
+}
+
+// timestampAt 属性采集时间的毫秒时间戳，未设置时使用 now
+func timestampAt(property *Property, now time.Time) int64 {
+	if property.Timestamp.IsZero() {
+		return now.UnixNano() / int64(time.Millisecond)
+	}
+	return property.Timestamp.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis 毫秒时间戳转换为时间
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
 }
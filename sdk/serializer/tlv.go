@@ -1,9 +1,12 @@
 package serializer
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"iot-sdk-go/pkg/tlv"
 	"iot-sdk-go/pkg/typeconv"
+	"math"
 	"time"
 
 	"iot-sdk-go/pkg/protocol"
@@ -12,18 +15,21 @@ import (
 // TLV TLV对象
 type TLV struct {
 	Serializer tlv.TLV
+	Options
 }
 
 // NewTLV 创建TLV对象
-func NewTLV() *TLV {
-	return &TLV{}
+func NewTLV(opts ...Option) *TLV {
+	t := &TLV{}
+	t.apply(opts)
+	return t
 }
 
 // Marshal 序列化
 func (t *TLV) Marshal(data interface{}) (interface{}, error) {
 	v, ok := data.([]interface{})
 	if ok {
-		return tlv.MakeTLVs(v)
+		return tlv.MakeTLVs(bitmapsToUint32(v))
 	}
 	return nil, errors.New("")
 }
@@ -33,37 +39,98 @@ func (t *TLV) Unmarshal(data interface{}) (interface{}, error) {
 	return nil, nil
 }
 
-// MakePropertyData 创建序列化后的属性数据
+// MakePropertyData 创建序列化后的属性数据，单位由物模型定义，不写入消息
 func (t *TLV) MakePropertyData(property *Property) ([]byte, error) {
+	return t.MakeBatchPropertyData([]*Property{property})
+}
+
+// MakeBatchPropertyData 将多个属性序列化为一条消息，每个属性为一个内嵌数据。
+// 头部时间戳为第一个属性的采集时间，采集时间与之不同的属性追加采集时间标记。
+// 未设置采集时间的属性统一使用调用时的时间
+func (t *TLV) MakeBatchPropertyData(properties []*Property) ([]byte, error) {
+	now := time.Now()
+	var timestamp int64
+	if len(properties) > 0 {
+		timestamp = timestampAt(properties[0], now)
+	}
 	payloadHead := protocol.DataHead{
 		Flag:      0,
-		Timestamp: uint64(time.Now().Unix() * 1000),
+		Timestamp: uint64(timestamp),
+	}
+	// 组装数据
+	status := protocol.Data{
+		Head:    payloadHead,
+		SubData: make([]protocol.SubData, 0, len(properties)),
 	}
+	for _, property := range properties {
+		sub, err := t.makeSubData(property, timestamp, now)
+		if err != nil {
+			return nil, err
+		}
+		status.SubData = append(status.SubData, sub)
+	}
+	// 转 byte
+	buf := t.getBuffer()
+	defer putBuffer(buf)
+	if err := t.marshalData(buf, &status); err != nil {
+		return nil, err
+	}
+	return copyBytes(buf), nil
+}
+
+// makeSubData 创建属性的内嵌数据，headTimestamp 为头部的毫秒时间戳，未设置采集时间时使用 now
+func (t *TLV) makeSubData(property *Property, headTimestamp int64, now time.Time) (protocol.SubData, error) {
 	params, err := t.Marshal(property.Value)
 	paramsTLV, ok := params.([]tlv.TLV)
 	if !ok {
-		return nil, errors.New("marshal property failed")
+		return protocol.SubData{}, errors.New("marshal property failed")
 	}
 	if err != nil {
-		return nil, err
+		return protocol.SubData{}, err
+	}
+	// 数据质量非正常时追加质量标记
+	if property.Quality != QualityGood {
+		paramsTLV = append(paramsTLV, tlv.TLV{
+			Tag:   tlv.TLVQUALITY,
+			Value: []byte{byte(property.Quality)},
+		})
+	}
+	// 带版本时追加版本标记
+	if property.Version != 0 {
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, property.Version)
+		paramsTLV = append(paramsTLV, tlv.TLV{
+			Tag:   tlv.TLVVERSION,
+			Value: version,
+		})
+	}
+	// 增量上报时追加增量标记
+	if property.Increment {
+		sequence := make([]byte, 8)
+		binary.BigEndian.PutUint64(sequence, property.Sequence)
+		paramsTLV = append(paramsTLV, tlv.TLV{
+			Tag:   tlv.TLVINCREMENT,
+			Value: sequence,
+		})
+	}
+	// 采集时间与头部不同时追加采集时间标记
+	if timestamp := timestampAt(property, now); timestamp != headTimestamp {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(timestamp))
+		paramsTLV = append(paramsTLV, tlv.TLV{
+			Tag:   tlv.TLVTIMESTAMP,
+			Value: value,
+		})
 	}
 	// 内嵌数据
-	sub := protocol.SubData{
+	return protocol.SubData{
 		Head: protocol.SubDataHead{
 			SubDeviceid: property.SubDeviceID,
 			PropertyNum: property.PropertyID,
 			ParamsCount: uint16(len(paramsTLV)),
 		},
 		Params: paramsTLV,
-	}
-	// 组装数据
-	status := protocol.Data{
-		Head:    payloadHead,
-		SubData: []protocol.SubData{},
-	}
-	status.SubData = append(status.SubData, sub)
-	// 转 byte
-	return status.Marshal()
+	}, nil
 }
 
 // MakeEventData 创建序列化后的事件数据
@@ -81,12 +148,19 @@ func (t *TLV) MakeEventData(property *Property) ([]byte, error) {
 	event.Head.No = property.PropertyID
 	event.Head.SubDeviceid = property.SubDeviceID
 	event.Head.ParamsCount = uint16(len(paramsTLV))
-	return event.Marshal()
+	if !property.Timestamp.IsZero() {
+		event.Head.Timestamp = uint64(timestampOf(property))
+	}
+	buf := t.getBuffer()
+	defer putBuffer(buf)
+	if err := t.marshalEvent(buf, &event); err != nil {
+		return nil, err
+	}
+	return copyBytes(buf), nil
 }
 
 // UnmarshalCommand 命令反序列化
 func (t *TLV) UnmarshalCommand(data []byte) (*Command, error) {
-	cmd := protocol.Command{}
 	dataByte := make([]byte, len(data))
 	for i, v := range data {
 		v2, err := typeconv.InterfaceToByte(v)
@@ -95,7 +169,7 @@ func (t *TLV) UnmarshalCommand(data []byte) (*Command, error) {
 		}
 		dataByte[i] = v2
 	}
-	err := cmd.UnMarshal(dataByte)
+	cmd, err := t.unmarshalCommand(dataByte)
 	if err != nil {
 		return nil, err
 	}
@@ -108,5 +182,121 @@ func (t *TLV) UnmarshalCommand(data []byte) (*Command, error) {
 		SubDeviceID: cmd.Head.SubDeviceid,
 		Params:      params,
 	}
+	if cmd.Head.Token != [8]byte{} {
+		ret.Token = hex.EncodeToString(cmd.Head.Token[:])
+	}
+	return ret, nil
+}
+
+// MakeCommandResponseData 创建序列化后的命令回复，格式与命令相同：头部编号为命令 ID，
+// 第一个参数为 int32 的状态码，之后依次为回复参数
+func (t *TLV) MakeCommandResponseData(resp *CommandResponse) ([]byte, error) {
+	if resp.Code < math.MinInt32 || resp.Code > math.MaxInt32 {
+		return nil, errors.New("command response code overflows int32")
+	}
+	params, err := responseParams(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	paramsTLV, err := tlv.MakeTLVs(append([]interface{}{int32(resp.Code)}, bitmapsToUint32(params)...))
+	if err != nil {
+		return nil, err
+	}
+	cmd := protocol.Event{Params: paramsTLV}
+	cmd.Head.Timestamp = uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	cmd.Head.No = resp.ID
+	cmd.Head.SubDeviceid = resp.SubDeviceID
+	cmd.Head.ParamsCount = uint16(len(paramsTLV))
+	buf := t.getBuffer()
+	defer putBuffer(buf)
+	// 命令与事件的头部格式相同
+	if err := t.marshalEvent(buf, &cmd); err != nil {
+		return nil, err
+	}
+	return copyBytes(buf), nil
+}
+
+// UnmarshalCommandResponse 命令回复反序列化，用于平台侧或测试解析 MakeCommandResponseData 的结果
+func (t *TLV) UnmarshalCommandResponse(data []byte) (*CommandResponse, error) {
+	cmd, err := t.unmarshalCommand(data)
+	if err != nil {
+		return nil, err
+	}
+	params, err := tlv.ReadTLVs(cmd.Params)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		return nil, errors.New("command response code is missing")
+	}
+	code, ok := params[0].(int32)
+	if !ok {
+		return nil, errors.New("command response code is not int32")
+	}
+	ret := &CommandResponse{
+		ID:          cmd.Head.No,
+		SubDeviceID: cmd.Head.SubDeviceid,
+		Code:        int(code),
+		Data:        make(map[int]interface{}, len(params)-1),
+	}
+	for i, v := range params[1:] {
+		ret.Data[i] = v
+	}
+	return ret, nil
+}
+
+// UnmarshalProperty 属性反序列化，消息包含多个属性时返回第一个
+func (t *TLV) UnmarshalProperty(data []byte) (*Property, error) {
+	properties, err := t.UnmarshalBatchProperty(data)
+	if err != nil {
+		return nil, err
+	}
+	return properties[0], nil
+}
+
+// UnmarshalBatchProperty 反序列化一条消息中的所有属性，每个内嵌数据为一个属性
+func (t *TLV) UnmarshalBatchProperty(data []byte) ([]*Property, error) {
+	status, err := t.unmarshalData(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.SubData) == 0 {
+		return nil, errors.New("property data is empty")
+	}
+	ret := make([]*Property, 0, len(status.SubData))
+	for _, sub := range status.SubData {
+		property := &Property{
+			SubDeviceID: sub.Head.SubDeviceid,
+			PropertyID:  sub.Head.PropertyNum,
+			Value:       []interface{}{},
+			Quality:     QualityGood,
+			Timestamp:   fromMillis(int64(status.Head.Timestamp)),
+		}
+		for _, param := range sub.Params {
+			if param.Tag == tlv.TLVTIMESTAMP {
+				property.Timestamp = fromMillis(int64(binary.BigEndian.Uint64(param.Value)))
+				continue
+			}
+			if param.Tag == tlv.TLVQUALITY {
+				property.Quality = Quality(param.Value[0])
+				continue
+			}
+			if param.Tag == tlv.TLVVERSION {
+				property.Version = binary.BigEndian.Uint64(param.Value)
+				continue
+			}
+			if param.Tag == tlv.TLVINCREMENT {
+				property.Increment = true
+				property.Sequence = binary.BigEndian.Uint64(param.Value)
+				continue
+			}
+			value, err := tlv.ReadTLV(&param)
+			if err != nil {
+				return nil, err
+			}
+			property.Value = append(property.Value, value)
+		}
+		ret = append(ret, property)
+	}
 	return ret, nil
 }
@@ -0,0 +1,196 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"iot-sdk-go/pkg/protocol"
+	"iot-sdk-go/pkg/tlv"
+	"math"
+)
+
+// DefaultIDWidth TLV 中属性 ID、事件 ID、命令 ID 默认占用的字节数
+const DefaultIDWidth = 2
+
+// WithIDWidth 设置 TLV 中属性 ID、事件 ID、命令 ID 占用的字节数，可以为 1、2、4，默认为 DefaultIDWidth。
+// 编码时 ID 超出该宽度能表示的范围返回错误；宽度为 4 时解析到超过 65535 的 ID 返回错误
+func WithIDWidth(width int) Option {
+	return func(o *Options) {
+		o.IDWidth = width
+	}
+}
+
+// idWidth ID 占用的字节数，未设置时为 DefaultIDWidth
+func (o *Options) idWidth() (int, error) {
+	switch o.IDWidth {
+	case 0:
+		return DefaultIDWidth, nil
+	case 1, 2, 4:
+		return o.IDWidth, nil
+	}
+	return 0, fmt.Errorf("invalid tlv id width %d, must be 1, 2 or 4", o.IDWidth)
+}
+
+// writeID 按宽度写入 ID
+func writeID(buf *bytes.Buffer, id uint16, width int) error {
+	switch width {
+	case 1:
+		if id > math.MaxUint8 {
+			return fmt.Errorf("id %d overflows 1 byte tlv id", id)
+		}
+		return buf.WriteByte(byte(id))
+	case 4:
+		return binary.Write(buf, binary.BigEndian, uint32(id))
+	}
+	return binary.Write(buf, binary.BigEndian, id)
+}
+
+// readID 按宽度读取 ID
+func readID(r *bytes.Reader, width int) (uint16, error) {
+	switch width {
+	case 1:
+		b, err := r.ReadByte()
+		return uint16(b), err
+	case 4:
+		var id uint32
+		if err := binary.Read(r, binary.BigEndian, &id); err != nil {
+			return 0, err
+		}
+		if id > math.MaxUint16 {
+			return 0, fmt.Errorf("tlv id %d overflows uint16", id)
+		}
+		return uint16(id), nil
+	}
+	var id uint16
+	err := binary.Read(r, binary.BigEndian, &id)
+	return id, err
+}
+
+// marshalData 序列化属性数据，ID 宽度为默认值时与 protocol.Data 的格式相同
+func (t *TLV) marshalData(buf *bytes.Buffer, data *protocol.Data) error {
+	width, err := t.idWidth()
+	if err != nil {
+		return err
+	}
+	if width == DefaultIDWidth {
+		return data.MarshalTo(buf)
+	}
+	if err := binary.Write(buf, binary.BigEndian, data.Head); err != nil {
+		return err
+	}
+	for _, sub := range data.SubData {
+		binary.Write(buf, binary.BigEndian, sub.Head.SubDeviceid)
+		if err := writeID(buf, sub.Head.PropertyNum, width); err != nil {
+			return err
+		}
+		binary.Write(buf, binary.BigEndian, sub.Head.ParamsCount)
+		for i := range sub.Params {
+			sub.Params[i].WriteBinary(buf)
+		}
+	}
+	return nil
+}
+
+// unmarshalData 解析属性数据
+func (t *TLV) unmarshalData(data []byte) (*protocol.Data, error) {
+	width, err := t.idWidth()
+	if err != nil {
+		return nil, err
+	}
+	status := &protocol.Data{}
+	if width == DefaultIDWidth {
+		return status, status.UnMarshal(data)
+	}
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.BigEndian, &status.Head); err != nil {
+		return nil, err
+	}
+	for r.Len() > 0 {
+		sub := protocol.SubData{}
+		if err := binary.Read(r, binary.BigEndian, &sub.Head.SubDeviceid); err != nil {
+			return nil, err
+		}
+		if sub.Head.PropertyNum, err = readID(r, width); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &sub.Head.ParamsCount); err != nil {
+			return nil, err
+		}
+		params, err := readParams(r, int(sub.Head.ParamsCount))
+		if err != nil {
+			return nil, err
+		}
+		sub.Params = params
+		status.SubData = append(status.SubData, sub)
+	}
+	return status, nil
+}
+
+// marshalEvent 序列化事件，ID 宽度为默认值时与 protocol.Event 的格式相同
+func (t *TLV) marshalEvent(buf *bytes.Buffer, event *protocol.Event) error {
+	width, err := t.idWidth()
+	if err != nil {
+		return err
+	}
+	if width == DefaultIDWidth {
+		return event.MarshalTo(buf)
+	}
+	head := event.Head
+	binary.Write(buf, binary.BigEndian, head.Flag)
+	binary.Write(buf, binary.BigEndian, head.Timestamp)
+	binary.Write(buf, binary.BigEndian, head.Token)
+	binary.Write(buf, binary.BigEndian, head.SubDeviceid)
+	if err := writeID(buf, head.No, width); err != nil {
+		return err
+	}
+	binary.Write(buf, binary.BigEndian, head.Priority)
+	binary.Write(buf, binary.BigEndian, head.ParamsCount)
+	for i := range event.Params {
+		event.Params[i].WriteBinary(buf)
+	}
+	return nil
+}
+
+// unmarshalCommand 解析命令
+func (t *TLV) unmarshalCommand(data []byte) (*protocol.Command, error) {
+	width, err := t.idWidth()
+	if err != nil {
+		return nil, err
+	}
+	cmd := &protocol.Command{}
+	if width == DefaultIDWidth {
+		return cmd, cmd.UnMarshal(data)
+	}
+	r := bytes.NewReader(data)
+	head := &cmd.Head
+	for _, v := range []interface{}{&head.Flag, &head.Timestamp, &head.Token, &head.SubDeviceid} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	if head.No, err = readID(r, width); err != nil {
+		return nil, err
+	}
+	for _, v := range []interface{}{&head.Priority, &head.ParamsCount} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.Params, err = readParams(r, int(head.ParamsCount)); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// readParams 读取 n 个参数
+func readParams(r *bytes.Reader, n int) ([]tlv.TLV, error) {
+	params := make([]tlv.TLV, 0, n)
+	for i := 0; i < n; i++ {
+		param := tlv.TLV{}
+		if err := param.FromBinary(r); err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
@@ -0,0 +1,314 @@
+package serializer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"iot-sdk-go/pkg/protocol"
+	"iot-sdk-go/pkg/tlv"
+	"testing"
+	"time"
+)
+
+func TestPropertyQuality(t *testing.T) {
+	s := NewTLV()
+	for _, q := range []Quality{QualityGood, QualityUncertain, QualityBad} {
+		data, err := s.MakePropertyData(&Property{
+			SubDeviceID: 1,
+			PropertyID:  2,
+			Value:       []interface{}{uint16(0)},
+			Quality:     q,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := s.UnmarshalProperty(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Quality != q || len(p.Value) != 1 || p.Value[0] != uint16(0) {
+			t.Fatalf("unexpected property: %+v", p)
+		}
+	}
+}
+
+func TestPropertyVersion(t *testing.T) {
+	for _, s := range []Serializer{NewTLV(), NewCSV([]string{CSVID, "0", CSVVersion})} {
+		for _, v := range []uint64{0, 7, 1<<64 - 1} {
+			data, err := s.MakePropertyData(&Property{PropertyID: 2, Value: []interface{}{uint16(1)}, Version: v})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := s.UnmarshalProperty(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Version != v || p.PropertyID != 2 || len(p.Value) != 1 {
+				t.Fatalf("%T: unexpected property: %+v, want version %d", s, p, v)
+			}
+		}
+	}
+}
+
+func TestPropertyIncrement(t *testing.T) {
+	for _, s := range []Serializer{NewTLV(), NewCSV([]string{CSVID, "0", CSVIncrement})} {
+		data, err := s.MakePropertyData(&Property{PropertyID: 3, Value: []interface{}{float64(1.5)}, Increment: true, Sequence: 42})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := s.UnmarshalProperty(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Increment || p.Sequence != 42 || len(p.Value) != 1 {
+			t.Fatalf("%T: unexpected property: %+v", s, p)
+		}
+	}
+	// 没有增量列时不能区分增量与绝对值
+	if _, err := NewCSV([]string{CSVID, "0"}).MakePropertyData(&Property{PropertyID: 3, Value: []interface{}{1}, Increment: true}); err == nil {
+		t.Fatal("csv without increment column should reject increment property")
+	}
+}
+
+func TestBitmap(t *testing.T) {
+	var b Bitmap
+	b.Set(0)
+	b.Set(15)
+	b.Set(BitmapWidth)
+	if !b.Get(0) || !b.Get(15) || b.Get(1) || b.Get(BitmapWidth) || b != 1<<15|1 {
+		t.Fatalf("unexpected bitmap: %b", b)
+	}
+	b.Clear(0)
+	if b.Get(0) {
+		t.Fatal("bit 0 should be cleared")
+	}
+	// TLV 中作为 uint32 传输，调用方的值不变
+	value := []interface{}{b}
+	data, err := NewTLV().MakePropertyData(&Property{PropertyID: 1, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := value[0].(Bitmap); !ok {
+		t.Fatal("caller's value should not be modified")
+	}
+	p, err := NewTLV().UnmarshalProperty(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ToBitmap(p.Value[0]); !ok || got != b {
+		t.Fatalf("decoded %v, want %v", p.Value[0], b)
+	}
+	for _, v := range []interface{}{int16(-1), uint64(1 << 32), "x", 1.5} {
+		if _, ok := ToBitmap(v); ok {
+			t.Fatalf("%v should not convert to bitmap", v)
+		}
+	}
+	if got, ok := ToBitmap("5"); !ok || !got.Get(0) || !got.Get(2) {
+		t.Fatalf("string 5 converted to %b", got)
+	}
+}
+
+func makeBatchProperty() *Property {
+	value := make([]interface{}, 100)
+	for i := range value {
+		value[i] = float64(i)
+	}
+	return &Property{SubDeviceID: 1, PropertyID: 2, Value: value}
+}
+
+func TestBufferHint(t *testing.T) {
+	property := makeBatchProperty()
+	want, err := NewTLV().MakePropertyData(property)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewTLV(WithBufferHint(2048)).MakePropertyData(property)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewTLV().UnmarshalProperty(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || len(p.Value) != 100 || p.Value[99] != float64(99) {
+		t.Fatalf("unexpected property: %+v", p)
+	}
+}
+
+func BenchmarkMakePropertyData(b *testing.B) {
+	property := makeBatchProperty()
+	b.Run("Default", func(b *testing.B) {
+		s := NewTLV()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.MakePropertyData(property)
+		}
+	})
+	b.Run("BufferHint", func(b *testing.B) {
+		s := NewTLV(WithBufferHint(2048))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.MakePropertyData(property)
+		}
+	})
+}
+
+func TestPropertyTimestamp(t *testing.T) {
+	measured := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	s := NewTLV()
+	data, err := s.MakeBatchPropertyData([]*Property{
+		{PropertyID: 1, Value: []interface{}{uint8(1)}, Timestamp: measured},
+		{PropertyID: 2, Value: []interface{}{uint8(2)}, Timestamp: measured.Add(time.Second)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	property, err := s.UnmarshalProperty(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !property.Timestamp.Equal(measured) {
+		t.Fatalf("got timestamp %v, want %v", property.Timestamp, measured)
+	}
+	// 采集时间与头部不同的属性带采集时间标记
+	status := protocol.Data{}
+	if err := status.UnMarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	params := status.SubData[1].Params
+	last := params[len(params)-1]
+	if last.Tag != tlv.TLVTIMESTAMP || int64(binary.BigEndian.Uint64(last.Value)) != measured.Add(time.Second).UnixNano()/int64(time.Millisecond) {
+		t.Fatalf("unexpected params of second property: %v", params)
+	}
+
+	c := NewCSV([]string{CSVTimestamp, CSVID, "0"})
+	data, err = c.MakePropertyData(&Property{PropertyID: 1, Value: []interface{}{1}, Timestamp: measured})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if property, err = c.UnmarshalProperty(data); err != nil {
+		t.Fatal(err)
+	}
+	if !property.Timestamp.Equal(measured) {
+		t.Fatalf("got csv timestamp %v, want %v", property.Timestamp, measured)
+	}
+}
+
+func TestTLVCommandToken(t *testing.T) {
+	s := NewTLV()
+	event, err := s.MakeEventData(&Property{PropertyID: 1, Value: []interface{}{uint8(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd, err := s.UnmarshalCommand(event); err != nil || cmd.Token != "" {
+		t.Fatalf("zero token: %+v, %v", cmd, err)
+	}
+	// 头部依次为 Flag、Timestamp、Token
+	copy(event[9:17], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	if cmd, err := s.UnmarshalCommand(event); err != nil || cmd.Token != "0102030405060708" {
+		t.Fatalf("token: %+v, %v", cmd, err)
+	}
+}
+
+func TestTLVIDWidth(t *testing.T) {
+	property := &Property{SubDeviceID: 1, PropertyID: 200, Value: []interface{}{uint8(3), "on"}}
+	defaultData, err := NewTLV().MakePropertyData(property)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, width := range []int{1, 2, 4} {
+		s := NewTLV(WithIDWidth(width))
+		data, err := s.MakePropertyData(property)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		if len(data) != len(defaultData)+width-DefaultIDWidth {
+			t.Fatalf("width %d: got %d bytes, want %d", width, len(data), len(defaultData)+width-DefaultIDWidth)
+		}
+		p, err := s.UnmarshalProperty(data)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		if p.SubDeviceID != 1 || p.PropertyID != 200 || len(p.Value) != 2 || p.Value[1] != "on" {
+			t.Fatalf("width %d: unexpected property %+v", width, p)
+		}
+		event, err := s.MakeEventData(property)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		// 命令与事件的头部相同，复用事件数据解析命令
+		cmd, err := s.UnmarshalCommand(event)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		if cmd.ID != 200 || cmd.SubDeviceID != 1 || len(cmd.Params) != 2 {
+			t.Fatalf("width %d: unexpected command %+v", width, cmd)
+		}
+	}
+	s := NewTLV(WithIDWidth(1))
+	if _, err := s.MakePropertyData(&Property{PropertyID: 256, Value: []interface{}{uint8(1)}}); err == nil {
+		t.Fatal("id 256 should overflow 1 byte")
+	}
+	if _, err := s.MakeEventData(&Property{PropertyID: 256, Value: []interface{}{uint8(1)}}); err == nil {
+		t.Fatal("event id 256 should overflow 1 byte")
+	}
+	if _, err := NewTLV(WithIDWidth(3)).MakePropertyData(property); err == nil {
+		t.Fatal("width 3 should be rejected")
+	}
+	// 4 字节 ID 超出 uint16
+	data, _ := NewTLV(WithIDWidth(4)).MakePropertyData(property)
+	data[17+2] = 1
+	if _, err := NewTLV(WithIDWidth(4)).UnmarshalProperty(data); err == nil {
+		t.Fatal("id over 65535 should be rejected")
+	}
+}
+
+func TestSubDevicePropertyScope(t *testing.T) {
+	// 两个子设备使用相同的属性 ID，序列化后按 SubDeviceID 区分
+	for _, s := range []Serializer{NewTLV(), NewCSV([]string{CSVSubDeviceID, CSVID, "0"})} {
+		for _, sub := range []uint16{1, 2} {
+			data, err := s.MakePropertyData(&Property{SubDeviceID: sub, PropertyID: 3, Value: []interface{}{int32(sub)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := s.UnmarshalProperty(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.SubDeviceID != sub || p.PropertyID != 3 {
+				t.Fatalf("%T: unexpected property: %+v", s, p)
+			}
+		}
+	}
+	if _, err := NewCSV([]string{CSVID, "0"}).MakePropertyData(&Property{SubDeviceID: 1, PropertyID: 3, Value: []interface{}{1}}); err == nil {
+		t.Fatal("csv without sub device column should reject sub device property")
+	}
+}
+
+func TestBatchPropertySubDevices(t *testing.T) {
+	// 一批中包含多个子设备的属性，解析后每个属性的子设备、属性 ID 与值不变
+	batch := []*Property{
+		{SubDeviceID: 1, PropertyID: 3, Value: []interface{}{int32(10)}},
+		{SubDeviceID: 1, PropertyID: 4, Value: []interface{}{int32(11)}},
+		{SubDeviceID: 2, PropertyID: 3, Value: []interface{}{int32(20)}},
+		{SubDeviceID: 1, PropertyID: 5, Value: []interface{}{int32(12)}},
+	}
+	for _, s := range []Serializer{NewTLV(), NewJSON()} {
+		data, err := s.(BatchSerializer).MakeBatchPropertyData(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.(BatchDeserializer).UnmarshalBatchProperty(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(batch) {
+			t.Fatalf("%T: got %d properties, want %d", s, len(got), len(batch))
+		}
+		for i, p := range got {
+			want := batch[i]
+			if p.SubDeviceID != want.SubDeviceID || p.PropertyID != want.PropertyID || fmt.Sprint(p.Value) != fmt.Sprint(want.Value) {
+				t.Fatalf("%T: property %d = %+v, want %+v", s, i, p, want)
+			}
+		}
+	}
+}
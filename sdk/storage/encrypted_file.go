@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// encryptedFileVersion 文件格式版本，文件内容为 版本(1 字节) + nonce + AES-GCM 密文
+const encryptedFileVersion = 1
+
+var (
+	// ErrInvalidKey 密钥长度不是 16、24 或 32 字节
+	ErrInvalidKey = errors.New("encryption key must be 16, 24 or 32 bytes")
+	// ErrWrongKey 无法解密存储文件，密钥错误或文件已损坏
+	ErrWrongKey = errors.New("cannot decrypt storage file: wrong key or corrupted file")
+)
+
+// EncryptedFile 加密的本地文件存储，使用 AES-GCM 加密整个文件，用于保护设备的 Secret、Token。
+// 数据在创建时解密到内存，每次写入都重新加密写文件。
+// 值按类型编码后保存，Get 返回与 Set 相同的类型
+type EncryptedFile struct {
+	path string
+	aead cipher.AEAD
+
+	mu   sync.Mutex
+	data map[string]string
+	size int64
+}
+
+// NewEncryptedFile 使用 key 打开 path 中的加密存储，key 为 16、24 或 32 字节，分别对应 AES-128、AES-192、AES-256。
+// 文件不存在时为空的存储，第一次写入时创建；密钥错误时返回 ErrWrongKey
+func NewEncryptedFile(path string, key []byte) (*EncryptedFile, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &EncryptedFile{path: path, aead: aead, data: map[string]string{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decrypt(content); err != nil {
+		return nil, err
+	}
+	s.size = int64(len(content))
+	return s, nil
+}
+
+// EncryptionKeyFromEnv 从环境变量读取密钥，值为 hex 或 base64 编码
+func EncryptionKeyFromEnv(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return parseEncryptionKey(value)
+}
+
+// EncryptionKeyFromFile 从文件读取密钥，文件内容为 16、24、32 字节的原始密钥，或 hex、base64 编码的文本
+func EncryptionKeyFromFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if validKeySize(len(content)) {
+		return content, nil
+	}
+	return parseEncryptionKey(string(bytes.TrimSpace(content)))
+}
+
+// parseEncryptionKey 按 hex、base64 解码密钥
+func parseEncryptionKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// validKeySize n 是否为 AES 密钥长度
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// decrypt 解密文件内容到 data
+func (s *EncryptedFile) decrypt(content []byte) error {
+	nonceSize := s.aead.NonceSize()
+	if len(content) < 1+nonceSize || content[0] != encryptedFileVersion {
+		return ErrWrongKey
+	}
+	nonce, ciphertext := content[1:1+nonceSize], content[1+nonceSize:]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte{encryptedFileVersion})
+	if err != nil {
+		return ErrWrongKey
+	}
+	return json.Unmarshal(plaintext, &s.data)
+}
+
+// save 加密 data 写入临时文件后替换原文件，避免写入中断时损坏原文件。调用方持有 mu
+func (s *EncryptedFile) save() error {
+	plaintext, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	content := make([]byte, 1+s.aead.NonceSize())
+	content[0] = encryptedFileVersion
+	if _, err := io.ReadFull(rand.Reader, content[1:]); err != nil {
+		return err
+	}
+	content = s.aead.Seal(content, content[1:], plaintext, []byte{encryptedFileVersion})
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.size = int64(len(content))
+	return nil
+}
+
+// Get 根据 key 获取 data，key 不存在时返回 nil
+func (s *EncryptedFile) Get(key string) (interface{}, error) {
+	if key == "" {
+		return nil, errors.New("Key cannot be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+	return decodeValue([]byte(data))
+}
+
+// Set 根据 key 设置 data
+func (s *EncryptedFile) Set(key string, value interface{}) error {
+	if key == "" {
+		return errors.New("Key cannot be empty")
+	}
+	if value == nil {
+		return errors.New("Value cannot be empty")
+	}
+	data, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, existed := s.data[key]
+	s.data[key] = data
+	if err := s.save(); err != nil {
+		if existed {
+			s.data[key] = old
+		} else {
+			delete(s.data, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Del 根据 key 删除 data
+func (s *EncryptedFile) Del(key string) error {
+	if key == "" {
+		return errors.New("Key cannot be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, existed := s.data[key]
+	if !existed {
+		return nil
+	}
+	delete(s.data, key)
+	if err := s.save(); err != nil {
+		s.data[key] = old
+		return err
+	}
+	return nil
+}
+
+// Size 存储文件的字节数
+func (s *EncryptedFile) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size, nil
+}
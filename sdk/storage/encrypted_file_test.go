@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	// 第一次运行文件不存在，读取返回 nil 而不是解密错误
+	s, err := NewEncryptedFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("light.Token"); v != nil || err != nil {
+		t.Fatalf("get from new storage = %v, %v", v, err)
+	}
+	token := []byte{0, 1, 0xfe, 0xff}
+	if err := s.Set("light.Token", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("light.ID", int64(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("light.Secret", "plain-secret"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("plain-secret")) || bytes.Contains(content, []byte("light")) {
+		t.Fatal("storage file contains plaintext")
+	}
+	if size, _ := s.Size(); size != int64(len(content)) {
+		t.Fatalf("size = %d, want %d", size, len(content))
+	}
+
+	// 使用正确的密钥重新打开
+	s, err = NewEncryptedFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("light.Token"); !bytes.Equal(v.([]byte), token) {
+		t.Fatalf("token = %v, want %v", v, token)
+	}
+	if v, _ := s.Get("light.ID"); v != int64(42) {
+		t.Fatalf("id = %#v", v)
+	}
+	if err := s.Del("light.Secret"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("light.Secret"); v != nil || err != nil {
+		t.Fatalf("deleted key = %v, %v", v, err)
+	}
+
+	// 使用错误的密钥重新打开
+	if _, err := NewEncryptedFile(path, bytes.Repeat([]byte{2}, 32)); err != ErrWrongKey {
+		t.Fatalf("expect ErrWrongKey, got %v", err)
+	}
+	if _, err := NewEncryptedFile(path, []byte("short")); err != ErrInvalidKey {
+		t.Fatalf("expect ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 16)
+	os.Setenv("IOT_TEST_STORAGE_KEY", hex.EncodeToString(key))
+	defer os.Unsetenv("IOT_TEST_STORAGE_KEY")
+	if got, err := EncryptionKeyFromEnv("IOT_TEST_STORAGE_KEY"); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("key from env = %x, %v", got, err)
+	}
+	if _, err := EncryptionKeyFromEnv("IOT_TEST_STORAGE_KEY_MISSING"); err == nil {
+		t.Fatal("missing env should fail")
+	}
+
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.key")
+	ioutil.WriteFile(raw, key, 0600)
+	text := filepath.Join(dir, "text.key")
+	ioutil.WriteFile(text, []byte("q6urq6urq6urq6urq6urqw==\n"), 0600)
+	for _, path := range []string{raw, text} {
+		if got, err := EncryptionKeyFromFile(path); err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("key from %s = %x, %v", path, got, err)
+		}
+	}
+}
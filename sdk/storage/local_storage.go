@@ -8,7 +8,7 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-// LocalStorage 本地存储
+// LocalStorage 本地存储，每次写入都直接写文件
 type LocalStorage struct{}
 
 var fileName = "storage.yaml"
@@ -69,6 +69,11 @@ func (s *LocalStorage) Set(key string, value interface{}) error {
 	return nil
 }
 
+// Size 存储文件的字节数
+func (s *LocalStorage) Size() (int64, error) {
+	return int64(len(content)), nil
+}
+
 // Del 根据 key 删除 data
 func (s *LocalStorage) Del(key string) error {
 	if key == "" {
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"iot-sdk-go/pkg/redis"
+)
+
+// RedisOptions Redis 存储的连接配置
+type RedisOptions struct {
+	// Addr 服务端地址，host:port
+	Addr string
+	// Username Redis 6 ACL 用户名，为空时只使用 Password 认证
+	Username string
+	// Password 密码，为空时不认证
+	Password string
+	// DB 数据库编号
+	DB int
+	// KeyPrefix 所有 key 的前缀，多个应用共用一个数据库时用于区分，例如 "iot:"
+	KeyPrefix string
+	// TTL 写入的 key 的有效期，为 0 时永不过期
+	TTL time.Duration
+	// Timeout 连接、每个命令的超时时间，为 0 时使用 redis.DefaultTimeout
+	Timeout time.Duration
+	// Dial 建立连接，为 nil 时使用 net.Dialer
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+// Redis 使用 Redis 保存数据，多个网关进程可以共用设备信息。值按类型编码后保存，Get 返回与 Set 相同的类型
+type Redis struct {
+	opts   RedisOptions
+	client *redis.Client
+}
+
+// NewRedis 连接 Redis，连接、认证失败时返回错误
+func NewRedis(opts RedisOptions) (*Redis, error) {
+	client, err := redis.Dial(redis.Options{
+		Addr:     opts.Addr,
+		Username: opts.Username,
+		Password: opts.Password,
+		DB:       opts.DB,
+		Timeout:  opts.Timeout,
+		Dial:     opts.Dial,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{opts: opts, client: client}, nil
+}
+
+// Get 根据 key 获取 data，key 不存在时返回 nil
+func (s *Redis) Get(key string) (interface{}, error) {
+	if key == "" {
+		return nil, errors.New("Key cannot be empty")
+	}
+	reply, err := s.client.Do("GET", s.opts.KeyPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.([]byte)
+	if data == nil {
+		return nil, nil
+	}
+	return decodeValue(data)
+}
+
+// Set 根据 key 设置 data，设置了 TTL 时同时设置有效期
+func (s *Redis) Set(key string, value interface{}) error {
+	if key == "" {
+		return errors.New("Key cannot be empty")
+	}
+	if value == nil {
+		return errors.New("Value cannot be empty")
+	}
+	data, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", s.opts.KeyPrefix + key, data}
+	if s.opts.TTL > 0 {
+		ms := s.opts.TTL.Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err = s.client.Do(args...)
+	return err
+}
+
+// Del 根据 key 删除 data
+func (s *Redis) Del(key string) error {
+	if key == "" {
+		return errors.New("Key cannot be empty")
+	}
+	_, err := s.client.Do("DEL", s.opts.KeyPrefix+key)
+	return err
+}
+
+// Close 关闭连接
+func (s *Redis) Close() error {
+	return s.client.Close()
+}
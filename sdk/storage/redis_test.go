@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"iot-sdk-go/pkg/redis/redistest"
+	"iot-sdk-go/pkg/typeconv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRedis 启动测试服务端并连接
+func newTestRedis(t *testing.T, opts RedisOptions) (*Redis, *redistest.Server) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	opts.Addr = server.Addr
+	s, err := NewRedis(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, server
+}
+
+func TestRedisRoundTrip(t *testing.T) {
+	s, _ := newTestRedis(t, RedisOptions{})
+	token := []byte{0, 1, 0xfe, 0xff, '\r', '\n'}
+	for key, value := range map[string]interface{}{
+		"light.Name":  "light",
+		"light.Token": token,
+		"light.ID":    int64(1) << 40,
+		"uint":        uint32(7),
+		"float":       1.5,
+		"bool":        true,
+		"commands":    []string{"a", "b"},
+		"json":        map[string]interface{}{"a": 1.0},
+	} {
+		if err := s.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == "uint" {
+			value = uint64(7)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("%s = %#v, want %#v", key, got, value)
+		}
+	}
+
+	// 与 GetDeviceInfo 相同的方式读取
+	v, _ := s.Get("light.Token")
+	if b, err := typeconv.InterfaceToSliceByte(v); err != nil || !bytes.Equal(b, token) {
+		t.Fatalf("token = %v, %v", b, err)
+	}
+	v, _ = s.Get("light.ID")
+	if id, err := typeconv.InterfaceToInt64(v); err != nil || id != int64(1)<<40 {
+		t.Fatalf("id = %d, %v", id, err)
+	}
+
+	if v, err := s.Get("missing"); v != nil || err != nil {
+		t.Fatalf("missing key = %v, %v", v, err)
+	}
+	if err := s.Del("light.Name"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("light.Name"); v != nil || err != nil {
+		t.Fatalf("deleted key = %v, %v", v, err)
+	}
+	if err := s.Set("", "a"); err == nil {
+		t.Fatal("empty key should fail")
+	}
+	if err := s.Set("a", nil); err == nil {
+		t.Fatal("nil value should fail")
+	}
+}
+
+func TestRedisOptions(t *testing.T) {
+	s, server := newTestRedis(t, RedisOptions{DB: 2, KeyPrefix: "iot:", TTL: time.Minute})
+	if err := s.Set("light.Secret", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := server.Get(2, "iot:light.Secret"); !ok || v != "s:secret" {
+		t.Fatalf("stored value = %q, %v", v, ok)
+	}
+	if ttl := server.TTL(2, "iot:light.Secret"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("ttl = %v", ttl)
+	}
+	server.FastForward(time.Minute)
+	if v, err := s.Get("light.Secret"); v != nil || err != nil {
+		t.Fatalf("expired key = %v, %v", v, err)
+	}
+}
+
+func TestRedisAuthAndReconnect(t *testing.T) {
+	server, err := redistest.NewServer(redistest.WithPassword("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if _, err := NewRedis(RedisOptions{Addr: server.Addr, Password: "wrong"}); err == nil {
+		t.Fatal("wrong password should fail")
+	}
+	s, err := NewRedis(RedisOptions{Addr: server.Addr, Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接断开后第一个命令失败，之后重新连接并认证
+	server.CloseConns()
+	s.Get("a")
+	if v, err := s.Get("a"); v != "1" || err != nil {
+		t.Fatalf("get after reconnect = %v, %v", v, err)
+	}
+}
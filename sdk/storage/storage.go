@@ -1,8 +1,43 @@
 package storage
 
+import "errors"
+
 // Storage 存储
 type Storage interface {
 	Get(key string) (interface{}, error)
 	Set(key string, value interface{}) error
 	Del(key string) error
 }
+
+// Flusher 缓冲写入的存储，为可选接口，未实现时视为每次写入都已落盘
+type Flusher interface {
+	// Flush 将缓冲中未写入的数据落盘
+	Flush() error
+}
+
+// Flush 将存储缓冲中的数据落盘，存储未实现 Flusher 时不做任何操作
+func Flush(s Storage) error {
+	flusher, ok := s.(Flusher)
+	if !ok {
+		return nil
+	}
+	return flusher.Flush()
+}
+
+// ErrSizeUnsupported 存储不支持查询占用空间
+var ErrSizeUnsupported = errors.New("storage size unsupported")
+
+// Sizer 可以查询占用空间的存储，为可选接口，未实现时视为不支持
+type Sizer interface {
+	// Size 存储占用的字节数
+	Size() (int64, error)
+}
+
+// Size 查询存储占用的字节数，存储未实现 Sizer 时返回 ErrSizeUnsupported
+func Size(s Storage) (int64, error) {
+	sizer, ok := s.(Sizer)
+	if !ok {
+		return 0, ErrSizeUnsupported
+	}
+	return sizer.Size()
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// encodeValue 编码为 "类型:内容"，类型为一个字符，decodeValue 返回与写入时相同的类型：string、[]byte、
+// int64（有符号整数）、uint64（无符号整数）、float64、bool、[]string，其他类型按 JSON 编码，返回 JSON 解码的结果。
+// 供只能保存字符串的存储使用，使 Token（[]byte）、ID（int64）可以正确读回
+func encodeValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return "s:" + v, nil
+	case []byte:
+		return "b:" + base64.StdEncoding.EncodeToString(v), nil
+	case int:
+		return "i:" + strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return "i:" + strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return "i:" + strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return "i:" + strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return "i:" + strconv.FormatInt(v, 10), nil
+	case uint:
+		return "u:" + strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return "u:" + strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return "u:" + strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return "u:" + strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return "u:" + strconv.FormatUint(v, 10), nil
+	case float32:
+		return "f:" + strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return "f:" + strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return "t:" + strconv.FormatBool(v), nil
+	case []string:
+		data, err := json.Marshal(v)
+		return "a:" + string(data), err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return "j:" + string(data), nil
+}
+
+// decodeValue 解码 encodeValue 编码的值
+func decodeValue(data []byte) (interface{}, error) {
+	s := string(data)
+	if len(s) < 2 || s[1] != ':' {
+		return nil, fmt.Errorf("invalid storage value %q", s)
+	}
+	kind, payload := s[0], s[2:]
+	switch kind {
+	case 's':
+		return payload, nil
+	case 'b':
+		return base64.StdEncoding.DecodeString(payload)
+	case 'i':
+		return strconv.ParseInt(payload, 10, 64)
+	case 'u':
+		return strconv.ParseUint(payload, 10, 64)
+	case 'f':
+		return strconv.ParseFloat(payload, 64)
+	case 't':
+		return strconv.ParseBool(payload)
+	case 'a':
+		var v []string
+		err := json.Unmarshal([]byte(payload), &v)
+		return v, err
+	case 'j':
+		var v interface{}
+		err := json.Unmarshal([]byte(payload), &v)
+		return v, err
+	}
+	return nil, fmt.Errorf("unknown storage value type %q", kind)
+}
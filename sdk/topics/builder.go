@@ -0,0 +1,296 @@
+package topics
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTopicLength MQTT 主题的最大字节数
+const maxTopicLength = 65535
+
+// ValidationError 主题校验错误，包含所有不合法的主题
+type ValidationError struct {
+	Errors []error
+}
+
+// Error 错误信息
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid topics: " + strings.Join(msgs, "; ")
+}
+
+// Builder 主题构建器，以 DefaultTopics 为基础逐项设置，Build 时统一校验
+type Builder struct {
+	topics Topics
+}
+
+// NewBuilder 创建主题构建器
+func NewBuilder() *Builder {
+	return &Builder{topics: DefaultTopics}
+}
+
+// WithRegister 设置注册地址
+func (b *Builder) WithRegister(topic string) *Builder {
+	b.topics.Register = topic
+	return b
+}
+
+// WithLogin 设置登录地址
+func (b *Builder) WithLogin(topic string) *Builder {
+	b.topics.Login = topic
+	return b
+}
+
+// WithDeviceStatus 设置注册状态查询地址
+func (b *Builder) WithDeviceStatus(topic string) *Builder {
+	b.topics.DeviceStatus = topic
+	return b
+}
+
+// WithProvision 设置认领码开通地址
+func (b *Builder) WithProvision(topic string) *Builder {
+	b.topics.Provision = topic
+	return b
+}
+
+// WithActivate 设置设备激活地址
+func (b *Builder) WithActivate(topic string) *Builder {
+	b.topics.Activate = topic
+	return b
+}
+
+// WithRotateSecret 设置密钥轮换地址
+func (b *Builder) WithRotateSecret(topic string) *Builder {
+	b.topics.RotateSecret = topic
+	return b
+}
+
+// WithPostProperty 设置属性上报主题
+func (b *Builder) WithPostProperty(topic string) *Builder {
+	b.topics.PostProperty = topic
+	return b
+}
+
+// WithSetProperty 设置属性设置主题
+func (b *Builder) WithSetProperty(topic string) *Builder {
+	b.topics.SetProperty = topic
+	return b
+}
+
+// WithOnProperty 设置属性下发主题
+func (b *Builder) WithOnProperty(topic string) *Builder {
+	b.topics.OnProperty = topic
+	return b
+}
+
+// WithPropertyReply 设置属性上报回复主题
+func (b *Builder) WithPropertyReply(topic string) *Builder {
+	b.topics.PropertyReply = topic
+	return b
+}
+
+// WithPostEvent 设置事件上报主题
+func (b *Builder) WithPostEvent(topic string) *Builder {
+	b.topics.PostEvent = topic
+	return b
+}
+
+// WithOnCommand 设置命令主题
+func (b *Builder) WithOnCommand(topic string) *Builder {
+	b.topics.OnCommand = topic
+	return b
+}
+
+// WithCommandResponse 设置命令回复主题
+func (b *Builder) WithCommandResponse(topic string) *Builder {
+	b.topics.CommandResponse = topic
+	return b
+}
+
+// WithSubDeviceStatus 设置子设备状态主题
+func (b *Builder) WithSubDeviceStatus(topic string) *Builder {
+	b.topics.SubDeviceStatus = topic
+	return b
+}
+
+// WithDiagnosticRequest 设置诊断请求主题
+func (b *Builder) WithDiagnosticRequest(topic string) *Builder {
+	b.topics.DiagnosticRequest = topic
+	return b
+}
+
+// WithDiagnosticReply 设置诊断回复主题
+func (b *Builder) WithDiagnosticReply(topic string) *Builder {
+	b.topics.DiagnosticReply = topic
+	return b
+}
+
+// WithAlarm 设置告警主题
+func (b *Builder) WithAlarm(topic string) *Builder {
+	b.topics.Alarm = topic
+	return b
+}
+
+// WithLoopback 设置自检主题前缀
+func (b *Builder) WithLoopback(topic string) *Builder {
+	b.topics.Loopback = topic
+	return b
+}
+
+// WithDeviceInfo 设置设备信息主题
+func (b *Builder) WithDeviceInfo(topic string) *Builder {
+	b.topics.DeviceInfo = topic
+	return b
+}
+
+// WithReboot 设置重启命令主题
+func (b *Builder) WithReboot(topic string) *Builder {
+	b.topics.Reboot = topic
+	return b
+}
+
+// WithRebootReply 设置重启命令确认主题
+func (b *Builder) WithRebootReply(topic string) *Builder {
+	b.topics.RebootReply = topic
+	return b
+}
+
+// Build 校验并返回主题列表，所有不合法的主题合并为一个 *ValidationError 返回
+func (b *Builder) Build() (Topics, error) {
+	if err := b.topics.Validate(); err != nil {
+		return Topics{}, err
+	}
+	return b.topics, nil
+}
+
+// Validate 校验主题列表，SetProperty 只在使用 OnSetProperty 时需要，允许为空
+func (t Topics) Validate() error {
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	check("Register", validateURL(t.Register))
+	check("Login", validateURL(t.Login))
+	check("DeviceStatus", validateURL(t.DeviceStatus))
+	check("RotateSecret", validateURL(t.RotateSecret))
+	check("Provision", validateURL(t.Provision))
+	check("Activate", validateURL(t.Activate))
+	check("PostProperty", validateTopic(t.PostProperty, false))
+	if t.SetProperty != "" {
+		check("SetProperty", validateTopic(t.SetProperty, true))
+	}
+	check("OnProperty", validateTopic(t.OnProperty, true))
+	check("PropertyReply", validateTopic(t.PropertyReply, true))
+	check("PostEvent", validateTopic(t.PostEvent, false))
+	check("OnCommand", validateTopic(t.OnCommand, true))
+	check("CommandResponse", validateTopic(t.CommandResponse, false))
+	check("SubDeviceStatus", validateTopic(t.SubDeviceStatus, false))
+	check("DiagnosticRequest", validateTopic(t.DiagnosticRequest, true))
+	check("DiagnosticReply", validateTopic(t.DiagnosticReply, false))
+	check("Alarm", validateTopic(t.Alarm, false))
+	check("Loopback", validateTopic(t.Loopback, false))
+	check("DeviceInfo", validateTopic(t.DeviceInfo, false))
+	check("Reboot", validateTopic(t.Reboot, true))
+	check("RebootReply", validateTopic(t.RebootReply, false))
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validateURL 校验 HTTP 地址，可以是完整 URL 或以 / 开头的路径
+func validateURL(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty")
+	}
+	if err := validatePlaceholders(s); err != nil {
+		return err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" && !strings.HasPrefix(s, "/") {
+		return fmt.Errorf("%q must be an absolute URL or start with /", s)
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q has unsupported scheme %s", s, u.Scheme)
+	}
+	return nil
+}
+
+// validateTopic 校验 MQTT 主题，订阅主题（subscribe 为 true）允许使用通配符与共享订阅
+func validateTopic(s string, subscribe bool) error {
+	if s == "" {
+		return fmt.Errorf("empty")
+	}
+	if IsShared(s) {
+		if !subscribe {
+			return fmt.Errorf("%q is a shared subscription in publish topic", s)
+		}
+		group, filter, ok := SplitShared(s)
+		if !ok || strings.ContainsAny(group, "+#") {
+			return fmt.Errorf("%q is not a valid shared subscription, want $share/{group}/{filter}", s)
+		}
+		s = filter
+	}
+	if len(s) > maxTopicLength {
+		return fmt.Errorf("longer than %d bytes", maxTopicLength)
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%q is not valid UTF-8", s)
+	}
+	if strings.ContainsRune(s, 0) {
+		return fmt.Errorf("%q contains null character", s)
+	}
+	if strings.ContainsAny(s, " \t\r\n") {
+		return fmt.Errorf("%q contains whitespace", s)
+	}
+	levels := strings.Split(s, "/")
+	for i, level := range levels {
+		if !strings.ContainsAny(level, "+#") {
+			continue
+		}
+		if !subscribe {
+			return fmt.Errorf("%q contains wildcard in publish topic", s)
+		}
+		// + 和 # 必须单独占据一级，# 只能位于最后一级
+		if len(level) > 1 || (level == "#" && i != len(levels)-1) {
+			return fmt.Errorf("%q has misplaced wildcard", s)
+		}
+	}
+	return validatePlaceholders(s)
+}
+
+// validatePlaceholders 校验 {name} 形式的占位符成对出现、不嵌套且名称非空
+func validatePlaceholders(s string) error {
+	open := -1
+	for i, c := range s {
+		switch c {
+		case '{':
+			if open >= 0 {
+				return fmt.Errorf("%q has nested placeholder", s)
+			}
+			open = i
+		case '}':
+			if open < 0 {
+				return fmt.Errorf("%q has unbalanced placeholder", s)
+			}
+			if i == open+1 {
+				return fmt.Errorf("%q has empty placeholder", s)
+			}
+			open = -1
+		}
+	}
+	if open >= 0 {
+		return fmt.Errorf("%q has unbalanced placeholder", s)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package topics
+
+import "testing"
+
+func TestBuilderDefault(t *testing.T) {
+	tps, err := NewBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tps.PostProperty != DefaultTopics.PostProperty {
+		t.Fatalf("unexpected topics: %+v", tps)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tps, err := NewBuilder().
+		WithRegister("https://example.com/v1/devices/registration").
+		WithPostProperty("devices/{device_id}/property").
+		WithOnCommand("devices/+/command/#").
+		WithDiagnosticRequest("$share/gateways/d").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tps.OnCommand != "devices/+/command/#" {
+		t.Fatalf("unexpected topics: %+v", tps)
+	}
+}
+
+func TestBuilderMalformed(t *testing.T) {
+	cases := map[string]*Builder{
+		"empty topic":              NewBuilder().WithPostEvent(""),
+		"empty url":                NewBuilder().WithLogin(""),
+		"relative url":             NewBuilder().WithLogin("v1/login"),
+		"unsupported scheme":       NewBuilder().WithRegister("ftp://example.com/register"),
+		"wildcard in publish":      NewBuilder().WithPostProperty("devices/+/property"),
+		"misplaced multi-level":    NewBuilder().WithOnCommand("devices/#/command"),
+		"wildcard inside level":    NewBuilder().WithOnCommand("devices/a+/command"),
+		"whitespace":               NewBuilder().WithPostEvent("devices /event"),
+		"null character":           NewBuilder().WithPostEvent("devices/\x00"),
+		"unbalanced placeholder":   NewBuilder().WithPostProperty("devices/{device_id/property"),
+		"unopened placeholder":     NewBuilder().WithPostProperty("devices/device_id}/property"),
+		"nested placeholder":       NewBuilder().WithPostProperty("devices/{{device_id}}/property"),
+		"empty placeholder":        NewBuilder().WithPostProperty("devices/{}/property"),
+		"invalid utf-8":            NewBuilder().WithDiagnosticReply("\xff"),
+		"wildcard in set property": NewBuilder().WithSetProperty("devices/#/set"),
+		"shared publish topic":     NewBuilder().WithPostEvent("$share/gateways/e"),
+		"shared without filter":    NewBuilder().WithOnCommand("$share/gateways"),
+		"shared wildcard group":    NewBuilder().WithOnCommand("$share/+/c"),
+	}
+	for name, b := range cases {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
+
+func TestBuilderAggregatedError(t *testing.T) {
+	_, err := NewBuilder().WithPostEvent("").WithPostProperty("a/+").Build()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expect *ValidationError, got %v", err)
+	}
+	if len(verr.Errors) != 2 {
+		t.Fatalf("expect 2 errors, got %v", verr)
+	}
+}
+
+func TestFromPrefix(t *testing.T) {
+	tps, err := FromPrefix("devices/{device_id}/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tps.PostProperty != "devices/{device_id}/property/post" || tps.OnCommand != "devices/{device_id}/command" ||
+		tps.RebootReply != "devices/{device_id}/reboot/reply" || tps.Login != DefaultTopics.Login {
+		t.Fatalf("unexpected topics: %+v", tps)
+	}
+	tps, err = FromPrefixWithSuffixes("devices/{device_id}", Suffixes{"OnCommand": "cmd/#", "SetProperty": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tps.OnCommand != "devices/{device_id}/cmd/#" || tps.SetProperty != DefaultTopics.SetProperty ||
+		tps.PostEvent != "devices/{device_id}/event/post" {
+		t.Fatalf("unexpected topics: %+v", tps)
+	}
+	for name, suffixes := range map[string]Suffixes{
+		"unknown topic":       {"Shadow": "shadow"},
+		"wildcard in publish": {"PostEvent": "event/+"},
+	} {
+		if _, err := FromPrefixWithSuffixes("devices/1", suffixes); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	if _, err := FromPrefix(""); err == nil {
+		t.Error("empty prefix: expect error")
+	}
+	if _, err := FromPrefix("devices/+"); err == nil {
+		t.Error("wildcard prefix: expect error")
+	}
+}
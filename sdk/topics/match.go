@@ -0,0 +1,35 @@
+package topics
+
+import (
+	"iot-sdk-go/pkg/mqtt"
+	"strings"
+)
+
+// SharedPrefix 共享订阅前缀，完整格式为 $share/{group}/{filter}
+const SharedPrefix = "$share/"
+
+// SplitShared 拆分共享订阅，返回分组名与实际的订阅过滤器，不是共享订阅或格式不正确时 ok 为 false
+func SplitShared(filter string) (group, topicFilter string, ok bool) {
+	if !strings.HasPrefix(filter, SharedPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(filter, SharedPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// IsShared 是否为共享订阅
+func IsShared(filter string) bool {
+	return strings.HasPrefix(filter, SharedPrefix)
+}
+
+// Match 判断消息主题是否匹配订阅过滤器，过滤器可以包含 + 和 # 通配符。
+// 共享订阅收到的消息主题不带 $share/{group}/ 前缀，按去掉前缀后的过滤器匹配
+func Match(filter, topic string) bool {
+	if _, f, ok := SplitShared(filter); ok {
+		filter = f
+	}
+	return mqtt.TopicMatches(filter, topic)
+}
@@ -0,0 +1,37 @@
+package topics
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"c", "c", true},
+		{"devices/+/command", "devices/1/command", true},
+		{"devices/#", "devices/1/command", true},
+		{"devices/+", "devices/1/command", false},
+		{"$share/gateways/c", "c", true},
+		{"$share/gateways/devices/+/command", "devices/1/command", true},
+		{"$share/gateways/devices/+/command", "$share/gateways/devices/1/command", false},
+		{"$share/gateways/c", "e", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.filter, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.filter, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestSplitShared(t *testing.T) {
+	group, filter, ok := SplitShared("$share/gateways/devices/+/command")
+	if !ok || group != "gateways" || filter != "devices/+/command" {
+		t.Fatalf("unexpected split: %q %q %v", group, filter, ok)
+	}
+	for _, s := range []string{"c", "$share/", "$share/gateways", "$share//c", "$share/gateways/"} {
+		if _, _, ok := SplitShared(s); ok {
+			t.Errorf("%q should not be a valid shared subscription", s)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package topics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Suffixes MQTT 主题相对于前缀的后缀，key 为 Topics 的字段名，如 "PostProperty"
+type Suffixes map[string]string
+
+// DefaultSuffixes FromPrefix 使用的默认后缀
+var DefaultSuffixes = Suffixes{
+	"PostProperty":      "property/post",
+	"SetProperty":       "property/set",
+	"OnProperty":        "property/sync",
+	"PropertyReply":     "property/post/reply",
+	"PostEvent":         "event/post",
+	"OnCommand":         "command",
+	"CommandResponse":   "command/reply",
+	"SubDeviceStatus":   "sub/status",
+	"DiagnosticRequest": "diagnostic",
+	"DiagnosticReply":   "diagnostic/reply",
+	"Alarm":             "alarm",
+	"Loopback":          "loopback",
+	"DeviceInfo":        "info",
+	"Reboot":            "reboot",
+	"RebootReply":       "reboot/reply",
+}
+
+// mqttTopics Topics 中的 MQTT 主题字段，Register、Login 等 HTTP 地址不在其中
+func (t *Topics) mqttTopics() map[string]*string {
+	return map[string]*string{
+		"PostProperty":      &t.PostProperty,
+		"SetProperty":       &t.SetProperty,
+		"OnProperty":        &t.OnProperty,
+		"PropertyReply":     &t.PropertyReply,
+		"PostEvent":         &t.PostEvent,
+		"OnCommand":         &t.OnCommand,
+		"CommandResponse":   &t.CommandResponse,
+		"SubDeviceStatus":   &t.SubDeviceStatus,
+		"DiagnosticRequest": &t.DiagnosticRequest,
+		"DiagnosticReply":   &t.DiagnosticReply,
+		"Alarm":             &t.Alarm,
+		"Loopback":          &t.Loopback,
+		"DeviceInfo":        &t.DeviceInfo,
+		"Reboot":            &t.Reboot,
+		"RebootReply":       &t.RebootReply,
+	}
+}
+
+// FromPrefix 以 prefix 为前缀、按 DefaultSuffixes 生成所有 MQTT 主题，如 prefix 为 devices/{device_id} 时
+// PostProperty 为 devices/{device_id}/property/post。Register、Login 等 HTTP 地址使用 DefaultTopics 中的值。
+// 生成的主题经过 Validate 校验
+func FromPrefix(prefix string) (Topics, error) {
+	return FromPrefixWithSuffixes(prefix, nil)
+}
+
+// FromPrefixWithSuffixes 与 FromPrefix 相同，suffixes 覆盖 DefaultSuffixes 中的同名后缀，
+// 后缀为空字符串时该主题使用 DefaultTopics 中的值，字段名不存在时返回错误
+func FromPrefixWithSuffixes(prefix string, suffixes Suffixes) (Topics, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return Topics{}, fmt.Errorf("topic prefix is empty")
+	}
+	t := DefaultTopics
+	fields := t.mqttTopics()
+	for name := range suffixes {
+		if _, ok := fields[name]; !ok {
+			return Topics{}, fmt.Errorf("unknown topic %s", name)
+		}
+	}
+	for name, field := range fields {
+		suffix, ok := suffixes[name]
+		if !ok {
+			suffix = DefaultSuffixes[name]
+		}
+		if suffix != "" {
+			*field = prefix + "/" + strings.TrimPrefix(suffix, "/")
+		}
+	}
+	if err := t.Validate(); err != nil {
+		return Topics{}, err
+	}
+	return t, nil
+}
@@ -10,20 +10,62 @@ import (
 type Topics struct {
 	Register     string
 	Login        string
+	DeviceStatus string
+	// RotateSecret 轮换设备密钥
+	RotateSecret string
+	// Provision 使用认领码开通设备
+	Provision string
+	// Activate 注册后激活设备，平台要求激活的设备激活后才能登录
+	Activate     string
 	PostProperty string
 	SetProperty  string
-	PostEvent    string
-	OnCommand    string
+	// OnProperty 平台下发的属性设置、同步
+	OnProperty string
+	PostEvent  string
+	OnCommand  string
+	// CommandResponse 命令回复
+	CommandResponse string
+	// SubDeviceStatus 网关上报子设备上线、下线
+	SubDeviceStatus string
+	// DiagnosticRequest 诊断请求，DiagnosticReply 诊断回复
+	DiagnosticRequest string
+	DiagnosticReply   string
+	// Alarm 告警产生、确认、清除
+	Alarm string
+	// Loopback 自检主题前缀，自检时在其下创建临时主题
+	Loopback string
+	// DeviceInfo 连接后上报固件版本、SDK 版本等设备信息
+	DeviceInfo string
+	// PropertyReply 平台对带版本的属性上报的回复，版本冲突时收到
+	PropertyReply string
+	// Reboot 平台下发的重启命令，RebootReply 重启命令的确认
+	Reboot      string
+	RebootReply string
 }
 
 // DefaultTopics 默认主题列表
 var DefaultTopics = Topics{
-	Register:     "/v1/devices/registration",
-	Login:        "/v1/devices/authentication",
-	PostProperty: "s",
-	SetProperty:  "",
-	PostEvent:    "e",
-	OnCommand:    "c",
+	Register:          "/v1/devices/registration",
+	Login:             "/v1/devices/authentication",
+	DeviceStatus:      "/v1/devices/status",
+	RotateSecret:      "/v1/devices/secret",
+	Provision:         "/v1/devices/provision",
+	Activate:          "/v1/devices/activation",
+	PostProperty:      "s",
+	SetProperty:       "",
+	OnProperty:        "p",
+	PostEvent:         "e",
+	OnCommand:         "c",
+	CommandResponse:   "cr",
+	SubDeviceStatus:   "ss",
+	DiagnosticRequest: "d",
+	DiagnosticReply:   "dr",
+	Alarm:             "a",
+	Loopback:          "lb",
+	DeviceInfo:        "i",
+	PropertyReply:     "sr",
+	Reboot:            "rb",
+	RebootReply:       "rbr",
 }
 
 // Override 合并默认主题列表
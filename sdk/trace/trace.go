@@ -0,0 +1,56 @@
+package trace
+
+import "context"
+
+// 属性名，与 OpenTelemetry 的语义约定保持一致
+const (
+	AttrTopic       = "messaging.destination"
+	AttrQos         = "messaging.mqtt.qos"
+	AttrPayloadSize = "messaging.message_payload_size_bytes"
+	AttrPropertyID  = "iot.property_id"
+	AttrCommandID   = "iot.command_id"
+	AttrDeviceName  = "iot.device_name"
+)
+
+// Attribute Span 属性
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String 创建字符串属性
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int 创建整数属性
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span 追踪片段，对应 OpenTelemetry 的 trace.Span
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer 追踪器，对应 OpenTelemetry 的 trace.Tracer，
+// SDK 不直接依赖 OpenTelemetry，接入时实现该接口的适配器即可
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// NopTracer 不记录任何数据的追踪器
+type NopTracer struct{}
+
+// Start 返回不记录任何数据的 Span
+func (NopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...Attribute) {}
+func (nopSpan) RecordError(err error)            {}
+func (nopSpan) End()                             {}